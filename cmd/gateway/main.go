@@ -4,6 +4,7 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"google.golang.org/grpc"
@@ -13,28 +14,50 @@ import (
 	"grpc-rbac-backend/internal/middleware" // 导入 JWT 中间件
 )
 
+// headerMatcher 除默认头外，额外透传请求 ID 供审计使用，以及机器调用方的 API Key
+func headerMatcher(key string) (string, bool) {
+	switch strings.ToLower(key) {
+	case "x-request-id":
+		return "x-request-id", true
+	case "x-api-key":
+		return "x-api-key", true
+	}
+	return runtime.DefaultHeaderMatcher(key)
+}
+
 func main() {
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	// 创建 gRPC-Gateway 的 mux
-	gwMux := runtime.NewServeMux()
+	gwMux := runtime.NewServeMux(runtime.WithIncomingHeaderMatcher(headerMatcher))
 
 	// gRPC 连接配置
 	opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
 
-	// 注册 gRPC 服务到 Gateway
-	err := api.RegisterRBACServiceHandlerFromEndpoint(ctx, gwMux, "127.0.0.1:50051", opts)
+	conn, err := grpc.NewClient("127.0.0.1:50051", opts...)
 	if err != nil {
+		log.Fatalf("❌ 连接 gRPC 服务失败: %v", err)
+	}
+	defer conn.Close()
+
+	// 注册 gRPC 服务到 Gateway
+	if err := api.RegisterRBACServiceHandler(ctx, gwMux, conn); err != nil {
 		log.Fatalf("❌ 注册 gRPC Gateway 失败: %v", err)
 	}
 
-	// 包裹 JWT 中间件
-	handlerWithMiddleware := middleware.JWTAuthMiddleware(gwMux)
+	// OAuth2/OIDC、SCIM 端点由网关直接处理，不经过 JWT 中间件；其余接口包裹 JWT 中间件
+	client := api.NewRBACServiceClient(conn)
+	mux := http.NewServeMux()
+	mux.Handle("/oauth2/token", oauthTokenHandler(client))
+	mux.Handle("/oauth2/authorize", authorizeHandler(client))
+	mux.Handle("/userinfo", userinfoHandler(client))
+	mux.Handle(scimBasePath+"/", scimHandler(client))
+	mux.Handle("/", middleware.JWTAuthMiddleware(gwMux))
 
 	log.Println("🚀 HTTP 网关启动成功，监听 http://localhost:8080")
-	if err := http.ListenAndServe(":8080", handlerWithMiddleware); err != nil {
+	if err := http.ListenAndServe(":8080", mux); err != nil {
 		log.Fatalf("❌ HTTP 服务启动失败: %v", err)
 	}
 }
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"grpc-rbac-backend/api"
+)
+
+// oauthTokenResponse RFC 6749 / RFC 8693 的 token 响应
+type oauthTokenResponse struct {
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int64  `json:"expires_in"`
+	RefreshToken    string `json:"refresh_token,omitempty"`
+	Scope           string `json:"scope,omitempty"`
+	IDToken         string `json:"id_token,omitempty"`
+}
+
+// oauthErrorResponse RFC 6749 的错误响应
+type oauthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// oauthTokenHandler /oauth2/token 端点：解析表单参数转为 IssueToken 调用，按 OAuth2 的格式返回 JSON
+func oauthTokenHandler(client api.RBACServiceClient) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeOAuthJSON(w, http.StatusMethodNotAllowed, oauthErrorResponse{Error: "invalid_request", ErrorDescription: "只支持 POST"})
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			writeOAuthJSON(w, http.StatusBadRequest, oauthErrorResponse{Error: "invalid_request", ErrorDescription: "请求参数格式错误"})
+			return
+		}
+
+		form := r.PostForm
+		req := &api.IssueTokenRequest{
+			GrantType:          form.Get("grant_type"),
+			ClientId:           form.Get("client_id"),
+			ClientSecret:       form.Get("client_secret"),
+			Scope:              form.Get("scope"),
+			RefreshToken:       form.Get("refresh_token"),
+			SubjectToken:       form.Get("subject_token"),
+			SubjectTokenType:   form.Get("subject_token_type"),
+			RequestedTokenType: form.Get("requested_token_type"),
+			Code:               form.Get("code"),
+			RedirectUri:        form.Get("redirect_uri"),
+			CodeVerifier:       form.Get("code_verifier"),
+		}
+		// 客户端凭证也可通过 HTTP Basic 认证传递，用户名和密码按表单编码（RFC 6749 2.3.1）
+		if id, secret, ok := r.BasicAuth(); ok {
+			if req.ClientId, _ = url.QueryUnescape(id); req.ClientId == "" {
+				req.ClientId = id
+			}
+			if req.ClientSecret, _ = url.QueryUnescape(secret); req.ClientSecret == "" {
+				req.ClientSecret = secret
+			}
+		}
+
+		resp, err := client.IssueToken(forwardContext(r), req)
+		if err != nil {
+			writeOAuthError(w, err)
+			return
+		}
+		writeOAuthJSON(w, http.StatusOK, oauthTokenResponse{
+			AccessToken:     resp.AccessToken,
+			IssuedTokenType: resp.IssuedTokenType,
+			TokenType:       resp.TokenType,
+			ExpiresIn:       resp.ExpiresIn,
+			RefreshToken:    resp.RefreshToken,
+			Scope:           resp.Scope,
+			IDToken:         resp.IdToken,
+		})
+	})
+}
+
+// forwardContext 手工转发的请求与 grpc-gateway 一致，在 x-forwarded-for 末尾追加客户端地址，
+// 并透传 Authorization 头
+func forwardContext(r *http.Request) context.Context {
+	md := metadata.MD{}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		xff := host
+		if prior := r.Header.Get("X-Forwarded-For"); prior != "" {
+			xff = prior + ", " + host
+		}
+		md.Set("x-forwarded-for", xff)
+	}
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		md.Set("authorization", auth)
+	}
+	return metadata.NewOutgoingContext(r.Context(), md)
+}
+
+// writeOAuthError gRPC 错误转为 OAuth2 错误，错误码取自 ErrorInfo.Reason
+func writeOAuthError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	body := oauthErrorResponse{Error: "server_error", ErrorDescription: "服务器内部错误"}
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok && info.Domain == "oauth2" {
+			body = oauthErrorResponse{Error: info.Reason, ErrorDescription: st.Message()}
+		}
+	}
+
+	code := http.StatusBadRequest
+	switch body.Error {
+	case "invalid_client":
+		code = http.StatusUnauthorized
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth2"`)
+	case "server_error":
+		code = http.StatusInternalServerError
+	}
+	writeOAuthJSON(w, code, body)
+}
+
+func writeOAuthJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	_ "embed"
+	"html/template"
+	"log"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"grpc-rbac-backend/api"
+)
+
+//go:embed login.html
+var loginHTML string
+
+var loginTemplate = template.Must(template.New("login").Parse(loginHTML))
+
+// authorizeParams 登录页需要原样带回的授权参数
+var authorizeParams = []string{
+	"response_type", "client_id", "redirect_uri", "scope", "state", "nonce", "code_challenge", "code_challenge_method",
+}
+
+// loginPage 登录页的渲染数据
+type loginPage struct {
+	ClientName string
+	Tenant     string
+	Error      string
+	ShowForm   bool
+	Username   string
+	Params     map[string]string
+}
+
+// authorizeHandler /oauth2/authorize：GET 校验授权参数后展示登录页，POST 提交登录表单，
+// 成功后跳转回客户端的回调地址
+func authorizeHandler(client api.RBACServiceClient) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+
+		page := loginPage{Params: make(map[string]string, len(authorizeParams)), Username: r.PostForm.Get("username")}
+		for _, k := range authorizeParams {
+			page.Params[k] = r.Form.Get(k)
+		}
+		req := &api.AuthorizeRequest{
+			ResponseType:        page.Params["response_type"],
+			ClientId:            page.Params["client_id"],
+			RedirectUri:         page.Params["redirect_uri"],
+			Scope:               page.Params["scope"],
+			State:               page.Params["state"],
+			Nonce:               page.Params["nonce"],
+			CodeChallenge:       page.Params["code_challenge"],
+			CodeChallengeMethod: page.Params["code_challenge_method"],
+			Username:            r.PostForm.Get("username"),
+			Password:            r.PostForm.Get("password"),
+			MfaCode:             r.PostForm.Get("mfa_code"),
+			ValidateOnly:        r.Method == http.MethodGet,
+		}
+
+		resp, err := client.Authorize(forwardContext(r), req)
+		code := http.StatusOK
+		switch {
+		case err != nil:
+			st := status.Convert(err)
+			page.Error = st.Message()
+			// 客户端或回调地址无效时不展示表单；登录失败则重新展示
+			page.ShowForm = r.Method == http.MethodPost && st.Code() != codes.InvalidArgument
+			code = http.StatusBadRequest
+			if st.Code() == codes.Unauthenticated || st.Code() == codes.ResourceExhausted {
+				code = http.StatusUnauthorized
+			}
+		case resp.RedirectTo != "":
+			http.Redirect(w, r, resp.RedirectTo, http.StatusFound)
+			return
+		default:
+			page.ClientName = resp.ClientName
+			page.Tenant = resp.Tenant
+			page.ShowForm = true
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+		w.WriteHeader(code)
+		if err := loginTemplate.Execute(w, page); err != nil {
+			log.Printf("❌ 渲染登录页失败: %v", err)
+		}
+	})
+}
+
+// userinfoHandler /userinfo：按 OIDC 的字段名返回当前 token 对应的用户信息
+func userinfoHandler(client api.RBACServiceClient) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		resp, err := client.GetUserInfo(forwardContext(r), &api.GetUserInfoRequest{})
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+		writeOAuthJSON(w, http.StatusOK, map[string]any{
+			"sub":                resp.Sub,
+			"preferred_username": resp.PreferredUsername,
+			"tenant":             resp.Tenant,
+			"roles":              resp.Roles,
+		})
+	})
+}
@@ -0,0 +1,559 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/model"
+	"grpc-rbac-backend/internal/scim"
+)
+
+// scimBasePath SCIM 端点的路径前缀
+const scimBasePath = "/scim/v2"
+
+// scimMeta 资源的元数据
+type scimMeta struct {
+	ResourceType string `json:"resourceType"`
+	Location     string `json:"location,omitempty"`
+}
+
+// scimMultiValue 多值属性的一个元素，如角色、所属组和组成员
+type scimMultiValue struct {
+	Value   string `json:"value"`
+	Ref     string `json:"$ref,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+type scimUserResource struct {
+	Schemas    []string         `json:"schemas"`
+	ID         string           `json:"id"`
+	ExternalID string           `json:"externalId,omitempty"`
+	UserName   string           `json:"userName"`
+	Active     bool             `json:"active"`
+	Roles      []scimMultiValue `json:"roles,omitempty"`
+	Groups     []scimMultiValue `json:"groups,omitempty"`
+	Meta       scimMeta         `json:"meta"`
+}
+
+type scimGroupResource struct {
+	Schemas     []string         `json:"schemas"`
+	ID          string           `json:"id"`
+	ExternalID  string           `json:"externalId,omitempty"`
+	DisplayName string           `json:"displayName"`
+	Members     []scimMultiValue `json:"members"`
+	Meta        scimMeta         `json:"meta"`
+}
+
+// scimUserInput 创建和替换用户的请求体，JSON 属性名不区分大小写
+type scimUserInput struct {
+	ExternalID string          `json:"externalId"`
+	UserName   string          `json:"userName"`
+	Active     json.RawMessage `json:"active"`
+	Password   string          `json:"password"`
+	Roles      json.RawMessage `json:"roles"`
+}
+
+type scimGroupInput struct {
+	ExternalID  string           `json:"externalId"`
+	DisplayName string           `json:"displayName"`
+	Members     []scimMultiValue `json:"members"`
+}
+
+// scimPatchInput PatchOp 请求体
+type scimPatchInput struct {
+	Operations []struct {
+		Op    string          `json:"op"`
+		Path  string          `json:"path"`
+		Value json.RawMessage `json:"value"`
+	} `json:"Operations"`
+}
+
+type scimListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int32    `json:"totalResults"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	StartIndex   int32    `json:"startIndex"`
+	Resources    []any    `json:"Resources"`
+}
+
+// scimErrorResponse RFC 7644 3.12 的错误响应
+type scimErrorResponse struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+// scimHandler /scim/v2 下的 Users、Groups 资源及服务发现端点，SCIM JSON 与 gRPC 调用互相转换
+func scimHandler(client api.RBACServiceClient) http.Handler {
+	h := &scimServer{client: client}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+scimBasePath+"/ServiceProviderConfig", h.serviceProviderConfig)
+	mux.HandleFunc("GET "+scimBasePath+"/ResourceTypes", h.resourceTypes)
+
+	mux.HandleFunc("GET "+scimBasePath+"/Users", h.listUsers)
+	mux.HandleFunc("POST "+scimBasePath+"/Users", h.createUser)
+	mux.HandleFunc("GET "+scimBasePath+"/Users/{id}", h.getUser)
+	mux.HandleFunc("PUT "+scimBasePath+"/Users/{id}", h.replaceUser)
+	mux.HandleFunc("PATCH "+scimBasePath+"/Users/{id}", h.patchUser)
+	mux.HandleFunc("DELETE "+scimBasePath+"/Users/{id}", h.deleteUser)
+
+	mux.HandleFunc("GET "+scimBasePath+"/Groups", h.listGroups)
+	mux.HandleFunc("POST "+scimBasePath+"/Groups", h.createGroup)
+	mux.HandleFunc("GET "+scimBasePath+"/Groups/{id}", h.getGroup)
+	mux.HandleFunc("PUT "+scimBasePath+"/Groups/{id}", h.replaceGroup)
+	mux.HandleFunc("PATCH "+scimBasePath+"/Groups/{id}", h.patchGroup)
+	mux.HandleFunc("DELETE "+scimBasePath+"/Groups/{id}", h.deleteGroup)
+
+	mux.HandleFunc(scimBasePath+"/", func(w http.ResponseWriter, r *http.Request) {
+		writeSCIMError(w, http.StatusNotFound, "", "资源不存在")
+	})
+	return mux
+}
+
+type scimServer struct {
+	client api.RBACServiceClient
+}
+
+// scimContext 预配客户端通常只能配置 Bearer token：API Key 转为 x-api-key，其余按 JWT 透传
+func scimContext(r *http.Request) context.Context {
+	ctx := forwardContext(r)
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || !strings.HasPrefix(token, model.APIKeyPrefix) {
+		return ctx
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	md.Delete("authorization")
+	md.Set("x-api-key", token)
+	return metadata.NewOutgoingContext(r.Context(), md)
+}
+
+// baseURL 用于生成资源的 location，反向代理后以 X-Forwarded-Proto 为准
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host + scimBasePath
+}
+
+func (h *scimServer) toUser(r *http.Request, u *api.ScimUser) scimUserResource {
+	base := baseURL(r)
+	res := scimUserResource{
+		Schemas:    []string{scim.UserSchema},
+		ID:         u.Id,
+		ExternalID: u.ExternalId,
+		UserName:   u.UserName,
+		Active:     u.Active,
+		Meta:       scimMeta{ResourceType: "User", Location: base + "/Users/" + u.Id},
+	}
+	for _, role := range u.Roles {
+		res.Roles = append(res.Roles, scimMultiValue{Value: role})
+	}
+	for _, g := range u.Groups {
+		res.Groups = append(res.Groups, scimMultiValue{Value: g.Value, Ref: base + "/Groups/" + g.Value, Display: g.Display})
+	}
+	return res
+}
+
+func (h *scimServer) toGroup(r *http.Request, g *api.ScimGroup) scimGroupResource {
+	base := baseURL(r)
+	res := scimGroupResource{
+		Schemas:     []string{scim.GroupSchema},
+		ID:          g.Id,
+		ExternalID:  g.ExternalId,
+		DisplayName: g.DisplayName,
+		Members:     []scimMultiValue{},
+		Meta:        scimMeta{ResourceType: "Group", Location: base + "/Groups/" + g.Id},
+	}
+	for _, m := range g.Members {
+		res.Members = append(res.Members, scimMultiValue{Value: m.Value, Ref: base + "/Users/" + m.Value, Display: m.Display})
+	}
+	return res
+}
+
+// listRequest 解析 filter、startIndex、count 查询参数，未指定 count 时取默认值
+func listRequest(r *http.Request) (*api.ListScimRequest, bool) {
+	q := r.URL.Query()
+	req := &api.ListScimRequest{Filter: q.Get("filter"), StartIndex: 1, Count: scim.DefaultCount}
+	if v := q.Get("startIndex"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, false
+		}
+		req.StartIndex = int32(n)
+	}
+	if v := q.Get("count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, false
+		}
+		req.Count = int32(n)
+	}
+	return req, true
+}
+
+func (h *scimServer) listUsers(w http.ResponseWriter, r *http.Request) {
+	req, ok := listRequest(r)
+	if !ok {
+		writeSCIMError(w, http.StatusBadRequest, scim.ErrInvalidValue, "startIndex、count 须为整数")
+		return
+	}
+	resp, err := h.client.ListScimUsers(scimContext(r), req)
+	if err != nil {
+		writeSCIMStatus(w, err)
+		return
+	}
+	list := scimListResponse{
+		Schemas:      []string{scim.ListResponseSchema},
+		TotalResults: resp.TotalResults,
+		ItemsPerPage: len(resp.Resources),
+		StartIndex:   resp.StartIndex,
+		Resources:    make([]any, 0, len(resp.Resources)),
+	}
+	for _, u := range resp.Resources {
+		list.Resources = append(list.Resources, h.toUser(r, u))
+	}
+	writeSCIMJSON(w, http.StatusOK, list)
+}
+
+func (h *scimServer) getUser(w http.ResponseWriter, r *http.Request) {
+	u, err := h.client.GetScimUser(scimContext(r), &api.ScimResourceRequest{Id: r.PathValue("id")})
+	if err != nil {
+		writeSCIMStatus(w, err)
+		return
+	}
+	writeSCIMJSON(w, http.StatusOK, h.toUser(r, u))
+}
+
+// decodeUser 解析用户请求体，未提供 active 时视为启用；rolesPresent 表示请求体中带有 roles
+func decodeUser(r *http.Request) (u *api.ScimUser, rolesPresent bool, detail string) {
+	var in scimUserInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		return nil, false, "请求体不是合法的 JSON"
+	}
+	u = &api.ScimUser{ExternalId: in.ExternalID, UserName: in.UserName, Password: in.Password, Active: true}
+	if len(in.Active) > 0 && string(in.Active) != "null" {
+		var v any
+		_ = json.Unmarshal(in.Active, &v)
+		b, ok := jsonBool(v)
+		if !ok {
+			return nil, false, "active 须为布尔值"
+		}
+		u.Active = b
+	}
+	if len(in.Roles) > 0 && string(in.Roles) != "null" {
+		roles, ok := jsonValues(in.Roles)
+		if !ok {
+			return nil, false, "roles 格式错误"
+		}
+		u.Roles, rolesPresent = roles, true
+	}
+	return u, rolesPresent, ""
+}
+
+func (h *scimServer) createUser(w http.ResponseWriter, r *http.Request) {
+	in, _, detail := decodeUser(r)
+	if in == nil {
+		writeSCIMError(w, http.StatusBadRequest, scim.ErrInvalidSyntax, detail)
+		return
+	}
+	u, err := h.client.CreateScimUser(scimContext(r), in)
+	if err != nil {
+		writeSCIMStatus(w, err)
+		return
+	}
+	res := h.toUser(r, u)
+	w.Header().Set("Location", res.Meta.Location)
+	writeSCIMJSON(w, http.StatusCreated, res)
+}
+
+func (h *scimServer) replaceUser(w http.ResponseWriter, r *http.Request) {
+	in, rolesPresent, detail := decodeUser(r)
+	if in == nil {
+		writeSCIMError(w, http.StatusBadRequest, scim.ErrInvalidSyntax, detail)
+		return
+	}
+	u, err := h.client.ReplaceScimUser(scimContext(r), &api.ReplaceScimUserRequest{
+		Id: r.PathValue("id"), User: in, RolesPresent: rolesPresent,
+	})
+	if err != nil {
+		writeSCIMStatus(w, err)
+		return
+	}
+	writeSCIMJSON(w, http.StatusOK, h.toUser(r, u))
+}
+
+// decodePatch 解析 PatchOp 请求体，value 原样以 JSON 字符串传给服务端
+func decodePatch(r *http.Request) (*api.PatchScimRequest, bool) {
+	var in scimPatchInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || len(in.Operations) == 0 {
+		return nil, false
+	}
+	req := &api.PatchScimRequest{Id: r.PathValue("id")}
+	for _, op := range in.Operations {
+		req.Operations = append(req.Operations, &api.ScimPatchOperation{Op: op.Op, Path: op.Path, Value: string(op.Value)})
+	}
+	return req, true
+}
+
+func (h *scimServer) patchUser(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodePatch(r)
+	if !ok {
+		writeSCIMError(w, http.StatusBadRequest, scim.ErrInvalidSyntax, "请求体须为包含 Operations 的 PatchOp")
+		return
+	}
+	u, err := h.client.PatchScimUser(scimContext(r), req)
+	if err != nil {
+		writeSCIMStatus(w, err)
+		return
+	}
+	writeSCIMJSON(w, http.StatusOK, h.toUser(r, u))
+}
+
+func (h *scimServer) deleteUser(w http.ResponseWriter, r *http.Request) {
+	if _, err := h.client.DeleteScimUser(scimContext(r), &api.ScimResourceRequest{Id: r.PathValue("id")}); err != nil {
+		writeSCIMStatus(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *scimServer) listGroups(w http.ResponseWriter, r *http.Request) {
+	req, ok := listRequest(r)
+	if !ok {
+		writeSCIMError(w, http.StatusBadRequest, scim.ErrInvalidValue, "startIndex、count 须为整数")
+		return
+	}
+	resp, err := h.client.ListScimGroups(scimContext(r), req)
+	if err != nil {
+		writeSCIMStatus(w, err)
+		return
+	}
+	list := scimListResponse{
+		Schemas:      []string{scim.ListResponseSchema},
+		TotalResults: resp.TotalResults,
+		ItemsPerPage: len(resp.Resources),
+		StartIndex:   resp.StartIndex,
+		Resources:    make([]any, 0, len(resp.Resources)),
+	}
+	for _, g := range resp.Resources {
+		list.Resources = append(list.Resources, h.toGroup(r, g))
+	}
+	writeSCIMJSON(w, http.StatusOK, list)
+}
+
+func (h *scimServer) getGroup(w http.ResponseWriter, r *http.Request) {
+	g, err := h.client.GetScimGroup(scimContext(r), &api.ScimResourceRequest{Id: r.PathValue("id")})
+	if err != nil {
+		writeSCIMStatus(w, err)
+		return
+	}
+	writeSCIMJSON(w, http.StatusOK, h.toGroup(r, g))
+}
+
+// decodeGroup 解析用户组请求体；membersPresent 表示请求体中带有 members
+func decodeGroup(r *http.Request) (g *api.ScimGroup, membersPresent bool, ok bool) {
+	var in scimGroupInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		return nil, false, false
+	}
+	g = &api.ScimGroup{ExternalId: in.ExternalID, DisplayName: in.DisplayName}
+	for _, m := range in.Members {
+		g.Members = append(g.Members, &api.ScimRef{Value: m.Value})
+	}
+	return g, in.Members != nil, true
+}
+
+func (h *scimServer) createGroup(w http.ResponseWriter, r *http.Request) {
+	in, _, ok := decodeGroup(r)
+	if !ok {
+		writeSCIMError(w, http.StatusBadRequest, scim.ErrInvalidSyntax, "请求体不是合法的 JSON")
+		return
+	}
+	g, err := h.client.CreateScimGroup(scimContext(r), in)
+	if err != nil {
+		writeSCIMStatus(w, err)
+		return
+	}
+	res := h.toGroup(r, g)
+	w.Header().Set("Location", res.Meta.Location)
+	writeSCIMJSON(w, http.StatusCreated, res)
+}
+
+func (h *scimServer) replaceGroup(w http.ResponseWriter, r *http.Request) {
+	in, membersPresent, ok := decodeGroup(r)
+	if !ok {
+		writeSCIMError(w, http.StatusBadRequest, scim.ErrInvalidSyntax, "请求体不是合法的 JSON")
+		return
+	}
+	g, err := h.client.ReplaceScimGroup(scimContext(r), &api.ReplaceScimGroupRequest{
+		Id: r.PathValue("id"), Group: in, MembersPresent: membersPresent,
+	})
+	if err != nil {
+		writeSCIMStatus(w, err)
+		return
+	}
+	writeSCIMJSON(w, http.StatusOK, h.toGroup(r, g))
+}
+
+func (h *scimServer) patchGroup(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodePatch(r)
+	if !ok {
+		writeSCIMError(w, http.StatusBadRequest, scim.ErrInvalidSyntax, "请求体须为包含 Operations 的 PatchOp")
+		return
+	}
+	g, err := h.client.PatchScimGroup(scimContext(r), req)
+	if err != nil {
+		writeSCIMStatus(w, err)
+		return
+	}
+	writeSCIMJSON(w, http.StatusOK, h.toGroup(r, g))
+}
+
+func (h *scimServer) deleteGroup(w http.ResponseWriter, r *http.Request) {
+	if _, err := h.client.DeleteScimGroup(scimContext(r), &api.ScimResourceRequest{Id: r.PathValue("id")}); err != nil {
+		writeSCIMStatus(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serviceProviderConfig 声明支持的功能：PATCH、过滤、修改密码，不支持批量、排序和 ETag
+func (h *scimServer) serviceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	supported := func(ok bool) map[string]any { return map[string]any{"supported": ok} }
+	writeSCIMJSON(w, http.StatusOK, map[string]any{
+		"schemas":        []string{scim.ServiceProviderConfigSchema},
+		"patch":          supported(true),
+		"bulk":           map[string]any{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]any{"supported": true, "maxResults": scim.MaxCount},
+		"changePassword": supported(true),
+		"sort":           supported(false),
+		"etag":           supported(false),
+		"authenticationSchemes": []map[string]any{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer Token",
+			"description": "API Key（rbk_ 前缀）或访问令牌",
+			"primary":     true,
+		}},
+		"meta": scimMeta{ResourceType: "ServiceProviderConfig", Location: baseURL(r) + "/ServiceProviderConfig"},
+	})
+}
+
+func (h *scimServer) resourceTypes(w http.ResponseWriter, r *http.Request) {
+	base := baseURL(r)
+	types := []any{
+		map[string]any{
+			"schemas": []string{scim.ResourceTypeSchema}, "id": "User", "name": "User",
+			"endpoint": "/Users", "schema": scim.UserSchema,
+			"meta": scimMeta{ResourceType: "ResourceType", Location: base + "/ResourceTypes/User"},
+		},
+		map[string]any{
+			"schemas": []string{scim.ResourceTypeSchema}, "id": "Group", "name": "Group",
+			"endpoint": "/Groups", "schema": scim.GroupSchema,
+			"meta": scimMeta{ResourceType: "ResourceType", Location: base + "/ResourceTypes/Group"},
+		},
+	}
+	writeSCIMJSON(w, http.StatusOK, scimListResponse{
+		Schemas:      []string{scim.ListResponseSchema},
+		TotalResults: int32(len(types)),
+		ItemsPerPage: len(types),
+		StartIndex:   1,
+		Resources:    types,
+	})
+}
+
+// jsonBool 布尔值，兼容部分客户端发送的 "True"/"False"
+func jsonBool(v any) (bool, bool) {
+	switch val := v.(type) {
+	case bool:
+		return val, true
+	case string:
+		b, err := strconv.ParseBool(strings.ToLower(val))
+		return b, err == nil
+	}
+	return false, false
+}
+
+// jsonValues 多值属性的值列表，元素可以是字符串或 {"value": ...}
+func jsonValues(raw json.RawMessage) ([]string, bool) {
+	var items []any
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil, false
+	}
+	out := make([]string, 0, len(items))
+	for _, item := range items {
+		switch v := item.(type) {
+		case string:
+			out = append(out, v)
+		case map[string]any:
+			s, ok := v["value"].(string)
+			if !ok {
+				return nil, false
+			}
+			out = append(out, s)
+		default:
+			return nil, false
+		}
+	}
+	return out, true
+}
+
+// writeSCIMStatus gRPC 错误转为 SCIM 错误响应，scimType 取自 ErrorInfo.Reason
+func writeSCIMStatus(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	var scimType string
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok && info.Domain == scim.ErrorDomain {
+			scimType = info.Reason
+		}
+	}
+
+	code, detail := http.StatusInternalServerError, st.Message()
+	switch st.Code() {
+	case codes.InvalidArgument, codes.FailedPrecondition:
+		code = http.StatusBadRequest
+	case codes.Unauthenticated:
+		code = http.StatusUnauthorized
+		w.Header().Set("WWW-Authenticate", `Bearer realm="scim"`)
+	case codes.PermissionDenied:
+		code = http.StatusForbidden
+	case codes.NotFound:
+		code = http.StatusNotFound
+	case codes.AlreadyExists:
+		code = http.StatusConflict
+	case codes.ResourceExhausted:
+		code = http.StatusTooManyRequests
+	default:
+		detail = "服务器内部错误"
+	}
+	writeSCIMError(w, code, scimType, detail)
+}
+
+func writeSCIMError(w http.ResponseWriter, code int, scimType, detail string) {
+	writeSCIMJSON(w, code, scimErrorResponse{
+		Schemas:  []string{scim.ErrorSchema},
+		Status:   strconv.Itoa(code),
+		ScimType: scimType,
+		Detail:   detail,
+	})
+}
+
+func writeSCIMJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/scim+json;charset=UTF-8")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
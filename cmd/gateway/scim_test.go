@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/config"
+	"grpc-rbac-backend/internal/audit"
+	"grpc-rbac-backend/internal/middleware"
+	"grpc-rbac-backend/internal/model"
+	"grpc-rbac-backend/internal/oidc"
+	"grpc-rbac-backend/internal/password"
+	"grpc-rbac-backend/internal/rbac"
+	"grpc-rbac-backend/internal/scim"
+	"grpc-rbac-backend/internal/utils"
+)
+
+// SCIM 端到端测试：网关经内存连接调用带认证拦截器的 gRPC 服务，数据库为临时 SQLite
+var (
+	scimURL        string
+	scimAPIKey     string // 拥有 scim:provision 权限的服务账号的 API Key
+	scimAdminToken string // 租户管理员登录得到的 token
+)
+
+const testAdminPassword = "Passw0rd!2024"
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "gateway-test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	code := func() int {
+		defer os.RemoveAll(dir)
+		stop, err := startSCIMTestServer(filepath.Join(dir, "rbac.db"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer stop()
+		return m.Run()
+	}()
+	os.Exit(code)
+}
+
+func startSCIMTestServer(dbPath string) (func(), error) {
+	db, err := gorm.Open(sqlite.Open(dbPath+"?_busy_timeout=5000&_journal_mode=WAL"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return nil, err
+	}
+	if err := model.Migrate(db); err != nil {
+		return nil, err
+	}
+	model.DB = db
+	audit.SigningKey = []byte("test-signing-key")
+
+	cfg := &config.Config{
+		LoginMaxFailures:   5,
+		LoginIPMaxFailures: 50,
+		LoginBackoff:       time.Second,
+		LoginLockout:       15 * time.Minute,
+		PasswordMinLength:  8,
+		PasswordMinClasses: 3,
+		PasswordHistory:    5,
+		RefreshTokenTTL:    time.Hour,
+		OIDCIssuer:         "http://localhost:8080",
+	}
+	namespaces, err := rbac.LoadNamespaceConfig("")
+	if err != nil {
+		return nil, err
+	}
+	passwords, err := password.NewPolicy(cfg.PasswordMinLength, cfg.PasswordMinClasses, "")
+	if err != nil {
+		return nil, err
+	}
+	signer, err := oidc.LoadSigner("")
+	if err != nil {
+		return nil, err
+	}
+	providers, _ := rbac.LoadFederationConfig("")
+	directories, _ := rbac.LoadDirectoryConfig("")
+	svc := rbac.NewRBACService(cfg, namespaces, passwords, signer, providers, directories)
+	middleware.APIKeyAuthenticator = svc.AuthenticateAPIKey
+
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(grpc.UnaryInterceptor(middleware.AuthInterceptor))
+	api.RegisterRBACServiceServer(server, svc)
+	go server.Serve(lis)
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	httpServer := httptest.NewServer(scimHandler(api.NewRBACServiceClient(conn)))
+	scimURL = httpServer.URL + scimBasePath
+
+	if err := seedSCIMTenant(svc); err != nil {
+		return nil, err
+	}
+	return func() {
+		httpServer.Close()
+		conn.Close()
+		server.Stop()
+	}, nil
+}
+
+// seedSCIMTenant 创建租户、管理员和拥有 scim:provision 权限的服务账号
+func seedSCIMTenant(svc *rbac.Service) error {
+	tenant := model.Tenant{Name: "acme"}
+	admin, err := model.CreateTenantWithAdmin(model.DB, &tenant, "admin", testAdminPassword)
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{"developer", "eng-lead"} {
+		if err := model.DB.Create(&model.Role{TenantID: tenant.ID, Name: name}).Error; err != nil {
+			return err
+		}
+	}
+	adminCtx := context.WithValue(context.Background(), middleware.ContextUserKey,
+		&utils.CustomClaims{TenantID: tenant.ID, Tenant: tenant.Name, Username: admin.Username, Roles: []string{"admin"}})
+
+	sa, err := svc.CreateServiceAccount(adminCtx, &api.CreateServiceAccountRequest{Name: "hr-sync"})
+	if err != nil {
+		return err
+	}
+	role := model.Role{TenantID: tenant.ID, Name: "provisioner", Permissions: []model.Permission{{TenantID: tenant.ID, Name: "scim:provision"}}}
+	if err := model.DB.Create(&role).Error; err != nil {
+		return err
+	}
+	if err := model.DB.Create(&model.UserRole{UserID: uint(sa.UserId), RoleID: role.ID}).Error; err != nil {
+		return err
+	}
+	key, err := svc.CreateAPIKey(adminCtx, &api.CreateAPIKeyRequest{ServiceAccountId: sa.UserId, Name: "scim", Scopes: []string{"scim:provision"}})
+	if err != nil {
+		return err
+	}
+	scimAPIKey = key.Key
+
+	login, err := svc.Login(context.Background(), &api.LoginRequest{Tenant: tenant.Name, Username: admin.Username, Password: testAdminPassword})
+	if err != nil {
+		return err
+	}
+	scimAdminToken = login.Token
+	return nil
+}
+
+// scimDo 发送 SCIM 请求，token 为空时不带 Authorization
+func scimDo(t *testing.T, method, path, token, body string) (int, http.Header, map[string]any) {
+	t.Helper()
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, scimURL+path, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/scim+json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var out map[string]any
+	if resp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			t.Fatalf("%s %s: 响应不是 JSON: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode, resp.Header, out
+}
+
+func wantStatus(t *testing.T, got, want int, body map[string]any) {
+	t.Helper()
+	if got != want {
+		t.Fatalf("HTTP %d（%v），期望 %d", got, body, want)
+	}
+}
+
+func TestSCIMBearerAuth(t *testing.T) {
+	for _, path := range []string{"/Users", "/Groups"} {
+		for name, token := range map[string]string{
+			"缺少 token":    "",
+			"无效的 JWT":     "not-a-jwt",
+			"无效的 API Key": "rbk_000000000000_bad",
+		} {
+			code, header, body := scimDo(t, http.MethodGet, path, token, "")
+			if code != http.StatusUnauthorized || header.Get("WWW-Authenticate") == "" {
+				t.Errorf("%s %s: HTTP %d，期望 401 并带 WWW-Authenticate", path, name, code)
+			}
+			if schemas, _ := body["schemas"].([]any); len(schemas) != 1 || schemas[0] != scim.ErrorSchema || body["status"] != "401" {
+				t.Errorf("%s %s: 错误响应 = %v", path, name, body)
+			}
+		}
+		for name, token := range map[string]string{"API Key": scimAPIKey, "管理员 JWT": scimAdminToken} {
+			code, _, body := scimDo(t, http.MethodGet, path, token, "")
+			if code != http.StatusOK {
+				t.Errorf("%s %s: HTTP %d（%v），期望 200", path, name, code, body)
+			}
+		}
+	}
+}
+
+func TestSCIMUsers(t *testing.T) {
+	for _, name := range []string{"xavier", "xena", "yuri"} {
+		code, header, body := scimDo(t, http.MethodPost, "/Users", scimAPIKey,
+			`{"schemas":["`+scim.UserSchema+`"],"userName":"`+name+`","roles":[{"value":"developer"}]}`)
+		wantStatus(t, code, http.StatusCreated, body)
+		if !strings.HasSuffix(header.Get("Location"), "/Users/"+body["id"].(string)) {
+			t.Errorf("Location = %s", header.Get("Location"))
+		}
+	}
+	code, _, body := scimDo(t, http.MethodPost, "/Users", scimAPIKey, `{"userName":"xena"}`)
+	wantStatus(t, code, http.StatusConflict, body)
+	if body["scimType"] != scim.ErrUniqueness {
+		t.Errorf("重复用户名的 scimType = %v", body["scimType"])
+	}
+
+	// 过滤和分页
+	q := url.Values{"filter": {`userName sw "x"`}, "startIndex": {"2"}, "count": {"1"}}
+	code, _, body = scimDo(t, http.MethodGet, "/Users?"+q.Encode(), scimAPIKey, "")
+	wantStatus(t, code, http.StatusOK, body)
+	resources, _ := body["Resources"].([]any)
+	if body["totalResults"] != 2.0 || body["itemsPerPage"] != 1.0 || body["startIndex"] != 2.0 || len(resources) != 1 ||
+		resources[0].(map[string]any)["userName"] != "xena" {
+		t.Errorf("分页结果 = %v", body)
+	}
+	code, _, body = scimDo(t, http.MethodGet, "/Users?filter="+url.QueryEscape(`userName zz "x"`), scimAPIKey, "")
+	wantStatus(t, code, http.StatusBadRequest, body)
+	if body["scimType"] != scim.ErrInvalidFilter {
+		t.Errorf("非法过滤条件的 scimType = %v", body["scimType"])
+	}
+	code, _, body = scimDo(t, http.MethodGet, "/Users?count=abc", scimAPIKey, "")
+	wantStatus(t, code, http.StatusBadRequest, body)
+
+	// PATCH
+	code, _, body = scimDo(t, http.MethodGet, "/Users?filter="+url.QueryEscape(`userName eq "yuri"`), scimAPIKey, "")
+	wantStatus(t, code, http.StatusOK, body)
+	id := body["Resources"].([]any)[0].(map[string]any)["id"].(string)
+	code, _, body = scimDo(t, http.MethodPatch, "/Users/"+id, scimAPIKey, `{"schemas":["`+scim.PatchOpSchema+`"],"Operations":[
+		{"op":"replace","value":{"active":false,"externalId":"E9"}},
+		{"op":"remove","path":"roles[value eq \"developer\"]"}]}`)
+	wantStatus(t, code, http.StatusOK, body)
+	if body["active"] != false || body["externalId"] != "E9" || body["roles"] != nil {
+		t.Errorf("PATCH 结果 = %v", body)
+	}
+	code, _, body = scimDo(t, http.MethodPatch, "/Users/"+id, scimAPIKey, `{"Operations":[]}`)
+	wantStatus(t, code, http.StatusBadRequest, body)
+
+	// provisioner 不能授予 admin 角色
+	code, _, body = scimDo(t, http.MethodPatch, "/Users/"+id, scimAPIKey, `{"Operations":[{"op":"add","path":"roles","value":[{"value":"admin"}]}]}`)
+	wantStatus(t, code, http.StatusForbidden, body)
+
+	code, _, body = scimDo(t, http.MethodDelete, "/Users/"+id, scimAPIKey, "")
+	wantStatus(t, code, http.StatusNoContent, body)
+	code, _, body = scimDo(t, http.MethodGet, "/Users/"+id, scimAPIKey, "")
+	wantStatus(t, code, http.StatusNotFound, body)
+}
+
+func TestSCIMGroups(t *testing.T) {
+	code, _, body := scimDo(t, http.MethodPost, "/Users", scimAPIKey, `{"userName":"zoe"}`)
+	wantStatus(t, code, http.StatusCreated, body)
+	zoe := body["id"].(string)
+
+	code, _, body = scimDo(t, http.MethodPost, "/Groups", scimAPIKey, `{"schemas":["`+scim.GroupSchema+`"],"displayName":"platform"}`)
+	wantStatus(t, code, http.StatusCreated, body)
+	id := body["id"].(string)
+	if members, _ := body["members"].([]any); members == nil || len(members) != 0 {
+		t.Errorf("空用户组的 members = %v，期望 []", body["members"])
+	}
+
+	code, _, body = scimDo(t, http.MethodPatch, "/Groups/"+id, scimAPIKey, `{"Operations":[
+		{"op":"add","path":"members","value":[{"value":"`+zoe+`"}]},
+		{"op":"replace","path":"displayName","value":"platform-eng"}]}`)
+	wantStatus(t, code, http.StatusOK, body)
+	members, _ := body["members"].([]any)
+	if body["displayName"] != "platform-eng" || len(members) != 1 || members[0].(map[string]any)["display"] != "zoe" {
+		t.Errorf("PATCH 结果 = %v", body)
+	}
+
+	code, _, body = scimDo(t, http.MethodGet, "/Groups?filter="+url.QueryEscape(`displayName eq "platform-eng"`), scimAPIKey, "")
+	wantStatus(t, code, http.StatusOK, body)
+	if body["totalResults"] != 1.0 {
+		t.Errorf("过滤结果 = %v", body)
+	}
+
+	code, _, body = scimDo(t, http.MethodPatch, "/Groups/"+id, scimAPIKey, `{"Operations":[{"op":"remove","path":"members[value eq \"`+zoe+`\"]"}]}`)
+	wantStatus(t, code, http.StatusOK, body)
+	if members, _ := body["members"].([]any); len(members) != 0 {
+		t.Errorf("删除成员后 members = %v", members)
+	}
+
+	code, _, body = scimDo(t, http.MethodDelete, "/Groups/"+id, scimAPIKey, "")
+	wantStatus(t, code, http.StatusNoContent, body)
+	code, _, body = scimDo(t, http.MethodGet, "/Groups/"+id, scimAPIKey, "")
+	wantStatus(t, code, http.StatusNotFound, body)
+}
@@ -0,0 +1,215 @@
+// mock-idp 本地开发用的外部 OIDC 身份提供方：登录页输入任意用户名和组即可登录，
+// 用于联调联合登录，不校验密码，不可用于生产
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"flag"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"grpc-rbac-backend/internal/oidc"
+	"grpc-rbac-backend/internal/utils"
+)
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Mock IdP</title></head>
+<body>
+<h3>Mock IdP 登录</h3>
+<form method="post" action="/authorize">
+  {{range $k, $v := .}}<input type="hidden" name="{{$k}}" value="{{index $v 0}}">{{end}}
+  <p>用户名 <input name="username" required></p>
+  <p>组（逗号分隔）<input name="groups"></p>
+  <button type="submit">登录</button>
+</form>
+</body></html>`))
+
+// pendingCode 已签发、尚未换取 token 的授权码
+type pendingCode struct {
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+	username      string
+	groups        []string
+	expiresAt     time.Time
+}
+
+type idp struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	signer       *oidc.Signer
+
+	mu    sync.Mutex
+	codes map[string]*pendingCode
+}
+
+func main() {
+	addr := flag.String("addr", ":9000", "监听地址")
+	issuer := flag.String("issuer", "http://localhost:9000", "issuer，须与身份提供方配置一致")
+	clientID := flag.String("client-id", "rbac-backend", "允许的 client_id")
+	clientSecret := flag.String("client-secret", "mock-secret", "client_secret")
+	jwksOut := flag.String("jwks-out", "mock-idp-jwks.json", "公钥写入的文件，供 jwks_file 配置使用")
+	flag.Parse()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatalf("❌ 生成签名密钥失败: %v", err)
+	}
+	p := &idp{
+		issuer:       strings.TrimRight(*issuer, "/"),
+		clientID:     *clientID,
+		clientSecret: *clientSecret,
+		signer:       oidc.NewSigner(key),
+		codes:        map[string]*pendingCode{},
+	}
+	jwks, err := p.signer.JWKS()
+	if err != nil {
+		log.Fatalf("❌ 生成 JWKS 失败: %v", err)
+	}
+	if err := os.WriteFile(*jwksOut, jwks, 0o644); err != nil {
+		log.Fatalf("❌ 写入 JWKS 失败: %v", err)
+	}
+	log.Printf("✅ JWKS 已写入 %s，每次启动都会生成新密钥", *jwksOut)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(jwks)
+	})
+
+	log.Printf("🚀 Mock IdP 监听 %s，issuer=%s", *addr, p.issuer)
+	if err := http.ListenAndServe(*addr, mux); err != nil {
+		log.Fatalf("❌ 启动失败: %v", err)
+	}
+}
+
+func (p *idp) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/authorize",
+		"token_endpoint":                        p.issuer + "/token",
+		"jwks_uri":                              p.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+// authorize GET 显示登录页，POST 签发授权码并跳转回 redirect_uri
+func (p *idp) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.Form.Get("client_id") != p.clientID || r.Form.Get("redirect_uri") == "" {
+		http.Error(w, "client_id 或 redirect_uri 无效", http.StatusBadRequest)
+		return
+	}
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = loginPage.Execute(w, r.URL.Query())
+		return
+	}
+
+	if r.Form.Get("code_challenge_method") != "S256" || r.Form.Get("code_challenge") == "" {
+		http.Error(w, "须使用 PKCE S256", http.StatusBadRequest)
+		return
+	}
+	var groups []string
+	for _, g := range strings.Split(r.Form.Get("groups"), ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			groups = append(groups, g)
+		}
+	}
+	code, err := utils.RandomToken(24)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	p.mu.Lock()
+	p.codes[code] = &pendingCode{
+		redirectURI:   r.Form.Get("redirect_uri"),
+		nonce:         r.Form.Get("nonce"),
+		codeChallenge: r.Form.Get("code_challenge"),
+		username:      r.Form.Get("username"),
+		groups:        groups,
+		expiresAt:     time.Now().Add(time.Minute),
+	}
+	p.mu.Unlock()
+
+	u, err := url.Parse(r.Form.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q := u.Query()
+	q.Set("code", code)
+	q.Set("state", r.Form.Get("state"))
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// token 授权码换取 ID Token，授权码只能使用一次
+func (p *idp) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.Method != http.MethodPost {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	if r.PostForm.Get("client_id") != p.clientID || r.PostForm.Get("client_secret") != p.clientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	pc := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+	if pc == nil || time.Now().After(pc.expiresAt) || pc.redirectURI != r.PostForm.Get("redirect_uri") ||
+		!oidc.VerifyPKCE(pc.codeChallenge, "S256", r.PostForm.Get("code_verifier")) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	idToken, err := p.signer.Sign(jwt.MapClaims{
+		"iss":                p.issuer,
+		"sub":                "mock-" + pc.username,
+		"aud":                p.clientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"nonce":              pc.nonce,
+		"preferred_username": pc.username,
+		"groups":             pc.groups,
+	})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": idToken,
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/config"
+	"grpc-rbac-backend/internal/audit"
+)
+
+const usage = `用法:
+  rbac-audit verify                                  在线校验审计哈希链
+  rbac-audit export [-start ID] [-end ID] -o FILE    导出签名 JSONL 用于归档
+  rbac-audit verify-file FILE                        离线校验导出文件`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+
+	cfg := config.Load()
+
+	switch os.Args[1] {
+	case "verify":
+		runVerify(cfg)
+	case "export":
+		runExport(cfg, os.Args[2:])
+	case "verify-file":
+		runVerifyFile(cfg, os.Args[2:])
+	default:
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+}
+
+// dial 连接服务并以管理员身份登录，返回带 token 的上下文
+func dial(cfg *config.Config) (api.RBACServiceClient, context.Context, func()) {
+	conn, err := grpc.NewClient("127.0.0.1:50051", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatalf("无法连接服务: %v", err)
+	}
+	client := api.NewRBACServiceClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	loginResp, err := client.Login(ctx, &api.LoginRequest{
+		Username: cfg.AdminUsername,
+		Password: cfg.AdminPassword,
+	})
+	if err != nil {
+		log.Fatalf("登录失败: %v", err)
+	}
+	md := metadata.New(map[string]string{"authorization": "Bearer " + loginResp.Token})
+
+	return client, metadata.NewOutgoingContext(ctx, md), func() {
+		cancel()
+		_ = conn.Close()
+	}
+}
+
+func runVerify(cfg *config.Config) {
+	client, ctx, closeFn := dial(cfg)
+	defer closeFn()
+
+	resp, err := client.VerifyAuditChain(ctx, &api.VerifyAuditChainRequest{})
+	if err != nil {
+		log.Fatalf("校验失败: %v", err)
+	}
+	if !resp.Ok {
+		log.Fatalf("❌ 审计链在记录 %d 处断裂（已校验 %d 条）: %s", resp.BrokenEventId, resp.Checked, resp.Reason)
+	}
+	log.Printf("✅ 审计链完整，共校验 %d 条记录", resp.Checked)
+}
+
+func runExport(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	start := fs.Uint("start", 0, "起始记录 ID")
+	end := fs.Uint("end", 0, "结束记录 ID，0 表示不限")
+	out := fs.String("o", "", "输出文件")
+	_ = fs.Parse(args)
+	if *out == "" {
+		log.Fatal("请通过 -o 指定输出文件")
+	}
+
+	client, ctx, closeFn := dial(cfg)
+	defer closeFn()
+
+	body, err := client.ExportAuditEvents(ctx, &api.ExportAuditEventsRequest{
+		StartId: uint32(*start),
+		EndId:   uint32(*end),
+	})
+	if err != nil {
+		log.Fatalf("导出失败: %v", err)
+	}
+	if err := os.WriteFile(*out, body.Data, 0o600); err != nil {
+		log.Fatalf("写入文件失败: %v", err)
+	}
+	log.Printf("✅ 已导出到 %s", *out)
+
+	// 单次导出有条数上限，被截断时提示从下一条继续导出
+	trailer, err := audit.ParseTrailer(body.Data)
+	if err != nil {
+		log.Fatalf("解析签名行失败: %v", err)
+	}
+	if trailer.Truncated {
+		log.Printf("⚠️ 已达到单次导出上限，仅导出到 ID %d，请使用 -start %d 继续导出", trailer.LastID, trailer.NextID)
+	}
+}
+
+func runVerifyFile(cfg *config.Config, args []string) {
+	if len(args) < 1 {
+		log.Fatal("请指定要校验的文件")
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		log.Fatalf("打开文件失败: %v", err)
+	}
+	defer f.Close()
+
+	trailer, err := audit.VerifyJSONL(f, []byte(cfg.AuditSigningKey))
+	if err != nil {
+		log.Fatalf("❌ 校验失败: %v", err)
+	}
+	log.Printf("✅ 文件完整，共 %d 条记录（ID %d - %d）", trailer.Count, trailer.FirstID, trailer.LastID)
+	if trailer.Truncated {
+		log.Printf("⚠️ 该文件不是区间内的全部记录，下一页从 ID %d 开始", trailer.NextID)
+	}
+}
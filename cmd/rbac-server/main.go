@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"grpc-rbac-backend/config"
 	"grpc-rbac-backend/internal/model"
@@ -17,7 +18,12 @@ import (
 	"google.golang.org/grpc/reflection"
 
 	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/audit"
+	"grpc-rbac-backend/internal/decision"
 	"grpc-rbac-backend/internal/middleware"
+	"grpc-rbac-backend/internal/notify"
+	"grpc-rbac-backend/internal/oidc"
+	"grpc-rbac-backend/internal/password"
 	"grpc-rbac-backend/internal/rbac"
 )
 
@@ -59,6 +65,24 @@ func main() {
 	// ✅ 初始化数据库连接 + 自动建表 + 自动创建admin
 	model.InitDB(cfg.MysqlDsn, cfg.AdminUsername, cfg.AdminPassword)
 
+	// 审计导出签名密钥
+	audit.SigningKey = []byte(cfg.AuditSigningKey)
+
+	// 授权判定日志
+	decisionLogger, err := decision.Open(cfg.DecisionLogSinks, cfg.DecisionLogFile, cfg.DecisionLogAllowRate, cfg.DecisionLogDenyRate)
+	if err != nil {
+		log.Fatalf("❌ 初始化判定日志失败: %v", err)
+	}
+	decision.SetDefault(decisionLogger)
+	defer decisionLogger.Close()
+
+	// 通知投递方式（密码重置等）
+	notifier, err := notify.Open(cfg.Notifier, cfg.NotifierFile)
+	if err != nil {
+		log.Fatalf("❌ 初始化通知失败: %v", err)
+	}
+	notify.SetDefault(notifier)
+
 	const (
 		port        = 50051
 		serviceID   = "rbac-service-1"
@@ -83,8 +107,39 @@ func main() {
 		grpc.UnaryInterceptor(middleware.AuthInterceptor),
 	)
 
+	// 关系元组的命名空间配置
+	namespaces, err := rbac.LoadNamespaceConfig(cfg.NamespaceConfig)
+	if err != nil {
+		log.Fatalf("❌ 加载命名空间配置失败: %v", err)
+	}
+
+	// 密码策略
+	passwords, err := password.NewPolicy(cfg.PasswordMinLength, cfg.PasswordMinClasses, cfg.PasswordBlocklistFile)
+	if err != nil {
+		log.Fatalf("❌ 加载密码策略失败: %v", err)
+	}
+
+	// OIDC ID Token 签名密钥
+	signer, err := oidc.LoadSigner(cfg.OIDCSigningKeyFile)
+	if err != nil {
+		log.Fatalf("❌ 加载 OIDC 签名密钥失败: %v", err)
+	}
+
+	// 外部身份提供方
+	providers, err := rbac.LoadFederationConfig(cfg.IDPConfig)
+	if err != nil {
+		log.Fatalf("❌ 加载身份提供方配置失败: %v", err)
+	}
+
+	// LDAP 目录
+	directories, err := rbac.LoadDirectoryConfig(cfg.LDAPConfig)
+	if err != nil {
+		log.Fatalf("❌ 加载 LDAP 目录配置失败: %v", err)
+	}
+
 	// 注册 RBAC 业务服务
-	rbacService := rbac.NewRBACService()
+	rbacService := rbac.NewRBACService(cfg, namespaces, passwords, signer, providers, directories)
+	middleware.APIKeyAuthenticator = rbacService.AuthenticateAPIKey
 	api.RegisterRBACServiceServer(grpcServer, rbacService)
 
 	// 注册健康检查服务
@@ -108,6 +163,12 @@ func main() {
 	}()
 	log.Printf("🚀 RBAC gRPC 服务启动成功，监听端口: %d", port)
 
+	// 启动后台清理任务
+	sweepCtx, stopSweeper := context.WithCancel(context.Background())
+	defer stopSweeper()
+	rbacService.StartSweeper(sweepCtx)
+	rbacService.StartDirectorySync(sweepCtx)
+
 	// 等待系统信号优雅关闭
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -3,6 +3,8 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -12,6 +14,71 @@ type Config struct {
 	AdminUsername string
 	AdminPassword string
 	Addr          string
+
+	AuditSigningKey string
+
+	// 判定日志：输出目标（stdout、file，逗号分隔，留空关闭）及允许/拒绝的采样率
+	DecisionLogSinks     string
+	DecisionLogFile      string
+	DecisionLogAllowRate float64
+	DecisionLogDenyRate  float64
+
+	// 后台清理任务（过期角色分配等）的执行间隔
+	SweepInterval time.Duration
+
+	// 临时权限申请：单次申请的最长时长，以及待审批申请的有效期
+	AccessRequestMaxDuration time.Duration
+	AccessRequestPendingTTL  time.Duration
+
+	// 关系元组的命名空间配置文件，留空使用内置示例配置
+	NamespaceConfig string
+
+	// 登录限流：同一用户名、同一 IP 的最大连续失败次数，首次失败后的退避时长及锁定时长
+	LoginMaxFailures   int
+	LoginIPMaxFailures int
+	LoginBackoff       time.Duration
+	LoginLockout       time.Duration
+
+	// 多因素认证：拥有这些角色（逗号分隔）的用户必须启用 TOTP；签发方名称显示在身份验证器中
+	MFARequiredRoles string
+	MFAIssuer        string
+
+	// 密码策略：最小长度、至少包含的字符类别数、弱密码列表文件（留空使用内置列表），
+	// 禁止重复使用最近几次的密码，以及密码最长有效期（0 表示不过期）
+	PasswordMinLength     int
+	PasswordMinClasses    int
+	PasswordBlocklistFile string
+	PasswordHistory       int
+	PasswordMaxAge        time.Duration
+
+	// 密码重置 token 的有效期，以及重置链接前缀（留空时通知中只包含 token）
+	PasswordResetTTL time.Duration
+	PasswordResetURL string
+
+	// 通知投递方式：log 输出到服务日志，file 追加写入 NotifierFile
+	Notifier     string
+	NotifierFile string
+
+	// refresh token 的有效期，即一次登录会话的最长时长；为 0 时不签发 refresh token
+	RefreshTokenTTL time.Duration
+
+	// OIDC 提供方：issuer 为网关对外地址，ID Token 签名密钥为 RSA 私钥 PEM 文件（留空时临时生成）
+	OIDCIssuer         string
+	OIDCSigningKeyFile string
+
+	// 外部 OIDC 身份提供方配置文件（JSON），为空时不启用联合登录
+	IDPConfig string
+
+	// LDAP / Active Directory 目录配置文件（JSON），为空时不启用；目录同步的间隔，为 0 时只能手动同步
+	LDAPConfig       string
+	LDAPSyncInterval time.Duration
+
+	// 已删除用户的保留期，期内可以恢复，之后由清理任务彻底删除
+	UserDeleteRetention time.Duration
+
+	// 邮箱验证 token 的有效期，以及验证链接前缀（留空时通知中只包含 token）
+	EmailVerificationTTL time.Duration
+	EmailVerificationURL string
 }
 
 func getEnv(k, d string) string {
@@ -21,6 +88,45 @@ func getEnv(k, d string) string {
 	return d
 }
 
+func getEnvFloat(k string, d float64) float64 {
+	v := os.Getenv(k)
+	if v == "" {
+		return d
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Printf("Warning: invalid %s=%q, using default %v", k, v, d)
+		return d
+	}
+	return f
+}
+
+func getEnvInt(k string, d int) int {
+	v := os.Getenv(k)
+	if v == "" {
+		return d
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("Warning: invalid %s=%q, using default %v", k, v, d)
+		return d
+	}
+	return n
+}
+
+func getEnvDuration(k string, d time.Duration) time.Duration {
+	v := os.Getenv(k)
+	if v == "" {
+		return d
+	}
+	dur, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("Warning: invalid %s=%q, using default %v", k, v, d)
+		return d
+	}
+	return dur
+}
+
 func Load() *Config {
 	// 加载 .env 文件
 	if err := godotenv.Load(); err != nil {
@@ -35,6 +141,55 @@ func Load() *Config {
 		AdminUsername: getEnv("ADMIN_USERNAME", "admin"),
 		AdminPassword: getEnv("ADMIN_PASSWORD", "123456"),
 		Addr:          getEnv("ADDR", ":8080"),
+
+		AuditSigningKey: getEnv("AUDIT_SIGNING_KEY", ""),
+
+		DecisionLogSinks:     getEnv("DECISION_LOG_SINKS", ""),
+		DecisionLogFile:      getEnv("DECISION_LOG_FILE", "decisions.jsonl"),
+		DecisionLogAllowRate: getEnvFloat("DECISION_LOG_ALLOW_RATE", 0.1),
+		DecisionLogDenyRate:  getEnvFloat("DECISION_LOG_DENY_RATE", 1),
+
+		SweepInterval: getEnvDuration("SWEEP_INTERVAL", time.Minute),
+
+		AccessRequestMaxDuration: getEnvDuration("ACCESS_REQUEST_MAX_DURATION", 8*time.Hour),
+		AccessRequestPendingTTL:  getEnvDuration("ACCESS_REQUEST_PENDING_TTL", 24*time.Hour),
+
+		NamespaceConfig: getEnv("NAMESPACE_CONFIG", ""),
+
+		LoginMaxFailures:   getEnvInt("LOGIN_MAX_FAILURES", 5),
+		LoginIPMaxFailures: getEnvInt("LOGIN_IP_MAX_FAILURES", 50),
+		LoginBackoff:       getEnvDuration("LOGIN_BACKOFF", time.Second),
+		LoginLockout:       getEnvDuration("LOGIN_LOCKOUT", 15*time.Minute),
+
+		MFARequiredRoles: getEnv("MFA_REQUIRED_ROLES", ""),
+		MFAIssuer:        getEnv("MFA_ISSUER", "grpc-rbac-backend"),
+
+		PasswordMinLength:     getEnvInt("PASSWORD_MIN_LENGTH", 8),
+		PasswordMinClasses:    getEnvInt("PASSWORD_MIN_CLASSES", 3),
+		PasswordBlocklistFile: getEnv("PASSWORD_BLOCKLIST_FILE", ""),
+		PasswordHistory:       getEnvInt("PASSWORD_HISTORY", 5),
+		PasswordMaxAge:        getEnvDuration("PASSWORD_MAX_AGE", 0),
+
+		PasswordResetTTL: getEnvDuration("PASSWORD_RESET_TTL", 30*time.Minute),
+		PasswordResetURL: getEnv("PASSWORD_RESET_URL", ""),
+
+		Notifier:     getEnv("NOTIFIER", "log"),
+		NotifierFile: getEnv("NOTIFIER_FILE", "notifications.jsonl"),
+
+		RefreshTokenTTL: getEnvDuration("REFRESH_TOKEN_TTL", 7*24*time.Hour),
+
+		OIDCIssuer:         getEnv("OIDC_ISSUER", "http://localhost:8080"),
+		OIDCSigningKeyFile: getEnv("OIDC_SIGNING_KEY_FILE", ""),
+
+		IDPConfig: getEnv("IDP_CONFIG", ""),
+
+		LDAPConfig:       getEnv("LDAP_CONFIG", ""),
+		LDAPSyncInterval: getEnvDuration("LDAP_SYNC_INTERVAL", time.Hour),
+
+		UserDeleteRetention: getEnvDuration("USER_DELETE_RETENTION", 30*24*time.Hour),
+
+		EmailVerificationTTL: getEnvDuration("EMAIL_VERIFICATION_TTL", 24*time.Hour),
+		EmailVerificationURL: getEnv("EMAIL_VERIFICATION_URL", ""),
 	}
 
 	// 调试信息
@@ -43,6 +198,23 @@ func Load() *Config {
 	log.Printf("Admin Username: %s", cfg.AdminUsername)
 	log.Printf("Admin Password: %s", cfg.AdminPassword)
 	log.Printf("Address: %s", cfg.Addr)
+	log.Printf("Audit Signing Key Set: %v", cfg.AuditSigningKey != "")
+	log.Printf("Decision Log: sinks=%q allow=%v deny=%v", cfg.DecisionLogSinks, cfg.DecisionLogAllowRate, cfg.DecisionLogDenyRate)
+	log.Printf("Sweep Interval: %v", cfg.SweepInterval)
+	log.Printf("Access Request: max=%v pending=%v", cfg.AccessRequestMaxDuration, cfg.AccessRequestPendingTTL)
+	log.Printf("Namespace Config: %q", cfg.NamespaceConfig)
+	log.Printf("Login Throttle: user=%d ip=%d backoff=%v lockout=%v", cfg.LoginMaxFailures, cfg.LoginIPMaxFailures, cfg.LoginBackoff, cfg.LoginLockout)
+	log.Printf("MFA: required roles=%q issuer=%q", cfg.MFARequiredRoles, cfg.MFAIssuer)
+	log.Printf("Password Policy: min=%d classes=%d blocklist=%q history=%d maxAge=%v",
+		cfg.PasswordMinLength, cfg.PasswordMinClasses, cfg.PasswordBlocklistFile, cfg.PasswordHistory, cfg.PasswordMaxAge)
+	log.Printf("Password Reset: ttl=%v url=%q", cfg.PasswordResetTTL, cfg.PasswordResetURL)
+	log.Printf("Notifier: %s file=%q", cfg.Notifier, cfg.NotifierFile)
+	log.Printf("Refresh Token TTL: %v", cfg.RefreshTokenTTL)
+	log.Printf("OIDC: issuer=%q signing key=%q", cfg.OIDCIssuer, cfg.OIDCSigningKeyFile)
+	log.Printf("Identity Providers: %q", cfg.IDPConfig)
+	log.Printf("LDAP: config=%q sync interval=%v", cfg.LDAPConfig, cfg.LDAPSyncInterval)
+	log.Printf("User Delete Retention: %v", cfg.UserDeleteRetention)
+	log.Printf("Email Verification: ttl=%v url=%q", cfg.EmailVerificationTTL, cfg.EmailVerificationURL)
 	log.Printf("============================")
 
 	return cfg
@@ -0,0 +1,112 @@
+package abac
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+)
+
+// Input 条件表达式可访问的属性
+//   - subject: 当前用户，包含 id、username、tenant、roles、email、email_verified、display_name，
+//     以及按租户定义的类型转换后的自定义属性 attributes
+//   - resource: 调用方传入的资源属性
+//   - context: 调用方传入的请求上下文，如 ip、时间等
+type Input struct {
+	Subject  map[string]any
+	Resource map[string]string
+	Context  map[string]string
+}
+
+var (
+	envOnce sync.Once
+	env     *cel.Env
+	envErr  error
+
+	// 已编译的表达式，按表达式文本缓存
+	programs sync.Map
+)
+
+func celEnv() (*cel.Env, error) {
+	envOnce.Do(func() {
+		env, envErr = cel.NewEnv(
+			cel.Variable("subject", cel.MapType(cel.StringType, cel.DynType)),
+			cel.Variable("resource", cel.MapType(cel.StringType, cel.StringType)),
+			cel.Variable("context", cel.MapType(cel.StringType, cel.StringType)),
+			// inCidr(ip, cidr) 判断 IP 是否属于指定网段
+			cel.Function("inCidr",
+				cel.Overload("in_cidr_string_string",
+					[]*cel.Type{cel.StringType, cel.StringType}, cel.BoolType,
+					cel.BinaryBinding(inCidr))),
+		)
+	})
+	return env, envErr
+}
+
+func inCidr(ip, cidr ref.Val) ref.Val {
+	_, network, err := net.ParseCIDR(string(cidr.(types.String)))
+	if err != nil {
+		return types.NewErr("无效的网段: %s", cidr)
+	}
+	addr := net.ParseIP(string(ip.(types.String)))
+	return types.Bool(addr != nil && network.Contains(addr))
+}
+
+// Compile 编译并校验条件表达式，表达式结果必须为 bool
+func Compile(expr string) (cel.Program, error) {
+	if prg, ok := programs.Load(expr); ok {
+		return prg.(cel.Program), nil
+	}
+	e, err := celEnv()
+	if err != nil {
+		return nil, err
+	}
+	ast, iss := e.Compile(expr)
+	if iss.Err() != nil {
+		return nil, iss.Err()
+	}
+	if ast.OutputType() != cel.BoolType {
+		return nil, fmt.Errorf("条件表达式的结果必须为 bool，实际为 %v", ast.OutputType())
+	}
+	prg, err := e.Program(ast)
+	if err != nil {
+		return nil, err
+	}
+	programs.Store(expr, prg)
+	return prg, nil
+}
+
+// Evaluate 对输入求值条件表达式；引用不存在的属性等运行时错误会返回 error
+func Evaluate(expr string, in *Input) (bool, error) {
+	prg, err := Compile(expr)
+	if err != nil {
+		return false, err
+	}
+	subject := in.Subject
+	if subject == nil {
+		subject = map[string]any{}
+	}
+	out, _, err := prg.Eval(map[string]any{
+		"subject":  subject,
+		"resource": nonNil(in.Resource),
+		"context":  nonNil(in.Context),
+	})
+	if err != nil {
+		return false, err
+	}
+	ok, isBool := out.Value().(bool)
+	if !isBool {
+		return false, fmt.Errorf("条件表达式的结果不是 bool: %v", out)
+	}
+	return ok, nil
+}
+
+func nonNil(m map[string]string) map[string]string {
+	if m == nil {
+		return map[string]string{}
+	}
+	return m
+}
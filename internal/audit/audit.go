@@ -0,0 +1,98 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"grpc-rbac-backend/internal/middleware"
+	"grpc-rbac-backend/internal/model"
+	"grpc-rbac-backend/internal/utils"
+)
+
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+
+	// AnonymousActor 未登录调用（如注册）的默认操作人
+	AnonymousActor = "anonymous"
+	// SystemActor 后台任务等非请求触发的操作人
+	SystemActor = "system"
+
+	maxErrorLen = 512
+)
+
+// NewEvent 根据请求上下文构造审计记录，填充操作人、方法、请求 ID 与来源地址
+func NewEvent(ctx context.Context, action string) *model.AuditEvent {
+	ev := &model.AuditEvent{Action: action, Actor: SystemActor}
+
+	if method, ok := grpc.Method(ctx); ok {
+		ev.Method = method
+		ev.Actor = AnonymousActor
+	}
+	if claims, ok := ctx.Value(middleware.ContextUserKey).(*utils.CustomClaims); ok {
+		ev.Actor = claims.Username
+		ev.TenantID = claims.TenantID
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("x-request-id"); len(v) > 0 {
+			ev.RequestID = v[0]
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		ev.PeerAddr = p.Addr.String()
+	}
+	return ev
+}
+
+// Target 生成统一格式的操作对象标识，如 user:1
+func Target(kind string, id uint) string {
+	return fmt.Sprintf("%s:%d", kind, id)
+}
+
+// Snapshot 将对象序列化为 JSON，用于记录变更前后的状态
+func Snapshot(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%q", err.Error())
+	}
+	return string(b)
+}
+
+// Write 写入审计记录并接入哈希链，tx 应为执行变更的同一事务
+func Write(tx *gorm.DB, ev *model.AuditEvent) error {
+	if r := []rune(ev.Error); len(r) > maxErrorLen {
+		ev.Error = string(r[:maxErrorLen])
+	}
+	return tx.Transaction(func(tx *gorm.DB) error {
+		// 锁住链头，保证并发写入时链的顺序与 ID 顺序一致
+		var head model.AuditChainHead
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			FirstOrCreate(&head, model.AuditChainHead{ID: 1}).Error; err != nil {
+			return err
+		}
+
+		// 数据库时间精度为毫秒，先截断以保证读回后哈希一致
+		ev.CreatedAt = time.Now().Truncate(time.Millisecond)
+		ev.PrevHash = head.LastHash
+		ev.Hash = ComputeHash(ev)
+		if err := tx.Create(ev).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&head).Updates(map[string]interface{}{
+			"last_event_id": ev.ID,
+			"last_hash":     ev.Hash,
+		}).Error
+	})
+}
@@ -0,0 +1,95 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"grpc-rbac-backend/internal/model"
+)
+
+// chainContent 参与哈希计算的字段，字段顺序即序列化顺序，不可调整
+type chainContent struct {
+	PrevHash  string `json:"prev_hash"`
+	Actor     string `json:"actor"`
+	Action    string `json:"action"`
+	Method    string `json:"method"`
+	Target    string `json:"target"`
+	Before    string `json:"before"`
+	After     string `json:"after"`
+	RequestID string `json:"request_id"`
+	PeerAddr  string `json:"peer_addr"`
+	Result    string `json:"result"`
+	Error     string `json:"error"`
+	CreatedAt int64  `json:"created_at"`
+	// 引入租户前的记录没有该字段，omitempty 保证其哈希不变
+	TenantID uint `json:"tenant_id,omitempty"`
+}
+
+// ComputeHash 计算审计记录内容与上一条哈希的 SHA-256
+func ComputeHash(ev *model.AuditEvent) string {
+	b, _ := json.Marshal(chainContent{
+		PrevHash:  ev.PrevHash,
+		Actor:     ev.Actor,
+		Action:    ev.Action,
+		Method:    ev.Method,
+		Target:    ev.Target,
+		Before:    ev.Before,
+		After:     ev.After,
+		RequestID: ev.RequestID,
+		PeerAddr:  ev.PeerAddr,
+		Result:    ev.Result,
+		Error:     ev.Error,
+		CreatedAt: ev.CreatedAt.UnixMilli(),
+		TenantID:  ev.TenantID,
+	})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// BrokenLinkError 哈希链断裂的位置与原因
+type BrokenLinkError struct {
+	EventID uint
+	Reason  string
+}
+
+func (e *BrokenLinkError) Error() string {
+	return fmt.Sprintf("审计链在记录 %d 处断裂: %s", e.EventID, e.Reason)
+}
+
+// ChainChecker 按顺序逐条校验审计记录
+type ChainChecker struct {
+	prevHash string
+	started  bool
+	Checked  int
+}
+
+// NewChainChecker 从链首开始校验
+func NewChainChecker() *ChainChecker {
+	return &ChainChecker{started: true}
+}
+
+// NewPartialChainChecker 从链中间开始校验（如导出的区间），首条记录的 prev_hash 不做比对
+func NewPartialChainChecker() *ChainChecker {
+	return &ChainChecker{}
+}
+
+// Check 校验下一条记录，返回 *BrokenLinkError 表示链断裂
+func (c *ChainChecker) Check(ev *model.AuditEvent) error {
+	if c.started && ev.PrevHash != c.prevHash {
+		return &BrokenLinkError{EventID: ev.ID, Reason: "prev_hash 与上一条记录的哈希不一致"}
+	}
+	if ComputeHash(ev) != ev.Hash {
+		return &BrokenLinkError{EventID: ev.ID, Reason: "记录内容与哈希不一致"}
+	}
+	c.prevHash = ev.Hash
+	c.started = true
+	c.Checked++
+	return nil
+}
+
+// LastHash 最后一条已校验记录的哈希
+func (c *ChainChecker) LastHash() string {
+	return c.prevHash
+}
@@ -0,0 +1,154 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"grpc-rbac-backend/internal/model"
+)
+
+// SigningKey 导出审计记录时使用的 HMAC 密钥，由启动配置注入
+var SigningKey []byte
+
+const (
+	ExportContentType  = "application/x-ndjson"
+	signatureType      = "signature"
+	signatureAlgorithm = "HMAC-SHA256"
+	maxExportLineSize  = 16 << 20
+)
+
+// ExportTrailer 导出文件最后一行，记录区间信息和对全部记录行的签名；
+// Truncated 表示区间内还有未导出的记录，应从 NextID 继续导出
+type ExportTrailer struct {
+	Type      string `json:"type"`
+	Algorithm string `json:"algorithm"`
+	Count     int    `json:"count"`
+	FirstID   uint   `json:"first_id"`
+	LastID    uint   `json:"last_id"`
+	LastHash  string `json:"last_hash"`
+	Truncated bool   `json:"truncated,omitempty"`
+	NextID    uint   `json:"next_id,omitempty"`
+	Signature string `json:"signature"`
+}
+
+// ExportJSONL 将审计记录逐行写出为 JSONL，并在末尾追加签名行；next 非 0 表示结果被截断，
+// 下一页从该 ID 开始
+func ExportJSONL(w io.Writer, events []model.AuditEvent, next uint, key []byte) error {
+	if len(key) == 0 {
+		return errors.New("未配置审计签名密钥")
+	}
+	mac := hmac.New(sha256.New, key)
+	out := io.MultiWriter(w, mac)
+
+	trailer := ExportTrailer{Type: signatureType, Algorithm: signatureAlgorithm, Count: len(events), Truncated: next > 0, NextID: next}
+	for i := range events {
+		line, err := json.Marshal(&events[i])
+		if err != nil {
+			return err
+		}
+		if _, err := out.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+	if len(events) > 0 {
+		trailer.FirstID = events[0].ID
+		trailer.LastID = events[len(events)-1].ID
+		trailer.LastHash = events[len(events)-1].Hash
+	}
+	trailer.Signature = hex.EncodeToString(mac.Sum(nil))
+
+	line, err := json.Marshal(trailer)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
+
+// ParseTrailer 读取导出内容最后一行的签名行，不校验签名
+func ParseTrailer(data []byte) (*ExportTrailer, error) {
+	data = bytes.TrimRight(data, "\n")
+	line := data[bytes.LastIndexByte(data, '\n')+1:]
+	var trailer ExportTrailer
+	if err := json.Unmarshal(line, &trailer); err != nil {
+		return nil, err
+	}
+	if trailer.Type != signatureType {
+		return nil, errors.New("缺少签名行，文件可能被截断")
+	}
+	return &trailer, nil
+}
+
+// VerifyJSONL 离线校验导出文件：签名、记录条数以及区间内的哈希链
+func VerifyJSONL(r io.Reader, key []byte) (*ExportTrailer, error) {
+	if len(key) == 0 {
+		return nil, errors.New("未配置审计签名密钥")
+	}
+	mac := hmac.New(sha256.New, key)
+	checker := NewPartialChainChecker()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxExportLineSize)
+
+	var trailer *ExportTrailer
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if trailer != nil {
+			return nil, fmt.Errorf("第 %d 行: 签名行之后存在多余内容", lineNo)
+		}
+
+		var probe struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(line, &probe); err != nil {
+			return nil, fmt.Errorf("第 %d 行: %w", lineNo, err)
+		}
+		if probe.Type == signatureType {
+			trailer = &ExportTrailer{}
+			if err := json.Unmarshal(line, trailer); err != nil {
+				return nil, fmt.Errorf("第 %d 行: %w", lineNo, err)
+			}
+			continue
+		}
+
+		mac.Write(line)
+		mac.Write([]byte{'\n'})
+		var ev model.AuditEvent
+		if err := json.Unmarshal(line, &ev); err != nil {
+			return nil, fmt.Errorf("第 %d 行: %w", lineNo, err)
+		}
+		if err := checker.Check(&ev); err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if trailer == nil {
+		return nil, errors.New("缺少签名行，文件可能被截断")
+	}
+	sig, err := hex.DecodeString(trailer.Signature)
+	if err != nil || !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, errors.New("签名校验失败，文件内容已被修改")
+	}
+	if trailer.Count != checker.Checked {
+		return nil, fmt.Errorf("记录条数不一致: 签名行为 %d，实际为 %d", trailer.Count, checker.Checked)
+	}
+	if trailer.LastHash != checker.LastHash() {
+		return nil, errors.New("末条记录哈希与签名行不一致")
+	}
+	return trailer, nil
+}
@@ -0,0 +1,126 @@
+package decision
+
+import (
+	"log"
+	"math/rand/v2"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	Allow = "allow"
+	Deny  = "deny"
+
+	SourceCheckPermission = "CheckPermission"
+	SourceRPC             = "rpc"
+)
+
+// Entry 一次授权判定的结构化记录
+type Entry struct {
+	Time        time.Time         `json:"time"`
+	Source      string            `json:"source"`
+	Subject     string            `json:"subject"`
+	Permission  string            `json:"permission"`
+	Resource    map[string]string `json:"resource,omitempty"`
+	Decision    string            `json:"decision"`
+	MatchedRule string            `json:"matched_rule,omitempty"`
+	LatencyMs   float64           `json:"latency_ms"`
+}
+
+// Sink 判定记录的输出目标
+type Sink interface {
+	Write(e *Entry) error
+	Close() error
+}
+
+// Logger 按允许/拒绝分别采样后写入所有 Sink
+type Logger struct {
+	sinks     []Sink
+	allowRate float64
+	denyRate  float64
+}
+
+// NewLogger 创建判定日志，采样率取值 0~1，0 表示不记录
+func NewLogger(allowRate, denyRate float64, sinks ...Sink) *Logger {
+	return &Logger{sinks: sinks, allowRate: allowRate, denyRate: denyRate}
+}
+
+// Open 根据 sink 名称列表（stdout、file）创建判定日志
+func Open(sinkNames string, filePath string, allowRate, denyRate float64) (*Logger, error) {
+	var sinks []Sink
+	for _, name := range strings.Split(sinkNames, ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case "stdout":
+			sinks = append(sinks, NewStdoutSink())
+		case "file":
+			fs, err := NewFileSink(filePath)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, fs)
+		default:
+			log.Printf("⚠️ 未知的判定日志输出: %s", name)
+		}
+	}
+	return NewLogger(allowRate, denyRate, sinks...), nil
+}
+
+// Log 采样后写入判定记录
+func (l *Logger) Log(e *Entry) {
+	if len(l.sinks) == 0 || !l.sampled(e.Decision) {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	for _, s := range l.sinks {
+		if err := s.Write(e); err != nil {
+			log.Printf("❌ 写入判定日志失败: %v", err)
+		}
+	}
+}
+
+func (l *Logger) sampled(decision string) bool {
+	rate := l.allowRate
+	if decision == Deny {
+		rate = l.denyRate
+	}
+	if rate >= 1 {
+		return true
+	}
+	return rate > 0 && rand.Float64() < rate
+}
+
+// Close 关闭所有 Sink
+func (l *Logger) Close() error {
+	var firstErr error
+	for _, s := range l.sinks {
+		if err := s.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+var std atomic.Pointer[Logger]
+
+func init() {
+	std.Store(NewLogger(0, 0))
+}
+
+// SetDefault 设置全局判定日志
+func SetDefault(l *Logger) {
+	std.Store(l)
+}
+
+// Log 使用全局判定日志记录
+func Log(e *Entry) {
+	std.Load().Log(e)
+}
+
+// Since 计算自 start 起的耗时（毫秒）
+func Since(start time.Time) float64 {
+	return float64(time.Since(start).Microseconds()) / 1000
+}
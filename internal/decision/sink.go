@@ -0,0 +1,42 @@
+package decision
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// jsonlSink 将判定记录逐行写为 JSON
+type jsonlSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	c   io.Closer
+}
+
+func (s *jsonlSink) Write(e *Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(e)
+}
+
+func (s *jsonlSink) Close() error {
+	if s.c == nil {
+		return nil
+	}
+	return s.c.Close()
+}
+
+// NewStdoutSink 输出到标准输出
+func NewStdoutSink() Sink {
+	return &jsonlSink{enc: json.NewEncoder(os.Stdout)}
+}
+
+// NewFileSink 追加写入 JSONL 文件
+func NewFileSink(path string) (Sink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonlSink{enc: json.NewEncoder(f), c: f}, nil
+}
@@ -0,0 +1,68 @@
+package mfa
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 附录 B 的 SHA1 测试向量，取后 6 位
+var rfcSecret = b32.EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	for unix, want := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		got, err := Code(rfcSecret, Step(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("T=%d 验证码 = %s，期望 %s", unix, got, want)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := Step(now)
+	for _, delta := range []int64{-1, 0, 1} {
+		code, _ := Code(rfcSecret, current+delta)
+		if step, ok := Verify(rfcSecret, code, now); !ok || step != current+delta {
+			t.Errorf("偏差 %d 步的验证码校验失败: %d %v", delta, step, ok)
+		}
+	}
+	for _, delta := range []int64{-2, 2} {
+		code, _ := Code(rfcSecret, current+delta)
+		if _, ok := Verify(rfcSecret, code, now); ok {
+			t.Errorf("偏差 %d 步的验证码不应通过", delta)
+		}
+	}
+	if _, ok := Verify(rfcSecret, "12345", now); ok {
+		t.Error("位数错误的验证码不应通过")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, c := range codes {
+		if len(c) != 11 || c[5] != '-' || seen[c] {
+			t.Errorf("恢复码格式错误或重复: %s", c)
+		}
+		seen[c] = true
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Errorf("生成了 %d 个恢复码，期望 %d", len(codes), recoveryCodeCount)
+	}
+	c := codes[0]
+	if HashRecoveryCode(c) != HashRecoveryCode(strings.ToUpper(strings.ReplaceAll(c, "-", ""))) {
+		t.Error("恢复码哈希应忽略大小写和连字符")
+	}
+}
@@ -2,13 +2,20 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"strings"
+	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"gorm.io/gorm"
 
+	"grpc-rbac-backend/internal/decision"
+	"grpc-rbac-backend/internal/model"
 	"grpc-rbac-backend/internal/utils"
 )
 
@@ -16,26 +23,68 @@ type contextKey string
 
 const ContextUserKey = contextKey("user")
 
+// APIKeyAuthenticator 校验 x-api-key 并返回对应服务账号的身份，由服务启动时设置
+var APIKeyAuthenticator func(ctx context.Context, key string) (*utils.CustomClaims, error)
+
 func AuthInterceptor(
 	ctx context.Context,
 	req interface{},
 	info *grpc.UnaryServerInfo,
 	handler grpc.UnaryHandler,
 ) (interface{}, error) {
-	// 登录、注册和健康接口不校验token
+	start := time.Now()
+	entry := &decision.Entry{
+		Source:     decision.SourceRPC,
+		Subject:    "anonymous",
+		Permission: info.FullMethod,
+		Resource:   requestResource(req),
+		Decision:   decision.Deny,
+	}
+	logDecision := func(d, rule string) {
+		entry.Decision = d
+		entry.MatchedRule = rule
+		entry.LatencyMs = decision.Since(start)
+		decision.Log(entry)
+	}
+
+	// 登录、注册、MFA 校验、找回密码、邮箱验证、联合登录回调、OAuth2/OIDC 公开端点和健康接口不校验token
 	if info.FullMethod == "/rbac.RBACService/Login" ||
+		info.FullMethod == "/rbac.RBACService/CompleteFederatedLogin" ||
 		info.FullMethod == "/rbac.RBACService/Register" ||
+		info.FullMethod == "/rbac.RBACService/VerifyMFA" ||
+		info.FullMethod == "/rbac.RBACService/RequestPasswordReset" ||
+		info.FullMethod == "/rbac.RBACService/ConfirmPasswordReset" ||
+		info.FullMethod == "/rbac.RBACService/ConfirmEmailVerification" ||
+		info.FullMethod == "/rbac.RBACService/IssueToken" ||
+		info.FullMethod == "/rbac.RBACService/Authorize" ||
+		info.FullMethod == "/rbac.RBACService/GetOpenIDConfiguration" ||
+		info.FullMethod == "/rbac.RBACService/GetJWKS" ||
 		info.FullMethod == "/grpc.health.v1.Health/Check" {
+		logDecision(decision.Allow, "public-method")
 		return handler(ctx, req)
 	}
 
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
+		logDecision(decision.Deny, "missing-metadata")
 		return nil, status.Error(codes.Unauthenticated, "missing metadata")
 	}
 
+	// 机器调用方使用 API Key
+	if apiKey := md.Get("x-api-key"); len(apiKey) > 0 && APIKeyAuthenticator != nil {
+		claims, err := APIKeyAuthenticator(ctx, apiKey[0])
+		if err != nil {
+			logDecision(decision.Deny, "invalid-api-key")
+			return nil, err
+		}
+		entry.Subject = claims.Username
+		logDecision(decision.Allow, "valid-api-key")
+		return mapNotFound(handler(context.WithValue(ctx, ContextUserKey, claims), req))
+	}
+
 	authHeader := md.Get("authorization")
 	if len(authHeader) == 0 {
+		logDecision(decision.Deny, "missing-token")
 		return nil, status.Error(codes.Unauthenticated, "missing token")
 	}
 
@@ -43,10 +92,70 @@ func AuthInterceptor(
 
 	claims, err := utils.ParseJWT(tokenStr)
 	if err != nil {
+		logDecision(decision.Deny, "invalid-token")
 		return nil, status.Error(codes.Unauthenticated, "invalid token")
 	}
+	entry.Subject = claims.Username
+	// 受限用途的 token 只能访问对应的接口
+	if claims.Purpose != "" && !purposeAllows(claims.Purpose, info.FullMethod) {
+		logDecision(decision.Deny, "restricted-token")
+		return nil, status.Error(codes.PermissionDenied, "该 token 只能用于完成多因素认证")
+	}
+	// 正式 token 须对应未撤销的会话，修改密码等操作会撤销旧会话
+	if claims.Purpose == "" {
+		active, err := model.SessionActive(model.DB, claims.ID)
+		if err != nil {
+			logDecision(decision.Deny, "session-error")
+			return nil, status.Error(codes.Internal, "校验会话失败")
+		}
+		if !active {
+			logDecision(decision.Deny, "revoked-session")
+			return nil, status.Error(codes.Unauthenticated, "会话已失效，请重新登录")
+		}
+	}
+	logDecision(decision.Allow, "valid-token")
 
 	// 把解析出的用户信息放到 context，业务接口可以取出来用
 	newCtx := context.WithValue(ctx, ContextUserKey, claims)
-	return handler(newCtx, req)
+	return mapNotFound(handler(newCtx, req))
+}
+
+// mapNotFound 业务接口按租户查询不到记录时返回 NotFound，不区分记录不存在和属于其他租户
+func mapNotFound(resp interface{}, err error) (interface{}, error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, status.Error(codes.NotFound, "记录不存在")
+	}
+	return resp, err
+}
+
+// requestResource 请求操作的对象：请求消息中名称以 Id 结尾或为 name 的顶层标量字段，如 userId、roleId
+func requestResource(req interface{}) map[string]string {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return nil
+	}
+	var out map[string]string
+	msg.ProtoReflect().Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		name := fd.JSONName()
+		if fd.IsList() || fd.IsMap() || fd.Kind() == protoreflect.MessageKind ||
+			(name != "name" && !strings.HasSuffix(name, "Id")) {
+			return true
+		}
+		if out == nil {
+			out = make(map[string]string)
+		}
+		out[name] = v.String()
+		return true
+	})
+	return out
+}
+
+// purposeAllows 待绑定 MFA 的 token 只能调用绑定接口，MFA 挑战 token 只能提交给 VerifyMFA
+func purposeAllows(purpose, method string) bool {
+	switch purpose {
+	case utils.PurposeMFAEnroll:
+		return method == "/rbac.RBACService/BeginTOTPEnrollment" ||
+			method == "/rbac.RBACService/ConfirmTOTPEnrollment"
+	}
+	return false
 }
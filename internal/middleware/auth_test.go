@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"grpc-rbac-backend/internal/utils"
+)
+
+// 受限用途的 token 只能调用对应的接口
+func TestAuthInterceptorRestrictsPurposeTokens(t *testing.T) {
+	for _, tc := range []struct {
+		purpose, method string
+		allowed         bool
+	}{
+		{utils.PurposeMFA, "/rbac.RBACService/ListUsers", false},
+		{utils.PurposeMFA, "/rbac.RBACService/BeginTOTPEnrollment", false},
+		{utils.PurposeMFA, "/rbac.RBACService/ConfirmTOTPEnrollment", false},
+		{utils.PurposeMFAEnroll, "/rbac.RBACService/ListUsers", false},
+		{utils.PurposeMFAEnroll, "/rbac.RBACService/AssignUserRole", false},
+		{utils.PurposeMFAEnroll, "/rbac.RBACService/BeginTOTPEnrollment", true},
+		{utils.PurposeMFAEnroll, "/rbac.RBACService/ConfirmTOTPEnrollment", true},
+	} {
+		token, err := utils.GeneratePurposeJWT(1, "default", "alice", tc.purpose, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+		called := false
+		_, err = AuthInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tc.method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			called = true
+			return nil, nil
+		})
+		if tc.allowed {
+			if err != nil || !called {
+				t.Errorf("%s token 调用 %s 应放行: %v", tc.purpose, tc.method, err)
+			}
+			continue
+		}
+		if status.Code(err) != codes.PermissionDenied || called {
+			t.Errorf("%s token 调用 %s 应返回 PermissionDenied，实际 %v", tc.purpose, tc.method, err)
+		}
+	}
+}
@@ -6,12 +6,16 @@ import (
 	"strings"
 
 	"github.com/golang-jwt/jwt/v5"
+
+	"grpc-rbac-backend/internal/model"
 )
 
 var JWTSecret = []byte("secret123") // 和 gRPC 中的一致
 
 // JWTClaims 用于解码的结构
 type JWTClaims struct {
+	TenantID uint     `json:"tid"`
+	Tenant   string   `json:"tenant"`
 	Username string   `json:"username"`
 	Roles    []string `json:"roles"`
 	jwt.RegisteredClaims
@@ -20,8 +24,25 @@ type JWTClaims struct {
 // JWTAuthMiddleware 用于 REST API 的中间件
 func JWTAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// 白名单：登录、注册接口放行
-		if r.URL.Path == "/v1/login" || r.URL.Path == "/v1/register" {
+		// 白名单：登录、注册、MFA 校验、找回密码、邮箱验证、OIDC 发现接口放行
+		switch r.URL.Path {
+		case "/v1/login", "/v1/register", "/v1/login/mfa", "/v1/password-resets", "/v1/password-resets:confirm",
+			"/v1/email-verifications:confirm", "/.well-known/openid-configuration", "/oauth2/jwks":
+			next.ServeHTTP(w, r)
+			return
+		}
+		// 联合登录回调由外部身份提供方跳转而来
+		if strings.HasPrefix(r.URL.Path, "/v1/login/federated/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// API Key 由 gRPC 服务端校验，这里只检查格式
+		if apiKey := r.Header.Get("X-Api-Key"); apiKey != "" {
+			if !strings.HasPrefix(apiKey, model.APIKeyPrefix) {
+				http.Error(w, "Invalid API key", http.StatusUnauthorized)
+				return
+			}
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -0,0 +1,50 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// 临时权限申请的状态
+const (
+	AccessRequestPending  = "pending"
+	AccessRequestApproved = "approved"
+	AccessRequestDenied   = "denied"
+	AccessRequestExpired  = "expired"
+	AccessRequestRevoked  = "revoked"
+)
+
+// accessRequestTransitions 状态机允许的迁移，denied/expired/revoked 为终态
+var accessRequestTransitions = map[string][]string{
+	AccessRequestPending:  {AccessRequestApproved, AccessRequestDenied, AccessRequestExpired},
+	AccessRequestApproved: {AccessRequestExpired, AccessRequestRevoked},
+}
+
+// AccessRequest 用户申请在一段时间内临时获得某个角色
+type AccessRequest struct {
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	TenantID        uint       `gorm:"index;not null" json:"tenant_id"`
+	RequesterID     uint       `gorm:"index;not null" json:"requester_id"`
+	RoleID          uint       `gorm:"not null" json:"role_id"`
+	DurationSeconds int64      `json:"duration_seconds"`
+	Justification   string     `gorm:"size:512" json:"justification"`
+	State           string     `gorm:"index;size:16" json:"state"`
+	ReviewerID      *uint      `json:"reviewer_id,omitempty"`
+	ReviewComment   string     `gorm:"size:512" json:"review_comment"`
+	ReviewedAt      *time.Time `json:"reviewed_at,omitempty"`
+	PendingUntil    time.Time  `gorm:"index" json:"pending_until"`
+	GrantedUntil    *time.Time `gorm:"index" json:"granted_until,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// Transition 按状态机迁移到 to，非法迁移返回错误
+func (r *AccessRequest) Transition(to string) error {
+	for _, next := range accessRequestTransitions[r.State] {
+		if next == to {
+			r.State = to
+			return nil
+		}
+	}
+	return fmt.Errorf("申请当前状态为 %s，不能变更为 %s", r.State, to)
+}
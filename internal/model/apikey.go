@@ -0,0 +1,37 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// APIKeyPrefix API Key 的固定前缀，便于在日志和代码仓库中识别泄露的密钥
+const APIKeyPrefix = "rbk_"
+
+// APIKey 服务账号的长期凭证，格式为 rbk_<KeyID>_<secret>，只保存 secret 的哈希
+type APIKey struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	TenantID   uint       `gorm:"index;not null" json:"tenant_id"`
+	UserID     uint       `gorm:"index;not null" json:"user_id"`
+	Name       string     `gorm:"size:64" json:"name"`
+	KeyID      string     `gorm:"uniqueIndex;size:16;not null" json:"key_id"` // 明文保存，用于查找和展示
+	SecretHash string     `gorm:"size:64;not null" json:"-"`
+	Scopes     []string   `gorm:"serializer:json;type:text" json:"scopes,omitempty"` // 为空表示服务账号的全部权限
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedBy  string     `gorm:"size:64" json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Active 未撤销且未过期
+func (k *APIKey) Active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// HashAPIKeySecret API Key secret 的存储哈希；secret 为高熵随机串，无需加盐
+func HashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,28 @@
+package model
+
+import "time"
+
+// 自定义属性的类型
+const (
+	AttributeTypeString = "string"
+	AttributeTypeInt    = "int"
+	AttributeTypeBool   = "bool"
+)
+
+// UserAttributeDefinition 租户定义的用户自定义属性，类型创建后不能修改
+type UserAttributeDefinition struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	TenantID    uint      `gorm:"uniqueIndex:idx_user_attr_defs_tenant_name;not null" json:"tenant_id"`
+	Name        string    `gorm:"uniqueIndex:idx_user_attr_defs_tenant_name;size:64;not null" json:"name"`
+	Type        string    `gorm:"size:16;not null" json:"type"` // string、int 或 bool
+	Description string    `gorm:"size:256" json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// UserAttribute 用户的一个自定义属性值，Value 按类型保存为规范文本（如 42、true）
+type UserAttribute struct {
+	UserID uint   `gorm:"primaryKey" json:"user_id"`
+	Name   string `gorm:"primaryKey;size:64" json:"name"`
+	Type   string `gorm:"size:16;not null" json:"type"`
+	Value  string `gorm:"size:1024" json:"value"`
+}
@@ -0,0 +1,29 @@
+package model
+
+import "time"
+
+// AuditEvent 审计记录，与对应的变更写在同一事务中
+type AuditEvent struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	TenantID  uint      `gorm:"index" json:"tenant_id,omitempty"`
+	Actor     string    `gorm:"index;size:64" json:"actor"`
+	Action    string    `gorm:"index;size:64" json:"action"`
+	Method    string    `gorm:"size:128" json:"method"`
+	Target    string    `gorm:"index;size:128" json:"target"`
+	Before    string    `gorm:"type:text" json:"before"`
+	After     string    `gorm:"type:text" json:"after"`
+	RequestID string    `gorm:"size:64" json:"request_id"`
+	PeerAddr  string    `gorm:"size:64" json:"peer_addr"`
+	Result    string    `gorm:"size:16" json:"result"`
+	Error     string    `gorm:"size:512" json:"error"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+	PrevHash  string    `gorm:"size:64" json:"prev_hash"`
+	Hash      string    `gorm:"size:64" json:"hash"`
+}
+
+// AuditChainHead 记录哈希链末端，写入审计时加锁以保证链的顺序
+type AuditChainHead struct {
+	ID          uint   `gorm:"primaryKey"`
+	LastEventID uint   `gorm:"not null;default:0"`
+	LastHash    string `gorm:"size:64"`
+}
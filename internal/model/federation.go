@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+// ExternalIdentity 外部身份提供方的账号（provider + sub）与本地用户的关联，
+// 首次联合登录时创建，之后按此找到本地用户，不受用户名变化影响
+type ExternalIdentity struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	TenantID  uint      `gorm:"index;not null" json:"tenant_id"`
+	UserID    uint      `gorm:"index;not null" json:"user_id"`
+	Provider  string    `gorm:"uniqueIndex:idx_provider_subject;size:64;not null" json:"provider"`
+	Subject   string    `gorm:"uniqueIndex:idx_provider_subject;size:191;not null" json:"subject"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// FederatedLoginState 跳转到外部身份提供方前保存的 state，回调时校验并取出 nonce 和 PKCE code_verifier，只能使用一次
+type FederatedLoginState struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	StateHash    string    `gorm:"uniqueIndex;size:64;not null" json:"-"`
+	Provider     string    `gorm:"size:64;not null" json:"provider"`
+	Nonce        string    `gorm:"size:64;not null" json:"-"`
+	CodeVerifier string    `gorm:"size:128;not null" json:"-"`
+	ExpiresAt    time.Time `gorm:"index" json:"expires_at"`
+	CreatedAt    time.Time `json:"created_at"`
+}
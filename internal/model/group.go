@@ -0,0 +1,45 @@
+package model
+
+import "gorm.io/gorm"
+
+// Group 用户组，组内成员（含子组成员）继承该组的角色
+type Group struct {
+	ID          uint    `gorm:"primaryKey" json:"id"`
+	TenantID    uint    `gorm:"uniqueIndex:idx_groups_tenant_name;not null" json:"tenant_id"`
+	Name        string  `gorm:"uniqueIndex:idx_groups_tenant_name;size:64" json:"name"`
+	Description string  `gorm:"size:256" json:"description"`
+	ExternalID  string  `gorm:"index;size:191" json:"external_id,omitempty"` // SCIM 客户端中的 ID
+	Users       []User  `gorm:"many2many:group_users;" json:"users,omitempty"`
+	Roles       []Role  `gorm:"many2many:group_roles;" json:"roles,omitempty"`
+	Children    []Group `gorm:"many2many:group_children;joinForeignKey:GroupID;joinReferences:ChildID" json:"children,omitempty"`
+}
+
+// GroupEdge group_children 中的一条父子关系
+type GroupEdge struct {
+	GroupID uint
+	ChildID uint
+}
+
+// LoadGroupEdges 读取租户内所有组的父子关系
+func LoadGroupEdges(tx *gorm.DB, tenantID uint) ([]GroupEdge, error) {
+	var edges []GroupEdge
+	err := tx.Table("group_children").
+		Select("group_children.group_id, group_children.child_id").
+		Joins("JOIN `groups` ON `groups`.id = group_children.group_id").
+		Where("`groups`.tenant_id = ?", tenantID).
+		Scan(&edges).Error
+	return edges, err
+}
+
+func DeleteGroupWithRelations(tx *gorm.DB, group *Group) error {
+	for _, assoc := range []string{"Users", "Roles", "Children"} {
+		if err := tx.Model(group).Association(assoc).Clear(); err != nil {
+			return err
+		}
+	}
+	// 从所有上级组中移除
+	if err := tx.Exec("DELETE FROM group_children WHERE child_id = ?", group.ID).Error; err != nil {
+		return err
+	}
+	return tx.Delete(group).Error
+}
@@ -3,9 +3,12 @@ package model
 import (
 	"errors"
 	"log"
+	"time"
 
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
+
+	"grpc-rbac-backend/internal/password"
 )
 
 var DB *gorm.DB
@@ -17,25 +20,58 @@ func InitDB(dsn string, adminUsername string, adminPassword string) {
 	}
 	DB = db
 
-	// 自动迁移所有模型
-	err = db.AutoMigrate(&User{}, &Role{}, &Permission{})
-	if err != nil {
+	if err := Migrate(db); err != nil {
 		log.Fatalf("❌ 自动迁移失败: %v", err)
 	}
-
 	log.Println("✅ 数据库连接成功，模型迁移完成")
 
+	// 初始化默认租户并迁移存量数据
+	tenant := migrateTenants(db)
+
+	// 引入用户状态前已停用的用户
+	if err := db.Model(&User{}).Where("disabled_at IS NOT NULL AND status = ?", UserStatusActive).
+		Update("status", UserStatusDisabled).Error; err != nil {
+		log.Fatalf("❌ 迁移用户状态失败: %v", err)
+	}
+	// 引入创建、更新时间前的存量用户
+	if err := db.Model(&User{}).Where("created_at IS NULL").
+		UpdateColumns(map[string]interface{}{"created_at": time.Now(), "updated_at": time.Now()}).Error; err != nil {
+		log.Fatalf("❌ 迁移用户创建时间失败: %v", err)
+	}
+
 	// 初始化数据
-	initAdminRoleAndUser(db, adminUsername, adminPassword)
+	initAdminRoleAndUser(db, tenant, adminUsername, adminPassword)
 }
 
-func initAdminRoleAndUser(db *gorm.DB, adminUsername string, adminPassword string) {
+// Migrate 设置连接表、迁移所有模型并初始化审计哈希链
+func Migrate(db *gorm.DB) error {
+	// 用户角色关联使用自定义连接表，记录生效时间窗口
+	if err := db.SetupJoinTable(&User{}, "Roles", &UserRole{}); err != nil {
+		return err
+	}
+	// 角色权限关联记录授予条件
+	if err := db.SetupJoinTable(&Role{}, "Permissions", &RolePermission{}); err != nil {
+		return err
+	}
+	if err := db.SetupJoinTable(&Permission{}, "Roles", &RolePermission{}); err != nil {
+		return err
+	}
+
+	err := db.AutoMigrate(&Tenant{}, &User{}, &Role{}, &Permission{}, &Group{}, &AccessRequest{}, &RelationTuple{}, &ReviewCampaign{}, &ReviewItem{}, &SodConstraint{}, &LoginThrottle{}, &UserTOTP{}, &RecoveryCode{}, &PasswordHistory{}, &Session{}, &PasswordResetToken{}, &EmailVerificationToken{}, &UserAttributeDefinition{}, &UserAttribute{}, &APIKey{}, &OAuthClient{}, &RefreshToken{}, &AuthorizationCode{}, &ExternalIdentity{}, &FederatedLoginState{}, &AuditEvent{}, &AuditChainHead{})
+	if err != nil {
+		return err
+	}
+	return db.FirstOrCreate(&AuditChainHead{ID: 1}).Error
+}
+
+func initAdminRoleAndUser(db *gorm.DB, tenant *Tenant, adminUsername string, adminPassword string) {
 	// 检查是否已存在 admin 角色
 	var adminRole Role
-	if err := db.First(&adminRole, "name = ?", "admin").Error; err != nil {
+	if err := db.First(&adminRole, "tenant_id = ? AND name = ?", tenant.ID, "admin").Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			// 如果没有找到 admin 角色，创建一个新的
 			adminRole = Role{
+				TenantID:    tenant.ID,
 				Name:        "admin",
 				Description: "Administrator with full access",
 			}
@@ -50,10 +86,11 @@ func initAdminRoleAndUser(db *gorm.DB, adminUsername string, adminPassword strin
 
 	// 检查是否已存在 write 权限
 	var writePermission Permission
-	if err := db.First(&writePermission, "name = ?", "write").Error; err != nil {
+	if err := db.First(&writePermission, "tenant_id = ? AND name = ?", tenant.ID, "write").Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			// 如果没有找到 write 权限，创建一个新的
 			writePermission = Permission{
+				TenantID:    tenant.ID,
 				Name:        "write",
 				Description: "write blogs",
 			}
@@ -74,16 +111,26 @@ func initAdminRoleAndUser(db *gorm.DB, adminUsername string, adminPassword strin
 
 	// 检查是否已存在管理员用户
 	var adminUser User
-	if err := db.First(&adminUser, "username = ?", adminUsername).Error; err != nil {
+	if err := db.First(&adminUser, "tenant_id = ? AND username = ?", tenant.ID, adminUsername).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			// 如果没有找到管理员用户，创建一个新的
+			hash, err := password.Hash(adminPassword)
+			if err != nil {
+				log.Fatalf("❌ 生成管理员密码哈希失败: %v", err)
+			}
+			now := time.Now()
 			adminUser = User{
-				Username: adminUsername,
-				Password: adminPassword, // 使用合适的密码
+				TenantID:          tenant.ID,
+				Username:          adminUsername,
+				Password:          hash,
+				PasswordChangedAt: &now,
 			}
 			if err := db.Create(&adminUser).Error; err != nil {
 				log.Fatalf("❌ 创建管理员用户失败: %v", err)
 			}
+			if err := db.Create(&PasswordHistory{UserID: adminUser.ID, Hash: hash}).Error; err != nil {
+				log.Fatalf("❌ 记录管理员密码历史失败: %v", err)
+			}
 			log.Println("✅ 创建管理员用户成功")
 		} else {
 			log.Fatalf("❌ 查询管理员用户失败: %v", err)
@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+// UserTOTP 用户的 TOTP 配置，ConfirmedAt 为空表示已开始绑定但尚未确认
+type UserTOTP struct {
+	UserID      uint       `gorm:"primaryKey" json:"user_id"`
+	Secret      string     `gorm:"size:64;not null" json:"-"`
+	ConfirmedAt *time.Time `json:"confirmed_at,omitempty"`
+	LastStep    int64      `json:"-"` // 最近一次通过校验的时间步，防止验证码重放
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// Enabled 是否已完成绑定
+func (t *UserTOTP) Enabled() bool {
+	return t.ConfirmedAt != nil
+}
+
+// RecoveryCode 一次性恢复码，只保存哈希
+type RecoveryCode struct {
+	ID       uint       `gorm:"primaryKey" json:"id"`
+	UserID   uint       `gorm:"index;not null" json:"user_id"`
+	CodeHash string     `gorm:"size:64;not null" json:"-"`
+	UsedAt   *time.Time `json:"used_at,omitempty"`
+}
@@ -0,0 +1,61 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// OAuthClient OAuth2 客户端。绑定了服务账号的客户端可用 client_credentials 以该账号身份获取 token，
+// 登记了回调地址的客户端可作为 OIDC 依赖方使用授权码流程；公开客户端没有 secret，须使用 PKCE
+type OAuthClient struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	TenantID      uint       `gorm:"index;not null" json:"tenant_id"`
+	UserID        uint       `gorm:"index;not null" json:"user_id"` // 绑定的服务账号，0 表示未绑定
+	Name          string     `gorm:"size:64" json:"name"`
+	ClientID      string     `gorm:"uniqueIndex;size:32;not null" json:"client_id"`
+	SecretHash    string     `gorm:"size:64" json:"-"`
+	Public        bool       `gorm:"not null;default:false" json:"public"`
+	RedirectURIs  []string   `gorm:"serializer:json;type:text" json:"redirect_uris,omitempty"`
+	AllowedScopes []string   `gorm:"serializer:json;type:text" json:"allowed_scopes,omitempty"` // 为空表示不限（仍以用户实际拥有的权限为准）
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+	CreatedBy     string     `gorm:"size:64" json:"created_by"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// AuthorizationCode 授权码流程中登录成功后签发的一次性授权码，只保存哈希；
+// 换取 token 后记录会话，重复使用时撤销该会话
+type AuthorizationCode struct {
+	ID                  uint       `gorm:"primaryKey" json:"id"`
+	CodeHash            string     `gorm:"uniqueIndex;size:64;not null" json:"-"`
+	ClientID            string     `gorm:"index;size:32;not null" json:"client_id"`
+	TenantID            uint       `gorm:"not null" json:"tenant_id"`
+	UserID              uint       `gorm:"index;not null" json:"user_id"`
+	RedirectURI         string     `gorm:"size:512;not null" json:"redirect_uri"`
+	Scope               string     `gorm:"type:text" json:"scope"`
+	Nonce               string     `gorm:"size:256" json:"nonce,omitempty"`
+	CodeChallenge       string     `gorm:"size:128" json:"-"`
+	CodeChallengeMethod string     `gorm:"size:16" json:"code_challenge_method,omitempty"`
+	AuthTime            time.Time  `json:"auth_time"`
+	ExpiresAt           time.Time  `gorm:"index" json:"expires_at"`
+	UsedAt              *time.Time `json:"used_at,omitempty"`
+	SessionID           string     `gorm:"size:64" json:"session_id,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+}
+
+// RefreshToken 登录会话的 refresh token，只保存哈希；每次使用后轮换，
+// 已使用的 token 再次出现时视为泄露并撤销整个会话
+type RefreshToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	SessionID string     `gorm:"index;size:64;not null" json:"session_id"`
+	TokenHash string     `gorm:"uniqueIndex;size:64;not null" json:"-"`
+	ExpiresAt time.Time  `gorm:"index" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// HashOAuthSecret client secret 和 refresh token 的存储哈希；二者均为高熵随机串，无需加盐
+func HashOAuthSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
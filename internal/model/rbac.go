@@ -1,23 +1,138 @@
 package model
 
-import "gorm.io/gorm"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type User struct {
-	ID       uint   `gorm:"primaryKey"`
-	Username string `gorm:"uniqueIndex;size:64"`
-	Password string `gorm:"size:128"`
-	Roles    []Role `gorm:"many2many:user_roles;"`
+	ID                uint            `gorm:"primaryKey" json:"id"`
+	TenantID          uint            `gorm:"uniqueIndex:idx_users_tenant_username;uniqueIndex:idx_users_tenant_email;not null;default:0" json:"tenant_id"`
+	Username          string          `gorm:"uniqueIndex:idx_users_tenant_username;size:64" json:"username"`
+	Email             *string         `gorm:"uniqueIndex:idx_users_tenant_email;size:191" json:"email,omitempty"` // 租户内唯一，未设置时为 NULL
+	EmailVerifiedAt   *time.Time      `json:"email_verified_at,omitempty"`
+	DisplayName       string          `gorm:"size:128" json:"display_name,omitempty"`
+	Password          string          `gorm:"size:128" json:"-"` // bcrypt 哈希，存量数据可能为明文
+	PasswordChangedAt *time.Time      `json:"password_changed_at,omitempty"`
+	Kind              string          `gorm:"size:16;not null;default:user" json:"kind"` // user 或 service
+	Description       string          `gorm:"size:256" json:"description,omitempty"`
+	Status            string          `gorm:"size:16;not null;default:active;index" json:"status"` // active、disabled、pending 或 deleted
+	StatusSource      string          `gorm:"size:80" json:"status_source,omitempty"`              // 最近一次修改状态的来源，如 admin、scim、ldap:corp-ad
+	DisabledAt        *time.Time      `json:"disabled_at,omitempty"`
+	ExternalID        string          `gorm:"index;size:191" json:"external_id,omitempty"` // SCIM 客户端（如 HR 系统）中的 ID
+	Roles             []Role          `gorm:"many2many:user_roles;" json:"roles,omitempty"`
+	Attributes        []UserAttribute `gorm:"foreignKey:UserID" json:"attributes,omitempty"`
+	LastLoginAt       *time.Time      `json:"last_login_at,omitempty"`
+	CreatedAt         time.Time       `json:"created_at"`
+	UpdatedAt         time.Time       `json:"updated_at"`
+	DeletedAt         gorm.DeletedAt  `gorm:"index" json:"deleted_at,omitempty"` // 软删除，保留期内可以恢复
+}
+
+// EmailAddress 用户的邮箱，未设置时为空
+func (u *User) EmailAddress() string {
+	if u.Email == nil {
+		return ""
+	}
+	return *u.Email
+}
+
+// 用户状态。locked（登录失败次数过多被锁定）由登录限流记录得出，不保存在用户上
+const (
+	UserStatusActive   = "active"
+	UserStatusDisabled = "disabled" // 停用，如管理员停用或已从 LDAP 目录中移除
+	UserStatusPending  = "pending"  // 已创建但尚未激活，如 SCIM 预先创建的入职员工
+	UserStatusLocked   = "locked"
+	UserStatusDeleted  = "deleted"
+)
+
+// 用户类型：普通用户使用密码登录，服务账号只能使用 API Key
+const (
+	UserKindHuman   = "user"
+	UserKindService = "service"
+)
+
+// IsService 是否为服务账号
+func (u *User) IsService() bool {
+	return u.Kind == UserKindService
+}
+
+// PasswordHistory 用户用过的密码哈希，用于禁止重复使用最近的密码
+type PasswordHistory struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"index;not null" json:"user_id"`
+	Hash      string    `gorm:"size:128;not null" json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PasswordResetToken 一次性的密码重置 token，只保存哈希
+type PasswordResetToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"index;not null" json:"user_id"`
+	TokenHash string     `gorm:"uniqueIndex;size:64;not null" json:"-"`
+	ExpiresAt time.Time  `gorm:"index" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// EmailVerificationToken 一次性的邮箱验证 token，只保存哈希；Email 为发送时的邮箱，邮箱修改后 token 作废
+type EmailVerificationToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"index;not null" json:"user_id"`
+	Email     string     `gorm:"size:191;not null" json:"email"`
+	TokenHash string     `gorm:"uniqueIndex;size:64;not null" json:"-"`
+	ExpiresAt time.Time  `gorm:"index" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// HashResetToken 重置 token 和邮箱验证 token 的存储哈希
+func HashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// UserRoleSourceSCIM 由 SCIM 预配分配的角色，SCIM 同步角色时只撤销这类分配
+const UserRoleSourceSCIM = "scim"
+
+// UserRole 用户与角色的分配关系，NotBefore/ExpiresAt 为空表示不限
+type UserRole struct {
+	UserID    uint       `gorm:"primaryKey" json:"user_id"`
+	RoleID    uint       `gorm:"primaryKey" json:"role_id"`
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	ExpiresAt *time.Time `gorm:"index" json:"expires_at,omitempty"`
+	Source    string     `gorm:"size:16" json:"source,omitempty"` // 为空表示手工分配
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// ActiveAt 筛选在 t 时刻生效的角色分配
+func ActiveAt(t time.Time) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("(user_roles.not_before IS NULL OR user_roles.not_before <= ?) AND (user_roles.expires_at IS NULL OR user_roles.expires_at > ?)", t, t)
+	}
+}
+
+// RolePermission 角色与权限的授予关系，Condition 为空表示无条件授予，
+// 否则仅在条件表达式求值为 true 时生效
+type RolePermission struct {
+	RoleID       uint   `gorm:"primaryKey" json:"role_id"`
+	PermissionID uint   `gorm:"primaryKey" json:"permission_id"`
+	Condition    string `gorm:"column:condition_expr;type:text" json:"condition,omitempty"`
 }
 
 type Role struct {
-	ID          uint         `gorm:"primaryKey"`
-	Name        string       `gorm:"uniqueIndex;size:64"`
-	Description string       `gorm:"size:256"`
-	Permissions []Permission `gorm:"many2many:role_permissions;"`
+	ID          uint         `gorm:"primaryKey" json:"id"`
+	TenantID    uint         `gorm:"uniqueIndex:idx_roles_tenant_name;not null;default:0" json:"tenant_id"`
+	Name        string       `gorm:"uniqueIndex:idx_roles_tenant_name;size:64" json:"name"`
+	Description string       `gorm:"size:256" json:"description"`
+	Permissions []Permission `gorm:"many2many:role_permissions;" json:"permissions,omitempty"`
 }
 type Permission struct {
 	ID          uint           `gorm:"primaryKey" json:"id"`
-	Name        string         `gorm:"uniqueIndex;size:64;not null" json:"name"`
+	TenantID    uint           `gorm:"uniqueIndex:idx_permissions_tenant_name;not null;default:0" json:"tenant_id"`
+	Name        string         `gorm:"uniqueIndex:idx_permissions_tenant_name;size:64;not null" json:"name"`
 	Description string         `gorm:"size:255" json:"description"`
 	CreatedAt   int64          `json:"created_at"`
 	UpdatedAt   int64          `json:"updated_at"`
@@ -25,13 +140,53 @@ type Permission struct {
 	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
+// DeleteUserWithRelations 彻底删除用户（包括已软删除的用户）及其关联数据
 func DeleteUserWithRelations(tx *gorm.DB, userID uint) error {
 	var user User
-	if err := tx.Preload("Roles").First(&user, userID).Error; err != nil {
+	if err := tx.Unscoped().Preload("Roles").First(&user, userID).Error; err != nil {
 		return err
 	}
 	if err := tx.Model(&user).Association("Roles").Clear(); err != nil {
 		return err
 	}
-	return tx.Delete(&user).Error
+	if err := tx.Exec("DELETE FROM group_users WHERE user_id = ?", user.ID).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", user.ID).Delete(&UserTOTP{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", user.ID).Delete(&RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", user.ID).Delete(&PasswordHistory{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", user.ID).Delete(&PasswordResetToken{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", user.ID).Delete(&EmailVerificationToken{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", user.ID).Delete(&UserAttribute{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", user.ID).Delete(&APIKey{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", user.ID).Delete(&OAuthClient{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", user.ID).Delete(&AuthorizationCode{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", user.ID).Delete(&ExternalIdentity{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("session_id IN (?)", tx.Model(&Session{}).Select("id").Where("user_id = ?", user.ID)).Delete(&RefreshToken{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", user.ID).Delete(&Session{}).Error; err != nil {
+		return err
+	}
+	return tx.Unscoped().Delete(&user).Error
 }
@@ -0,0 +1,75 @@
+package model
+
+import "time"
+
+// 权限审核活动的状态
+const (
+	ReviewCampaignOpen     = "open"
+	ReviewCampaignClosed   = "closed"
+	ReviewCampaignCanceled = "canceled"
+)
+
+// 审核项的结论
+const (
+	ReviewPending = "pending"
+	ReviewKeep    = "keep"
+	ReviewRevoke  = "revoke"
+)
+
+// ReviewCampaign 一次权限审核活动，覆盖指定角色在发起时的全部直接分配
+type ReviewCampaign struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	TenantID    uint       `gorm:"index;not null" json:"tenant_id"`
+	Name        string     `gorm:"size:128;not null" json:"name"`
+	Description string     `gorm:"size:512" json:"description"`
+	State       string     `gorm:"index;size:16" json:"state"`
+	CreatedBy   uint       `json:"created_by"`
+	DueAt       *time.Time `json:"due_at,omitempty"`
+	ClosedAt    *time.Time `json:"closed_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Roles       []Role     `gorm:"many2many:review_campaign_roles;" json:"roles,omitempty"`
+}
+
+// ReviewItem 审核人需要确认的一条角色分配：保留或撤销用户的某个角色
+type ReviewItem struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	CampaignID uint       `gorm:"uniqueIndex:idx_review_items_assignment;not null" json:"campaign_id"`
+	UserID     uint       `gorm:"uniqueIndex:idx_review_items_assignment;not null" json:"user_id"`
+	RoleID     uint       `gorm:"uniqueIndex:idx_review_items_assignment;not null" json:"role_id"`
+	ReviewerID uint       `gorm:"index;not null" json:"reviewer_id"`
+	Decision   string     `gorm:"index;size:16" json:"decision"`
+	Comment    string     `gorm:"size:512" json:"comment"`
+	DecidedAt  *time.Time `json:"decided_at,omitempty"`
+	Applied    bool       `json:"applied"` // 撤销结论是否已在活动结束时执行
+	CreatedAt  time.Time  `json:"created_at"`
+
+	// 审核人作出结论时（未审核时为发起活动时）该角色分配的快照，结束活动时仅撤销未变化的分配
+	GrantCreatedAt *time.Time `json:"grant_created_at,omitempty"`
+	GrantNotBefore *time.Time `json:"grant_not_before,omitempty"`
+	GrantExpiresAt *time.Time `json:"grant_expires_at,omitempty"`
+}
+
+// SnapshotGrant 记录审核项对应角色分配的当前状态，ur 为 nil 表示分配已不存在
+func (item *ReviewItem) SnapshotGrant(ur *UserRole) {
+	item.GrantCreatedAt, item.GrantNotBefore, item.GrantExpiresAt = nil, nil, nil
+	if ur == nil {
+		return
+	}
+	createdAt := ur.CreatedAt
+	item.GrantCreatedAt = &createdAt
+	item.GrantNotBefore = ur.NotBefore
+	item.GrantExpiresAt = ur.ExpiresAt
+}
+
+// MatchesGrant 角色分配自快照以来是否未被重新分配或修改
+func (item *ReviewItem) MatchesGrant(ur *UserRole) bool {
+	return item.GrantCreatedAt != nil && item.GrantCreatedAt.Equal(ur.CreatedAt) &&
+		sameTime(item.GrantNotBefore, ur.NotBefore) && sameTime(item.GrantExpiresAt, ur.ExpiresAt)
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
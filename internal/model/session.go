@@ -0,0 +1,39 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Session 一次登录签发的 token，ID 即 token 的 jti；撤销后该 token 立即失效
+type Session struct {
+	ID        string     `gorm:"primaryKey;size:64" json:"id"`
+	TenantID  uint       `gorm:"index;not null" json:"tenant_id"`
+	UserID    uint       `gorm:"index;not null" json:"user_id"`
+	ClientID  string     `gorm:"index;size:32" json:"client_id,omitempty"` // 通过 OAuth2 客户端签发时记录
+	Scope     string     `gorm:"type:text" json:"scope,omitempty"`         // 会话限定的权限范围，刷新 token 时沿用
+	ExpiresAt time.Time  `gorm:"index" json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// SessionActive 会话存在、未撤销且未过期，且用户处于启用状态
+func SessionActive(tx *gorm.DB, id string) (bool, error) {
+	var n int64
+	err := tx.Model(&Session{}).
+		Joins("JOIN users ON users.id = sessions.user_id").
+		Where("sessions.id = ? AND sessions.revoked_at IS NULL AND sessions.expires_at > ?", id, time.Now()).
+		Where("users.status = ? AND users.deleted_at IS NULL", UserStatusActive).
+		Count(&n).Error
+	return n > 0, err
+}
+
+// RevokeUserSessions 撤销用户的所有会话，except 非空时保留该会话
+func RevokeUserSessions(tx *gorm.DB, userID uint, except string) error {
+	q := tx.Model(&Session{}).Where("user_id = ? AND revoked_at IS NULL", userID)
+	if except != "" {
+		q = q.Where("id <> ?", except)
+	}
+	return q.Update("revoked_at", time.Now()).Error
+}
@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+// SodConstraint 职责分离约束：同一用户最多同时拥有 Roles 中的 MaxRoles 个角色，
+// MaxRoles 为 1 时即互斥角色
+type SodConstraint struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	TenantID    uint      `gorm:"uniqueIndex:idx_sod_tenant_name;not null" json:"tenant_id"`
+	Name        string    `gorm:"uniqueIndex:idx_sod_tenant_name;size:64;not null" json:"name"`
+	Description string    `gorm:"size:256" json:"description"`
+	MaxRoles    int       `gorm:"not null;default:1" json:"max_roles"`
+	Roles       []Role    `gorm:"many2many:sod_constraint_roles;" json:"roles,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Violated 返回用户拥有的约束内角色，超出上限时 ok 为 true
+func (c *SodConstraint) Violated(userRoles map[uint]bool) (held []Role, ok bool) {
+	for _, r := range c.Roles {
+		if userRoles[r.ID] {
+			held = append(held, r)
+		}
+	}
+	return held, len(held) > c.MaxRoles
+}
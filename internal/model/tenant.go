@@ -0,0 +1,101 @@
+package model
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+
+	"grpc-rbac-backend/internal/password"
+)
+
+// DefaultTenantName 默认租户，存量数据及未指定租户的登录、注册均归属于此
+const DefaultTenantName = "default"
+
+// Tenant 租户（组织），用户、角色、权限均归属于某个租户
+type Tenant struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Name        string    `gorm:"uniqueIndex;size:64;not null" json:"name"`
+	Description string    `gorm:"size:256" json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// TenantScope 将查询限定在指定租户内
+func TenantScope(tenantID uint) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("tenant_id = ?", tenantID)
+	}
+}
+
+// CreateTenantWithAdmin 创建租户及其 admin、user 角色和管理员账号，adminPassword 为明文；
+// 同时记录密码修改时间和第一条密码历史
+func CreateTenantWithAdmin(tx *gorm.DB, tenant *Tenant, adminUsername, adminPassword string) (*User, error) {
+	hash, err := password.Hash(adminPassword)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Create(tenant).Error; err != nil {
+		return nil, err
+	}
+
+	adminRole := Role{TenantID: tenant.ID, Name: "admin", Description: "Administrator with full access"}
+	userRole := Role{TenantID: tenant.ID, Name: "user", Description: "Default role for registered users"}
+	if err := tx.Create(&[]*Role{&adminRole, &userRole}).Error; err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	admin := User{
+		TenantID:          tenant.ID,
+		Username:          adminUsername,
+		Password:          hash,
+		PasswordChangedAt: &now,
+		Roles:             []Role{adminRole},
+	}
+	if err := tx.Create(&admin).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Create(&PasswordHistory{UserID: admin.ID, Hash: hash}).Error; err != nil {
+		return nil, err
+	}
+	return &admin, nil
+}
+
+// migrateTenants 创建默认租户，并将引入租户前的全局唯一索引与存量数据迁移到默认租户下
+func migrateTenants(db *gorm.DB) *Tenant {
+	var tenant Tenant
+	if err := db.First(&tenant, "name = ?", DefaultTenantName).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Fatalf("❌ 查询默认租户失败: %v", err)
+		}
+		tenant = Tenant{Name: DefaultTenantName, Description: "Default tenant"}
+		if err := db.Create(&tenant).Error; err != nil {
+			log.Fatalf("❌ 创建默认租户失败: %v", err)
+		}
+		log.Println("✅ 创建默认租户成功")
+	}
+
+	legacyIndexes := []struct {
+		model interface{}
+		name  string
+	}{
+		{&User{}, "idx_users_username"},
+		{&Role{}, "idx_roles_name"},
+		{&Permission{}, "idx_permissions_name"},
+	}
+	for _, idx := range legacyIndexes {
+		if db.Migrator().HasIndex(idx.model, idx.name) {
+			if err := db.Migrator().DropIndex(idx.model, idx.name); err != nil {
+				log.Fatalf("❌ 删除旧索引 %s 失败: %v", idx.name, err)
+			}
+		}
+	}
+
+	for _, m := range []interface{}{&User{}, &Role{}, &Permission{}} {
+		if err := db.Model(m).Where("tenant_id = ?", 0).Update("tenant_id", tenant.ID).Error; err != nil {
+			log.Fatalf("❌ 迁移存量数据到默认租户失败: %v", err)
+		}
+	}
+	return &tenant
+}
@@ -0,0 +1,24 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// LoginThrottle 按用户名或客户端 IP 记录的登录失败次数
+type LoginThrottle struct {
+	Key           string     `gorm:"column:throttle_key;primaryKey;size:191" json:"key"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `gorm:"index" json:"last_failure_at"`
+	BlockedUntil  *time.Time `json:"blocked_until,omitempty"` // 在此之前拒绝登录
+}
+
+// UserThrottleKey 用户名维度的限流键，用户名按租户区分
+func UserThrottleKey(tenantID uint, username string) string {
+	return fmt.Sprintf("user:%d:%s", tenantID, username)
+}
+
+// IPThrottleKey 客户端 IP 维度的限流键
+func IPThrottleKey(ip string) string {
+	return "ip:" + ip
+}
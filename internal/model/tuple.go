@@ -0,0 +1,61 @@
+package model
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// RelationTuple 关系元组 object#relation@subject，如 doc:readme#viewer@user:alice；
+// 主体也可以是另一个对象的关系集合，如 doc:readme#viewer@folder:eng#viewer
+type RelationTuple struct {
+	ID              uint      `gorm:"primaryKey" json:"id"`
+	TenantID        uint      `gorm:"uniqueIndex:idx_tuples_unique;index:idx_tuples_object;not null" json:"tenant_id"`
+	Namespace       string    `gorm:"uniqueIndex:idx_tuples_unique;index:idx_tuples_object;size:64;not null" json:"namespace"`
+	ObjectID        string    `gorm:"uniqueIndex:idx_tuples_unique;index:idx_tuples_object;size:128;not null" json:"object_id"`
+	Relation        string    `gorm:"uniqueIndex:idx_tuples_unique;index:idx_tuples_object;size:64;not null" json:"relation"`
+	SubjectType     string    `gorm:"uniqueIndex:idx_tuples_unique;index:idx_tuples_subject;size:64;not null" json:"subject_type"`
+	SubjectID       string    `gorm:"uniqueIndex:idx_tuples_unique;index:idx_tuples_subject;size:128;not null" json:"subject_id"`
+	SubjectRelation string    `gorm:"uniqueIndex:idx_tuples_unique;size:64;not null;default:''" json:"subject_relation,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// Object 元组的对象部分，格式为 namespace:id
+func (t RelationTuple) Object() string {
+	return t.Namespace + ":" + t.ObjectID
+}
+
+// Subject 元组的主体部分，格式为 type:id 或 type:id#relation
+func (t RelationTuple) Subject() string {
+	s := t.SubjectType + ":" + t.SubjectID
+	if t.SubjectRelation != "" {
+		s += "#" + t.SubjectRelation
+	}
+	return s
+}
+
+func (t RelationTuple) String() string {
+	return t.Object() + "#" + t.Relation + "@" + t.Subject()
+}
+
+// ParseObject 解析 namespace:id
+func ParseObject(s string) (namespace, id string, err error) {
+	namespace, id, ok := strings.Cut(s, ":")
+	if !ok || namespace == "" || id == "" || strings.ContainsAny(id, "#@") {
+		return "", "", errors.New("对象格式应为 namespace:id")
+	}
+	return namespace, id, nil
+}
+
+// ParseSubject 解析 type:id 或 type:id#relation
+func ParseSubject(s string) (typ, id, relation string, err error) {
+	obj, relation, hasRelation := strings.Cut(s, "#")
+	if hasRelation && relation == "" {
+		return "", "", "", errors.New("主体格式应为 type:id 或 type:id#relation")
+	}
+	typ, id, err = ParseObject(obj)
+	if err != nil {
+		return "", "", "", errors.New("主体格式应为 type:id 或 type:id#relation")
+	}
+	return typ, id, relation, nil
+}
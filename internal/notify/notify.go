@@ -0,0 +1,88 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Message 发给某个用户的通知，如密码重置链接
+type Message struct {
+	Time     time.Time `json:"time"`
+	TenantID uint      `json:"tenant_id"`
+	Tenant   string    `json:"tenant"`
+	Username string    `json:"username"`
+	To       string    `json:"to,omitempty"` // 收件地址，为空时由实现自行决定
+	Subject  string    `json:"subject"`
+	Body     string    `json:"body"`
+}
+
+// Notifier 通知的投递方式，可替换为邮件、短信等实现
+type Notifier interface {
+	Notify(ctx context.Context, m *Message) error
+}
+
+// LogNotifier 输出到服务日志，仅用于本地开发
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(_ context.Context, m *Message) error {
+	log.Printf("📨 通知 %s/%s: %s\n%s", m.Tenant, m.Username, m.Subject, m.Body)
+	return nil
+}
+
+// FileNotifier 逐行追加写为 JSON，便于本地测试时读取
+type FileNotifier struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewFileNotifier 追加写入 JSONL 文件
+func NewFileNotifier(path string) (*FileNotifier, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileNotifier{enc: json.NewEncoder(f)}, nil
+}
+
+func (n *FileNotifier) Notify(_ context.Context, m *Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.enc.Encode(m)
+}
+
+// Open 按名称（log、file）创建通知方式
+func Open(kind, filePath string) (Notifier, error) {
+	switch kind {
+	case "", "log":
+		return LogNotifier{}, nil
+	case "file":
+		return NewFileNotifier(filePath)
+	default:
+		return nil, fmt.Errorf("未知的通知方式: %s", kind)
+	}
+}
+
+var std atomic.Pointer[Notifier]
+
+func init() {
+	SetDefault(LogNotifier{})
+}
+
+// SetDefault 设置全局通知方式
+func SetDefault(n Notifier) {
+	std.Store(&n)
+}
+
+// Send 使用全局通知方式发送
+func Send(ctx context.Context, m *Message) error {
+	if m.Time.IsZero() {
+		m.Time = time.Now()
+	}
+	return (*std.Load()).Notify(ctx, m)
+}
@@ -0,0 +1,98 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"log"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Signer 签发 ID Token 的 RSA 密钥，公钥通过 JWKS 对外公布
+type Signer struct {
+	key *rsa.PrivateKey
+	kid string
+}
+
+// LoadSigner 从 PEM 文件（PKCS#1 或 PKCS#8）加载签名密钥；path 为空时生成临时密钥，
+// 重启后已签发的 ID Token 将无法校验，仅用于本地开发
+func LoadSigner(path string) (*Signer, error) {
+	if path == "" {
+		log.Println("⚠️ 未配置 OIDC_SIGNING_KEY_FILE，使用临时生成的签名密钥")
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		return NewSigner(key), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("签名密钥不是 PEM 格式")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return NewSigner(key), nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("签名密钥须为 RSA 私钥")
+	}
+	return NewSigner(key), nil
+}
+
+// NewSigner kid 取公钥模数的 SHA-256 前缀，换用新密钥时随之变化
+func NewSigner(key *rsa.PrivateKey) *Signer {
+	sum := sha256.Sum256(key.N.Bytes())
+	return &Signer{key: key, kid: base64.RawURLEncoding.EncodeToString(sum[:12])}
+}
+
+// Sign 使用 RS256 签名
+func (s *Signer) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = s.kid
+	return token.SignedString(s.key)
+}
+
+// JWK RFC 7517 的 RSA 公钥
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKS RFC 7517 的公钥集合
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS 返回公钥集合的 JSON
+func (s *Signer) JWKS() ([]byte, error) {
+	pub := s.key.PublicKey
+	return json.Marshal(JWKS{Keys: []JWK{{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: "RS256",
+		Kid: s.kid,
+		N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}}})
+}
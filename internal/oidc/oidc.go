@@ -0,0 +1,97 @@
+package oidc
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// 标准 scope，其余 scope 视为权限名
+const (
+	ScopeOpenID        = "openid"
+	ScopeProfile       = "profile"
+	ScopeOfflineAccess = "offline_access"
+)
+
+// IsStandardScope 是否为 OIDC 定义的 scope
+func IsStandardScope(scope string) bool {
+	switch scope {
+	case ScopeOpenID, ScopeProfile, ScopeOfflineAccess:
+		return true
+	}
+	return false
+}
+
+// IDTokenClaims ID Token 的声明，profile 相关字段仅在申请了 profile 时填写
+type IDTokenClaims struct {
+	Nonce             string   `json:"nonce,omitempty"`
+	AuthTime          int64    `json:"auth_time,omitempty"`
+	AtHash            string   `json:"at_hash,omitempty"`
+	PreferredUsername string   `json:"preferred_username,omitempty"`
+	Tenant            string   `json:"tenant,omitempty"`
+	Roles             []string `json:"roles,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// AccessTokenHash ID Token 中的 at_hash：access token 的 SHA-256 左半部分
+func AccessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+}
+
+// VerifyPKCE 校验 code_verifier（RFC 7636），只支持 S256
+func VerifyPKCE(challenge, method, verifier string) bool {
+	if method != "S256" || len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+// ValidateRedirectURI 登记回调地址时校验：须为不含 fragment 的绝对地址，
+// 除本机调试地址外只允许 https
+func ValidateRedirectURI(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return errors.New("回调地址须为绝对地址: " + raw)
+	}
+	if u.Fragment != "" || strings.Contains(raw, "#") {
+		return errors.New("回调地址不能包含 fragment: " + raw)
+	}
+	switch u.Scheme {
+	case "https":
+		return nil
+	case "http":
+		if host := u.Hostname(); host == "localhost" || host == "127.0.0.1" || host == "::1" {
+			return nil
+		}
+	}
+	return errors.New("回调地址须使用 https: " + raw)
+}
+
+// Discovery /.well-known/openid-configuration 的内容
+func Discovery(issuer string) ([]byte, error) {
+	issuer = strings.TrimRight(issuer, "/")
+	return json.Marshal(map[string]any{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/oauth2/authorize",
+		"token_endpoint":                        issuer + "/oauth2/token",
+		"userinfo_endpoint":                     issuer + "/userinfo",
+		"jwks_uri":                              issuer + "/oauth2/jwks",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code", "refresh_token", "client_credentials", "urn:ietf:params:oauth:grant-type:token-exchange"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      []string{ScopeOpenID, ScopeProfile, ScopeOfflineAccess},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported":                      []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "preferred_username", "tenant", "roles"},
+	})
+}
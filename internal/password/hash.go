@@ -0,0 +1,39 @@
+package password
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Hash 使用 bcrypt 计算密码哈希
+func Hash(pw string) (string, error) {
+	h, err := bcrypt.GenerateFromPassword([]byte(pw), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(h), nil
+}
+
+// IsHashed 是否为 bcrypt 哈希；引入哈希前的存量密码为明文
+func IsHashed(stored string) bool {
+	return strings.HasPrefix(stored, "$2a$") || strings.HasPrefix(stored, "$2b$") || strings.HasPrefix(stored, "$2y$")
+}
+
+// Verify 校验密码，兼容存量明文密码（调用方应在校验通过后改存哈希）
+func Verify(stored, pw string) bool {
+	if !IsHashed(stored) {
+		return stored != "" && subtle.ConstantTimeCompare([]byte(stored), []byte(pw)) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(stored), []byte(pw)) == nil
+}
+
+// dummyHash 用户不存在时也做一次比较，使响应时间与密码错误时一致
+var dummyHash, _ = Hash("dummy-password")
+
+// VerifyMissing 用户不存在时调用，始终返回 false
+func VerifyMissing(pw string) bool {
+	_ = bcrypt.CompareHashAndPassword([]byte(dummyHash), []byte(pw))
+	return false
+}
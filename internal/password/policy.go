@@ -0,0 +1,94 @@
+package password
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+//go:embed common.txt
+var defaultBlocklist []byte
+
+// MaxBytes bcrypt 只接受不超过 72 字节的密码
+const MaxBytes = 72
+
+// Policy 密码复杂度策略
+type Policy struct {
+	MinLength  int
+	MinClasses int // 大写字母、小写字母、数字、符号中至少包含几类
+	blocklist  map[string]bool
+}
+
+// NewPolicy 创建策略，blocklistFile 为空时使用内置的常见弱密码列表
+func NewPolicy(minLength, minClasses int, blocklistFile string) (*Policy, error) {
+	data := defaultBlocklist
+	if blocklistFile != "" {
+		var err error
+		if data, err = os.ReadFile(blocklistFile); err != nil {
+			return nil, fmt.Errorf("读取弱密码列表失败: %w", err)
+		}
+	}
+	p := &Policy{MinLength: minLength, MinClasses: minClasses, blocklist: make(map[string]bool)}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.blocklist[strings.ToLower(line)] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取弱密码列表失败: %w", err)
+	}
+	return p, nil
+}
+
+// Validate 返回密码不满足的所有规则，全部满足时返回 nil
+func (p *Policy) Validate(username, pw string) []string {
+	var problems []string
+	if n := utf8.RuneCountInString(pw); n < p.MinLength {
+		problems = append(problems, fmt.Sprintf("长度不能少于 %d 个字符", p.MinLength))
+	}
+	if len(pw) > MaxBytes {
+		problems = append(problems, fmt.Sprintf("长度不能超过 %d 字节", MaxBytes))
+	}
+	if n := classes(pw); n < p.MinClasses {
+		problems = append(problems, fmt.Sprintf("需包含大写字母、小写字母、数字、符号中的至少 %d 类", p.MinClasses))
+	}
+	lower := strings.ToLower(pw)
+	if username != "" && lower == strings.ToLower(username) {
+		problems = append(problems, "不能与用户名相同")
+	}
+	if p.blocklist[lower] {
+		problems = append(problems, "属于常见弱密码")
+	}
+	return problems
+}
+
+func classes(pw string) int {
+	var upper, lower, digit, symbol bool
+	for _, r := range pw {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	n := 0
+	for _, b := range []bool{upper, lower, digit, symbol} {
+		if b {
+			n++
+		}
+	}
+	return n
+}
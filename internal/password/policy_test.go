@@ -0,0 +1,26 @@
+package password
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateMaxBytes(t *testing.T) {
+	p, err := NewPolicy(8, 3, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := "Aa1!" + strings.Repeat("x", MaxBytes-4)
+	if problems := p.Validate("alice", ok); len(problems) != 0 {
+		t.Errorf("72 字节的密码不应报错: %v", problems)
+	}
+	// 多字节字符按字节计算
+	long := "Aa1!" + strings.Repeat("密", 23)
+	problems := p.Validate("alice", long)
+	if len(problems) != 1 || !strings.Contains(problems[0], "72") {
+		t.Fatalf("超长密码的校验结果 = %v", problems)
+	}
+	if _, err := Hash(ok); err != nil {
+		t.Errorf("72 字节的密码无法哈希: %v", err)
+	}
+}
@@ -0,0 +1,329 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/audit"
+	"grpc-rbac-backend/internal/model"
+)
+
+// ApproverPermission 拥有该权限的用户可以审批本租户的临时权限申请
+const ApproverPermission = "access-requests:approve"
+
+// RequestAccess 申请在一段时间内临时获得某个角色
+func (s *Service) RequestAccess(ctx context.Context, req *api.RequestAccessRequest) (*api.RequestAccessResponse, error) {
+	duration := time.Duration(req.DurationSeconds) * time.Second
+	if duration <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "申请时长必须大于 0")
+	}
+	if duration > s.cfg.AccessRequestMaxDuration {
+		return nil, status.Errorf(codes.InvalidArgument, "申请时长不能超过 %v", s.cfg.AccessRequestMaxDuration)
+	}
+	if strings.TrimSpace(req.Justification) == "" {
+		return nil, status.Error(codes.InvalidArgument, "请填写申请理由")
+	}
+
+	var ar model.AccessRequest
+	err := withAudit(ctx, "RequestAccess", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		user, err := currentUser(tx, ctx)
+		if err != nil {
+			return err
+		}
+		var role model.Role
+		if err := tx.Scopes(model.TenantScope(user.TenantID)).First(&role, req.RoleId).Error; err != nil {
+			return err
+		}
+
+		// 同一角色只能有一个待审批的申请
+		var pending int64
+		if err := tx.Model(&model.AccessRequest{}).
+			Where("requester_id = ? AND role_id = ? AND state = ?", user.ID, role.ID, model.AccessRequestPending).
+			Count(&pending).Error; err != nil {
+			return err
+		}
+		if pending > 0 {
+			return status.Error(codes.AlreadyExists, "该角色已有待审批的申请")
+		}
+
+		ar = model.AccessRequest{
+			TenantID:        user.TenantID,
+			RequesterID:     user.ID,
+			RoleID:          role.ID,
+			DurationSeconds: req.DurationSeconds,
+			Justification:   req.Justification,
+			State:           model.AccessRequestPending,
+			PendingUntil:    time.Now().Add(s.cfg.AccessRequestPendingTTL),
+		}
+		if err := tx.Create(&ar).Error; err != nil {
+			return err
+		}
+		ev.Target = audit.Target("access-request", ar.ID)
+		ev.After = audit.Snapshot(ar)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.RequestAccessResponse{Message: "申请已提交", RequestId: uint32(ar.ID)}, nil
+}
+
+// ListAccessRequests 审批人可查看本租户全部申请，其他用户只能查看自己的申请
+func (s *Service) ListAccessRequests(ctx context.Context, req *api.ListAccessRequestsRequest) (*api.ListAccessRequestsResponse, error) {
+	user, err := currentUser(model.DB, ctx)
+	if err != nil {
+		return nil, err
+	}
+	isApprover, err := callerHasPermission(model.DB, ctx, user, ApproverPermission)
+	if err != nil {
+		return nil, err
+	}
+
+	q := model.DB.Model(&model.AccessRequest{}).Scopes(model.TenantScope(user.TenantID))
+	if !isApprover || req.Mine {
+		q = q.Where("requester_id = ?", user.ID)
+	}
+	if req.State != "" {
+		q = q.Where("state = ?", req.State)
+	}
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, err
+	}
+	var requests []model.AccessRequest
+	if err := q.Order("id DESC").Scopes(paginate(req.Page, req.PageSize)).Find(&requests).Error; err != nil {
+		return nil, err
+	}
+
+	// 批量查询申请人、审批人和角色名称
+	userIDs := make([]uint, 0, len(requests)*2)
+	roleIDs := make([]uint, 0, len(requests))
+	for _, r := range requests {
+		userIDs = append(userIDs, r.RequesterID)
+		if r.ReviewerID != nil {
+			userIDs = append(userIDs, *r.ReviewerID)
+		}
+		roleIDs = append(roleIDs, r.RoleID)
+	}
+	usernames := make(map[uint]string)
+	roleNames := make(map[uint]string)
+	if len(requests) > 0 {
+		var users []model.User
+		if err := model.DB.Select("id", "username").Find(&users, userIDs).Error; err != nil {
+			return nil, err
+		}
+		for _, u := range users {
+			usernames[u.ID] = u.Username
+		}
+		var roles []model.Role
+		if err := model.DB.Select("id", "name").Find(&roles, roleIDs).Error; err != nil {
+			return nil, err
+		}
+		for _, r := range roles {
+			roleNames[r.ID] = r.Name
+		}
+	}
+
+	infos := make([]*api.AccessRequestInfo, 0, len(requests))
+	for _, r := range requests {
+		info := &api.AccessRequestInfo{
+			Id:              uint32(r.ID),
+			Requester:       usernames[r.RequesterID],
+			RoleId:          uint32(r.RoleID),
+			RoleName:        roleNames[r.RoleID],
+			DurationSeconds: r.DurationSeconds,
+			Justification:   r.Justification,
+			State:           r.State,
+			ReviewComment:   r.ReviewComment,
+			CreatedAt:       r.CreatedAt.Unix(),
+			PendingUntil:    r.PendingUntil.Unix(),
+			ReviewedAt:      ptrUnix(r.ReviewedAt),
+			GrantedUntil:    ptrUnix(r.GrantedUntil),
+		}
+		if r.ReviewerID != nil {
+			info.Reviewer = usernames[*r.ReviewerID]
+		}
+		infos = append(infos, info)
+	}
+	return &api.ListAccessRequestsResponse{Requests: infos, Total: total}, nil
+}
+
+// ApproveAccessRequest 批准申请，自动创建限时角色分配
+func (s *Service) ApproveAccessRequest(ctx context.Context, req *api.ApproveAccessRequestRequest) (*api.ApproveAccessRequestResponse, error) {
+	if err := s.reviewAccessRequest(ctx, "ApproveAccessRequest", req.RequestId, req.Comment, model.AccessRequestApproved); err != nil {
+		return nil, err
+	}
+	return &api.ApproveAccessRequestResponse{Message: "申请已批准"}, nil
+}
+
+// DenyAccessRequest 拒绝申请
+func (s *Service) DenyAccessRequest(ctx context.Context, req *api.DenyAccessRequestRequest) (*api.DenyAccessRequestResponse, error) {
+	if err := s.reviewAccessRequest(ctx, "DenyAccessRequest", req.RequestId, req.Comment, model.AccessRequestDenied); err != nil {
+		return nil, err
+	}
+	return &api.DenyAccessRequestResponse{Message: "申请已拒绝"}, nil
+}
+
+// RevokeAccessRequest 提前收回已批准的临时角色
+func (s *Service) RevokeAccessRequest(ctx context.Context, req *api.RevokeAccessRequestRequest) (*api.RevokeAccessRequestResponse, error) {
+	if err := s.reviewAccessRequest(ctx, "RevokeAccessRequest", req.RequestId, req.Comment, model.AccessRequestRevoked); err != nil {
+		return nil, err
+	}
+	return &api.RevokeAccessRequestResponse{Message: "临时权限已收回"}, nil
+}
+
+// reviewAccessRequest 审批人推进申请状态，并同步创建或删除对应的角色分配
+func (s *Service) reviewAccessRequest(ctx context.Context, action string, requestID uint32, comment string, to string) error {
+	return withAudit(ctx, action, func(tx *gorm.DB, ev *model.AuditEvent) error {
+		ev.Target = audit.Target("access-request", uint(requestID))
+
+		reviewer, err := currentUser(tx, ctx)
+		if err != nil {
+			return err
+		}
+		ok, err := callerHasPermission(tx, ctx, reviewer, ApproverPermission)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return status.Error(codes.PermissionDenied, "没有审批权限")
+		}
+
+		var ar model.AccessRequest
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Scopes(model.TenantScope(reviewer.TenantID)).
+			First(&ar, requestID).Error; err != nil {
+			return err
+		}
+		ev.Before = audit.Snapshot(ar)
+
+		now := time.Now()
+		if to != model.AccessRequestRevoked && ar.RequesterID == reviewer.ID {
+			return status.Error(codes.PermissionDenied, "不能审批自己的申请")
+		}
+		if to == model.AccessRequestApproved && now.After(ar.PendingUntil) {
+			return status.Error(codes.FailedPrecondition, "申请已超过审批期限")
+		}
+		if err := ar.Transition(to); err != nil {
+			return status.Error(codes.FailedPrecondition, err.Error())
+		}
+		ar.ReviewerID = &reviewer.ID
+		ar.ReviewComment = comment
+		ar.ReviewedAt = &now
+
+		switch to {
+		case model.AccessRequestApproved:
+			// 截断到秒，便于收回时按过期时间精确匹配
+			until := now.Add(time.Duration(ar.DurationSeconds) * time.Second).Truncate(time.Second)
+			ar.GrantedUntil = &until
+			guard, err := beginSodGuard(tx, ar.TenantID, []uint{ar.RequesterID})
+			if err != nil {
+				return err
+			}
+			if err := grantTemporaryRole(tx, ar.RequesterID, ar.RoleID, until); err != nil {
+				return err
+			}
+			if err := guard.check(tx); err != nil {
+				return err
+			}
+		case model.AccessRequestRevoked:
+			if err := tx.Where("user_id = ? AND role_id = ? AND expires_at = ?", ar.RequesterID, ar.RoleID, ar.GrantedUntil).
+				Delete(&model.UserRole{}).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Save(&ar).Error; err != nil {
+			return err
+		}
+		ev.After = audit.Snapshot(ar)
+		return nil
+	})
+}
+
+// grantTemporaryRole 创建到 until 为止的角色分配；用户已有该角色的分配时只提前生效时间、
+// 延长过期时间，不会缩短已有的分配
+func grantTemporaryRole(tx *gorm.DB, userID, roleID uint, until time.Time) error {
+	var existing model.UserRole
+	if err := tx.Where("user_id = ? AND role_id = ?", userID, roleID).Limit(1).Find(&existing).Error; err != nil {
+		return err
+	}
+	if existing.UserID == 0 {
+		return tx.Create(&model.UserRole{UserID: userID, RoleID: roleID, ExpiresAt: &until}).Error
+	}
+	updates := map[string]interface{}{}
+	if existing.NotBefore != nil && existing.NotBefore.After(time.Now()) {
+		updates["not_before"] = nil
+	}
+	if existing.ExpiresAt != nil && existing.ExpiresAt.Before(until) {
+		updates["expires_at"] = until
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	return tx.Model(&model.UserRole{}).Where("user_id = ? AND role_id = ?", userID, roleID).Updates(updates).Error
+}
+
+// errAccessRequestChanged 申请在清理期间被并发审批或撤销
+var errAccessRequestChanged = errors.New("申请状态已变化")
+
+// sweepAccessRequests 将超过审批期限的待审批申请和已到期的已批准申请标记为过期，每条申请单独写一条审计记录
+func sweepAccessRequests(ctx context.Context) error {
+	for {
+		now := time.Now()
+		var due []model.AccessRequest
+		if err := model.DB.
+			Where("(state = ? AND pending_until <= ?) OR (state = ? AND granted_until <= ?)",
+				model.AccessRequestPending, now, model.AccessRequestApproved, now).
+			Order("id").Limit(sweepBatchSize).Find(&due).Error; err != nil {
+			return err
+		}
+		for i := range due {
+			err := expireAccessRequest(ctx, due[i])
+			if errors.Is(err, errAccessRequestChanged) {
+				log.Printf("⚠️ 申请 %d 已被并发处理，跳过过期", due[i].ID)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if len(due) < sweepBatchSize || ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// expireAccessRequest 将申请标记为过期；只更新仍处于原状态的记录，避免覆盖并发的审批结果
+func expireAccessRequest(ctx context.Context, ar model.AccessRequest) error {
+	from := ar.State
+	return withAudit(ctx, "ExpireAccessRequest", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		ev.Target = audit.Target("access-request", ar.ID)
+		ev.TenantID = ar.TenantID
+		ev.Before = audit.Snapshot(ar)
+		if err := ar.Transition(model.AccessRequestExpired); err != nil {
+			return err
+		}
+		res := tx.Model(&model.AccessRequest{}).
+			Where("id = ? AND state = ?", ar.ID, from).
+			Update("state", ar.State)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errAccessRequestChanged
+		}
+		ev.After = audit.Snapshot(ar)
+		return nil
+	})
+}
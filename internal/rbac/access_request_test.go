@@ -0,0 +1,58 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"grpc-rbac-backend/internal/model"
+)
+
+// 清理任务逐批处理全部到期申请，跳过已被并发处理的申请
+func TestSweepAccessRequests(t *testing.T) {
+	tt := newTestTenant(t)
+	user := tt.createUser(t, "alice")
+	role := tt.createRole(t, "deployer")
+	past := time.Now().Add(-time.Minute)
+	requests := make([]model.AccessRequest, sweepBatchSize+20)
+	for i := range requests {
+		requests[i] = model.AccessRequest{
+			TenantID: tt.ID, RequesterID: user.ID, RoleID: role.ID, DurationSeconds: 3600,
+			State: model.AccessRequestPending, PendingUntil: past,
+		}
+	}
+	if err := model.DB.Create(&requests).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 清理前申请已被审批人拒绝
+	stale := requests[0]
+	if err := model.DB.Model(&stale).Update("state", model.AccessRequestDenied).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := expireAccessRequest(context.Background(), requests[0]); !errors.Is(err, errAccessRequestChanged) {
+		t.Fatalf("err = %v，期望 errAccessRequestChanged", err)
+	}
+
+	if err := sweepAccessRequests(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var counts []struct {
+		State string
+		N     int
+	}
+	if err := model.DB.Model(&model.AccessRequest{}).Select("state, COUNT(*) AS n").
+		Where("tenant_id = ?", tt.ID).Group("state").Scan(&counts).Error; err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{model.AccessRequestDenied: 1, model.AccessRequestExpired: len(requests) - 1}
+	if len(counts) != len(want) {
+		t.Fatalf("申请状态统计 = %v，期望 %v", counts, want)
+	}
+	for _, c := range counts {
+		if want[c.State] != c.N {
+			t.Errorf("%s 状态的申请有 %d 条，期望 %d", c.State, c.N, want[c.State])
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package rbac
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/audit"
+	"grpc-rbac-backend/internal/model"
+	"grpc-rbac-backend/internal/utils"
+)
+
+// apiKeyTouchInterval 最近使用时间的更新间隔，避免每次请求都写库
+const apiKeyTouchInterval = time.Minute
+
+var errInvalidAPIKey = status.Error(codes.Unauthenticated, "API Key 无效")
+
+// AuthenticateAPIKey 校验 API Key，返回其服务账号的身份；Key 限定了权限时写入 Scope。
+// 供 middleware.APIKeyAuthenticator 使用
+func (s *Service) AuthenticateAPIKey(ctx context.Context, key string) (*utils.CustomClaims, error) {
+	rest, ok := strings.CutPrefix(key, model.APIKeyPrefix)
+	if !ok {
+		return nil, errInvalidAPIKey
+	}
+	keyID, secret, ok := strings.Cut(rest, "_")
+	if !ok || keyID == "" || secret == "" {
+		return nil, errInvalidAPIKey
+	}
+
+	var k model.APIKey
+	if err := model.DB.Where("key_id = ?", keyID).Limit(1).Find(&k).Error; err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if k.ID == 0 || subtle.ConstantTimeCompare([]byte(k.SecretHash), []byte(model.HashAPIKeySecret(secret))) != 1 {
+		return nil, errInvalidAPIKey
+	}
+	if !k.Active(now) {
+		return nil, status.Error(codes.Unauthenticated, "API Key 已撤销或过期")
+	}
+
+	var user model.User
+	if err := model.DB.First(&user, k.UserID).Error; err != nil {
+		return nil, errInvalidAPIKey
+	}
+	if err := checkUserActive(&user); err != nil {
+		return nil, err
+	}
+	var tenant model.Tenant
+	if err := model.DB.Select("id", "name").First(&tenant, k.TenantID).Error; err != nil {
+		return nil, err
+	}
+	grants, err := effectiveRoles(model.DB, k.TenantID, user.ID, false)
+	if err != nil {
+		return nil, err
+	}
+	roleNames, _ := roleNamesWithExpiry(grants)
+
+	if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) > apiKeyTouchInterval {
+		if err := model.DB.Model(&k).Update("last_used_at", now).Error; err != nil {
+			return nil, err
+		}
+	}
+	return &utils.CustomClaims{
+		TenantID: k.TenantID,
+		Tenant:   tenant.Name,
+		Username: user.Username,
+		Roles:    roleNames,
+		Scope:    strings.Join(k.Scopes, " "),
+	}, nil
+}
+
+// callerHasPermission 调用方本人是否拥有权限，限定了范围的调用方还需在范围内
+func callerHasPermission(tx *gorm.DB, ctx context.Context, user *model.User, permission string) (bool, error) {
+	claims, err := callerClaims(ctx)
+	if err != nil {
+		return false, err
+	}
+	if !claims.InScope(permission) {
+		return false, nil
+	}
+	return hasPermission(tx, user.TenantID, user.ID, permission)
+}
+
+// tenantScopes 去重后的权限范围，须为本租户已定义的权限
+func tenantScopes(tx *gorm.DB, tenantID uint, names []string) ([]string, error) {
+	scopes := make([]string, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		scopes = append(scopes, name)
+	}
+	if len(scopes) == 0 {
+		return scopes, nil
+	}
+	var count int64
+	if err := tx.Model(&model.Permission{}).Scopes(model.TenantScope(tenantID)).
+		Where("name IN ?", scopes).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if int(count) != len(scopes) {
+		return nil, status.Error(codes.InvalidArgument, "权限范围中包含不存在的权限")
+	}
+	return scopes, nil
+}
+
+// CreateServiceAccount 创建服务账号。服务账号不能用密码登录，只能通过 API Key 调用，
+// 角色通过 AssignUserRole 等接口分配
+func (s *Service) CreateServiceAccount(ctx context.Context, req *api.CreateServiceAccountRequest) (*api.CreateServiceAccountResponse, error) {
+	if err := requireTenantAdmin(ctx); err != nil {
+		return nil, err
+	}
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		return nil, status.Error(codes.InvalidArgument, "服务账号名称不能为空")
+	}
+
+	account := model.User{
+		TenantID:    tenantID,
+		Username:    req.Name,
+		Kind:        model.UserKindService,
+		Description: req.Description,
+	}
+	err = withAudit(ctx, "CreateServiceAccount", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		var count int64
+		if err := tx.Model(&model.User{}).Scopes(model.TenantScope(tenantID)).
+			Where("username = ?", req.Name).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return errors.New("用户名已存在")
+		}
+		if err := tx.Create(&account).Error; err != nil {
+			return err
+		}
+		ev.Target = audit.Target("user", account.ID)
+		ev.After = audit.Snapshot(account)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.CreateServiceAccountResponse{Message: "服务账号创建成功", UserId: uint32(account.ID)}, nil
+}
+
+// CreateAPIKey 为服务账号创建 API Key，完整的 Key 只在创建时返回一次
+func (s *Service) CreateAPIKey(ctx context.Context, req *api.CreateAPIKeyRequest) (*api.CreateAPIKeyResponse, error) {
+	if err := requireTenantAdmin(ctx); err != nil {
+		return nil, err
+	}
+	claims, err := callerClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.ExpiresInSeconds < 0 {
+		return nil, status.Error(codes.InvalidArgument, "有效期不能为负数")
+	}
+
+	idBytes := make([]byte, 6)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, err
+	}
+	keyID := hex.EncodeToString(idBytes)
+	secret, err := utils.RandomToken(24)
+	if err != nil {
+		return nil, err
+	}
+
+	k := model.APIKey{
+		TenantID:   claims.TenantID,
+		UserID:     uint(req.ServiceAccountId),
+		Name:       req.Name,
+		KeyID:      keyID,
+		SecretHash: model.HashAPIKeySecret(secret),
+		CreatedBy:  claims.Username,
+	}
+	if req.ExpiresInSeconds > 0 {
+		expiresAt := time.Now().Add(time.Duration(req.ExpiresInSeconds) * time.Second)
+		k.ExpiresAt = &expiresAt
+	}
+	err = withAudit(ctx, "CreateAPIKey", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		var account model.User
+		if err := tx.Scopes(model.TenantScope(claims.TenantID)).First(&account, req.ServiceAccountId).Error; err != nil {
+			return err
+		}
+		if !account.IsService() {
+			return status.Error(codes.InvalidArgument, "只能为服务账号创建 API Key")
+		}
+
+		scopes, err := tenantScopes(tx, claims.TenantID, req.Scopes)
+		if err != nil {
+			return err
+		}
+		k.Scopes = scopes
+
+		if err := tx.Create(&k).Error; err != nil {
+			return err
+		}
+		ev.Target = audit.Target("api-key", k.ID)
+		ev.After = audit.Snapshot(k)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.CreateAPIKeyResponse{
+		Message: "API Key 创建成功，请妥善保存，之后将无法再次查看",
+		KeyId:   uint32(k.ID),
+		Key:     model.APIKeyPrefix + keyID + "_" + secret,
+	}, nil
+}
+
+// ListAPIKeys 列出本租户的 API Key，可按服务账号过滤；不返回密钥本身
+func (s *Service) ListAPIKeys(ctx context.Context, req *api.ListAPIKeysRequest) (*api.ListAPIKeysResponse, error) {
+	if err := requireTenantAdmin(ctx); err != nil {
+		return nil, err
+	}
+	db, _, err := scoped(ctx)
+	if err != nil {
+		return nil, err
+	}
+	q := db.Model(&model.APIKey{})
+	if req.ServiceAccountId != 0 {
+		q = q.Where("user_id = ?", req.ServiceAccountId)
+	}
+	if !req.IncludeRevoked {
+		q = q.Where("revoked_at IS NULL")
+	}
+	var keys []model.APIKey
+	if err := q.Order("id").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+
+	usernames := make(map[uint]string)
+	if len(keys) > 0 {
+		userIDs := make([]uint, 0, len(keys))
+		for _, k := range keys {
+			userIDs = append(userIDs, k.UserID)
+		}
+		var users []model.User
+		if err := model.DB.Select("id", "username").Find(&users, userIDs).Error; err != nil {
+			return nil, err
+		}
+		for _, u := range users {
+			usernames[u.ID] = u.Username
+		}
+	}
+
+	infos := make([]*api.APIKeyInfo, 0, len(keys))
+	for _, k := range keys {
+		infos = append(infos, &api.APIKeyInfo{
+			Id:               uint32(k.ID),
+			Name:             k.Name,
+			Prefix:           model.APIKeyPrefix + k.KeyID,
+			ServiceAccountId: uint32(k.UserID),
+			ServiceAccount:   usernames[k.UserID],
+			Scopes:           k.Scopes,
+			CreatedBy:        k.CreatedBy,
+			CreatedAt:        k.CreatedAt.Unix(),
+			ExpiresAt:        ptrUnix(k.ExpiresAt),
+			LastUsedAt:       ptrUnix(k.LastUsedAt),
+			RevokedAt:        ptrUnix(k.RevokedAt),
+		})
+	}
+	return &api.ListAPIKeysResponse{Keys: infos}, nil
+}
+
+// RevokeAPIKey 撤销 API Key，立即生效
+func (s *Service) RevokeAPIKey(ctx context.Context, req *api.RevokeAPIKeyRequest) (*api.RevokeAPIKeyResponse, error) {
+	if err := requireTenantAdmin(ctx); err != nil {
+		return nil, err
+	}
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = withAudit(ctx, "RevokeAPIKey", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		ev.Target = audit.Target("api-key", uint(req.KeyId))
+
+		var k model.APIKey
+		if err := tx.Scopes(model.TenantScope(tenantID)).First(&k, req.KeyId).Error; err != nil {
+			return err
+		}
+		if k.RevokedAt != nil {
+			return status.Error(codes.FailedPrecondition, "API Key 已撤销")
+		}
+		ev.Before = audit.Snapshot(k)
+		now := time.Now()
+		k.RevokedAt = &now
+		if err := tx.Model(&k).Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+		ev.After = audit.Snapshot(k)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.RevokeAPIKeyResponse{Message: "API Key 已撤销"}, nil
+}
@@ -0,0 +1,196 @@
+package rbac
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/audit"
+	"grpc-rbac-backend/internal/model"
+)
+
+// unixPtr 将 Unix 秒转换为时间，0 表示不限
+func unixPtr(sec int64) *time.Time {
+	if sec <= 0 {
+		return nil
+	}
+	t := time.Unix(sec, 0)
+	return &t
+}
+
+func ptrUnix(t *time.Time) int64 {
+	if t == nil {
+		return 0
+	}
+	return t.Unix()
+}
+
+// AssignUserRole 租户管理员为用户分配角色，可指定生效和过期时间；已存在的分配只更新请求中指定的时间
+func (s *Service) AssignUserRole(ctx context.Context, req *api.AssignUserRoleRequest) (*api.AssignUserRoleResponse, error) {
+	if err := requireTenantAdmin(ctx); err != nil {
+		return nil, err
+	}
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	assignment := model.UserRole{
+		UserID:    uint(req.UserId),
+		RoleID:    uint(req.RoleId),
+		NotBefore: unixPtr(req.NotBefore),
+		ExpiresAt: unixPtr(req.ExpiresAt),
+	}
+	if assignment.ExpiresAt != nil {
+		if !assignment.ExpiresAt.After(time.Now()) {
+			return nil, status.Error(codes.InvalidArgument, "过期时间必须晚于当前时间")
+		}
+		if assignment.NotBefore != nil && !assignment.ExpiresAt.After(*assignment.NotBefore) {
+			return nil, status.Error(codes.InvalidArgument, "过期时间必须晚于生效时间")
+		}
+	}
+
+	err = withAudit(ctx, "AssignUserRole", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		ev.Target = audit.Target("user", assignment.UserID)
+		return assignUserRole(tx, tenantID, &assignment, ev)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.AssignUserRoleResponse{Message: "角色分配成功"}, nil
+}
+
+// assignUserRole 在事务中写入角色分配，校验用户和角色属于同一租户
+func assignUserRole(tx *gorm.DB, tenantID uint, assignment *model.UserRole, ev *model.AuditEvent) error {
+	var user model.User
+	if err := tx.Scopes(model.TenantScope(tenantID)).First(&user, assignment.UserID).Error; err != nil {
+		return err
+	}
+	var role model.Role
+	if err := tx.Scopes(model.TenantScope(tenantID)).First(&role, assignment.RoleID).Error; err != nil {
+		return err
+	}
+
+	var before model.UserRole
+	if err := tx.Where("user_id = ? AND role_id = ?", user.ID, role.ID).Limit(1).Find(&before).Error; err != nil {
+		return err
+	}
+	if before.UserID != 0 {
+		ev.Before = audit.Snapshot(before)
+	}
+
+	guard, err := beginSodGuard(tx, tenantID, []uint{user.ID})
+	if err != nil {
+		return err
+	}
+	if before.UserID == 0 {
+		if err := tx.Create(assignment).Error; err != nil {
+			return err
+		}
+	} else {
+		if err := updateAssignmentWindow(tx, &before, assignment.NotBefore, assignment.ExpiresAt); err != nil {
+			return err
+		}
+		*assignment = before
+	}
+	if err := guard.check(tx); err != nil {
+		return err
+	}
+	ev.After = audit.Snapshot(map[string]interface{}{"role": role.Name, "assignment": assignment})
+	return nil
+}
+
+// updateAssignmentWindow 只更新已有分配中非空的生效、过期时间，其余字段保持不变
+func updateAssignmentWindow(tx *gorm.DB, assignment *model.UserRole, notBefore, expiresAt *time.Time) error {
+	var columns []string
+	if notBefore != nil {
+		assignment.NotBefore = notBefore
+		columns = append(columns, "not_before")
+	}
+	if expiresAt != nil {
+		assignment.ExpiresAt = expiresAt
+		columns = append(columns, "expires_at")
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+	if assignment.NotBefore != nil && assignment.ExpiresAt != nil && !assignment.ExpiresAt.After(*assignment.NotBefore) {
+		return status.Error(codes.InvalidArgument, "过期时间必须晚于生效时间")
+	}
+	return tx.Model(&model.UserRole{UserID: assignment.UserID, RoleID: assignment.RoleID}).
+		Select(columns).Updates(assignment).Error
+}
+
+// RevokeUserRole 租户管理员撤销用户的直接角色分配
+func (s *Service) RevokeUserRole(ctx context.Context, req *api.RevokeUserRoleRequest) (*api.RevokeUserRoleResponse, error) {
+	if err := requireTenantAdmin(ctx); err != nil {
+		return nil, err
+	}
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = withAudit(ctx, "RevokeUserRole", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		ev.Target = audit.Target("user", uint(req.UserId))
+
+		var user model.User
+		if err := tx.Scopes(model.TenantScope(tenantID)).First(&user, req.UserId).Error; err != nil {
+			return err
+		}
+		var assignment model.UserRole
+		if err := tx.Where("user_id = ? AND role_id = ?", user.ID, req.RoleId).First(&assignment).Error; err != nil {
+			return err
+		}
+		ev.Before = audit.Snapshot(assignment)
+		return tx.Delete(&assignment).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.RevokeUserRoleResponse{Message: "角色已撤销"}, nil
+}
+
+// ListUserRoleAssignments 列出用户的直接角色分配及其时间窗口
+func (s *Service) ListUserRoleAssignments(ctx context.Context, req *api.ListUserRoleAssignmentsRequest) (*api.ListUserRoleAssignmentsResponse, error) {
+	db, _, err := scoped(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var user model.User
+	if err := db.First(&user, req.UserId).Error; err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		model.UserRole
+		RoleName string
+	}
+	if err := model.DB.Table("user_roles").
+		Select("user_roles.*, roles.name AS role_name").
+		Joins("JOIN roles ON roles.id = user_roles.role_id").
+		Where("user_roles.user_id = ?", user.ID).
+		Order("user_roles.role_id").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	infos := make([]*api.RoleAssignment, 0, len(rows))
+	for _, r := range rows {
+		active := (r.NotBefore == nil || !r.NotBefore.After(now)) && (r.ExpiresAt == nil || r.ExpiresAt.After(now))
+		infos = append(infos, &api.RoleAssignment{
+			RoleId:    uint32(r.RoleID),
+			RoleName:  r.RoleName,
+			NotBefore: ptrUnix(r.NotBefore),
+			ExpiresAt: ptrUnix(r.ExpiresAt),
+			Active:    active,
+		})
+	}
+	return &api.ListUserRoleAssignmentsResponse{Assignments: infos}, nil
+}
@@ -0,0 +1,96 @@
+package rbac
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/model"
+)
+
+func findAssignment(t *testing.T, userID, roleID uint) model.UserRole {
+	t.Helper()
+	var ur model.UserRole
+	if err := model.DB.Where("user_id = ? AND role_id = ?", userID, roleID).First(&ur).Error; err != nil {
+		t.Fatal(err)
+	}
+	return ur
+}
+
+// 再次分配只更新请求中指定的时间
+func TestAssignUserRoleKeepsUnspecifiedWindow(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	user := tt.createUser(t, "dave")
+	role := tt.createRole(t, "oncall")
+	ctx := tt.adminCtx()
+
+	notBefore := time.Now().Add(time.Hour).Unix()
+	expiresAt := time.Now().Add(8 * time.Hour).Unix()
+	if _, err := s.AssignUserRole(ctx, &api.AssignUserRoleRequest{UserId: uint32(user.ID), RoleId: uint32(role.ID), NotBefore: notBefore, ExpiresAt: expiresAt}); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(24 * time.Hour).Unix()
+	if _, err := s.AssignUserRole(ctx, &api.AssignUserRoleRequest{UserId: uint32(user.ID), RoleId: uint32(role.ID), ExpiresAt: later}); err != nil {
+		t.Fatal(err)
+	}
+	ur := findAssignment(t, user.ID, role.ID)
+	if ptrUnix(ur.NotBefore) != notBefore || ptrUnix(ur.ExpiresAt) != later {
+		t.Errorf("分配窗口 = %d-%d，期望 %d-%d", ptrUnix(ur.NotBefore), ptrUnix(ur.ExpiresAt), notBefore, later)
+	}
+
+	// 合并后的窗口无效时拒绝
+	early := time.Now().Add(30 * time.Minute).Unix()
+	_, err := s.AssignUserRole(ctx, &api.AssignUserRoleRequest{UserId: uint32(user.ID), RoleId: uint32(role.ID), ExpiresAt: early})
+	if err == nil {
+		t.Fatal("过期时间早于已有的生效时间时应拒绝")
+	}
+}
+
+// 普通用户不能给自己分配 admin 角色，也不能撤销他人的角色
+func TestAssignUserRoleRequiresTenantAdmin(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	user := tt.createUser(t, "mallory")
+	admin := tt.role(t, "admin")
+	ctx := tt.ctx("mallory", "user")
+
+	_, err := s.AssignUserRole(ctx, &api.AssignUserRoleRequest{UserId: uint32(user.ID), RoleId: uint32(admin.ID)})
+	wantCode(t, err, codes.PermissionDenied)
+	if got := directRoles(t, user.ID); len(got) != 0 {
+		t.Errorf("非管理员获得了角色: %v", got)
+	}
+	_, err = s.RevokeUserRole(ctx, &api.RevokeUserRoleRequest{UserId: uint32(tt.Admin.ID), RoleId: uint32(admin.ID)})
+	wantCode(t, err, codes.PermissionDenied)
+	if got := directRoles(t, tt.Admin.ID); len(got) != 1 || got[0] != "admin" {
+		t.Errorf("管理员的角色被撤销: %v", got)
+	}
+}
+
+// 临时授权不缩短、不覆盖已有的分配
+func TestGrantTemporaryRoleOnlyExtends(t *testing.T) {
+	tt := newTestTenant(t)
+	user := tt.createUser(t, "erin")
+	role := tt.createRole(t, "deployer")
+
+	long := time.Now().Add(30 * 24 * time.Hour).Truncate(time.Second)
+	if err := model.DB.Create(&model.UserRole{UserID: user.ID, RoleID: role.ID, ExpiresAt: &long}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := grantTemporaryRole(model.DB, user.ID, role.ID, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if ur := findAssignment(t, user.ID, role.ID); !ur.ExpiresAt.Equal(long) {
+		t.Errorf("已有分配被缩短为 %v", ur.ExpiresAt)
+	}
+
+	until := time.Now().Add(60 * 24 * time.Hour).Truncate(time.Second)
+	if err := grantTemporaryRole(model.DB, user.ID, role.ID, until); err != nil {
+		t.Fatal(err)
+	}
+	if ur := findAssignment(t, user.ID, role.ID); !ur.ExpiresAt.Equal(until) {
+		t.Errorf("过期时间 = %v，期望延长到 %v", ur.ExpiresAt, until)
+	}
+}
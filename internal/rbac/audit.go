@@ -0,0 +1,177 @@
+package rbac
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"google.golang.org/genproto/googleapis/api/httpbody"
+	"gorm.io/gorm"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/audit"
+	"grpc-rbac-backend/internal/model"
+)
+
+// withAudit 在同一事务中执行变更并写入审计记录；变更失败时事务回滚，另行记录失败结果
+func withAudit(ctx context.Context, action string, fn func(tx *gorm.DB, ev *model.AuditEvent) error) error {
+	ev := audit.NewEvent(ctx, action)
+	err := model.DB.Transaction(func(tx *gorm.DB) error {
+		if err := fn(tx, ev); err != nil {
+			return err
+		}
+		ev.Result = audit.ResultSuccess
+		return audit.Write(tx, ev)
+	})
+	if err != nil {
+		ev.ID = 0
+		ev.Result = audit.ResultFailure
+		ev.Error = err.Error()
+		if werr := audit.Write(model.DB, ev); werr != nil {
+			log.Printf("❌ 写入审计记录失败: %v", werr)
+		}
+	}
+	return err
+}
+
+// ListAuditEvents 按操作人、对象、动作和时间范围查询本租户的审计记录
+func (s *Service) ListAuditEvents(ctx context.Context, req *api.ListAuditEventsRequest) (*api.ListAuditEventsResponse, error) {
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	q := model.DB.Model(&model.AuditEvent{}).Scopes(model.TenantScope(tenantID))
+	if req.Actor != "" {
+		q = q.Where("actor = ?", req.Actor)
+	}
+	if req.Target != "" {
+		q = q.Where("target = ?", req.Target)
+	}
+	if req.Action != "" {
+		q = q.Where("action = ?", req.Action)
+	}
+	if req.StartTime > 0 {
+		q = q.Where("created_at >= ?", time.Unix(req.StartTime, 0))
+	}
+	if req.EndTime > 0 {
+		q = q.Where("created_at < ?", time.Unix(req.EndTime, 0))
+	}
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	var events []model.AuditEvent
+	if err := q.Order("id DESC").Scopes(paginate(req.Page, req.PageSize)).Find(&events).Error; err != nil {
+		return nil, err
+	}
+
+	infos := make([]*api.AuditEvent, 0, len(events))
+	for _, e := range events {
+		infos = append(infos, toAuditEventInfo(e))
+	}
+	return &api.ListAuditEventsResponse{Events: infos, Total: total}, nil
+}
+
+func toAuditEventInfo(e model.AuditEvent) *api.AuditEvent {
+	return &api.AuditEvent{
+		Id:        uint32(e.ID),
+		Actor:     e.Actor,
+		Action:    e.Action,
+		Method:    e.Method,
+		Target:    e.Target,
+		Before:    e.Before,
+		After:     e.After,
+		RequestId: e.RequestID,
+		PeerAddr:  e.PeerAddr,
+		Result:    e.Result,
+		Error:     e.Error,
+		CreatedAt: e.CreatedAt.Unix(),
+	}
+}
+
+const maxAuditExportSize = 2000
+
+// VerifyAuditChain 从链首逐条校验审计哈希链，返回第一处断裂的位置
+func (s *Service) VerifyAuditChain(ctx context.Context, req *api.VerifyAuditChainRequest) (*api.VerifyAuditChainResponse, error) {
+	// 哈希链跨越所有租户，只有平台管理员可以校验
+	if err := requirePlatformAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	// 先读取链头，只校验此刻之前写入的记录，避免与并发写入互相干扰
+	var head model.AuditChainHead
+	if err := model.DB.First(&head, 1).Error; err != nil {
+		return nil, err
+	}
+
+	checker := audit.NewChainChecker()
+	var batch []model.AuditEvent
+	err := model.DB.Where("id <= ?", head.LastEventID).
+		FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+			for i := range batch {
+				if err := checker.Check(&batch[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}).Error
+
+	var broken *audit.BrokenLinkError
+	if errors.As(err, &broken) {
+		return &api.VerifyAuditChainResponse{
+			Checked:       uint64(checker.Checked),
+			BrokenEventId: uint32(broken.EventID),
+			Reason:        broken.Reason,
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 末尾记录被删除时剩余部分仍是完整的链，需要与链头比对
+	if checker.LastHash() != head.LastHash {
+		return &api.VerifyAuditChainResponse{
+			Checked:       uint64(checker.Checked),
+			BrokenEventId: uint32(head.LastEventID),
+			Reason:        "末条记录与链头不一致，记录可能被删除",
+		}, nil
+	}
+	return &api.VerifyAuditChainResponse{Ok: true, Checked: uint64(checker.Checked)}, nil
+}
+
+// ExportAuditEvents 导出 ID 区间内的审计记录为签名 JSONL，单次最多 maxAuditExportSize 条；
+// 超出时签名行标记 truncated 并给出下一页的起始 ID
+func (s *Service) ExportAuditEvents(ctx context.Context, req *api.ExportAuditEventsRequest) (*httpbody.HttpBody, error) {
+	if err := requirePlatformAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	q := model.DB.Order("id")
+	if req.StartId > 0 {
+		q = q.Where("id >= ?", req.StartId)
+	}
+	if req.EndId > 0 {
+		q = q.Where("id <= ?", req.EndId)
+	}
+
+	var events []model.AuditEvent
+	if err := q.Limit(maxAuditExportSize + 1).Find(&events).Error; err != nil {
+		return nil, err
+	}
+	var next uint
+	if len(events) > maxAuditExportSize {
+		next = events[maxAuditExportSize].ID
+		events = events[:maxAuditExportSize]
+	}
+
+	var buf bytes.Buffer
+	if err := audit.ExportJSONL(&buf, events, next, audit.SigningKey); err != nil {
+		return nil, err
+	}
+	return &httpbody.HttpBody{ContentType: audit.ExportContentType, Data: buf.Bytes()}, nil
+}
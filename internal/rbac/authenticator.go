@@ -0,0 +1,85 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"grpc-rbac-backend/internal/model"
+	"grpc-rbac-backend/internal/password"
+)
+
+// Authenticator 用户名密码的一种校验方式。本地账号使用本地密码，
+// 由目录服务（如 LDAP）管理的账号交给对应的 Authenticator 校验
+type Authenticator interface {
+	// Name 认证来源，目录账号以此作为 ExternalIdentity.Provider 与本地用户关联
+	Name() string
+	// Tenant 负责登录的租户名
+	Tenant() string
+	// Authenticate 校验用户名密码，通过后返回本地用户；user 为关联的本地用户，
+	// 为 nil 时表示首次登录，由实现创建本地用户。凭证错误时返回 errBadCredentials
+	Authenticate(ctx context.Context, tenant *model.Tenant, user *model.User, username, plain string) (*model.User, error)
+}
+
+// localAuthenticator 校验本地保存的密码哈希
+type localAuthenticator struct {
+	s *Service
+}
+
+func (a localAuthenticator) Name() string { return "local" }
+
+func (a localAuthenticator) Tenant() string { return "" }
+
+func (a localAuthenticator) Authenticate(ctx context.Context, tenant *model.Tenant, user *model.User, username, plain string) (*model.User, error) {
+	// 用户不存在时同样计算一次哈希，避免通过响应时间枚举用户名
+	if user == nil {
+		password.VerifyMissing(plain)
+		return nil, errBadCredentials
+	}
+	if !password.Verify(user.Password, plain) {
+		return nil, errBadCredentials
+	}
+	if !password.IsHashed(user.Password) {
+		if err := upgradePasswordHash(user, plain); err != nil {
+			return nil, err
+		}
+	}
+	if a.s.passwordExpired(user) {
+		return nil, status.Error(codes.FailedPrecondition, "密码已过期，请通过找回密码重置")
+	}
+	return user, nil
+}
+
+// authenticate 选择校验方式：已关联目录的用户只能通过该目录登录，其余本地用户使用本地密码；
+// 本地不存在的用户名依次尝试本租户的目录，通过后创建本地用户
+func (s *Service) authenticate(ctx context.Context, tenant *model.Tenant, user *model.User, username, plain string) (*model.User, error) {
+	local := localAuthenticator{s: s}
+	if user != nil {
+		var providers []string
+		if err := model.DB.Model(&model.ExternalIdentity{}).Where("user_id = ?", user.ID).
+			Pluck("provider", &providers).Error; err != nil {
+			return nil, err
+		}
+		for _, a := range s.authenticators {
+			for _, p := range providers {
+				if a.Name() == p {
+					return a.Authenticate(ctx, tenant, user, username, plain)
+				}
+			}
+		}
+		return local.Authenticate(ctx, tenant, user, username, plain)
+	}
+
+	for _, a := range s.authenticators {
+		if a.Tenant() != tenant.Name {
+			continue
+		}
+		u, err := a.Authenticate(ctx, tenant, nil, username, plain)
+		if !errors.Is(err, errBadCredentials) {
+			return u, err
+		}
+	}
+	return local.Authenticate(ctx, tenant, nil, username, plain)
+}
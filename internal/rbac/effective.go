@@ -0,0 +1,316 @@
+package rbac
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"grpc-rbac-backend/internal/abac"
+	"grpc-rbac-backend/internal/model"
+)
+
+// roleGrant 用户直接或通过组继承获得的一个角色
+type roleGrant struct {
+	Role      model.Role
+	Via       []string   // 继承路径，如 [group:eng group:all]；直接分配时为空
+	ExpiresAt *time.Time // 限时分配的失效时间，仅直接分配可能设置
+
+	Conditions map[uint]string // 权限 ID 到授予条件，仅加载权限时设置
+}
+
+func (g roleGrant) describe() string {
+	if len(g.Via) == 0 {
+		return "role:" + g.Role.Name
+	}
+	return "role:" + g.Role.Name + " via " + strings.Join(g.Via, " -> ")
+}
+
+// groupGraph 租户内组的名称与父子关系
+type groupGraph struct {
+	names    map[uint]string
+	parents  map[uint][]uint
+	children map[uint][]uint
+}
+
+func loadGroupGraph(tx *gorm.DB, tenantID uint) (*groupGraph, error) {
+	var groups []model.Group
+	if err := tx.Scopes(model.TenantScope(tenantID)).Select("id", "name").Find(&groups).Error; err != nil {
+		return nil, err
+	}
+	edges, err := model.LoadGroupEdges(tx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &groupGraph{
+		names:    make(map[uint]string, len(groups)),
+		parents:  make(map[uint][]uint),
+		children: make(map[uint][]uint),
+	}
+	for _, grp := range groups {
+		g.names[grp.ID] = grp.Name
+	}
+	for _, e := range edges {
+		g.parents[e.ChildID] = append(g.parents[e.ChildID], e.GroupID)
+		g.children[e.GroupID] = append(g.children[e.GroupID], e.ChildID)
+	}
+	return g, nil
+}
+
+// descendants 返回 start 及其所有下级组
+func (g *groupGraph) descendants(start []uint) map[uint]bool {
+	seen := make(map[uint]bool, len(start))
+	queue := make([]uint, 0, len(start))
+	for _, id := range start {
+		if !seen[id] {
+			seen[id] = true
+			queue = append(queue, id)
+		}
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, c := range g.children[id] {
+			if !seen[c] {
+				seen[c] = true
+				queue = append(queue, c)
+			}
+		}
+	}
+	return seen
+}
+
+// ancestors 返回从 start 出发可达的所有组（含自身）及到达路径
+func (g *groupGraph) ancestors(start []uint) map[uint][]string {
+	paths := make(map[uint][]string)
+	queue := make([]uint, 0, len(start))
+	for _, id := range start {
+		if _, ok := paths[id]; !ok {
+			paths[id] = []string{"group:" + g.names[id]}
+			queue = append(queue, id)
+		}
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, p := range g.parents[id] {
+			if _, ok := paths[p]; ok {
+				continue
+			}
+			path := append(append([]string{}, paths[id]...), "group:"+g.names[p])
+			paths[p] = path
+			queue = append(queue, p)
+		}
+	}
+	return paths
+}
+
+// userGroupPaths 用户所在的组及其所有上级组
+func userGroupPaths(tx *gorm.DB, tenantID, userID uint) (map[uint][]string, error) {
+	var direct []uint
+	if err := tx.Table("group_users").Where("user_id = ?", userID).Pluck("group_id", &direct).Error; err != nil {
+		return nil, err
+	}
+	if len(direct) == 0 {
+		return map[uint][]string{}, nil
+	}
+	graph, err := loadGroupGraph(tx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	return graph.ancestors(direct), nil
+}
+
+// effectiveRoles 汇总用户直接分配和通过组继承的角色，withPermissions 为 true 时同时加载角色权限
+func effectiveRoles(tx *gorm.DB, tenantID, userID uint, withPermissions bool) ([]roleGrant, error) {
+	type source struct {
+		roleID    uint
+		via       []string
+		expiresAt *time.Time
+	}
+	var sources []source
+
+	// 只取当前时间窗口内生效的直接分配
+	var direct []model.UserRole
+	if err := tx.Scopes(model.ActiveAt(time.Now())).Where("user_id = ?", userID).Find(&direct).Error; err != nil {
+		return nil, err
+	}
+	for _, ur := range direct {
+		sources = append(sources, source{roleID: ur.RoleID, expiresAt: ur.ExpiresAt})
+	}
+
+	groupPaths, err := userGroupPaths(tx, tenantID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(groupPaths) > 0 {
+		groupIDs := make([]uint, 0, len(groupPaths))
+		for id := range groupPaths {
+			groupIDs = append(groupIDs, id)
+		}
+		var rows []struct {
+			GroupID uint
+			RoleID  uint
+		}
+		if err := tx.Table("group_roles").Select("group_id, role_id").Where("group_id IN ?", groupIDs).Scan(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, r := range rows {
+			sources = append(sources, source{roleID: r.RoleID, via: groupPaths[r.GroupID]})
+		}
+	}
+	if len(sources) == 0 {
+		return nil, nil
+	}
+
+	roleIDs := make([]uint, 0, len(sources))
+	for _, src := range sources {
+		roleIDs = append(roleIDs, src.roleID)
+	}
+	q := tx.Scopes(model.TenantScope(tenantID))
+	if withPermissions {
+		q = q.Preload("Permissions")
+	}
+	var roles []model.Role
+	if err := q.Find(&roles, roleIDs).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]model.Role, len(roles))
+	for _, r := range roles {
+		byID[r.ID] = r
+	}
+
+	conditions := make(map[uint]map[uint]string)
+	if withPermissions {
+		var conditional []model.RolePermission
+		if err := tx.Where("role_id IN ? AND condition_expr <> ''", roleIDs).Find(&conditional).Error; err != nil {
+			return nil, err
+		}
+		for _, rp := range conditional {
+			if conditions[rp.RoleID] == nil {
+				conditions[rp.RoleID] = make(map[uint]string)
+			}
+			conditions[rp.RoleID][rp.PermissionID] = rp.Condition
+		}
+	}
+
+	grants := make([]roleGrant, 0, len(sources))
+	for _, src := range sources {
+		if role, ok := byID[src.roleID]; ok {
+			grants = append(grants, roleGrant{Role: role, Via: src.via, ExpiresAt: src.expiresAt, Conditions: conditions[role.ID]})
+		}
+	}
+	return grants, nil
+}
+
+// effectiveRoleNames 去重后的有效角色名
+func effectiveRoleNames(tx *gorm.DB, tenantID, userID uint) ([]string, error) {
+	grants, err := effectiveRoles(tx, tenantID, userID, false)
+	if err != nil {
+		return nil, err
+	}
+	names, _ := roleNamesWithExpiry(grants)
+	return names, nil
+}
+
+// roleNamesWithExpiry 去重后的角色名，以及其中最早失效的限时角色的失效时间；
+// 同一角色有多条来源时取最晚的失效时间，全部不限时则返回 nil
+func roleNamesWithExpiry(grants []roleGrant) ([]string, *time.Time) {
+	names := make([]string, 0, len(grants))
+	latest := make(map[uint]*time.Time, len(grants))
+	for _, g := range grants {
+		prev, seen := latest[g.Role.ID]
+		if !seen {
+			names = append(names, g.Role.Name)
+			latest[g.Role.ID] = g.ExpiresAt
+			continue
+		}
+		if prev != nil && (g.ExpiresAt == nil || g.ExpiresAt.After(*prev)) {
+			latest[g.Role.ID] = g.ExpiresAt
+		}
+	}
+
+	var earliest *time.Time
+	for _, exp := range latest {
+		if exp != nil && (earliest == nil || exp.Before(*earliest)) {
+			earliest = exp
+		}
+	}
+	return names, earliest
+}
+
+// grantMatch 命中的角色来源及其授予条件
+type grantMatch struct {
+	*roleGrant
+	Condition string
+}
+
+func (m grantMatch) describe() string {
+	if m.Condition == "" {
+		return m.roleGrant.describe()
+	}
+	return m.roleGrant.describe() + " when " + m.Condition
+}
+
+// findGrant 返回第一个包含指定权限且条件满足的角色来源，grants 需已加载权限；
+// 没有命中时返回求值过程中遇到的第一个错误，条件求值出错视为不满足
+func findGrant(grants []roleGrant, permission string, in *abac.Input) (*grantMatch, error) {
+	var firstErr error
+	for i := range grants {
+		for _, perm := range grants[i].Role.Permissions {
+			if perm.Name != permission {
+				continue
+			}
+			cond := grants[i].Conditions[perm.ID]
+			if cond == "" {
+				return &grantMatch{roleGrant: &grants[i]}, nil
+			}
+			ok, err := abac.Evaluate(cond, in)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("%s: %w", grants[i].describe(), err)
+				}
+				continue
+			}
+			if ok {
+				return &grantMatch{roleGrant: &grants[i], Condition: cond}, nil
+			}
+		}
+	}
+	return nil, firstErr
+}
+
+// subjectAttributes 条件表达式中 subject 的属性，user 须预加载 Attributes
+func subjectAttributes(user *model.User, tenant string, grants []roleGrant) map[string]any {
+	names, _ := roleNamesWithExpiry(grants)
+	return map[string]any{
+		"id":             int64(user.ID),
+		"username":       user.Username,
+		"tenant":         tenant,
+		"roles":          names,
+		"email":          user.EmailAddress(),
+		"email_verified": user.EmailVerifiedAt != nil,
+		"display_name":   user.DisplayName,
+		"attributes":     attributeValues(user.Attributes),
+	}
+}
+
+// hasPermission 用户当前是否拥有指定权限，带条件的授予只按主体属性求值
+func hasPermission(tx *gorm.DB, tenantID, userID uint, permission string) (bool, error) {
+	grants, err := effectiveRoles(tx, tenantID, userID, true)
+	if err != nil {
+		return false, err
+	}
+	var user model.User
+	if err := tx.Preload("Attributes").First(&user, userID).Error; err != nil {
+		return false, err
+	}
+	var tenant model.Tenant
+	if err := tx.Select("id", "name").First(&tenant, tenantID).Error; err != nil {
+		return false, err
+	}
+	m, _ := findGrant(grants, permission, &abac.Input{Subject: subjectAttributes(&user, tenant.Name, grants)})
+	return m != nil, nil
+}
@@ -0,0 +1,182 @@
+package rbac
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/abac"
+	"grpc-rbac-backend/internal/model"
+)
+
+// 判定过程中各角色来源的状态
+const (
+	grantActive        = "active"
+	grantNotYetActive  = "not-yet-active"
+	grantExpired       = "expired"
+	conditionSatisfied = "satisfied"
+	conditionFailed    = "not-satisfied"
+)
+
+// ListEffectivePermissions 列出用户当前拥有的全部权限及授予它们的角色来源；
+// 与 CheckPermission 一致，停用或尚未激活的用户没有任何权限
+func (s *Service) ListEffectivePermissions(ctx context.Context, req *api.ListEffectivePermissionsRequest) (*api.ListEffectivePermissionsResponse, error) {
+	db, tenantID, err := scoped(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var user model.User
+	if err := db.First(&user, req.UserId).Error; err != nil {
+		return nil, err
+	}
+	if user.Status != model.UserStatusActive {
+		return &api.ListEffectivePermissionsResponse{MatchedRule: "user-" + user.Status}, nil
+	}
+	grants, err := effectiveRoles(model.DB, tenantID, user.ID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[uint]*api.EffectivePermission)
+	for _, g := range grants {
+		for _, perm := range g.Role.Permissions {
+			ep, ok := byID[perm.ID]
+			if !ok {
+				ep = &api.EffectivePermission{
+					PermissionId: uint32(perm.ID),
+					Name:         perm.Name,
+					Description:  perm.Description,
+				}
+				byID[perm.ID] = ep
+			}
+			ep.Sources = append(ep.Sources, &api.PermissionSource{
+				RoleId:    uint32(g.Role.ID),
+				RoleName:  g.Role.Name,
+				Via:       g.Via,
+				ExpiresAt: ptrUnix(g.ExpiresAt),
+				Condition: g.Conditions[perm.ID],
+			})
+		}
+	}
+
+	permissions := make([]*api.EffectivePermission, 0, len(byID))
+	for _, ep := range byID {
+		permissions = append(permissions, ep)
+	}
+	sort.Slice(permissions, func(i, j int) bool { return permissions[i].Name < permissions[j].Name })
+	return &api.ListEffectivePermissionsResponse{Permissions: permissions}, nil
+}
+
+// ExplainDecision 返回 CheckPermission 的完整判定过程：每个角色来源是否生效、
+// 是否包含该权限以及授予条件的求值结果
+func (s *Service) ExplainDecision(ctx context.Context, req *api.ExplainDecisionRequest) (*api.ExplainDecisionResponse, error) {
+	db, tenantID, err := scoped(ctx)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := callerClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var user model.User
+	if err := db.Preload("Attributes").First(&user, req.UserId).Error; err != nil {
+		return nil, err
+	}
+	// 与 CheckPermission 一致，停用或尚未激活的用户直接拒绝
+	if user.Status != model.UserStatusActive {
+		return &api.ExplainDecisionResponse{MatchedRule: "user-" + user.Status}, nil
+	}
+	grants, err := effectiveRoles(model.DB, tenantID, user.ID, true)
+	if err != nil {
+		return nil, err
+	}
+	in := &abac.Input{
+		Subject:  subjectAttributes(&user, claims.Tenant, grants),
+		Resource: req.Resource,
+		Context:  req.Context,
+	}
+
+	resp := &api.ExplainDecisionResponse{MatchedRule: "no-matching-grant"}
+	for i := range grants {
+		g := &grants[i]
+		step := &api.DecisionTraceStep{
+			RoleId:    uint32(g.Role.ID),
+			RoleName:  g.Role.Name,
+			Via:       g.Via,
+			Status:    grantActive,
+			ExpiresAt: ptrUnix(g.ExpiresAt),
+		}
+		for _, perm := range g.Role.Permissions {
+			if perm.Name != req.Permission {
+				continue
+			}
+			step.GrantsPermission = true
+			step.Condition = g.Conditions[perm.ID]
+			matched := step.Condition == ""
+			if !matched {
+				ok, err := abac.Evaluate(step.Condition, in)
+				switch {
+				case err != nil:
+					step.ConditionResult = "error: " + err.Error()
+				case ok:
+					step.ConditionResult = conditionSatisfied
+					matched = true
+				default:
+					step.ConditionResult = conditionFailed
+				}
+			}
+			// 与 CheckPermission 一致，以第一个命中的来源作为判定依据
+			if matched && !resp.Allowed {
+				step.Matched = true
+				resp.Allowed = true
+				resp.MatchedRule = grantMatch{roleGrant: g, Condition: step.Condition}.describe()
+			}
+		}
+		resp.Trace = append(resp.Trace, step)
+	}
+
+	// 不在时间窗口内的直接分配不参与判定，一并列出便于排查
+	inactive, err := inactiveAssignments(tenantID, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	resp.Trace = append(resp.Trace, inactive...)
+	return resp, nil
+}
+
+// inactiveAssignments 尚未生效或已过期但还未被清理的直接分配
+func inactiveAssignments(tenantID, userID uint) ([]*api.DecisionTraceStep, error) {
+	now := time.Now()
+	var rows []struct {
+		model.UserRole
+		RoleName string
+	}
+	if err := model.DB.Table("user_roles").
+		Select("user_roles.*, roles.name AS role_name").
+		Joins("JOIN roles ON roles.id = user_roles.role_id").
+		Where("user_roles.user_id = ? AND roles.tenant_id = ?", userID, tenantID).
+		Where("user_roles.not_before > ? OR user_roles.expires_at <= ?", now, now).
+		Order("user_roles.role_id").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	steps := make([]*api.DecisionTraceStep, 0, len(rows))
+	for _, r := range rows {
+		status := grantExpired
+		if r.NotBefore != nil && r.NotBefore.After(now) {
+			status = grantNotYetActive
+		}
+		steps = append(steps, &api.DecisionTraceStep{
+			RoleId:    uint32(r.RoleID),
+			RoleName:  r.RoleName,
+			Status:    status,
+			NotBefore: ptrUnix(r.NotBefore),
+			ExpiresAt: ptrUnix(r.ExpiresAt),
+		})
+	}
+	return steps, nil
+}
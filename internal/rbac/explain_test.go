@@ -0,0 +1,35 @@
+package rbac
+
+import (
+	"testing"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/model"
+)
+
+// 停用用户的有效权限为空，判定解释与 CheckPermission 一致返回 user-disabled
+func TestExplainDisabledUser(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	user := tt.createUser(t, "bob")
+	tt.grant(t, user, tt.createRole(t, "approver", "invoices:approve"))
+	if err := model.DB.Model(user).Update("status", model.UserStatusDisabled).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	explain, err := s.ExplainDecision(tt.adminCtx(), &api.ExplainDecisionRequest{UserId: uint32(user.ID), Permission: "invoices:approve"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if explain.Allowed || explain.MatchedRule != "user-disabled" {
+		t.Errorf("判定解释 = %v/%s，期望拒绝 user-disabled", explain.Allowed, explain.MatchedRule)
+	}
+
+	effective, err := s.ListEffectivePermissions(tt.adminCtx(), &api.ListEffectivePermissionsRequest{UserId: uint32(user.ID)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(effective.Permissions) != 0 || effective.MatchedRule != "user-disabled" {
+		t.Errorf("有效权限 = %v/%s，期望为空且 user-disabled", effective.Permissions, effective.MatchedRule)
+	}
+}
@@ -0,0 +1,362 @@
+package rbac
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/audit"
+	"grpc-rbac-backend/internal/model"
+	"grpc-rbac-backend/internal/oidc"
+	"grpc-rbac-backend/internal/utils"
+)
+
+// federatedStateTTL 跳转到外部身份提供方后完成登录的时限
+const federatedStateTTL = 10 * time.Minute
+
+// FederationConfig 外部 OIDC 身份提供方配置，键为提供方名称
+type FederationConfig struct {
+	Providers map[string]*IdentityProvider `json:"providers"`
+}
+
+// IdentityProvider 一个外部身份提供方。ID Token 只用本地配置的 JWKS 校验，不在运行时拉取
+type IdentityProvider struct {
+	Tenant                string        `json:"tenant"` // 登录用户归属的租户，为空时使用默认租户
+	Issuer                string        `json:"issuer"`
+	ClientID              string        `json:"client_id"`
+	ClientSecret          string        `json:"client_secret"`
+	AuthorizationEndpoint string        `json:"authorization_endpoint"`
+	TokenEndpoint         string        `json:"token_endpoint"`
+	JWKSFile              string        `json:"jwks_file"`
+	RedirectURI           string        `json:"redirect_uri"` // 网关的回调地址 /v1/login/federated/{provider}/callback
+	Scopes                []string      `json:"scopes"`
+	UsernameClaim         string        `json:"username_claim"` // 默认 preferred_username，缺失时依次取 email、sub
+	RoleMappings          []RoleMapping `json:"role_mappings"`
+	DefaultRoles          []string      `json:"default_roles"` // 首次登录创建用户时分配
+
+	keys map[string]*rsa.PublicKey
+}
+
+// RoleMapping 声明到本地角色的映射：claim 的值（字符串或字符串数组）包含 value 时授予 role
+type RoleMapping struct {
+	Claim string `json:"claim"`
+	Value string `json:"value"`
+	Role  string `json:"role"`
+}
+
+// LoadFederationConfig 加载外部身份提供方配置并读取各自的 JWKS，path 为空时不启用联合登录
+func LoadFederationConfig(path string) (*FederationConfig, error) {
+	cfg := &FederationConfig{Providers: map[string]*IdentityProvider{}}
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("解析身份提供方配置失败: %w", err)
+	}
+	for name, p := range cfg.Providers {
+		if p == nil || p.Issuer == "" || p.ClientID == "" || p.AuthorizationEndpoint == "" ||
+			p.TokenEndpoint == "" || p.RedirectURI == "" || p.JWKSFile == "" {
+			return nil, fmt.Errorf("身份提供方 %s 缺少必填项（issuer、client_id、authorization_endpoint、token_endpoint、redirect_uri、jwks_file）", name)
+		}
+		jwks, err := os.ReadFile(p.JWKSFile)
+		if err != nil {
+			return nil, fmt.Errorf("身份提供方 %s: %w", name, err)
+		}
+		if p.keys, err = oidc.ParseJWKS(jwks); err != nil {
+			return nil, fmt.Errorf("身份提供方 %s: %w", name, err)
+		}
+		if len(p.Scopes) == 0 {
+			p.Scopes = []string{oidc.ScopeOpenID, oidc.ScopeProfile}
+		}
+		if p.UsernameClaim == "" {
+			p.UsernameClaim = "preferred_username"
+		}
+	}
+	return cfg, nil
+}
+
+func (s *Service) identityProvider(name string) (*IdentityProvider, error) {
+	p, ok := s.providers.Providers[name]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "身份提供方不存在: "+name)
+	}
+	return p, nil
+}
+
+// federatedRedirect 生成跳转到外部身份提供方的授权地址，state、nonce 和 PKCE 参数保存在库中供回调校验
+func (s *Service) federatedRedirect(name string) (*api.LoginResponse, error) {
+	p, err := s.identityProvider(name)
+	if err != nil {
+		return nil, err
+	}
+	state, err := utils.RandomToken(24)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := utils.RandomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := utils.RandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	if err := model.DB.Create(&model.FederatedLoginState{
+		StateHash:    model.HashOAuthSecret(state),
+		Provider:     name,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(federatedStateTTL),
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(p.AuthorizationEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", p.RedirectURI)
+	q.Set("scope", strings.Join(p.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", oidc.PKCEChallenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return &api.LoginResponse{RedirectUrl: u.String()}, nil
+}
+
+// CompleteFederatedLogin 外部身份提供方的回调：校验 state，用授权码换取 ID Token 并校验，
+// 按 sub 找到或创建本地用户，同步映射的角色后签发正式 token。MFA 由外部身份提供方负责
+func (s *Service) CompleteFederatedLogin(ctx context.Context, req *api.CompleteFederatedLoginRequest) (*api.LoginResponse, error) {
+	if req.Error != "" {
+		return nil, status.Error(codes.Unauthenticated, "身份提供方拒绝登录: "+req.Error+" "+req.ErrorDescription)
+	}
+	p, err := s.identityProvider(req.Provider)
+	if err != nil {
+		return nil, err
+	}
+
+	var st model.FederatedLoginState
+	err = model.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("state_hash = ?", model.HashOAuthSecret(req.State)).
+			Limit(1).Find(&st).Error; err != nil {
+			return err
+		}
+		if st.ID == 0 || st.Provider != req.Provider || !time.Now().Before(st.ExpiresAt) {
+			return status.Error(codes.Unauthenticated, "登录请求已失效，请重新登录")
+		}
+		return tx.Delete(&st).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rawIDToken, err := oidc.ExchangeCode(ctx, p.TokenEndpoint, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {req.Code},
+		"redirect_uri":  {p.RedirectURI},
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+		"code_verifier": {st.CodeVerifier},
+	})
+	if err != nil {
+		log.Printf("❌ 身份提供方 %s 换取 token 失败: %v", req.Provider, err)
+		return nil, status.Error(codes.Unauthenticated, "身份提供方登录失败")
+	}
+	claims, err := oidc.VerifyIDToken(rawIDToken, p.keys, p.Issuer, p.ClientID, st.Nonce)
+	if err != nil {
+		log.Printf("❌ 身份提供方 %s 的 ID Token 校验失败: %v", req.Provider, err)
+		return nil, status.Error(codes.Unauthenticated, "ID Token 校验失败")
+	}
+
+	tenant, err := resolveTenant(model.DB, p.Tenant)
+	if err != nil {
+		return nil, err
+	}
+	user, err := provisionFederatedUser(ctx, tenant, req.Provider, p, claims)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkUserActive(user); err != nil {
+		return nil, err
+	}
+	grants, err := effectiveRoles(model.DB, tenant.ID, user.ID, false)
+	if err != nil {
+		return nil, err
+	}
+	t, err := s.sessionToken(tenant.ID, tenant.Name, user, grants)
+	if err != nil {
+		return nil, err
+	}
+	return &api.LoginResponse{Token: t.AccessToken, RefreshToken: t.RefreshToken}, nil
+}
+
+// claimString 取字符串类型的声明
+func claimString(claims jwt.MapClaims, name string) string {
+	v, _ := claims[name].(string)
+	return v
+}
+
+// claimHasValue 声明等于 value，或为包含 value 的数组
+func claimHasValue(claims jwt.MapClaims, name, value string) bool {
+	switch v := claims[name].(type) {
+	case string:
+		return v == value
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// provisionFederatedUser 按 provider + sub 找到或创建本地用户，并同步映射的角色
+func provisionFederatedUser(ctx context.Context, tenant *model.Tenant, provider string, p *IdentityProvider, claims jwt.MapClaims) (*model.User, error) {
+	username := claimString(claims, p.UsernameClaim)
+	if username == "" {
+		username = claimString(claims, "email")
+	}
+	if username == "" {
+		username = claimString(claims, "sub")
+	}
+	var user *model.User
+	err := withAudit(ctx, "FederatedLogin", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		ev.TenantID = tenant.ID
+		var created bool
+		var err error
+		user, created, err = linkExternalUser(tx, tenant.ID, provider, claimString(claims, "sub"), username, p.DefaultRoles)
+		if err != nil {
+			return err
+		}
+		if created {
+			ev.After = audit.Snapshot(user)
+		}
+		ev.Actor = user.Username
+		ev.Target = audit.Target("user", user.ID)
+
+		managed := make([]string, 0, len(p.RoleMappings))
+		wanted := make(map[string]bool)
+		for _, m := range p.RoleMappings {
+			managed = append(managed, m.Role)
+			if claimHasValue(claims, m.Claim, m.Value) {
+				wanted[m.Role] = true
+			}
+		}
+		return syncManagedRoles(tx, tenant.ID, user.ID, managed, wanted)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// linkExternalUser 按 provider + subject 找到关联的本地用户；首次登录时创建用户（JIT）并分配默认角色。
+// 用户名已被本地账号占用时拒绝，避免外部账号接管本地账号
+func linkExternalUser(tx *gorm.DB, tenantID uint, provider, subject, username string, defaultRoles []string) (*model.User, bool, error) {
+	var ident model.ExternalIdentity
+	if err := tx.Where("provider = ? AND subject = ?", provider, subject).Limit(1).Find(&ident).Error; err != nil {
+		return nil, false, err
+	}
+	var user model.User
+	if ident.ID != 0 {
+		if ident.TenantID != tenantID {
+			return nil, false, status.Error(codes.FailedPrecondition, "外部账号已关联到其他租户")
+		}
+		if err := tx.First(&user, ident.UserID).Error; err != nil {
+			return nil, false, err
+		}
+		return &user, false, nil
+	}
+
+	var count int64
+	if err := tx.Model(&model.User{}).Scopes(model.TenantScope(tenantID)).
+		Where("username = ?", username).Count(&count).Error; err != nil {
+		return nil, false, err
+	}
+	if count > 0 {
+		return nil, false, status.Error(codes.AlreadyExists, "用户名 "+username+" 已被本地账号占用，请联系管理员")
+	}
+	user = model.User{TenantID: tenantID, Username: username, Kind: model.UserKindHuman}
+	if err := tx.Create(&user).Error; err != nil {
+		return nil, false, err
+	}
+	if err := tx.Create(&model.ExternalIdentity{TenantID: tenantID, UserID: user.ID, Provider: provider, Subject: subject}).Error; err != nil {
+		return nil, false, err
+	}
+	if err := grantRolesByName(tx, tenantID, user.ID, defaultRoles); err != nil {
+		return nil, false, err
+	}
+	return &user, true, nil
+}
+
+// grantRolesByName 按角色名分配不限时的角色，已有的分配保持不变，不存在的角色忽略
+func grantRolesByName(tx *gorm.DB, tenantID, userID uint, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	var roles []model.Role
+	if err := tx.Scopes(model.TenantScope(tenantID)).Where("name IN ?", names).Find(&roles).Error; err != nil {
+		return err
+	}
+	guard, err := beginSodGuard(tx, tenantID, []uint{userID})
+	if err != nil {
+		return err
+	}
+	for _, role := range roles {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&model.UserRole{UserID: userID, RoleID: role.ID}).Error; err != nil {
+			return err
+		}
+	}
+	return guard.check(tx)
+}
+
+// syncManagedRoles 同步由外部来源管理的角色：managed 中的角色在 wanted 中时分配，
+// 否则撤销（包括手工分配的同名角色）
+func syncManagedRoles(tx *gorm.DB, tenantID, userID uint, managed []string, wanted map[string]bool) error {
+	if len(managed) == 0 {
+		return nil
+	}
+	var roles []model.Role
+	if err := tx.Scopes(model.TenantScope(tenantID)).Where("name IN ?", managed).Find(&roles).Error; err != nil {
+		return err
+	}
+	var grant []string
+	var revoke []uint
+	for _, role := range roles {
+		if wanted[role.Name] {
+			grant = append(grant, role.Name)
+		} else {
+			revoke = append(revoke, role.ID)
+		}
+	}
+	if len(revoke) > 0 {
+		if err := tx.Where("user_id = ? AND role_id IN ?", userID, revoke).Delete(&model.UserRole{}).Error; err != nil {
+			return err
+		}
+	}
+	return grantRolesByName(tx, tenantID, userID, grant)
+}
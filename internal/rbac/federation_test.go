@@ -0,0 +1,232 @@
+package rbac
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc/codes"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/model"
+	"grpc-rbac-backend/internal/oidc"
+)
+
+// fakeIdP 模拟外部身份提供方的 token 端点：按授权码返回签名的 ID Token，并校验 PKCE
+type fakeIdP struct {
+	t      *testing.T
+	server *httptest.Server
+	signer *oidc.Signer
+	mu     sync.Mutex
+	codes  map[string]fakeGrant
+}
+
+type fakeGrant struct {
+	challenge string
+	claims    jwt.MapClaims
+}
+
+const fakeClientID = "rbac-test"
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &fakeIdP{t: t, signer: oidc.NewSigner(key), codes: map[string]fakeGrant{}}
+	idp.server = httptest.NewServer(http.HandlerFunc(idp.token))
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (idp *fakeIdP) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	idp.mu.Lock()
+	grant, ok := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	idp.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if !ok || r.PostForm.Get("client_id") != fakeClientID ||
+		!oidc.VerifyPKCE(grant.challenge, "S256", r.PostForm.Get("code_verifier")) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	raw, err := idp.signer.Sign(grant.claims)
+	if err != nil {
+		idp.t.Error(err)
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": raw})
+}
+
+// provider 生成指向模拟端点的身份提供方配置：groups 含 finance 时授予 approver
+func (idp *fakeIdP) provider(t *testing.T, tenant string) *IdentityProvider {
+	jwks, err := idp.signer.JWKS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := oidc.ParseJWKS(jwks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &IdentityProvider{
+		Tenant:                tenant,
+		Issuer:                idp.server.URL,
+		ClientID:              fakeClientID,
+		AuthorizationEndpoint: idp.server.URL + "/authorize",
+		TokenEndpoint:         idp.server.URL + "/token",
+		RedirectURI:           "http://localhost:8080/v1/login/federated/corp/callback",
+		Scopes:                []string{oidc.ScopeOpenID, oidc.ScopeProfile},
+		UsernameClaim:         "preferred_username",
+		RoleMappings:          []RoleMapping{{Claim: "groups", Value: "finance", Role: "approver"}},
+		DefaultRoles:          []string{"user"},
+		keys:                  keys,
+	}
+}
+
+// authorize 发起登录并模拟用户在身份提供方处登录成功，返回 state 与授权码
+func (idp *fakeIdP) authorize(t *testing.T, s *Service, claims jwt.MapClaims) (state, code string) {
+	t.Helper()
+	resp, err := s.Login(context.Background(), &api.LoginRequest{Provider: "corp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(resp.RedirectUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" || q.Get("nonce") == "" {
+		t.Fatalf("授权地址缺少 PKCE 或 nonce 参数: %s", resp.RedirectUrl)
+	}
+	full := jwt.MapClaims{
+		"iss":   idp.server.URL,
+		"aud":   fakeClientID,
+		"exp":   time.Now().Add(5 * time.Minute).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": q.Get("nonce"),
+	}
+	for k, v := range claims {
+		full[k] = v
+	}
+	code = q.Get("state") + "-code"
+	idp.mu.Lock()
+	idp.codes[code] = fakeGrant{challenge: q.Get("code_challenge"), claims: full}
+	idp.mu.Unlock()
+	return q.Get("state"), code
+}
+
+func newFederationTest(t *testing.T) (*Service, *testTenant, *fakeIdP) {
+	tt := newTestTenant(t)
+	tt.createRole(t, "approver", "invoices:approve")
+	idp := newFakeIdP(t)
+	s := newTestService(t, &FederationConfig{Providers: map[string]*IdentityProvider{"corp": idp.provider(t, tt.Name)}}, nil)
+	return s, tt, idp
+}
+
+func completeLogin(s *Service, state, code string) (*api.LoginResponse, error) {
+	return s.CompleteFederatedLogin(context.Background(), &api.CompleteFederatedLoginRequest{Provider: "corp", State: state, Code: code})
+}
+
+// 首次登录创建用户并分配默认角色和映射的角色，之后按声明同步映射的角色
+func TestFederatedLoginRoleMapping(t *testing.T) {
+	s, tt, idp := newFederationTest(t)
+
+	state, code := idp.authorize(t, s, jwt.MapClaims{"sub": "u-1", "preferred_username": "gina", "groups": []string{"finance", "eng"}})
+	resp, err := completeLogin(s, state, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Token == "" {
+		t.Fatal("未签发 token")
+	}
+	var user model.User
+	if err := model.DB.Scopes(model.TenantScope(tt.ID)).Where("username = ?", "gina").First(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if got := directRoles(t, user.ID); len(got) != 2 || got[0] != "approver" || got[1] != "user" {
+		t.Errorf("首次登录的角色 = %v，期望 [approver user]", got)
+	}
+
+	// 离开 finance 组后再次登录，映射的角色被撤销，默认角色保留
+	state, code = idp.authorize(t, s, jwt.MapClaims{"sub": "u-1", "preferred_username": "gina", "groups": []string{"eng"}})
+	if _, err := completeLogin(s, state, code); err != nil {
+		t.Fatal(err)
+	}
+	if got := directRoles(t, user.ID); len(got) != 1 || got[0] != "user" {
+		t.Errorf("再次登录后的角色 = %v，期望 [user]", got)
+	}
+}
+
+// state 只能使用一次，且必须是本服务发出的
+func TestFederatedLoginState(t *testing.T) {
+	s, _, idp := newFederationTest(t)
+
+	_, err := completeLogin(s, "forged", "forged-code")
+	wantCode(t, err, codes.Unauthenticated)
+
+	state, code := idp.authorize(t, s, jwt.MapClaims{"sub": "u-2", "preferred_username": "hank"})
+	if _, err := completeLogin(s, state, code); err != nil {
+		t.Fatal(err)
+	}
+	_, err = completeLogin(s, state, code)
+	wantCode(t, err, codes.Unauthenticated)
+
+	// 过期的 state
+	state, code = idp.authorize(t, s, jwt.MapClaims{"sub": "u-2", "preferred_username": "hank"})
+	if err := model.DB.Model(&model.FederatedLoginState{}).Where("state_hash = ?", model.HashOAuthSecret(state)).
+		Update("expires_at", time.Now().Add(-time.Minute)).Error; err != nil {
+		t.Fatal(err)
+	}
+	_, err = completeLogin(s, state, code)
+	wantCode(t, err, codes.Unauthenticated)
+}
+
+// ID Token 中的 nonce 必须与发起登录时的一致
+func TestFederatedLoginNonce(t *testing.T) {
+	s, _, idp := newFederationTest(t)
+	state, code := idp.authorize(t, s, jwt.MapClaims{"sub": "u-3", "preferred_username": "ivy", "nonce": "replayed"})
+	_, err := completeLogin(s, state, code)
+	wantCode(t, err, codes.Unauthenticated)
+}
+
+// 换取 token 时提交的 code_verifier 必须与授权请求中的 code_challenge 对应
+func TestFederatedLoginPKCE(t *testing.T) {
+	s, _, idp := newFederationTest(t)
+	state, code := idp.authorize(t, s, jwt.MapClaims{"sub": "u-4", "preferred_username": "jack"})
+	if err := model.DB.Model(&model.FederatedLoginState{}).Where("state_hash = ?", model.HashOAuthSecret(state)).
+		Update("code_verifier", "tampered-verifier-tampered-verifier-tampered").Error; err != nil {
+		t.Fatal(err)
+	}
+	_, err := completeLogin(s, state, code)
+	wantCode(t, err, codes.Unauthenticated)
+}
+
+// 用户名已被本地账号占用时拒绝，不关联到本地账号
+func TestFederatedLoginUsernameCollision(t *testing.T) {
+	s, tt, idp := newFederationTest(t)
+	local := tt.createUser(t, "kate")
+	state, code := idp.authorize(t, s, jwt.MapClaims{"sub": "u-5", "preferred_username": "kate", "groups": []string{"finance"}})
+	_, err := completeLogin(s, state, code)
+	wantCode(t, err, codes.AlreadyExists)
+
+	var linked int64
+	model.DB.Model(&model.ExternalIdentity{}).Where("user_id = ?", local.ID).Count(&linked)
+	if linked != 0 {
+		t.Error("外部账号被关联到了本地账号")
+	}
+	if got := directRoles(t, local.ID); len(got) != 0 {
+		t.Errorf("本地账号获得了映射的角色 %v", got)
+	}
+}
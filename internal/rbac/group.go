@@ -0,0 +1,376 @@
+package rbac
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/audit"
+	"grpc-rbac-backend/internal/model"
+)
+
+// CreateGroup 创建用户组
+func (s *Service) CreateGroup(ctx context.Context, req *api.CreateGroupRequest) (*api.CreateGroupResponse, error) {
+	if err := requireTenantAdmin(ctx); err != nil {
+		return nil, err
+	}
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	group := model.Group{
+		TenantID:    tenantID,
+		Name:        req.Name,
+		Description: req.Description,
+	}
+	err = withAudit(ctx, "CreateGroup", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		if err := tx.Create(&group).Error; err != nil {
+			return err
+		}
+		ev.Target = audit.Target("group", group.ID)
+		ev.After = audit.Snapshot(group)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.CreateGroupResponse{
+		Message: "用户组创建成功",
+		GroupId: uint32(group.ID),
+	}, nil
+}
+
+// UpdateGroup 修改用户组名称和描述
+func (s *Service) UpdateGroup(ctx context.Context, req *api.UpdateGroupRequest) (*api.UpdateGroupResponse, error) {
+	if err := requireTenantAdmin(ctx); err != nil {
+		return nil, err
+	}
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = withAudit(ctx, "UpdateGroup", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		ev.Target = audit.Target("group", uint(req.GroupId))
+
+		var group model.Group
+		if err := tx.Scopes(model.TenantScope(tenantID)).First(&group, req.GroupId).Error; err != nil {
+			return err
+		}
+		ev.Before = audit.Snapshot(group)
+
+		group.Name = req.Name
+		group.Description = req.Description
+		if err := tx.Save(&group).Error; err != nil {
+			return err
+		}
+		ev.After = audit.Snapshot(group)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.UpdateGroupResponse{Message: "用户组更新成功"}, nil
+}
+
+// DeleteGroup 删除用户组及其成员、角色和父子关系
+func (s *Service) DeleteGroup(ctx context.Context, req *api.DeleteGroupRequest) (*api.DeleteGroupResponse, error) {
+	if err := requireTenantAdmin(ctx); err != nil {
+		return nil, err
+	}
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = withAudit(ctx, "DeleteGroup", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		ev.Target = audit.Target("group", uint(req.GroupId))
+
+		var group model.Group
+		if err := tx.Scopes(model.TenantScope(tenantID)).
+			Preload("Users").Preload("Roles").Preload("Children").
+			First(&group, req.GroupId).Error; err != nil {
+			return err
+		}
+		ev.Before = audit.Snapshot(group)
+		return model.DeleteGroupWithRelations(tx, &group)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.DeleteGroupResponse{Message: "用户组删除成功"}, nil
+}
+
+// ListGroups 列出本租户的用户组
+func (s *Service) ListGroups(ctx context.Context, req *api.ListGroupsRequest) (*api.ListGroupsResponse, error) {
+	db, _, err := scoped(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []model.Group
+	if err := db.Preload("Users").Preload("Roles").Preload("Children").Order("id").Find(&groups).Error; err != nil {
+		return nil, err
+	}
+	infos := make([]*api.GroupInfo, 0, len(groups))
+	for _, g := range groups {
+		infos = append(infos, toGroupInfo(g))
+	}
+	return &api.ListGroupsResponse{Groups: infos}, nil
+}
+
+// GetGroup 查询用户组详情
+func (s *Service) GetGroup(ctx context.Context, req *api.GetGroupRequest) (*api.GetGroupResponse, error) {
+	db, _, err := scoped(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var group model.Group
+	if err := db.Preload("Users").Preload("Roles").Preload("Children").First(&group, req.GroupId).Error; err != nil {
+		return nil, err
+	}
+	return &api.GetGroupResponse{Group: toGroupInfo(group)}, nil
+}
+
+// AddGroupMembers 将用户加入用户组
+func (s *Service) AddGroupMembers(ctx context.Context, req *api.AddGroupMembersRequest) (*api.AddGroupMembersResponse, error) {
+	err := s.changeGroupMembers(ctx, "AddGroupMembers", req.GroupId, req.UserIds, true, func(a *gorm.Association, users []model.User) error {
+		return a.Append(&users)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.AddGroupMembersResponse{Message: "成员添加成功"}, nil
+}
+
+// RemoveGroupMembers 将用户移出用户组
+func (s *Service) RemoveGroupMembers(ctx context.Context, req *api.RemoveGroupMembersRequest) (*api.RemoveGroupMembersResponse, error) {
+	err := s.changeGroupMembers(ctx, "RemoveGroupMembers", req.GroupId, req.UserIds, false, func(a *gorm.Association, users []model.User) error {
+		return a.Delete(&users)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.RemoveGroupMembersResponse{Message: "成员移除成功"}, nil
+}
+
+// changeGroupMembers 变更组成员，checkSod 为 true 时校验成员继承的角色是否违反职责分离约束
+func (s *Service) changeGroupMembers(ctx context.Context, action string, groupID uint32, userIDs []uint32, checkSod bool,
+	apply func(a *gorm.Association, users []model.User) error) error {
+	if err := requireTenantAdmin(ctx); err != nil {
+		return err
+	}
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return err
+	}
+
+	return withAudit(ctx, action, func(tx *gorm.DB, ev *model.AuditEvent) error {
+		ev.Target = audit.Target("group", uint(groupID))
+
+		var group model.Group
+		if err := tx.Scopes(model.TenantScope(tenantID)).Preload("Users").First(&group, groupID).Error; err != nil {
+			return err
+		}
+		ev.Before = audit.Snapshot(memberNames(group.Users))
+
+		var users []model.User
+		if err := tx.Scopes(model.TenantScope(tenantID)).Where("id IN ?", userIDs).Find(&users).Error; err != nil {
+			return err
+		}
+		if len(users) != len(uniqueIDs(userIDs)) {
+			return status.Error(codes.NotFound, "用户不存在或不属于当前租户")
+		}
+		var guard *sodGuard
+		if checkSod {
+			ids := make([]uint, 0, len(users))
+			for _, u := range users {
+				ids = append(ids, u.ID)
+			}
+			if guard, err = beginSodGuard(tx, tenantID, ids); err != nil {
+				return err
+			}
+		}
+		if err := apply(tx.Model(&group).Association("Users"), users); err != nil {
+			return err
+		}
+		if guard != nil {
+			if err := guard.check(tx); err != nil {
+				return err
+			}
+		}
+
+		var after []model.User
+		if err := tx.Model(&group).Association("Users").Find(&after); err != nil {
+			return err
+		}
+		ev.After = audit.Snapshot(memberNames(after))
+		return nil
+	})
+}
+
+// AddSubgroup 将子组加入用户组，子组成员继承上级组的角色
+func (s *Service) AddSubgroup(ctx context.Context, req *api.AddSubgroupRequest) (*api.AddSubgroupResponse, error) {
+	if err := requireTenantAdmin(ctx); err != nil {
+		return nil, err
+	}
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.GroupId == req.ChildGroupId {
+		return nil, status.Error(codes.InvalidArgument, "用户组不能包含自身")
+	}
+
+	err = withAudit(ctx, "AddSubgroup", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		ev.Target = audit.Target("group", uint(req.GroupId))
+
+		var groups []model.Group
+		if err := tx.Scopes(model.TenantScope(tenantID)).Find(&groups, []uint32{req.GroupId, req.ChildGroupId}).Error; err != nil {
+			return err
+		}
+		if len(groups) != 2 {
+			return status.Error(codes.NotFound, "用户组不存在或不属于当前租户")
+		}
+
+		// 若上级组已经是子组的下级，加入后会形成环
+		graph, err := loadGroupGraph(tx, tenantID)
+		if err != nil {
+			return err
+		}
+		if _, ok := graph.ancestors([]uint{uint(req.GroupId)})[uint(req.ChildGroupId)]; ok {
+			return status.Error(codes.FailedPrecondition, "不能形成循环嵌套的用户组")
+		}
+
+		// 子组成员将继承上级组的角色
+		members, err := groupSubtreeMembers(tx, tenantID, uint(req.ChildGroupId))
+		if err != nil {
+			return err
+		}
+		guard, err := beginSodGuard(tx, tenantID, members)
+		if err != nil {
+			return err
+		}
+		parent := model.Group{ID: uint(req.GroupId)}
+		if err := tx.Model(&parent).Association("Children").Append(&model.Group{ID: uint(req.ChildGroupId)}); err != nil {
+			return err
+		}
+		if err := guard.check(tx); err != nil {
+			return err
+		}
+		ev.After = audit.Snapshot(map[string]uint32{"group_id": req.GroupId, "child_group_id": req.ChildGroupId})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.AddSubgroupResponse{Message: "子组添加成功"}, nil
+}
+
+// RemoveSubgroup 移除子组
+func (s *Service) RemoveSubgroup(ctx context.Context, req *api.RemoveSubgroupRequest) (*api.RemoveSubgroupResponse, error) {
+	if err := requireTenantAdmin(ctx); err != nil {
+		return nil, err
+	}
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = withAudit(ctx, "RemoveSubgroup", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		ev.Target = audit.Target("group", uint(req.GroupId))
+
+		var parent model.Group
+		if err := tx.Scopes(model.TenantScope(tenantID)).First(&parent, req.GroupId).Error; err != nil {
+			return err
+		}
+		ev.Before = audit.Snapshot(map[string]uint32{"group_id": req.GroupId, "child_group_id": req.ChildGroupId})
+		return tx.Model(&parent).Association("Children").Delete(&model.Group{ID: uint(req.ChildGroupId)})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.RemoveSubgroupResponse{Message: "子组移除成功"}, nil
+}
+
+// AssignGroupRoles 设置用户组的角色（覆盖原有角色）
+func (s *Service) AssignGroupRoles(ctx context.Context, req *api.AssignGroupRolesRequest) (*api.AssignGroupRolesResponse, error) {
+	if err := requireTenantAdmin(ctx); err != nil {
+		return nil, err
+	}
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = withAudit(ctx, "AssignGroupRoles", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		ev.Target = audit.Target("group", uint(req.GroupId))
+
+		var group model.Group
+		if err := tx.Scopes(model.TenantScope(tenantID)).Preload("Roles").First(&group, req.GroupId).Error; err != nil {
+			return err
+		}
+		ev.Before = audit.Snapshot(group)
+
+		var roles []model.Role
+		if err := tx.Scopes(model.TenantScope(tenantID)).Where("id IN ?", req.RoleIds).Find(&roles).Error; err != nil {
+			return err
+		}
+		if len(roles) != len(uniqueIDs(req.RoleIds)) {
+			return status.Error(codes.NotFound, "角色不存在或不属于当前租户")
+		}
+		members, err := groupSubtreeMembers(tx, tenantID, group.ID)
+		if err != nil {
+			return err
+		}
+		guard, err := beginSodGuard(tx, tenantID, members)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&group).Association("Roles").Replace(&roles); err != nil {
+			return err
+		}
+		if err := guard.check(tx); err != nil {
+			return err
+		}
+		group.Roles = roles
+		ev.After = audit.Snapshot(group)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.AssignGroupRolesResponse{Message: "角色分配成功"}, nil
+}
+
+func memberNames(users []model.User) []string {
+	names := make([]string, 0, len(users))
+	for _, u := range users {
+		names = append(names, u.Username)
+	}
+	return names
+}
+
+func toGroupInfo(g model.Group) *api.GroupInfo {
+	roles := make([]string, 0, len(g.Roles))
+	for _, r := range g.Roles {
+		roles = append(roles, r.Name)
+	}
+	children := make([]uint32, 0, len(g.Children))
+	for _, c := range g.Children {
+		children = append(children, uint32(c.ID))
+	}
+	return &api.GroupInfo{
+		Id:            uint32(g.ID),
+		Name:          g.Name,
+		Description:   g.Description,
+		Roles:         roles,
+		Members:       memberNames(g.Users),
+		ChildGroupIds: children,
+	}
+}
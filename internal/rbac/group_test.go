@@ -0,0 +1,128 @@
+package rbac
+
+import (
+	"strconv"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/model"
+)
+
+func (tt *testTenant) createGroup(t *testing.T, s *Service, name string) uint32 {
+	t.Helper()
+	resp, err := s.CreateGroup(tt.adminCtx(), &api.CreateGroupRequest{Name: name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.GroupId
+}
+
+// 子组成员继承所有上级组的角色，移除子组后不再继承
+func TestSubgroupInheritsRoles(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	ctx := tt.adminCtx()
+	role := tt.createRole(t, "reader", "docs:read")
+	user := tt.createUser(t, "alice")
+	all, eng, backend := tt.createGroup(t, s, "all"), tt.createGroup(t, s, "eng"), tt.createGroup(t, s, "backend")
+
+	if _, err := s.AssignGroupRoles(ctx, &api.AssignGroupRolesRequest{GroupId: all, RoleIds: []uint32{uint32(role.ID)}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddSubgroup(ctx, &api.AddSubgroupRequest{GroupId: all, ChildGroupId: eng}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddSubgroup(ctx, &api.AddSubgroupRequest{GroupId: eng, ChildGroupId: backend}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddGroupMembers(ctx, &api.AddGroupMembersRequest{GroupId: backend, UserIds: []uint32{uint32(user.ID)}}); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func() bool {
+		t.Helper()
+		resp, err := s.CheckPermission(ctx, &api.CheckPermissionRequest{UserId: strconv.Itoa(int(user.ID)), Permission: "docs:read"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Allowed
+	}
+	if !check() {
+		t.Fatal("子组成员应继承上级组的角色")
+	}
+	grants, err := effectiveRoles(model.DB, tt.ID, user.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grants) != 1 || len(grants[0].Via) != 3 {
+		t.Errorf("继承来源 = %+v，期望经由 backend、eng、all 三级", grants)
+	}
+
+	if _, err := s.RemoveSubgroup(ctx, &api.RemoveSubgroupRequest{GroupId: all, ChildGroupId: eng}); err != nil {
+		t.Fatal(err)
+	}
+	if check() {
+		t.Error("移除子组后不应再继承上级组的角色")
+	}
+}
+
+// 嵌套关系不允许成环
+func TestAddSubgroupRejectsCycles(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	ctx := tt.adminCtx()
+	a, b, c := tt.createGroup(t, s, "a"), tt.createGroup(t, s, "b"), tt.createGroup(t, s, "c")
+
+	_, err := s.AddSubgroup(ctx, &api.AddSubgroupRequest{GroupId: a, ChildGroupId: a})
+	wantCode(t, err, codes.InvalidArgument)
+
+	if _, err := s.AddSubgroup(ctx, &api.AddSubgroupRequest{GroupId: a, ChildGroupId: b}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddSubgroup(ctx, &api.AddSubgroupRequest{GroupId: b, ChildGroupId: c}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.AddSubgroup(ctx, &api.AddSubgroupRequest{GroupId: b, ChildGroupId: a})
+	wantCode(t, err, codes.FailedPrecondition)
+	_, err = s.AddSubgroup(ctx, &api.AddSubgroupRequest{GroupId: c, ChildGroupId: a})
+	wantCode(t, err, codes.FailedPrecondition)
+
+	// 不成环的菱形结构允许
+	if _, err := s.AddSubgroup(ctx, &api.AddSubgroupRequest{GroupId: a, ChildGroupId: c}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 普通用户不能通过用户组给自己授予角色
+func TestGroupChangesRequireTenantAdmin(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	user := tt.createUser(t, "mallory")
+	group := tt.createGroup(t, s, "eng")
+	child := tt.createGroup(t, s, "backend")
+	ctx := tt.ctx("mallory", "user")
+	admin := uint32(tt.role(t, "admin").ID)
+
+	_, err := s.CreateGroup(ctx, &api.CreateGroupRequest{Name: "mine"})
+	wantCode(t, err, codes.PermissionDenied)
+	_, err = s.UpdateGroup(ctx, &api.UpdateGroupRequest{GroupId: group, Name: "mine"})
+	wantCode(t, err, codes.PermissionDenied)
+	_, err = s.AssignGroupRoles(ctx, &api.AssignGroupRolesRequest{GroupId: group, RoleIds: []uint32{admin}})
+	wantCode(t, err, codes.PermissionDenied)
+	_, err = s.AddGroupMembers(ctx, &api.AddGroupMembersRequest{GroupId: group, UserIds: []uint32{uint32(user.ID)}})
+	wantCode(t, err, codes.PermissionDenied)
+	_, err = s.RemoveGroupMembers(ctx, &api.RemoveGroupMembersRequest{GroupId: group, UserIds: []uint32{uint32(user.ID)}})
+	wantCode(t, err, codes.PermissionDenied)
+	_, err = s.AddSubgroup(ctx, &api.AddSubgroupRequest{GroupId: group, ChildGroupId: child})
+	wantCode(t, err, codes.PermissionDenied)
+	_, err = s.RemoveSubgroup(ctx, &api.RemoveSubgroupRequest{GroupId: group, ChildGroupId: child})
+	wantCode(t, err, codes.PermissionDenied)
+	_, err = s.DeleteGroup(ctx, &api.DeleteGroupRequest{GroupId: group})
+	wantCode(t, err, codes.PermissionDenied)
+
+	if got := directRoles(t, user.ID); len(got) != 0 {
+		t.Errorf("非管理员获得了角色: %v", got)
+	}
+}
@@ -0,0 +1,282 @@
+package rbac
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/genproto/googleapis/api/httpbody"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/model"
+)
+
+// holderIndex 持有某个权限的用户，按授予角色分组
+type holderIndex struct {
+	roles   []model.Role
+	byRole  map[uint]map[uint]bool // 角色 ID -> 持有该角色的用户
+	userIDs []uint                 // 全部持有人，升序
+}
+
+// findPermission 按名称查询本租户的权限
+func findPermission(tx *gorm.DB, tenantID uint, name string) (*model.Permission, error) {
+	var perm model.Permission
+	if err := tx.Scopes(model.TenantScope(tenantID)).Where("name = ?", name).First(&perm).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, status.Errorf(codes.NotFound, "权限 %s 不存在", name)
+		}
+		return nil, err
+	}
+	return &perm, nil
+}
+
+// loadHolderIndex 汇总当前直接分配或通过用户组（含上级组）获得权限的用户；
+// 停用、尚未激活和已删除的用户不拥有任何权限，不计入
+func loadHolderIndex(tx *gorm.DB, tenantID uint, perm *model.Permission) (*holderIndex, error) {
+	idx := &holderIndex{byRole: make(map[uint]map[uint]bool)}
+
+	var roleIDs []uint
+	if err := tx.Table("role_permissions").Where("permission_id = ?", perm.ID).Pluck("role_id", &roleIDs).Error; err != nil {
+		return nil, err
+	}
+	if len(roleIDs) == 0 {
+		return idx, nil
+	}
+	if err := tx.Scopes(model.TenantScope(tenantID)).Order("id").Find(&idx.roles, roleIDs).Error; err != nil {
+		return nil, err
+	}
+	add := func(roleID, userID uint) {
+		if idx.byRole[roleID] == nil {
+			idx.byRole[roleID] = make(map[uint]bool)
+		}
+		idx.byRole[roleID][userID] = true
+	}
+
+	var direct []model.UserRole
+	if err := tx.Scopes(model.ActiveAt(time.Now())).Where("role_id IN ?", roleIDs).Find(&direct).Error; err != nil {
+		return nil, err
+	}
+	for _, ur := range direct {
+		add(ur.RoleID, ur.UserID)
+	}
+
+	var groupRoles []struct {
+		GroupID uint
+		RoleID  uint
+	}
+	if err := tx.Table("group_roles").Select("group_id, role_id").Where("role_id IN ?", roleIDs).Scan(&groupRoles).Error; err != nil {
+		return nil, err
+	}
+	if len(groupRoles) > 0 {
+		graph, err := loadGroupGraph(tx, tenantID)
+		if err != nil {
+			return nil, err
+		}
+		// 角色授予某个组后，该组及其所有下级组的成员都持有该角色
+		roleGroups := make(map[uint]map[uint]bool)
+		var allGroups []uint
+		for _, gr := range groupRoles {
+			desc := graph.descendants([]uint{gr.GroupID})
+			if roleGroups[gr.RoleID] == nil {
+				roleGroups[gr.RoleID] = make(map[uint]bool)
+			}
+			for id := range desc {
+				roleGroups[gr.RoleID][id] = true
+				allGroups = append(allGroups, id)
+			}
+		}
+		var members []struct {
+			GroupID uint
+			UserID  uint
+		}
+		if err := tx.Table("group_users").Select("group_id, user_id").Where("group_id IN ?", allGroups).Scan(&members).Error; err != nil {
+			return nil, err
+		}
+		for roleID, groups := range roleGroups {
+			for _, m := range members {
+				if groups[m.GroupID] {
+					add(roleID, m.UserID)
+				}
+			}
+		}
+	}
+
+	var candidates []uint
+	seen := make(map[uint]bool)
+	for _, users := range idx.byRole {
+		for id := range users {
+			if !seen[id] {
+				seen[id] = true
+				candidates = append(candidates, id)
+			}
+		}
+	}
+	if len(candidates) > 0 {
+		if err := tx.Model(&model.User{}).Scopes(model.TenantScope(tenantID)).
+			Where("id IN ? AND status = ?", candidates, model.UserStatusActive).
+			Pluck("id", &idx.userIDs).Error; err != nil {
+			return nil, err
+		}
+	}
+	active := make(map[uint]bool, len(idx.userIDs))
+	for _, id := range idx.userIDs {
+		active[id] = true
+	}
+	for _, users := range idx.byRole {
+		for id := range users {
+			if !active[id] {
+				delete(users, id)
+			}
+		}
+	}
+	sort.Slice(idx.userIDs, func(i, j int) bool { return idx.userIDs[i] < idx.userIDs[j] })
+	return idx, nil
+}
+
+// holderSources 用户获得该权限的所有角色来源
+func holderSources(tx *gorm.DB, tenantID uint, user *model.User, perm *model.Permission) ([]*api.PermissionSource, error) {
+	grants, err := effectiveRoles(tx, tenantID, user.ID, true)
+	if err != nil {
+		return nil, err
+	}
+	var sources []*api.PermissionSource
+	for _, g := range grants {
+		for _, p := range g.Role.Permissions {
+			if p.ID != perm.ID {
+				continue
+			}
+			sources = append(sources, &api.PermissionSource{
+				RoleId:    uint32(g.Role.ID),
+				RoleName:  g.Role.Name,
+				Via:       g.Via,
+				ExpiresAt: ptrUnix(g.ExpiresAt),
+				Condition: g.Conditions[p.ID],
+			})
+		}
+	}
+	return sources, nil
+}
+
+// ListPermissionHolders 租户管理员列出持有指定权限的用户，并按授予角色统计人数
+func (s *Service) ListPermissionHolders(ctx context.Context, req *api.ListPermissionHoldersRequest) (*api.ListPermissionHoldersResponse, error) {
+	if err := requireTenantAdmin(ctx); err != nil {
+		return nil, err
+	}
+	db, tenantID, err := scoped(ctx)
+	if err != nil {
+		return nil, err
+	}
+	perm, err := findPermission(model.DB, tenantID, req.Permission)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := loadHolderIndex(model.DB, tenantID, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &api.ListPermissionHoldersResponse{Total: int64(len(idx.userIDs))}
+	for _, r := range idx.roles {
+		resp.Roles = append(resp.Roles, &api.PermissionHolderRole{
+			RoleId:    uint32(r.ID),
+			RoleName:  r.Name,
+			UserCount: int64(len(idx.byRole[r.ID])),
+		})
+	}
+	if len(idx.userIDs) == 0 {
+		return resp, nil
+	}
+
+	var users []model.User
+	if err := db.Where("id IN ?", idx.userIDs).Order("id").Scopes(paginate(req.Page, req.PageSize)).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	for i := range users {
+		sources, err := holderSources(model.DB, tenantID, &users[i], perm)
+		if err != nil {
+			return nil, err
+		}
+		resp.Holders = append(resp.Holders, &api.PermissionHolder{
+			UserId:   uint32(users[i].ID),
+			Username: users[i].Username,
+			Sources:  sources,
+		})
+	}
+	return resp, nil
+}
+
+// ExportPermissionHolders 租户管理员导出持有指定权限的全部用户为 CSV，每个角色来源一行，供定期权限认证使用
+func (s *Service) ExportPermissionHolders(ctx context.Context, req *api.ExportPermissionHoldersRequest) (*httpbody.HttpBody, error) {
+	if err := requireTenantAdmin(ctx); err != nil {
+		return nil, err
+	}
+	db, tenantID, err := scoped(ctx)
+	if err != nil {
+		return nil, err
+	}
+	perm, err := findPermission(model.DB, tenantID, req.Permission)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := loadHolderIndex(model.DB, tenantID, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []model.User
+	if len(idx.userIDs) > 0 {
+		if err := db.Where("id IN ?", idx.userIDs).Order("id").Find(&users).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write([]string{"user_id", "username", "permission", "role", "via", "expires_at", "condition"}); err != nil {
+		return nil, err
+	}
+	for i := range users {
+		sources, err := holderSources(model.DB, tenantID, &users[i], perm)
+		if err != nil {
+			return nil, err
+		}
+		for _, src := range sources {
+			expires := ""
+			if src.ExpiresAt > 0 {
+				expires = time.Unix(src.ExpiresAt, 0).UTC().Format(time.RFC3339)
+			}
+			if err := w.Write([]string{
+				strconv.FormatUint(uint64(users[i].ID), 10),
+				csvCell(users[i].Username),
+				csvCell(perm.Name),
+				csvCell(src.RoleName),
+				csvCell(strings.Join(src.Via, " -> ")),
+				expires,
+				csvCell(src.Condition),
+			}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+	return &httpbody.HttpBody{ContentType: "text/csv; charset=utf-8", Data: buf.Bytes()}, nil
+}
+
+// csvCell 以 =、+、-、@ 或制表符、回车开头的单元格在电子表格中会被当作公式执行，加 ' 前缀转为文本
+func csvCell(v string) string {
+	if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
+	}
+	return v
+}
@@ -0,0 +1,80 @@
+package rbac
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/model"
+)
+
+// 导出的 CSV 中公式开头的单元格加 ' 前缀
+func TestExportPermissionHoldersEscapesFormulas(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	user := tt.createUser(t, "=HYPERLINK(\"http://evil\")")
+	role := tt.createRole(t, "@approver", "invoices:approve")
+	tt.grant(t, user, role)
+
+	body, err := s.ExportPermissionHolders(tt.adminCtx(), &api.ExportPermissionHoldersRequest{Permission: "invoices:approve"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(body.Data)
+	if !strings.Contains(out, `'=HYPERLINK`) || !strings.Contains(out, "'@approver") {
+		t.Errorf("公式单元格未转义:\n%s", out)
+	}
+}
+
+// 停用和已删除的用户不计入持有人
+func TestListPermissionHoldersExcludesInactiveUsers(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	role := tt.createRole(t, "approver", "invoices:approve")
+	active := tt.createUser(t, "alice")
+	disabled := tt.createUser(t, "bob")
+	deleted := tt.createUser(t, "carol")
+	for _, u := range []*model.User{active, disabled, deleted} {
+		tt.grant(t, u, role)
+	}
+	if err := model.DB.Model(disabled).Update("status", model.UserStatusDisabled).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := model.DB.Delete(deleted).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := s.ListPermissionHolders(tt.adminCtx(), &api.ListPermissionHoldersRequest{Permission: "invoices:approve"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Total != 1 || len(resp.Holders) != 1 || resp.Holders[0].UserId != uint32(active.ID) {
+		t.Errorf("持有人 = %d %v，期望仅 alice", resp.Total, resp.Holders)
+	}
+	if len(resp.Roles) != 1 || resp.Roles[0].UserCount != 1 {
+		t.Errorf("角色统计 = %v，期望 approver 1 人", resp.Roles)
+	}
+}
+
+func TestPermissionHoldersRequireTenantAdmin(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	ctx := tt.ctx("auditor", "viewer")
+
+	_, err := s.ListPermissionHolders(ctx, &api.ListPermissionHoldersRequest{Permission: "invoices:approve"})
+	wantCode(t, err, codes.PermissionDenied)
+	_, err = s.ExportPermissionHolders(ctx, &api.ExportPermissionHoldersRequest{Permission: "invoices:approve"})
+	wantCode(t, err, codes.PermissionDenied)
+}
+
+func TestCSVCell(t *testing.T) {
+	for in, want := range map[string]string{
+		"alice": "alice", "": "", "-1": "'-1", "+1": "'+1", "\tx": "'\tx", "a=b": "a=b",
+	} {
+		if got := csvCell(in); got != want {
+			t.Errorf("csvCell(%q) = %q，期望 %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,467 @@
+package rbac
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/audit"
+	"grpc-rbac-backend/internal/model"
+)
+
+// ldapPageSize 同步时分页查询目录的每页条数
+const ldapPageSize = 500
+
+// DirectoryConfig LDAP / Active Directory 目录配置，键为目录名称
+type DirectoryConfig struct {
+	Directories map[string]*LDAPDirectory `json:"directories"`
+}
+
+// LDAPDirectory 一个 LDAP 目录。用户先用服务账号查找到 DN，再以该 DN 和用户密码绑定校验
+type LDAPDirectory struct {
+	TenantName         string             `json:"tenant"` // 目录用户归属的租户，为空时使用默认租户
+	URL                string             `json:"url"`    // ldap://host:389 或 ldaps://host:636
+	StartTLS           bool               `json:"start_tls"`
+	InsecureSkipVerify bool               `json:"insecure_skip_verify"`
+	BindDN             string             `json:"bind_dn"` // 查询目录用的服务账号
+	BindPassword       string             `json:"bind_password"`
+	BaseDN             string             `json:"base_dn"`
+	UserFilter         string             `json:"user_filter"`        // 默认 (objectClass=person)
+	UsernameAttribute  string             `json:"username_attribute"` // 默认 uid，Active Directory 使用 sAMAccountName
+	GroupAttribute     string             `json:"group_attribute"`    // 默认 memberOf，值为组的 DN
+	RoleMappings       []GroupRoleMapping `json:"role_mappings"`
+	DefaultRoles       []string           `json:"default_roles"` // 首次登录创建用户时分配
+
+	name string
+	// dial 连接目录，可替换为测试用的内存目录
+	dial func() (ldap.Client, error)
+}
+
+// GroupRoleMapping 用户属于 group（DN，不区分大小写）时授予 role
+type GroupRoleMapping struct {
+	Group string `json:"group"`
+	Role  string `json:"role"`
+}
+
+// LoadDirectoryConfig 加载 LDAP 目录配置，path 为空时不启用 LDAP 登录
+func LoadDirectoryConfig(path string) (*DirectoryConfig, error) {
+	cfg := &DirectoryConfig{Directories: map[string]*LDAPDirectory{}}
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("解析 LDAP 目录配置失败: %w", err)
+	}
+	for name, d := range cfg.Directories {
+		if d == nil || d.URL == "" || d.BaseDN == "" {
+			return nil, fmt.Errorf("LDAP 目录 %s 缺少必填项（url、base_dn）", name)
+		}
+		d.name = name
+		if d.TenantName == "" {
+			d.TenantName = model.DefaultTenantName
+		}
+		if d.UserFilter == "" {
+			d.UserFilter = "(objectClass=person)"
+		}
+		if d.UsernameAttribute == "" {
+			d.UsernameAttribute = "uid"
+		}
+		if d.GroupAttribute == "" {
+			d.GroupAttribute = "memberOf"
+		}
+		if d.dial == nil {
+			d.dial = d.connect
+		}
+	}
+	return cfg, nil
+}
+
+// authenticators 按名称排序的目录，登录时依次尝试
+func (c *DirectoryConfig) authenticators() []Authenticator {
+	names := make([]string, 0, len(c.Directories))
+	for name := range c.Directories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := make([]Authenticator, 0, len(names))
+	for _, name := range names {
+		list = append(list, c.Directories[name])
+	}
+	return list
+}
+
+// Name 目录用户的 ExternalIdentity.Provider
+func (d *LDAPDirectory) Name() string { return "ldap:" + d.name }
+
+func (d *LDAPDirectory) Tenant() string { return d.TenantName }
+
+// connect 连接目录并以服务账号绑定
+func (d *LDAPDirectory) connect() (ldap.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: d.InsecureSkipVerify}
+	conn, err := ldap.DialURL(d.URL, ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(10 * time.Second)
+	if d.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// bindService 以服务账号绑定，未配置时匿名查询
+func (d *LDAPDirectory) bindService(conn ldap.Client) error {
+	if d.BindDN == "" {
+		return nil
+	}
+	return conn.Bind(d.BindDN, d.BindPassword)
+}
+
+// ldapEntry 目录中的一个用户
+type ldapEntry struct {
+	DN       string
+	Username string
+	Groups   []string
+}
+
+func (d *LDAPDirectory) entry(e *ldap.Entry) ldapEntry {
+	return ldapEntry{
+		DN:       e.DN,
+		Username: e.GetEqualFoldAttributeValue(d.UsernameAttribute),
+		Groups:   e.GetEqualFoldAttributeValues(d.GroupAttribute),
+	}
+}
+
+func (d *LDAPDirectory) searchRequest(filter string) *ldap.SearchRequest {
+	return ldap.NewSearchRequest(d.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filter, []string{d.UsernameAttribute, d.GroupAttribute}, nil)
+}
+
+// findUser 按用户名查找目录用户，不存在或不唯一时返回 nil
+func (d *LDAPDirectory) findUser(conn ldap.Client, username string) (*ldapEntry, error) {
+	filter := fmt.Sprintf("(&%s(%s=%s))", d.UserFilter, d.UsernameAttribute, ldap.EscapeFilter(username))
+	res, err := conn.Search(d.searchRequest(filter))
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Entries) != 1 {
+		return nil, nil
+	}
+	e := d.entry(res.Entries[0])
+	return &e, nil
+}
+
+// listUsers 分页列出目录中的全部用户，键为小写的用户名
+func (d *LDAPDirectory) listUsers(conn ldap.Client) (map[string]ldapEntry, error) {
+	res, err := conn.SearchWithPaging(d.searchRequest(d.UserFilter), ldapPageSize)
+	if err != nil {
+		return nil, err
+	}
+	users := make(map[string]ldapEntry, len(res.Entries))
+	for _, e := range res.Entries {
+		entry := d.entry(e)
+		if entry.Username != "" {
+			users[strings.ToLower(entry.Username)] = entry
+		}
+	}
+	return users, nil
+}
+
+// mappedRoles 目录管理的角色，以及用户所在组对应的角色
+func (d *LDAPDirectory) mappedRoles(groups []string) ([]string, map[string]bool) {
+	managed := make([]string, 0, len(d.RoleMappings))
+	wanted := make(map[string]bool)
+	for _, m := range d.RoleMappings {
+		managed = append(managed, m.Role)
+		for _, g := range groups {
+			if strings.EqualFold(g, m.Group) {
+				wanted[m.Role] = true
+			}
+		}
+	}
+	return managed, wanted
+}
+
+// Authenticate 以用户的 DN 和密码绑定目录校验，通过后关联（首次登录时创建）本地用户并按组同步角色
+func (d *LDAPDirectory) Authenticate(ctx context.Context, tenant *model.Tenant, user *model.User, username, plain string) (*model.User, error) {
+	// 空密码会被 LDAP 当作匿名绑定而成功，必须拒绝
+	if plain == "" {
+		return nil, errBadCredentials
+	}
+	conn, err := d.dial()
+	if err != nil {
+		log.Printf("❌ 连接 LDAP 目录 %s 失败: %v", d.name, err)
+		return nil, status.Error(codes.Unavailable, "目录服务暂不可用")
+	}
+	defer conn.Close()
+	if err := d.bindService(conn); err != nil {
+		log.Printf("❌ LDAP 目录 %s 服务账号绑定失败: %v", d.name, err)
+		return nil, status.Error(codes.Unavailable, "目录服务暂不可用")
+	}
+	entry, err := d.findUser(conn, username)
+	if err != nil {
+		log.Printf("❌ LDAP 目录 %s 查询用户失败: %v", d.name, err)
+		return nil, status.Error(codes.Unavailable, "目录服务暂不可用")
+	}
+	if entry == nil {
+		return nil, errBadCredentials
+	}
+	if err := conn.Bind(entry.DN, plain); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, errBadCredentials
+		}
+		log.Printf("❌ LDAP 目录 %s 用户绑定失败: %v", d.name, err)
+		return nil, status.Error(codes.Unavailable, "目录服务暂不可用")
+	}
+
+	err = withAudit(ctx, "DirectoryLogin", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		ev.TenantID = tenant.ID
+		var created bool
+		var err error
+		user, created, err = linkExternalUser(tx, tenant.ID, d.Name(), strings.ToLower(entry.Username), entry.Username, d.DefaultRoles)
+		if err != nil {
+			return err
+		}
+		if created {
+			ev.After = audit.Snapshot(user)
+		}
+		ev.Actor = user.Username
+		ev.Target = audit.Target("user", user.ID)
+		managed, wanted := d.mappedRoles(entry.Groups)
+		return syncManagedRoles(tx, tenant.ID, user.ID, managed, wanted)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// 目录同步对本地用户的变更
+const (
+	syncActionDisable    = "disable"
+	syncActionEnable     = "enable"
+	syncActionGrantRole  = "grant_role"
+	syncActionRevokeRole = "revoke_role"
+)
+
+// errSyncDryRun 预览时回滚事务
+var errSyncDryRun = errors.New("dry run")
+
+// syncDirectory 对照目录同步已关联的本地用户：从目录中移除的用户停用并撤销其会话，
+// 重新出现的用户恢复启用，按所在组分配或撤销映射的角色。单个用户失败时记入 Errors 并继续。
+// dryRun 时在回滚的事务中执行，只返回将要执行的变更和将会失败的用户
+func syncDirectory(ctx context.Context, d *LDAPDirectory, dryRun bool) (*api.SyncDirectoryResponse, error) {
+	conn, err := d.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := d.bindService(conn); err != nil {
+		return nil, err
+	}
+	entries, err := d.listUsers(conn)
+	if err != nil {
+		return nil, err
+	}
+	// 查询结果为空多半是配置或权限问题，不能因此停用全部用户
+	if len(entries) == 0 {
+		return nil, errors.New("目录中没有查询到用户，请检查 base_dn 和 user_filter")
+	}
+
+	tenant, err := resolveTenant(model.DB, d.TenantName)
+	if err != nil {
+		return nil, err
+	}
+	var idents []model.ExternalIdentity
+	if err := model.DB.Where("tenant_id = ? AND provider = ?", tenant.ID, d.Name()).
+		Order("id").Find(&idents).Error; err != nil {
+		return nil, err
+	}
+
+	resp := &api.SyncDirectoryResponse{Directory: d.name, DryRun: dryRun, UsersScanned: int32(len(entries))}
+	for _, ident := range idents {
+		var user model.User
+		if err := model.DB.Limit(1).Find(&user, ident.UserID).Error; err != nil {
+			return nil, err
+		}
+		// 已删除（保留期内）的用户不参与同步
+		if user.ID == 0 {
+			continue
+		}
+		entry, inDirectory := entries[ident.Subject]
+		var changes []*api.DirectoryChange
+		// 只恢复由本目录停用的用户，管理员停用的用户保持停用
+		disable := !inDirectory && user.Status == model.UserStatusActive
+		enable := inDirectory && user.Status == model.UserStatusDisabled && user.StatusSource == d.Name()
+		switch {
+		case disable:
+			changes = append(changes, &api.DirectoryChange{Username: user.Username, Action: syncActionDisable})
+		case enable:
+			changes = append(changes, &api.DirectoryChange{Username: user.Username, Action: syncActionEnable})
+		}
+		var managed []string
+		var wanted map[string]bool
+		if inDirectory {
+			managed, wanted = d.mappedRoles(entry.Groups)
+			roleChanges, err := managedRoleChanges(model.DB, tenant.ID, &user, managed, wanted)
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, roleChanges...)
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		// 预览时列出全部计划中的变更（失败的同时出现在 errors 中），实际同步只列出已生效的变更
+		if dryRun {
+			resp.Changes = append(resp.Changes, changes...)
+		}
+
+		apply := func(tx *gorm.DB) error {
+			if disable || enable {
+				to := model.UserStatusDisabled
+				if enable {
+					to = model.UserStatusActive
+				}
+				if err := setUserStatus(tx, &user, to, d.Name()); err != nil {
+					return err
+				}
+				if err := saveUserStatus(tx, &user); err != nil {
+					return err
+				}
+			}
+			return syncManagedRoles(tx, tenant.ID, user.ID, managed, wanted)
+		}
+		if dryRun {
+			err = model.DB.Transaction(func(tx *gorm.DB) error {
+				if err := apply(tx); err != nil {
+					return err
+				}
+				return errSyncDryRun
+			})
+			if errors.Is(err, errSyncDryRun) {
+				err = nil
+			}
+		} else {
+			err = withAudit(ctx, "SyncDirectoryUser", func(tx *gorm.DB, ev *model.AuditEvent) error {
+				ev.TenantID = tenant.ID
+				ev.Target = audit.Target("user", user.ID)
+				ev.Before = audit.Snapshot(user)
+				if err := apply(tx); err != nil {
+					return err
+				}
+				ev.After = audit.Snapshot(user)
+				return nil
+			})
+		}
+		if err != nil {
+			log.Printf("❌ 同步 LDAP 目录 %s 的用户 %s 失败: %v", d.name, user.Username, err)
+			resp.Errors = append(resp.Errors, &api.DirectorySyncError{Username: user.Username, Error: status.Convert(err).Message()})
+			continue
+		}
+		if !dryRun {
+			resp.Changes = append(resp.Changes, changes...)
+		}
+	}
+	return resp, nil
+}
+
+// managedRoleChanges 计算同步映射角色时将要分配和撤销的角色
+func managedRoleChanges(tx *gorm.DB, tenantID uint, user *model.User, managed []string, wanted map[string]bool) ([]*api.DirectoryChange, error) {
+	if len(managed) == 0 {
+		return nil, nil
+	}
+	var held []string
+	if err := tx.Model(&model.UserRole{}).
+		Joins("JOIN roles ON roles.id = user_roles.role_id").
+		Where("user_roles.user_id = ? AND roles.tenant_id = ? AND roles.name IN ?", user.ID, tenantID, managed).
+		Pluck("roles.name", &held).Error; err != nil {
+		return nil, err
+	}
+	var existing []string
+	if err := tx.Model(&model.Role{}).Scopes(model.TenantScope(tenantID)).
+		Where("name IN ?", managed).Order("name").Pluck("name", &existing).Error; err != nil {
+		return nil, err
+	}
+	isHeld := make(map[string]bool, len(held))
+	for _, name := range held {
+		isHeld[name] = true
+	}
+	var changes []*api.DirectoryChange
+	for _, name := range existing {
+		switch {
+		case wanted[name] && !isHeld[name]:
+			changes = append(changes, &api.DirectoryChange{Username: user.Username, Action: syncActionGrantRole, Role: name})
+		case !wanted[name] && isHeld[name]:
+			changes = append(changes, &api.DirectoryChange{Username: user.Username, Action: syncActionRevokeRole, Role: name})
+		}
+	}
+	return changes, nil
+}
+
+// SyncDirectory 手动同步 LDAP 目录，dryRun 时只预览变更
+func (s *Service) SyncDirectory(ctx context.Context, req *api.SyncDirectoryRequest) (*api.SyncDirectoryResponse, error) {
+	if err := requireTenantAdmin(ctx); err != nil {
+		return nil, err
+	}
+	claims, err := callerClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+	d, ok := s.directories.Directories[req.Directory]
+	if !ok || d.TenantName != claims.Tenant {
+		return nil, status.Error(codes.NotFound, "LDAP 目录不存在: "+req.Directory)
+	}
+	resp, err := syncDirectory(ctx, d, req.DryRun)
+	if err != nil {
+		log.Printf("❌ 同步 LDAP 目录 %s 失败: %v", d.name, err)
+		return nil, status.Error(codes.Unavailable, "同步目录失败: "+err.Error())
+	}
+	return resp, nil
+}
+
+// StartDirectorySync 按配置的间隔定期同步全部 LDAP 目录，ctx 取消后退出
+func (s *Service) StartDirectorySync(ctx context.Context) {
+	if len(s.directories.Directories) == 0 || s.cfg.LDAPSyncInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(s.cfg.LDAPSyncInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				for name, d := range s.directories.Directories {
+					resp, err := syncDirectory(ctx, d, false)
+					if err != nil {
+						log.Printf("❌ 同步 LDAP 目录 %s 失败: %v", name, err)
+						continue
+					}
+					log.Printf("✅ 同步 LDAP 目录 %s：目录用户 %d，变更 %d，失败 %d", name, resp.UsersScanned, len(resp.Changes), len(resp.Errors))
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,299 @@
+package rbac
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"google.golang.org/grpc/codes"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/model"
+)
+
+const (
+	testBaseDN      = "ou=people,dc=example,dc=com"
+	testServiceDN   = "cn=svc,dc=example,dc=com"
+	testFinanceDN   = "cn=finance,ou=groups,dc=example,dc=com"
+	testServicePass = "svc-secret"
+)
+
+// fakeDirectory 内存中的 LDAP 目录，只实现登录和同步用到的方法
+type fakeDirectory struct {
+	ldap.Client
+	mu    sync.Mutex
+	users map[string]*fakeLDAPUser // 键为用户名
+}
+
+type fakeLDAPUser struct {
+	password string
+	groups   []string
+}
+
+func (f *fakeDirectory) dn(username string) string { return "uid=" + username + "," + testBaseDN }
+
+func (f *fakeDirectory) set(username, password string, groups ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.users[username] = &fakeLDAPUser{password: password, groups: groups}
+}
+
+func (f *fakeDirectory) remove(username string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.users, username)
+}
+
+func (f *fakeDirectory) Close() error { return nil }
+
+func (f *fakeDirectory) Bind(dn, password string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if dn == testServiceDN && password == testServicePass {
+		return nil
+	}
+	for name, u := range f.users {
+		if f.dn(name) == dn && u.password == password {
+			return nil
+		}
+	}
+	return ldap.NewError(ldap.LDAPResultInvalidCredentials, nil)
+}
+
+var uidFilter = regexp.MustCompile(`\(uid=([^)]*)\)\)$`)
+
+func (f *fakeDirectory) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	res := &ldap.SearchResult{}
+	for name, u := range f.users {
+		if m := uidFilter.FindStringSubmatch(req.Filter); m != nil && m[1] != name {
+			continue
+		}
+		res.Entries = append(res.Entries, ldap.NewEntry(f.dn(name), map[string][]string{
+			"uid":      {name},
+			"memberOf": u.groups,
+		}))
+	}
+	return res, nil
+}
+
+func (f *fakeDirectory) SearchWithPaging(req *ldap.SearchRequest, _ uint32) (*ldap.SearchResult, error) {
+	return f.Search(req)
+}
+
+// newLDAPTest 本租户配置一个内存目录：finance 组映射为 approver 角色，首次登录分配 user 角色。
+// 外部账号按目录名全局唯一，目录以租户名命名
+func newLDAPTest(t *testing.T) (*Service, *testTenant, *fakeDirectory) {
+	tt := newTestTenant(t)
+	tt.createRole(t, "approver", "invoices:approve")
+	fake := &fakeDirectory{users: map[string]*fakeLDAPUser{}}
+	d := &LDAPDirectory{
+		TenantName:        tt.Name,
+		URL:               "ldap://directory.test",
+		BindDN:            testServiceDN,
+		BindPassword:      testServicePass,
+		BaseDN:            testBaseDN,
+		UserFilter:        "(objectClass=person)",
+		UsernameAttribute: "uid",
+		GroupAttribute:    "memberOf",
+		RoleMappings:      []GroupRoleMapping{{Group: testFinanceDN, Role: "approver"}},
+		DefaultRoles:      []string{"user"},
+		name:              tt.Name,
+		dial:              func() (ldap.Client, error) { return fake, nil },
+	}
+	s := newTestService(t, nil, &DirectoryConfig{Directories: map[string]*LDAPDirectory{tt.Name: d}})
+	// 不测试登录限流，失败后无需等待
+	s.cfg.LoginBackoff = 0
+	return s, tt, fake
+}
+
+func ldapLogin(s *Service, tt *testTenant, username, password string) (*api.LoginResponse, error) {
+	return s.Login(context.Background(), &api.LoginRequest{Tenant: tt.Name, Username: username, Password: password})
+}
+
+func findUser(t *testing.T, tt *testTenant, username string) *model.User {
+	t.Helper()
+	var user model.User
+	if err := model.DB.Scopes(model.TenantScope(tt.ID)).Where("username = ?", username).First(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &user
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	s, tt, fake := newLDAPTest(t)
+	fake.set("alice", "ldap-pass", testFinanceDN)
+
+	resp, err := ldapLogin(s, tt, "alice", "ldap-pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Token == "" {
+		t.Fatal("未签发 token")
+	}
+	alice := findUser(t, tt, "alice")
+	if got := directRoles(t, alice.ID); len(got) != 2 || got[0] != "approver" || got[1] != "user" {
+		t.Errorf("目录用户的角色 = %v，期望 [approver user]", got)
+	}
+
+	_, err = ldapLogin(s, tt, "alice", "wrong")
+	wantCode(t, err, codes.Unauthenticated)
+	// 空密码会被当作匿名绑定，必须拒绝
+	_, err = ldapLogin(s, tt, "alice", "")
+	wantCode(t, err, codes.Unauthenticated)
+	_, err = ldapLogin(s, tt, "nobody", "ldap-pass")
+	wantCode(t, err, codes.Unauthenticated)
+
+	// 离开 finance 组后再次登录，映射的角色被撤销
+	fake.set("alice", "ldap-pass")
+	if _, err := ldapLogin(s, tt, "alice", "ldap-pass"); err != nil {
+		t.Fatal(err)
+	}
+	if got := directRoles(t, alice.ID); len(got) != 1 || got[0] != "user" {
+		t.Errorf("离开 finance 组后的角色 = %v，期望 [user]", got)
+	}
+}
+
+// 本地账号不会被同名的目录账号接管
+func TestLDAPAuthenticateLocalUser(t *testing.T) {
+	s, tt, fake := newLDAPTest(t)
+	tt.createUser(t, "bob")
+	fake.set("bob", "ldap-pass", testFinanceDN)
+
+	_, err := ldapLogin(s, tt, "bob", "ldap-pass")
+	wantCode(t, err, codes.Unauthenticated)
+	if _, err := ldapLogin(s, tt, "bob", testPassword); err != nil {
+		t.Fatalf("本地密码登录失败: %v", err)
+	}
+}
+
+func hasChange(resp *api.SyncDirectoryResponse, username, action, role string) bool {
+	for _, c := range resp.Changes {
+		if c.Username == username && c.Action == action && c.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSyncDirectory(t *testing.T) {
+	s, tt, fake := newLDAPTest(t)
+	fake.set("alice", "ldap-pass", testFinanceDN)
+	fake.set("bob", "ldap-pass")
+	fake.set("carol", "ldap-pass")
+	for _, name := range []string{"alice", "bob", "carol"} {
+		if _, err := ldapLogin(s, tt, name, "ldap-pass"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	alice, carol := findUser(t, tt, "alice"), findUser(t, tt, "carol")
+
+	// bob 从目录中移除，alice 离开、carol 加入 finance 组
+	fake.remove("bob")
+	fake.set("alice", "ldap-pass")
+	fake.set("carol", "ldap-pass", testFinanceDN)
+
+	dry, err := s.SyncDirectory(tt.adminCtx(), &api.SyncDirectoryRequest{Directory: tt.Name, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasChange(dry, "bob", syncActionDisable, "") || !hasChange(dry, "alice", syncActionRevokeRole, "approver") ||
+		!hasChange(dry, "carol", syncActionGrantRole, "approver") || len(dry.Changes) != 3 {
+		t.Errorf("预览的变更 = %v", dry.Changes)
+	}
+	if findUser(t, tt, "bob").Status != model.UserStatusActive || len(directRoles(t, alice.ID)) != 2 {
+		t.Fatal("dryRun 修改了本地数据")
+	}
+
+	resp, err := s.SyncDirectory(tt.adminCtx(), &api.SyncDirectoryRequest{Directory: tt.Name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Changes) != 3 || len(resp.Errors) != 0 {
+		t.Errorf("同步结果: 变更 %v，失败 %v", resp.Changes, resp.Errors)
+	}
+	if u := findUser(t, tt, "bob"); u.Status != model.UserStatusDisabled || u.StatusSource != "ldap:"+tt.Name {
+		t.Errorf("移除的用户状态 = %s（%s），期望由目录停用", u.Status, u.StatusSource)
+	}
+	if got := directRoles(t, alice.ID); len(got) != 1 || got[0] != "user" {
+		t.Errorf("alice 的角色 = %v，期望 [user]", got)
+	}
+	if got := directRoles(t, carol.ID); len(got) != 2 || got[0] != "approver" {
+		t.Errorf("carol 的角色 = %v，期望 [approver user]", got)
+	}
+
+	// 用户重新出现在目录中时恢复启用
+	fake.set("bob", "ldap-pass")
+	if _, err := s.SyncDirectory(tt.adminCtx(), &api.SyncDirectoryRequest{Directory: tt.Name}); err != nil {
+		t.Fatal(err)
+	}
+	if u := findUser(t, tt, "bob"); u.Status != model.UserStatusActive {
+		t.Errorf("重新出现的用户状态 = %s，期望恢复启用", u.Status)
+	}
+}
+
+// 单个用户违反职责分离约束时记入 errors，其他用户照常同步；dryRun 提前报告冲突
+// changedUsers 变更涉及的用户名
+func changedUsers(changes []*api.DirectoryChange) map[string]bool {
+	users := make(map[string]bool)
+	for _, c := range changes {
+		users[c.Username] = true
+	}
+	return users
+}
+
+func TestSyncDirectorySodConflict(t *testing.T) {
+	s, tt, fake := newLDAPTest(t)
+	payer := tt.createRole(t, "payer", "invoices:pay")
+	sod := model.SodConstraint{TenantID: tt.ID, Name: "approve-pay", MaxRoles: 1, Roles: []model.Role{*tt.role(t, "approver"), *payer}}
+	if err := model.DB.Create(&sod).Error; err != nil {
+		t.Fatal(err)
+	}
+	fake.set("dave", "ldap-pass")
+	fake.set("erin", "ldap-pass")
+	for _, name := range []string{"dave", "erin"} {
+		if _, err := ldapLogin(s, tt, name, "ldap-pass"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dave, erin := findUser(t, tt, "dave"), findUser(t, tt, "erin")
+	tt.grant(t, dave, payer)
+	fake.set("dave", "ldap-pass", testFinanceDN)
+	fake.set("erin", "ldap-pass", testFinanceDN)
+
+	dry, err := s.SyncDirectory(tt.adminCtx(), &api.SyncDirectoryRequest{Directory: tt.Name, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dry.Errors) != 1 || dry.Errors[0].Username != "dave" || !strings.Contains(dry.Errors[0].Error, "职责分离") {
+		t.Errorf("dryRun 报告的失败 = %v，期望 dave 违反职责分离约束", dry.Errors)
+	}
+	if got := changedUsers(dry.Changes); len(got) != 2 {
+		t.Errorf("dryRun 的变更涉及 %v，期望 dave 和 erin", got)
+	}
+	if len(directRoles(t, erin.ID)) != 1 {
+		t.Fatal("dryRun 修改了本地数据")
+	}
+
+	resp, err := s.SyncDirectory(tt.adminCtx(), &api.SyncDirectoryRequest{Directory: tt.Name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Errors) != 1 || resp.Errors[0].Username != "dave" {
+		t.Errorf("同步失败的用户 = %v，期望 [dave]", resp.Errors)
+	}
+	// 失败的用户不计入已生效的变更
+	if got := changedUsers(resp.Changes); len(got) != 1 || !got["erin"] {
+		t.Errorf("已生效的变更涉及 %v，期望仅 erin", got)
+	}
+	if got := directRoles(t, dave.ID); len(got) != 2 || got[0] != "payer" {
+		t.Errorf("dave 的角色 = %v，期望保持 [payer user]", got)
+	}
+	if got := directRoles(t, erin.ID); len(got) != 2 || got[0] != "approver" {
+		t.Errorf("erin 的角色 = %v，期望 [approver user]", got)
+	}
+}
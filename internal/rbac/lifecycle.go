@@ -0,0 +1,224 @@
+package rbac
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/audit"
+	"grpc-rbac-backend/internal/model"
+)
+
+// 修改用户状态的来源，目录同步只恢复由该目录停用的用户
+const (
+	statusSourceAdmin = "admin"
+	statusSourceSCIM  = "scim"
+)
+
+// checkUserActive 停用或尚未激活的用户不能登录，也不能继续使用 API Key
+func checkUserActive(user *model.User) error {
+	switch user.Status {
+	case model.UserStatusDisabled:
+		return status.Error(codes.PermissionDenied, "用户已停用，请联系管理员")
+	case model.UserStatusPending:
+		return status.Error(codes.PermissionDenied, "账号尚未激活，请联系管理员")
+	}
+	return nil
+}
+
+// setUserStatus 修改用户状态并记录来源，离开 active 时撤销全部会话；调用方负责保存
+func setUserStatus(tx *gorm.DB, user *model.User, to, source string) error {
+	if user.Status == to {
+		return nil
+	}
+	user.Status, user.StatusSource = to, source
+	user.DisabledAt = nil
+	if to == model.UserStatusDisabled {
+		now := time.Now()
+		user.DisabledAt = &now
+	}
+	if to == model.UserStatusActive || user.ID == 0 {
+		return nil
+	}
+	return model.RevokeUserSessions(tx, user.ID, "")
+}
+
+// saveUserStatus 只保存状态相关的字段
+func saveUserStatus(tx *gorm.DB, user *model.User) error {
+	return tx.Model(user).Select("status", "status_source", "disabled_at").Updates(user).Error
+}
+
+// softDeleteUser 标记为已删除并撤销会话，角色、组成员等关联数据保留到保留期结束
+func softDeleteUser(tx *gorm.DB, user *model.User, source string) error {
+	if err := setUserStatus(tx, user, model.UserStatusDeleted, source); err != nil {
+		return err
+	}
+	if err := saveUserStatus(tx, user); err != nil {
+		return err
+	}
+	return tx.Delete(user).Error
+}
+
+// lockedUsers 因登录失败次数过多正处于锁定期的用户名
+func (s *Service) lockedUsers(tenantID uint, users []model.User) (map[string]bool, error) {
+	if len(users) == 0 {
+		return nil, nil
+	}
+	keys := make([]string, 0, len(users))
+	for _, u := range users {
+		keys = append(keys, model.UserThrottleKey(tenantID, u.Username))
+	}
+	var rows []model.LoginThrottle
+	if err := model.DB.Where("throttle_key IN ? AND failures >= ? AND blocked_until > ?", keys, s.cfg.LoginMaxFailures, time.Now()).
+		Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	locked := make(map[string]bool, len(rows))
+	for _, r := range rows {
+		locked[r.Key] = true
+	}
+	return locked, nil
+}
+
+// displayStatus 对外展示的状态：已删除的用户为 deleted，启用但被锁定的用户为 locked
+func displayStatus(user *model.User, locked map[string]bool) string {
+	if user.DeletedAt.Valid {
+		return model.UserStatusDeleted
+	}
+	if user.Status == model.UserStatusActive && locked[model.UserThrottleKey(user.TenantID, user.Username)] {
+		return model.UserStatusLocked
+	}
+	return user.Status
+}
+
+// changeUserStatus 管理员修改用户状态，不能修改自己
+func (s *Service) changeUserStatus(ctx context.Context, action string, userID uint32, to string, allowed ...string) error {
+	if err := requireTenantAdmin(ctx); err != nil {
+		return err
+	}
+	claims, err := callerClaims(ctx)
+	if err != nil {
+		return err
+	}
+	return withAudit(ctx, action, func(tx *gorm.DB, ev *model.AuditEvent) error {
+		ev.Target = audit.Target("user", uint(userID))
+
+		var user model.User
+		if err := tx.Scopes(model.TenantScope(claims.TenantID)).First(&user, userID).Error; err != nil {
+			return err
+		}
+		if user.Username == claims.Username {
+			return status.Error(codes.InvalidArgument, "不能修改自己的状态")
+		}
+		if user.Status == to {
+			return nil
+		}
+		allowedFrom := false
+		for _, from := range allowed {
+			allowedFrom = allowedFrom || user.Status == from
+		}
+		if !allowedFrom {
+			return status.Errorf(codes.FailedPrecondition, "用户当前状态为 %s，不能执行此操作", user.Status)
+		}
+		ev.Before = audit.Snapshot(user)
+		if err := setUserStatus(tx, &user, to, statusSourceAdmin); err != nil {
+			return err
+		}
+		if err := saveUserStatus(tx, &user); err != nil {
+			return err
+		}
+		ev.After = audit.Snapshot(user)
+		return nil
+	})
+}
+
+// DisableUser 停用用户，立即撤销其全部会话，API Key 也随之失效
+func (s *Service) DisableUser(ctx context.Context, req *api.UserStatusRequest) (*api.UserStatusResponse, error) {
+	err := s.changeUserStatus(ctx, "DisableUser", req.UserId, model.UserStatusDisabled,
+		model.UserStatusActive, model.UserStatusPending)
+	if err != nil {
+		return nil, err
+	}
+	return &api.UserStatusResponse{Message: "用户已停用", Status: model.UserStatusDisabled}, nil
+}
+
+// EnableUser 启用已停用或尚未激活的用户；登录失败导致的锁定需通过 UnlockUser 解除
+func (s *Service) EnableUser(ctx context.Context, req *api.UserStatusRequest) (*api.UserStatusResponse, error) {
+	err := s.changeUserStatus(ctx, "EnableUser", req.UserId, model.UserStatusActive,
+		model.UserStatusDisabled, model.UserStatusPending)
+	if err != nil {
+		return nil, err
+	}
+	return &api.UserStatusResponse{Message: "用户已启用", Status: model.UserStatusActive}, nil
+}
+
+// RestoreUser 恢复保留期内已删除的用户，恢复后为启用状态，角色和组成员不变
+func (s *Service) RestoreUser(ctx context.Context, req *api.UserStatusRequest) (*api.UserStatusResponse, error) {
+	if err := requireTenantAdmin(ctx); err != nil {
+		return nil, err
+	}
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = withAudit(ctx, "RestoreUser", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		ev.Target = audit.Target("user", uint(req.UserId))
+
+		var user model.User
+		if err := tx.Unscoped().Scopes(model.TenantScope(tenantID)).Where("deleted_at IS NOT NULL").
+			Limit(1).Find(&user, req.UserId).Error; err != nil {
+			return err
+		}
+		if user.ID == 0 {
+			return status.Error(codes.NotFound, "已删除的用户不存在")
+		}
+		if time.Since(user.DeletedAt.Time) > s.cfg.UserDeleteRetention {
+			return status.Error(codes.FailedPrecondition, "已超过保留期，不能恢复")
+		}
+		ev.Before = audit.Snapshot(user)
+		user.DeletedAt = gorm.DeletedAt{}
+		if err := setUserStatus(tx, &user, model.UserStatusActive, statusSourceAdmin); err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Model(&user).Select("status", "status_source", "disabled_at", "deleted_at").
+			Updates(&user).Error; err != nil {
+			return err
+		}
+		ev.After = audit.Snapshot(user)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.UserStatusResponse{Message: "用户已恢复", Status: model.UserStatusActive}, nil
+}
+
+// sweepDeletedUsers 彻底删除超过保留期的用户及其关联数据，每个用户单独写一条审计记录
+func (s *Service) sweepDeletedUsers(ctx context.Context) error {
+	for {
+		var users []model.User
+		if err := model.DB.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", time.Now().Add(-s.cfg.UserDeleteRetention)).
+			Limit(sweepBatchSize).Find(&users).Error; err != nil {
+			return err
+		}
+		for i := range users {
+			user := users[i]
+			err := withAudit(ctx, "PurgeUser", func(tx *gorm.DB, ev *model.AuditEvent) error {
+				ev.TenantID = user.TenantID
+				ev.Target = audit.Target("user", user.ID)
+				ev.Before = audit.Snapshot(user)
+				return model.DeleteUserWithRelations(tx, user.ID)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if len(users) < sweepBatchSize || ctx.Err() != nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package rbac
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/audit"
+	"grpc-rbac-backend/internal/model"
+)
+
+// 普通用户不能删除或停用其他用户，管理员不能删除或停用自己
+func TestUserLifecycleRequiresTenantAdmin(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	tt.createUser(t, "mallory")
+	ctx := tt.ctx("mallory", "user")
+	admin := uint32(tt.Admin.ID)
+
+	_, err := s.DeleteUser(ctx, &api.DeleteUserRequest{UserId: admin})
+	wantCode(t, err, codes.PermissionDenied)
+	_, err = s.DisableUser(ctx, &api.UserStatusRequest{UserId: admin})
+	wantCode(t, err, codes.PermissionDenied)
+	_, err = s.DeleteUser(tt.adminCtx(), &api.DeleteUserRequest{UserId: admin})
+	wantCode(t, err, codes.InvalidArgument)
+	_, err = s.DisableUser(tt.adminCtx(), &api.UserStatusRequest{UserId: admin})
+	wantCode(t, err, codes.InvalidArgument)
+
+	var after model.User
+	if err := model.DB.First(&after, tt.Admin.ID).Error; err != nil || after.Status != model.UserStatusActive {
+		t.Errorf("管理员被删除或停用: %+v %v", after, err)
+	}
+}
+
+// 停用后会话、refresh token 和 API Key 立即失效，启用后可重新登录
+func TestDisableUserRevokesCredentials(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	alice := tt.createUser(t, "alice")
+	login := tt.login(t, s, "alice")
+	ctx := tt.adminCtx()
+
+	account, err := s.CreateServiceAccount(ctx, &api.CreateServiceAccountRequest{Name: "batch"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := s.CreateAPIKey(ctx, &api.CreateAPIKeyRequest{ServiceAccountId: account.UserId, Name: "ci"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AuthenticateAPIKey(context.Background(), key.Key); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []uint32{uint32(alice.ID), account.UserId} {
+		if _, err := s.DisableUser(ctx, &api.UserStatusRequest{UserId: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sessionActive(t, login.Token) {
+		t.Error("停用后会话仍有效")
+	}
+	_, err = refresh(s, login.RefreshToken, nil)
+	wantCode(t, err, codes.Unauthenticated)
+	_, err = s.Login(context.Background(), &api.LoginRequest{Tenant: tt.Name, Username: "alice", Password: testPassword})
+	wantCode(t, err, codes.PermissionDenied)
+	_, err = s.AuthenticateAPIKey(context.Background(), key.Key)
+	wantCode(t, err, codes.PermissionDenied)
+
+	if _, err := s.EnableUser(ctx, &api.UserStatusRequest{UserId: uint32(alice.ID)}); err != nil {
+		t.Fatal(err)
+	}
+	tt.login(t, s, "alice")
+}
+
+// 保留期内可以恢复，角色保持不变；超过保留期后不能恢复
+func TestRestoreUser(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	s.cfg.LoginBackoff = 0
+	tt := newTestTenant(t)
+	alice := tt.createUser(t, "alice")
+	tt.grant(t, alice, tt.createRole(t, "reader", "docs:read"))
+	login := tt.login(t, s, "alice")
+	ctx := tt.adminCtx()
+
+	if _, err := s.DeleteUser(ctx, &api.DeleteUserRequest{UserId: uint32(alice.ID)}); err != nil {
+		t.Fatal(err)
+	}
+	if sessionActive(t, login.Token) {
+		t.Error("删除后会话仍有效")
+	}
+	_, err := s.Login(context.Background(), &api.LoginRequest{Tenant: tt.Name, Username: "alice", Password: testPassword})
+	if err == nil {
+		t.Fatal("已删除的用户不应能登录")
+	}
+
+	resp, err := s.RestoreUser(ctx, &api.UserStatusRequest{UserId: uint32(alice.ID)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != model.UserStatusActive {
+		t.Errorf("恢复后状态 = %s", resp.Status)
+	}
+	if got := directRoles(t, alice.ID); len(got) != 1 || got[0] != "reader" {
+		t.Errorf("恢复后的角色 = %v，期望 [reader]", got)
+	}
+	tt.login(t, s, "alice")
+
+	// 超过保留期
+	if _, err := s.DeleteUser(ctx, &api.DeleteUserRequest{UserId: uint32(alice.ID)}); err != nil {
+		t.Fatal(err)
+	}
+	expired := time.Now().Add(-s.cfg.UserDeleteRetention - time.Hour)
+	if err := model.DB.Unscoped().Model(&model.User{}).Where("id = ?", alice.ID).Update("deleted_at", expired).Error; err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.RestoreUser(ctx, &api.UserStatusRequest{UserId: uint32(alice.ID)})
+	wantCode(t, err, codes.FailedPrecondition)
+	_, err = s.RestoreUser(ctx, &api.UserStatusRequest{UserId: uint32(tt.Admin.ID)})
+	wantCode(t, err, codes.NotFound)
+}
+
+// 清理任务只彻底删除超过保留期的用户及其关联数据
+func TestSweepDeletedUsers(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	role := tt.createRole(t, "reader", "docs:read")
+	alice, bob := tt.createUser(t, "alice"), tt.createUser(t, "bob")
+	tt.grant(t, alice, role)
+	tt.grant(t, bob, role)
+	ctx := tt.adminCtx()
+	for _, u := range []*model.User{alice, bob} {
+		if _, err := s.DeleteUser(ctx, &api.DeleteUserRequest{UserId: uint32(u.ID)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expired := time.Now().Add(-s.cfg.UserDeleteRetention - time.Hour)
+	if err := model.DB.Unscoped().Model(&model.User{}).Where("id = ?", alice.ID).Update("deleted_at", expired).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.sweepDeletedUsers(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var remaining []string
+	if err := model.DB.Unscoped().Model(&model.User{}).Where("id IN ?", []uint{alice.ID, bob.ID}).Pluck("username", &remaining).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 1 || remaining[0] != "bob" {
+		t.Errorf("清理后剩余用户 = %v，期望仅保留期内的 bob", remaining)
+	}
+	if got := directRoles(t, alice.ID); len(got) != 0 {
+		t.Errorf("已彻底删除用户的角色分配未清除: %v", got)
+	}
+	if got := directRoles(t, bob.ID); len(got) != 1 {
+		t.Errorf("保留期内用户的角色分配被清除: %v", got)
+	}
+	var purged int64
+	model.DB.Model(&model.AuditEvent{}).Where("action = ? AND target = ?", "PurgeUser", audit.Target("user", alice.ID)).Count(&purged)
+	if purged != 1 {
+		t.Errorf("PurgeUser 审计记录 %d 条，期望 1", purged)
+	}
+}
@@ -0,0 +1,189 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"grpc-rbac-backend/config"
+	"grpc-rbac-backend/internal/audit"
+	"grpc-rbac-backend/internal/middleware"
+	"grpc-rbac-backend/internal/model"
+	"grpc-rbac-backend/internal/oidc"
+	"grpc-rbac-backend/internal/password"
+	"grpc-rbac-backend/internal/utils"
+)
+
+// 测试使用临时 SQLite 数据库代替 MySQL，所有测试共用一个库，各自创建独立的租户
+var testSigner *oidc.Signer
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "rbac-test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	dsn := filepath.Join(dir, "rbac.db") + "?_busy_timeout=5000&_journal_mode=WAL"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := model.Migrate(db); err != nil {
+		log.Fatal(err)
+	}
+	model.DB = db
+	audit.SigningKey = []byte("test-signing-key")
+	if testSigner, err = oidc.LoadSigner(""); err != nil {
+		log.Fatal(err)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func testConfig() *config.Config {
+	return &config.Config{
+		SweepInterval:            time.Minute,
+		AccessRequestMaxDuration: 8 * time.Hour,
+		AccessRequestPendingTTL:  24 * time.Hour,
+		LoginMaxFailures:         5,
+		LoginIPMaxFailures:       50,
+		LoginBackoff:             time.Second,
+		LoginLockout:             15 * time.Minute,
+		MFAIssuer:                "grpc-rbac-backend",
+		PasswordMinLength:        8,
+		PasswordMinClasses:       3,
+		PasswordHistory:          5,
+		PasswordResetTTL:         30 * time.Minute,
+		RefreshTokenTTL:          7 * 24 * time.Hour,
+		OIDCIssuer:               "http://localhost:8080",
+		UserDeleteRetention:      30 * 24 * time.Hour,
+		EmailVerificationTTL:     24 * time.Hour,
+	}
+}
+
+// newTestService 使用默认配置创建服务，federation、directories 为空时不启用对应功能
+func newTestService(t *testing.T, providers *FederationConfig, directories *DirectoryConfig) *Service {
+	t.Helper()
+	cfg := testConfig()
+	namespaces, err := LoadNamespaceConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	passwords, err := password.NewPolicy(cfg.PasswordMinLength, cfg.PasswordMinClasses, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if providers == nil {
+		providers = &FederationConfig{Providers: map[string]*IdentityProvider{}}
+	}
+	if directories == nil {
+		directories = &DirectoryConfig{Directories: map[string]*LDAPDirectory{}}
+	}
+	return NewRBACService(cfg, namespaces, passwords, testSigner, providers, directories)
+}
+
+var tenantSeq atomic.Int64
+
+// testTenant 测试租户及其管理员
+type testTenant struct {
+	model.Tenant
+	Admin *model.User
+}
+
+const testPassword = "Passw0rd!2024"
+
+func newTestTenant(t *testing.T) *testTenant {
+	t.Helper()
+	tenant := model.Tenant{Name: fmt.Sprintf("t%d", tenantSeq.Add(1))}
+	admin, err := model.CreateTenantWithAdmin(model.DB, &tenant, "admin", testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testTenant{Tenant: tenant, Admin: admin}
+}
+
+// ctx 以租户内指定用户和角色的身份调用
+func (tt *testTenant) ctx(username string, roles ...string) context.Context {
+	claims := &utils.CustomClaims{TenantID: tt.ID, Tenant: tt.Name, Username: username, Roles: roles}
+	return context.WithValue(context.Background(), middleware.ContextUserKey, claims)
+}
+
+func (tt *testTenant) adminCtx() context.Context {
+	return tt.ctx(tt.Admin.Username, "admin")
+}
+
+func (tt *testTenant) createUser(t *testing.T, username string) *model.User {
+	t.Helper()
+	hash, err := password.Hash(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := model.User{TenantID: tt.ID, Username: username, Password: hash}
+	if err := model.DB.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &user
+}
+
+func (tt *testTenant) createRole(t *testing.T, name string, permissions ...string) *model.Role {
+	t.Helper()
+	role := model.Role{TenantID: tt.ID, Name: name}
+	for _, p := range permissions {
+		perm := model.Permission{TenantID: tt.ID, Name: p}
+		if err := model.DB.Where(perm).FirstOrCreate(&perm).Error; err != nil {
+			t.Fatal(err)
+		}
+		role.Permissions = append(role.Permissions, perm)
+	}
+	if err := model.DB.Create(&role).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &role
+}
+
+func (tt *testTenant) grant(t *testing.T, user *model.User, role *model.Role) {
+	t.Helper()
+	if err := model.DB.Create(&model.UserRole{UserID: user.ID, RoleID: role.ID}).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (tt *testTenant) role(t *testing.T, name string) *model.Role {
+	t.Helper()
+	var role model.Role
+	if err := model.DB.Scopes(model.TenantScope(tt.ID)).Where("name = ?", name).First(&role).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &role
+}
+
+// directRoles 用户直接分配的角色名
+func directRoles(t *testing.T, userID uint) []string {
+	t.Helper()
+	var names []string
+	if err := model.DB.Table("roles").Joins("JOIN user_roles ON user_roles.role_id = roles.id").
+		Where("user_roles.user_id = ?", userID).Order("roles.name").Pluck("roles.name", &names).Error; err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+// wantCode 断言 err 为指定的 gRPC 状态码
+func wantCode(t *testing.T, err error, want codes.Code) {
+	t.Helper()
+	if got := status.Code(err); got != want {
+		t.Fatalf("状态码 = %v (%v)，期望 %v", got, err, want)
+	}
+}
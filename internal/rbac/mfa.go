@@ -0,0 +1,239 @@
+package rbac
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/audit"
+	"grpc-rbac-backend/internal/mfa"
+	"grpc-rbac-backend/internal/model"
+	"grpc-rbac-backend/internal/utils"
+)
+
+// mfaChallengeTTL MFA 挑战 token 和待绑定 token 的有效期
+const mfaChallengeTTL = 5 * time.Minute
+
+// findTOTP 查询用户的 TOTP 配置，未开始绑定时返回 nil
+func findTOTP(tx *gorm.DB, userID uint) (*model.UserTOTP, error) {
+	var t model.UserTOTP
+	if err := tx.Where("user_id = ?", userID).Limit(1).Find(&t).Error; err != nil {
+		return nil, err
+	}
+	if t.UserID == 0 {
+		return nil, nil
+	}
+	return &t, nil
+}
+
+// mfaRequired 用户拥有 MFA_REQUIRED_ROLES 中的任一角色时必须启用 MFA
+func (s *Service) mfaRequired(grants []roleGrant) bool {
+	if s.cfg.MFARequiredRoles == "" {
+		return false
+	}
+	required := make(map[string]bool)
+	for _, name := range strings.Split(s.cfg.MFARequiredRoles, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			required[name] = true
+		}
+	}
+	for _, g := range grants {
+		if required[g.Role.Name] {
+			return true
+		}
+	}
+	return false
+}
+
+// verifySecondFactor 校验 TOTP 验证码或恢复码；通过后记录时间步或作废恢复码，防止重复使用
+func verifySecondFactor(tx *gorm.DB, userID uint, code string) (bool, error) {
+	var totp model.UserTOTP
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ?", userID).Limit(1).Find(&totp).Error; err != nil {
+		return false, err
+	}
+	if totp.UserID == 0 || !totp.Enabled() {
+		return false, nil
+	}
+	if step, ok := mfa.Verify(totp.Secret, code, time.Now()); ok {
+		if step <= totp.LastStep {
+			return false, nil
+		}
+		return true, tx.Model(&totp).Update("last_step", step).Error
+	}
+
+	var rc model.RecoveryCode
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, mfa.HashRecoveryCode(code)).
+		Limit(1).Find(&rc).Error; err != nil {
+		return false, err
+	}
+	if rc.ID == 0 {
+		return false, nil
+	}
+	return true, tx.Model(&rc).Update("used_at", time.Now()).Error
+}
+
+// VerifyMFA 用登录返回的挑战 token 和验证码（或恢复码）换取正式 token
+func (s *Service) VerifyMFA(ctx context.Context, req *api.VerifyMFARequest) (*api.VerifyMFAResponse, error) {
+	claims, err := utils.ParseJWT(req.ChallengeToken)
+	if err != nil || claims.Purpose != utils.PurposeMFA {
+		return nil, status.Error(codes.Unauthenticated, "MFA 挑战已失效，请重新登录")
+	}
+
+	keys := loginThrottleKeys(ctx, claims.TenantID, claims.Username)
+	if err := checkLoginThrottle(keys...); err != nil {
+		return nil, err
+	}
+
+	var user model.User
+	if err := model.DB.Scopes(model.TenantScope(claims.TenantID)).Where("username = ?", claims.Username).First(&user).Error; err != nil {
+		return nil, err
+	}
+	if err := checkUserActive(&user); err != nil {
+		return nil, err
+	}
+	var ok bool
+	err = model.DB.Transaction(func(tx *gorm.DB) error {
+		ok, err = verifySecondFactor(tx, user.ID, req.Code)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if err := s.recordLoginFailures(keys); err != nil {
+			return nil, err
+		}
+		return nil, status.Error(codes.Unauthenticated, "验证码错误")
+	}
+	if err := resetLoginFailures(keys[0]); err != nil {
+		return nil, err
+	}
+
+	grants, err := effectiveRoles(model.DB, claims.TenantID, user.ID, false)
+	if err != nil {
+		return nil, err
+	}
+	t, err := s.sessionToken(claims.TenantID, claims.Tenant, &user, grants)
+	if err != nil {
+		return nil, err
+	}
+	return &api.VerifyMFAResponse{Token: t.AccessToken, RefreshToken: t.RefreshToken}, nil
+}
+
+// BeginTOTPEnrollment 生成新的 TOTP 密钥，需调用 ConfirmTOTPEnrollment 提交验证码后才生效
+func (s *Service) BeginTOTPEnrollment(ctx context.Context, req *api.BeginTOTPEnrollmentRequest) (*api.BeginTOTPEnrollmentResponse, error) {
+	claims, err := callerClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+	user, err := currentUser(model.DB, ctx)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := findTOTP(model.DB, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil && existing.Enabled() {
+		return nil, status.Error(codes.FailedPrecondition, "已启用 TOTP")
+	}
+
+	secret, err := mfa.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	totp := model.UserTOTP{UserID: user.ID, Secret: secret}
+	if err := model.DB.Clauses(clause.OnConflict{UpdateAll: true}).Create(&totp).Error; err != nil {
+		return nil, err
+	}
+	return &api.BeginTOTPEnrollmentResponse{
+		Secret:     secret,
+		OtpauthUrl: mfa.ProvisioningURI(s.cfg.MFAIssuer, user.Username+"@"+claims.Tenant, secret),
+	}, nil
+}
+
+// ConfirmTOTPEnrollment 校验验证码后启用 TOTP，并生成一组新的恢复码（只返回这一次）。
+// 使用待绑定 token 调用时同时返回正式 token
+func (s *Service) ConfirmTOTPEnrollment(ctx context.Context, req *api.ConfirmTOTPEnrollmentRequest) (*api.ConfirmTOTPEnrollmentResponse, error) {
+	claims, err := callerClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var user *model.User
+	var recoveryCodes []string
+	err = withAudit(ctx, "ConfirmTOTPEnrollment", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		user, err = currentUser(tx, ctx)
+		if err != nil {
+			return err
+		}
+		ev.Target = audit.Target("user", user.ID)
+
+		var totp model.UserTOTP
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ?", user.ID).Limit(1).Find(&totp).Error; err != nil {
+			return err
+		}
+		if totp.UserID == 0 {
+			return status.Error(codes.FailedPrecondition, "请先调用 BeginTOTPEnrollment")
+		}
+		if totp.Enabled() {
+			return status.Error(codes.FailedPrecondition, "已启用 TOTP")
+		}
+		step, ok := mfa.Verify(totp.Secret, req.Code, time.Now())
+		if !ok {
+			return status.Error(codes.InvalidArgument, "验证码错误")
+		}
+		now := time.Now()
+		totp.ConfirmedAt = &now
+		totp.LastStep = step
+		if err := tx.Save(&totp).Error; err != nil {
+			return err
+		}
+
+		recoveryCodes, err = mfa.GenerateRecoveryCodes()
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&model.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		rows := make([]model.RecoveryCode, 0, len(recoveryCodes))
+		for _, c := range recoveryCodes {
+			rows = append(rows, model.RecoveryCode{UserID: user.ID, CodeHash: mfa.HashRecoveryCode(c)})
+		}
+		if err := tx.Create(&rows).Error; err != nil {
+			return err
+		}
+		ev.After = audit.Snapshot(totp)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &api.ConfirmTOTPEnrollmentResponse{Message: "TOTP 已启用，请妥善保存恢复码", RecoveryCodes: recoveryCodes}
+	if claims.Purpose == utils.PurposeMFAEnroll {
+		if err := checkUserActive(user); err != nil {
+			return nil, err
+		}
+		grants, err := effectiveRoles(model.DB, claims.TenantID, user.ID, false)
+		if err != nil {
+			return nil, err
+		}
+		t, err := s.sessionToken(claims.TenantID, claims.Tenant, user, grants)
+		if err != nil {
+			return nil, err
+		}
+		resp.Token, resp.RefreshToken = t.AccessToken, t.RefreshToken
+	}
+	return resp, nil
+}
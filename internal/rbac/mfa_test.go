@@ -0,0 +1,181 @@
+package rbac
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/mfa"
+	"grpc-rbac-backend/internal/middleware"
+	"grpc-rbac-backend/internal/model"
+	"grpc-rbac-backend/internal/utils"
+)
+
+// totpCode 计算相对当前时间偏移 delta 个时间步的验证码
+func totpCode(t *testing.T, secret string, delta int64) string {
+	t.Helper()
+	code, err := mfa.Code(secret, mfa.Step(time.Now())+delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// stepCode 计算用户最近一次通过校验的时间步之后第 delta 步的验证码
+func stepCode(t *testing.T, userID uint, secret string, delta int64) string {
+	t.Helper()
+	var totp model.UserTOTP
+	if err := model.DB.Where("user_id = ?", userID).First(&totp).Error; err != nil {
+		t.Fatal(err)
+	}
+	code, err := mfa.Code(secret, totp.LastStep+delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// enrollTOTP 以 ctx 对应的用户完成 TOTP 绑定，返回密钥和确认结果
+func enrollTOTP(t *testing.T, s *Service, ctx context.Context) (string, *api.ConfirmTOTPEnrollmentResponse) {
+	t.Helper()
+	begin, err := s.BeginTOTPEnrollment(ctx, &api.BeginTOTPEnrollmentRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 与当前时间步相差过大的验证码
+	_, err = s.ConfirmTOTPEnrollment(ctx, &api.ConfirmTOTPEnrollmentRequest{Code: totpCode(t, begin.Secret, -10)})
+	wantCode(t, err, codes.InvalidArgument)
+	confirm, err := s.ConfirmTOTPEnrollment(ctx, &api.ConfirmTOTPEnrollmentRequest{Code: totpCode(t, begin.Secret, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return begin.Secret, confirm
+}
+
+// mfaChallenge 用户名密码登录，返回 MFA 挑战 token
+func (tt *testTenant) mfaChallenge(t *testing.T, s *Service, username string) string {
+	t.Helper()
+	resp, err := s.Login(context.Background(), &api.LoginRequest{Tenant: tt.Name, Username: username, Password: testPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.MfaRequired || resp.Token != "" || resp.ChallengeToken == "" {
+		t.Fatalf("已启用 TOTP 的用户登录应只返回挑战 token: %+v", resp)
+	}
+	return resp.ChallengeToken
+}
+
+func TestTOTPEnrollmentAndReplay(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	s.cfg.LoginBackoff = 0
+	tt := newTestTenant(t)
+	user := tt.createUser(t, "alice")
+	ctx := tt.ctx("alice")
+
+	secret, confirm := enrollTOTP(t, s, ctx)
+	if len(confirm.RecoveryCodes) != 10 || confirm.Token != "" {
+		t.Errorf("绑定结果 = %+v，期望 10 个恢复码且不签发 token", confirm)
+	}
+	_, err := s.BeginTOTPEnrollment(ctx, &api.BeginTOTPEnrollmentRequest{})
+	wantCode(t, err, codes.FailedPrecondition)
+
+	// 绑定时使用过的验证码不能再用于登录
+	used := stepCode(t, user.ID, secret, 0)
+	challenge := tt.mfaChallenge(t, s, "alice")
+	_, err = s.VerifyMFA(context.Background(), &api.VerifyMFARequest{ChallengeToken: challenge, Code: used})
+	wantCode(t, err, codes.Unauthenticated)
+
+	next := stepCode(t, user.ID, secret, 1)
+	resp, err := s.VerifyMFA(context.Background(), &api.VerifyMFARequest{ChallengeToken: challenge, Code: next})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Token == "" || resp.RefreshToken == "" {
+		t.Errorf("校验通过后应签发 token: %+v", resp)
+	}
+	// 同一验证码重放
+	_, err = s.VerifyMFA(context.Background(), &api.VerifyMFARequest{ChallengeToken: tt.mfaChallenge(t, s, "alice"), Code: next})
+	wantCode(t, err, codes.Unauthenticated)
+}
+
+func TestRecoveryCodesSingleUse(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	s.cfg.LoginBackoff = 0
+	tt := newTestTenant(t)
+	tt.createUser(t, "alice")
+	_, confirm := enrollTOTP(t, s, tt.ctx("alice"))
+	code := confirm.RecoveryCodes[0]
+
+	if _, err := s.VerifyMFA(context.Background(), &api.VerifyMFARequest{ChallengeToken: tt.mfaChallenge(t, s, "alice"), Code: code}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.VerifyMFA(context.Background(), &api.VerifyMFARequest{ChallengeToken: tt.mfaChallenge(t, s, "alice"), Code: code})
+	wantCode(t, err, codes.Unauthenticated)
+
+	// 其余恢复码仍可使用，且忽略大小写和连字符
+	other := strings.ToUpper(strings.ReplaceAll(confirm.RecoveryCodes[1], "-", ""))
+	if _, err := s.VerifyMFA(context.Background(), &api.VerifyMFARequest{ChallengeToken: tt.mfaChallenge(t, s, "alice"), Code: other}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 只有 MFA 挑战 token 能提交给 VerifyMFA
+func TestVerifyMFARejectsOtherTokens(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	s.cfg.LoginBackoff = 0
+	tt := newTestTenant(t)
+	tt.createUser(t, "alice")
+	secret, _ := enrollTOTP(t, s, tt.ctx("alice"))
+
+	enroll, err := utils.GeneratePurposeJWT(tt.ID, tt.Name, "alice", utils.PurposeMFAEnroll, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := utils.GenerateJWT("sid", tt.ID, tt.Name, "alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{enroll, session, "garbage"} {
+		_, err := s.VerifyMFA(context.Background(), &api.VerifyMFARequest{ChallengeToken: token, Code: totpCode(t, secret, 1)})
+		wantCode(t, err, codes.Unauthenticated)
+	}
+}
+
+// 拥有 MFA_REQUIRED_ROLES 中角色的用户须先绑定 TOTP 才能拿到正式 token
+func TestMFARequiredRoles(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	s.cfg.MFARequiredRoles = " auditor, admin "
+	tt := newTestTenant(t)
+	tt.createUser(t, "alice")
+
+	resp, err := s.Login(context.Background(), &api.LoginRequest{Tenant: tt.Name, Username: "alice", Password: testPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Token == "" || resp.MfaEnrollmentRequired {
+		t.Errorf("不要求 MFA 的用户应直接签发 token: %+v", resp)
+	}
+
+	resp, err = s.Login(context.Background(), &api.LoginRequest{Tenant: tt.Name, Username: "admin", Password: testPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.MfaEnrollmentRequired || resp.Token != "" || resp.RefreshToken != "" {
+		t.Fatalf("要求 MFA 的用户登录应只返回待绑定 token: %+v", resp)
+	}
+	claims, err := utils.ParseJWT(resp.ChallengeToken)
+	if err != nil || claims.Purpose != utils.PurposeMFAEnroll || len(claims.Roles) != 0 {
+		t.Fatalf("待绑定 token = %+v %v", claims, err)
+	}
+
+	_, confirm := enrollTOTP(t, s, context.WithValue(context.Background(), middleware.ContextUserKey, claims))
+	if confirm.Token == "" || confirm.RefreshToken == "" {
+		t.Errorf("使用待绑定 token 完成绑定后应签发 token: %+v", confirm)
+	}
+	// 绑定后登录改为 MFA 挑战
+	tt.mfaChallenge(t, s, "admin")
+}
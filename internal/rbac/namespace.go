@@ -0,0 +1,148 @@
+package rbac
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+//go:embed namespaces.json
+var defaultNamespaceConfig []byte
+
+// NamespaceConfig 关系元组的命名空间定义，描述每个对象类型有哪些关系及其改写规则
+type NamespaceConfig struct {
+	Namespaces map[string]*Namespace `json:"namespaces"`
+}
+
+// Namespace 一个对象类型，如 doc、folder
+type Namespace struct {
+	Relations map[string]*Userset `json:"relations"`
+}
+
+// Userset 关系的改写规则，各字段只能设置一个；全部为空等价于 this
+//   - this: 直接写入该关系的元组
+//   - computed_userset: 同一对象的另一个关系，如 editor 同时是 viewer
+//   - tuple_to_userset: 沿 tupleset 关系找到关联对象，再取其 computed_userset 关系，
+//     如文档所在文件夹的 viewer 也是文档的 viewer
+//   - union / intersection: 子规则的并集 / 交集
+//   - exclusion: base 中去掉 subtract
+type Userset struct {
+	This            bool            `json:"this,omitempty"`
+	ComputedUserset string          `json:"computed_userset,omitempty"`
+	TupleToUserset  *TupleToUserset `json:"tuple_to_userset,omitempty"`
+	Union           []*Userset      `json:"union,omitempty"`
+	Intersection    []*Userset      `json:"intersection,omitempty"`
+	Exclusion       *Exclusion      `json:"exclusion,omitempty"`
+}
+
+type TupleToUserset struct {
+	Tupleset        string `json:"tupleset"`
+	ComputedUserset string `json:"computed_userset"`
+}
+
+type Exclusion struct {
+	Base     *Userset `json:"base"`
+	Subtract *Userset `json:"subtract"`
+}
+
+// LoadNamespaceConfig 从文件加载命名空间配置，path 为空时使用内置的 doc/folder 示例配置
+func LoadNamespaceConfig(path string) (*NamespaceConfig, error) {
+	data := defaultNamespaceConfig
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	var cfg NamespaceConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("解析命名空间配置失败: %w", err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func (c *NamespaceConfig) validate() error {
+	for name, ns := range c.Namespaces {
+		if ns == nil {
+			c.Namespaces[name] = &Namespace{}
+			continue
+		}
+		for rel, rw := range ns.Relations {
+			if rw == nil {
+				ns.Relations[rel] = &Userset{}
+				continue
+			}
+			if err := rw.validate(ns); err != nil {
+				return fmt.Errorf("%s#%s: %w", name, rel, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (u *Userset) validate(ns *Namespace) error {
+	set := 0
+	if u.This {
+		set++
+	}
+	if u.ComputedUserset != "" {
+		set++
+		if _, ok := ns.Relations[u.ComputedUserset]; !ok {
+			return fmt.Errorf("未定义的关系 %s", u.ComputedUserset)
+		}
+	}
+	if u.TupleToUserset != nil {
+		set++
+		if _, ok := ns.Relations[u.TupleToUserset.Tupleset]; !ok {
+			return fmt.Errorf("未定义的关系 %s", u.TupleToUserset.Tupleset)
+		}
+		if u.TupleToUserset.ComputedUserset == "" {
+			return fmt.Errorf("tuple_to_userset 缺少 computed_userset")
+		}
+	}
+	children := append(append([]*Userset{}, u.Union...), u.Intersection...)
+	if len(u.Union) > 0 {
+		set++
+	}
+	if len(u.Intersection) > 0 {
+		set++
+	}
+	if u.Exclusion != nil {
+		set++
+		if u.Exclusion.Base == nil || u.Exclusion.Subtract == nil {
+			return fmt.Errorf("exclusion 需要同时设置 base 和 subtract")
+		}
+		children = append(children, u.Exclusion.Base, u.Exclusion.Subtract)
+	}
+	if set > 1 {
+		return fmt.Errorf("改写规则只能设置一种")
+	}
+	for _, child := range children {
+		if child == nil {
+			return fmt.Errorf("改写规则不能为空")
+		}
+		if err := child.validate(ns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewrite 返回关系的改写规则，未定义时返回 nil
+func (c *NamespaceConfig) rewrite(namespace, relation string) *Userset {
+	ns, ok := c.Namespaces[namespace]
+	if !ok {
+		return nil
+	}
+	return ns.Relations[relation]
+}
+
+// hasNamespace 命名空间是否已定义
+func (c *NamespaceConfig) hasNamespace(namespace string) bool {
+	_, ok := c.Namespaces[namespace]
+	return ok
+}
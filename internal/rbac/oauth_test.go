@@ -0,0 +1,181 @@
+package rbac
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/model"
+	"grpc-rbac-backend/internal/utils"
+)
+
+// login 用户名密码登录，返回正式 token
+func (tt *testTenant) login(t *testing.T, s *Service, username string) *api.LoginResponse {
+	t.Helper()
+	resp, err := s.Login(context.Background(), &api.LoginRequest{Tenant: tt.Name, Username: username, Password: testPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Token == "" || resp.RefreshToken == "" {
+		t.Fatalf("登录未签发 token: %+v", resp)
+	}
+	return resp
+}
+
+// createClient 创建登记了回调地址的机密客户端
+func (tt *testTenant) createClient(t *testing.T, s *Service, redirectURI string, scopes ...string) *api.CreateOAuthClientResponse {
+	t.Helper()
+	resp, err := s.CreateOAuthClient(tt.adminCtx(), &api.CreateOAuthClientRequest{
+		Name: "app", RedirectUris: []string{redirectURI}, Scopes: scopes,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func refresh(s *Service, token string, client *api.CreateOAuthClientResponse) (*api.IssueTokenResponse, error) {
+	req := &api.IssueTokenRequest{GrantType: grantRefreshToken, RefreshToken: token}
+	if client != nil {
+		req.ClientId, req.ClientSecret = client.ClientId, client.ClientSecret
+	}
+	return s.IssueToken(context.Background(), req)
+}
+
+func sessionActive(t *testing.T, token string) bool {
+	t.Helper()
+	claims, err := utils.ParseJWT(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	active, err := model.SessionActive(model.DB, claims.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return active
+}
+
+// refresh token 每次使用后轮换，旧 token 再次出现时撤销整个会话
+func TestRefreshTokenRotation(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	tt.createUser(t, "alice")
+	login := tt.login(t, s, "alice")
+
+	first, err := refresh(s, login.RefreshToken, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.RefreshToken == "" || first.RefreshToken == login.RefreshToken || first.AccessToken == "" {
+		t.Fatalf("刷新结果 = %+v，期望轮换 refresh token", first)
+	}
+	second, err := refresh(s, first.RefreshToken, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = refresh(s, login.RefreshToken, nil)
+	wantCode(t, err, codes.Unauthenticated)
+	if sessionActive(t, login.Token) {
+		t.Error("refresh token 被重复使用后会话未撤销")
+	}
+	_, err = refresh(s, second.RefreshToken, nil)
+	wantCode(t, err, codes.Unauthenticated)
+}
+
+// 客户端会话的 refresh token 只能由该客户端使用
+func TestRefreshTokenClientBinding(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	alice := tt.createUser(t, "alice")
+	app := tt.createClient(t, s, "https://app.example.com/cb")
+	other := tt.createClient(t, s, "https://other.example.com/cb")
+	issued, err := s.startSession(&model.Session{TenantID: tt.ID, UserID: alice.ID, ClientID: app.ClientId}, tt.Name, "alice", nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = refresh(s, issued.RefreshToken, nil)
+	wantCode(t, err, codes.Unauthenticated)
+	_, err = refresh(s, issued.RefreshToken, other)
+	wantCode(t, err, codes.Unauthenticated)
+	_, err = refresh(s, issued.RefreshToken, &api.CreateOAuthClientResponse{ClientId: app.ClientId, ClientSecret: "wrong"})
+	wantCode(t, err, codes.Unauthenticated)
+
+	// 以上失败不消耗 refresh token
+	if _, err := refresh(s, issued.RefreshToken, app); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRefreshTokenInactiveUser(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	alice := tt.createUser(t, "alice")
+	login := tt.login(t, s, "alice")
+	if err := model.DB.Model(alice).Update("status", model.UserStatusPending).Error; err != nil {
+		t.Fatal(err)
+	}
+	_, err := refresh(s, login.RefreshToken, nil)
+	wantCode(t, err, codes.Unauthenticated)
+}
+
+func exchange(s *Service, token, scope string) (*api.IssueTokenResponse, error) {
+	return s.IssueToken(context.Background(), &api.IssueTokenRequest{
+		GrantType: grantTokenExchange, SubjectToken: token, SubjectTokenType: tokenTypeAccessToken, Scope: scope,
+	})
+}
+
+// token exchange 只能缩小权限范围
+func TestTokenExchangeNarrowsScope(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	alice := tt.createUser(t, "alice")
+	tt.grant(t, alice, tt.createRole(t, "editor", "docs:read", "docs:write"))
+	tt.createRole(t, "billing", "billing:admin")
+	login := tt.login(t, s, "alice")
+
+	narrowed, err := exchange(s, login.Token, "docs:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := utils.ParseJWT(narrowed.AccessToken)
+	if err != nil || claims.Scope != "docs:read" || narrowed.IssuedTokenType != tokenTypeAccessToken {
+		t.Fatalf("换出的 token = %+v %v", claims, err)
+	}
+
+	// 已缩小的 token 不能再换回原有的其他权限
+	_, err = exchange(s, narrowed.AccessToken, "docs:write")
+	wantCode(t, err, codes.PermissionDenied)
+	// 用户未拥有的权限
+	_, err = exchange(s, login.Token, "billing:admin")
+	wantCode(t, err, codes.PermissionDenied)
+	_, err = exchange(s, login.Token, "")
+	wantCode(t, err, codes.InvalidArgument)
+
+	// 受限用途的 token 不能用于换取
+	challenge, err := utils.GeneratePurposeJWT(tt.ID, tt.Name, "alice", utils.PurposeMFA, mfaChallengeTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = exchange(s, challenge, "docs:read")
+	wantCode(t, err, codes.Unauthenticated)
+}
+
+// 停用或尚未激活的用户不能换取 token
+func TestTokenExchangeInactiveUser(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	alice := tt.createUser(t, "alice")
+	tt.grant(t, alice, tt.createRole(t, "reader", "docs:read"))
+	login := tt.login(t, s, "alice")
+
+	// 直接修改状态，会话保持有效
+	if err := model.DB.Model(alice).Update("status", model.UserStatusPending).Error; err != nil {
+		t.Fatal(err)
+	}
+	_, err := exchange(s, login.Token, "docs:read")
+	wantCode(t, err, codes.Unauthenticated)
+}
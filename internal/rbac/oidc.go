@@ -0,0 +1,320 @@
+package rbac
+
+import (
+	"context"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/genproto/googleapis/api/httpbody"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/model"
+	"grpc-rbac-backend/internal/oidc"
+	"grpc-rbac-backend/internal/utils"
+)
+
+// authCodeTTL 授权码的有效期
+const authCodeTTL = 5 * time.Minute
+
+// splitScopes 把 scope 分为 OIDC 标准 scope 和权限名
+func splitScopes(scopes []string) (standard, permissions []string) {
+	for _, name := range scopes {
+		if oidc.IsStandardScope(name) {
+			standard = append(standard, name)
+		} else {
+			permissions = append(permissions, name)
+		}
+	}
+	return standard, permissions
+}
+
+// authorizeRedirect 在回调地址上追加参数，保留其原有的查询参数，忽略空值
+func authorizeRedirect(redirectURI string, params map[string]string) string {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+	q := u.Query()
+	for k, v := range params {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// Authorize 授权码流程的登录：校验客户端和回调地址后验证用户名密码（及 MFA），
+// 通过后签发一次性授权码并返回跳转地址。客户端或回调地址无效时返回错误而不跳转，
+// 其余参数错误按 RFC 6749 通过回调地址告知客户端
+func (s *Service) Authorize(ctx context.Context, req *api.AuthorizeRequest) (*api.AuthorizeResponse, error) {
+	client, err := findClient(req.ClientId)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, status.Error(codes.InvalidArgument, "客户端不存在或已撤销")
+	}
+	if !slices.Contains(client.RedirectURIs, req.RedirectUri) {
+		return nil, status.Error(codes.InvalidArgument, "回调地址未登记")
+	}
+	var tenant model.Tenant
+	if err := model.DB.Select("id", "name").First(&tenant, client.TenantID).Error; err != nil {
+		return nil, err
+	}
+
+	resp := &api.AuthorizeResponse{ClientName: client.Name, Tenant: tenant.Name}
+	redirectError := func(code, desc string) (*api.AuthorizeResponse, error) {
+		resp.RedirectTo = authorizeRedirect(req.RedirectUri, map[string]string{
+			"error":             code,
+			"error_description": desc,
+			"state":             req.State,
+		})
+		return resp, nil
+	}
+	if req.ResponseType != "code" {
+		return redirectError("unsupported_response_type", "只支持 response_type=code")
+	}
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != "S256" {
+		return redirectError("invalid_request", "须使用 PKCE，code_challenge_method 为 S256")
+	}
+	scopes := requestedScopes(req.Scope)
+	if !slices.Contains(scopes, oidc.ScopeOpenID) {
+		return redirectError("invalid_scope", "scope 须包含 openid")
+	}
+	_, permissions := splitScopes(scopes)
+	if len(client.AllowedScopes) > 0 {
+		for _, name := range permissions {
+			if !slices.Contains(client.AllowedScopes, name) {
+				return redirectError("invalid_scope", "客户端无权申请权限: "+name)
+			}
+		}
+	}
+	if req.ValidateOnly {
+		return resp, nil
+	}
+
+	user, keys, err := s.checkPassword(ctx, &tenant, req.Username, req.Password)
+	if err != nil {
+		return nil, err
+	}
+	totp, err := findTOTP(model.DB, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if totp != nil && totp.Enabled() {
+		if req.MfaCode == "" {
+			return nil, status.Error(codes.Unauthenticated, "请输入动态验证码")
+		}
+		var ok bool
+		err = model.DB.Transaction(func(tx *gorm.DB) error {
+			ok, err = verifySecondFactor(tx, user.ID, req.MfaCode)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			if err := s.recordLoginFailures(keys); err != nil {
+				return nil, err
+			}
+			return nil, status.Error(codes.Unauthenticated, "验证码错误")
+		}
+	}
+	if err := resetLoginFailures(keys[0]); err != nil {
+		return nil, err
+	}
+	grants, err := effectiveRoles(model.DB, tenant.ID, user.ID, false)
+	if err != nil {
+		return nil, err
+	}
+	if (totp == nil || !totp.Enabled()) && s.mfaRequired(grants) {
+		return nil, status.Error(codes.FailedPrecondition, "需先启用 MFA，请通过登录接口完成 TOTP 绑定")
+	}
+	for _, name := range permissions {
+		ok, err := hasPermission(model.DB, tenant.ID, user.ID, name)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return redirectError("invalid_scope", "未拥有权限: "+name)
+		}
+	}
+
+	code, err := utils.RandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if err := model.DB.Create(&model.AuthorizationCode{
+		CodeHash:            model.HashOAuthSecret(code),
+		ClientID:            client.ClientID,
+		TenantID:            tenant.ID,
+		UserID:              user.ID,
+		RedirectURI:         req.RedirectUri,
+		Scope:               strings.Join(scopes, " "),
+		Nonce:               req.Nonce,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		AuthTime:            now,
+		ExpiresAt:           now.Add(authCodeTTL),
+	}).Error; err != nil {
+		return nil, err
+	}
+	resp.RedirectTo = authorizeRedirect(req.RedirectUri, map[string]string{"code": code, "state": req.State})
+	return resp, nil
+}
+
+// authorizationCodeToken 用授权码换取 token 和 ID Token；授权码只能使用一次，
+// 重复使用时撤销第一次换取的会话
+func (s *Service) authorizationCodeToken(req *api.IssueTokenRequest) (*api.IssueTokenResponse, error) {
+	if req.Code == "" {
+		return nil, oauthError(codes.InvalidArgument, "invalid_request", "缺少 code")
+	}
+	client, err := authenticateClient(req.ClientId, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	var ac model.AuthorizationCode
+	var reused bool
+	err = model.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("code_hash = ?", model.HashOAuthSecret(req.Code)).
+			Limit(1).Find(&ac).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		if ac.ID == 0 || !now.Before(ac.ExpiresAt) || ac.ClientID != client.ClientID {
+			return oauthError(codes.Unauthenticated, "invalid_grant", "授权码无效或已过期")
+		}
+		if ac.UsedAt != nil {
+			reused = true
+			return nil
+		}
+		if ac.RedirectURI != req.RedirectUri {
+			return oauthError(codes.InvalidArgument, "invalid_grant", "redirect_uri 与授权请求不一致")
+		}
+		if !oidc.VerifyPKCE(ac.CodeChallenge, ac.CodeChallengeMethod, req.CodeVerifier) {
+			return oauthError(codes.InvalidArgument, "invalid_grant", "code_verifier 校验失败")
+		}
+		return tx.Model(&ac).Update("used_at", now).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if reused {
+		if ac.SessionID != "" {
+			if err := model.DB.Model(&model.Session{}).
+				Where("id = ? AND revoked_at IS NULL", ac.SessionID).
+				Update("revoked_at", time.Now()).Error; err != nil {
+				return nil, err
+			}
+		}
+		return nil, oauthError(codes.Unauthenticated, "invalid_grant", "授权码已被使用")
+	}
+
+	var user model.User
+	if err := model.DB.First(&user, ac.UserID).Error; err != nil {
+		return nil, oauthError(codes.Unauthenticated, "invalid_grant", "用户不存在")
+	}
+	if user.Status != model.UserStatusActive {
+		return nil, oauthError(codes.PermissionDenied, "invalid_grant", "用户已停用")
+	}
+	var tenant model.Tenant
+	if err := model.DB.Select("id", "name").First(&tenant, ac.TenantID).Error; err != nil {
+		return nil, err
+	}
+	grants, err := effectiveRoles(model.DB, ac.TenantID, user.ID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// 标准 scope 不写入 token，申请了权限名时 token 只拥有这些权限
+	scopes := strings.Fields(ac.Scope)
+	standard, permissions := splitScopes(scopes)
+	session := model.Session{TenantID: ac.TenantID, UserID: user.ID, ClientID: client.ClientID, Scope: strings.Join(permissions, " ")}
+	t, err := s.startSession(&session, tenant.Name, user.Username, grants, slices.Contains(standard, oidc.ScopeOfflineAccess))
+	if err != nil {
+		return nil, err
+	}
+	if err := model.DB.Model(&ac).Update("session_id", session.ID).Error; err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	claims := &oidc.IDTokenClaims{
+		Nonce:    ac.Nonce,
+		AuthTime: ac.AuthTime.Unix(),
+		AtHash:   oidc.AccessTokenHash(t.AccessToken),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    strings.TrimRight(s.cfg.OIDCIssuer, "/"),
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			Audience:  jwt.ClaimStrings{client.ClientID},
+			ExpiresAt: jwt.NewNumericDate(t.ExpiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+	if slices.Contains(standard, oidc.ScopeProfile) {
+		roleNames, _ := roleNamesWithExpiry(grants)
+		claims.PreferredUsername = user.Username
+		claims.Tenant = tenant.Name
+		claims.Roles = roleNames
+	}
+	idToken, err := s.signer.Sign(claims)
+	if err != nil {
+		return nil, err
+	}
+	return &api.IssueTokenResponse{
+		AccessToken:  t.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(time.Until(t.ExpiresAt).Seconds()),
+		RefreshToken: t.RefreshToken,
+		Scope:        ac.Scope,
+		IdToken:      idToken,
+	}, nil
+}
+
+// GetUserInfo OIDC userinfo：当前 token 对应用户的基本信息
+func (s *Service) GetUserInfo(ctx context.Context, req *api.GetUserInfoRequest) (*api.GetUserInfoResponse, error) {
+	claims, err := callerClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+	user, err := currentUser(model.DB, ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &api.GetUserInfoResponse{
+		Sub:               strconv.FormatUint(uint64(user.ID), 10),
+		PreferredUsername: user.Username,
+		Tenant:            claims.Tenant,
+		Roles:             claims.Roles,
+	}, nil
+}
+
+// GetOpenIDConfiguration OIDC 发现文档
+func (s *Service) GetOpenIDConfiguration(ctx context.Context, req *api.GetOpenIDConfigurationRequest) (*httpbody.HttpBody, error) {
+	data, err := oidc.Discovery(s.cfg.OIDCIssuer)
+	if err != nil {
+		return nil, err
+	}
+	return &httpbody.HttpBody{ContentType: "application/json", Data: data}, nil
+}
+
+// GetJWKS ID Token 签名公钥
+func (s *Service) GetJWKS(ctx context.Context, req *api.GetJWKSRequest) (*httpbody.HttpBody, error) {
+	data, err := s.signer.JWKS()
+	if err != nil {
+		return nil, err
+	}
+	return &httpbody.HttpBody{ContentType: "application/json", Data: data}, nil
+}
@@ -0,0 +1,181 @@
+package rbac
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/oidc"
+)
+
+const (
+	testRedirectURI  = "https://app.example.com/cb"
+	testCodeVerifier = "dBjftJeZ4CVP-mJ92ZLk6oDbO1e4Lq3zAqu8fwWdZTe4wbn1h8o"
+)
+
+func authorizeRequest(client *api.CreateOAuthClientResponse, username string) *api.AuthorizeRequest {
+	return &api.AuthorizeRequest{
+		ResponseType:        "code",
+		ClientId:            client.ClientId,
+		RedirectUri:         testRedirectURI,
+		Scope:               "openid profile offline_access",
+		State:               "xyz",
+		Nonce:               "n-0S6",
+		CodeChallenge:       oidc.PKCEChallenge(testCodeVerifier),
+		CodeChallengeMethod: "S256",
+		Username:            username,
+		Password:            testPassword,
+	}
+}
+
+// authorizeCode 完成授权并从跳转地址中取出授权码
+func authorizeCode(t *testing.T, s *Service, req *api.AuthorizeRequest) string {
+	t.Helper()
+	resp, err := s.Authorize(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(resp.RedirectTo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code") == "" || q.Get("state") != req.State {
+		t.Fatalf("跳转地址 = %s，期望包含授权码和 state", resp.RedirectTo)
+	}
+	return q.Get("code")
+}
+
+func exchangeCode(s *Service, client *api.CreateOAuthClientResponse, code, redirectURI, verifier string) (*api.IssueTokenResponse, error) {
+	return s.IssueToken(context.Background(), &api.IssueTokenRequest{
+		GrantType: grantAuthorizationCode, ClientId: client.ClientId, ClientSecret: client.ClientSecret,
+		Code: code, RedirectUri: redirectURI, CodeVerifier: verifier,
+	})
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	tt.createUser(t, "alice")
+	client := tt.createClient(t, s, testRedirectURI)
+
+	code := authorizeCode(t, s, authorizeRequest(client, "alice"))
+	resp, err := exchangeCode(s, client, code, testRedirectURI, testCodeVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.AccessToken == "" || resp.IdToken == "" || resp.RefreshToken == "" {
+		t.Fatalf("换取结果 = %+v", resp)
+	}
+	// 授权码会话的 refresh token 只能由原客户端使用
+	_, err = refresh(s, resp.RefreshToken, nil)
+	wantCode(t, err, codes.Unauthenticated)
+	if _, err := refresh(s, resp.RefreshToken, client); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthorizationCodePKCE(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	tt.createUser(t, "alice")
+	client := tt.createClient(t, s, testRedirectURI)
+
+	// 不使用 PKCE 的授权请求通过回调地址返回错误
+	req := authorizeRequest(client, "alice")
+	req.CodeChallengeMethod = "plain"
+	resp, err := s.Authorize(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u, _ := url.Parse(resp.RedirectTo); u.Query().Get("error") != "invalid_request" {
+		t.Errorf("跳转地址 = %s，期望 error=invalid_request", resp.RedirectTo)
+	}
+
+	code := authorizeCode(t, s, authorizeRequest(client, "alice"))
+	for _, verifier := range []string{"", "wrong-verifier-wrong-verifier-wrong-verifier-1234"} {
+		_, err := exchangeCode(s, client, code, testRedirectURI, verifier)
+		wantCode(t, err, codes.InvalidArgument)
+	}
+	// 校验失败不消耗授权码
+	if _, err := exchangeCode(s, client, code, testRedirectURI, testCodeVerifier); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthorizationCodeRedirectURI(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	tt.createUser(t, "alice")
+	client := tt.createClient(t, s, testRedirectURI)
+
+	// 未登记的回调地址直接返回错误，不跳转
+	req := authorizeRequest(client, "alice")
+	req.RedirectUri = "https://evil.example.com/cb"
+	_, err := s.Authorize(context.Background(), req)
+	wantCode(t, err, codes.InvalidArgument)
+
+	code := authorizeCode(t, s, authorizeRequest(client, "alice"))
+	_, err = exchangeCode(s, client, code, "https://app.example.com/other", testCodeVerifier)
+	wantCode(t, err, codes.InvalidArgument)
+
+	// 其他客户端不能使用该授权码
+	other := tt.createClient(t, s, testRedirectURI)
+	_, err = exchangeCode(s, other, code, testRedirectURI, testCodeVerifier)
+	wantCode(t, err, codes.Unauthenticated)
+}
+
+// 授权码重复使用时撤销第一次换取的会话
+func TestAuthorizationCodeReuse(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	tt.createUser(t, "alice")
+	client := tt.createClient(t, s, testRedirectURI)
+
+	code := authorizeCode(t, s, authorizeRequest(client, "alice"))
+	first, err := exchangeCode(s, client, code, testRedirectURI, testCodeVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = exchangeCode(s, client, code, testRedirectURI, testCodeVerifier)
+	wantCode(t, err, codes.Unauthenticated)
+	if sessionActive(t, first.AccessToken) {
+		t.Error("授权码被重复使用后第一次换取的会话未撤销")
+	}
+	_, err = refresh(s, first.RefreshToken, client)
+	wantCode(t, err, codes.Unauthenticated)
+}
+
+// 授权码流程的登录同样要求第二因素，并执行 MFA_REQUIRED_ROLES 策略
+func TestAuthorizeEnforcesMFA(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	s.cfg.LoginBackoff = 0
+	s.cfg.MFARequiredRoles = "admin"
+	tt := newTestTenant(t)
+	alice := tt.createUser(t, "alice")
+	client := tt.createClient(t, s, testRedirectURI)
+
+	// 要求 MFA 但尚未绑定
+	_, err := s.Authorize(context.Background(), authorizeRequest(client, "admin"))
+	wantCode(t, err, codes.FailedPrecondition)
+
+	secret, confirm := enrollTOTP(t, s, tt.ctx("alice"))
+	req := authorizeRequest(client, "alice")
+	_, err = s.Authorize(context.Background(), req)
+	wantCode(t, err, codes.Unauthenticated)
+	req.MfaCode = "123"
+	_, err = s.Authorize(context.Background(), req)
+	wantCode(t, err, codes.Unauthenticated)
+
+	req.MfaCode = stepCode(t, alice.ID, secret, 1)
+	authorizeCode(t, s, req)
+	// 验证码不能重放
+	_, err = s.Authorize(context.Background(), req)
+	wantCode(t, err, codes.Unauthenticated)
+
+	req.MfaCode = confirm.RecoveryCodes[0]
+	authorizeCode(t, s, req)
+}
@@ -0,0 +1,24 @@
+package rbac
+
+import "gorm.io/gorm"
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// paginate 按页码（从 1 开始）和每页条数分页
+func paginate(page, pageSize uint32) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if pageSize == 0 {
+			pageSize = defaultPageSize
+		}
+		if pageSize > maxPageSize {
+			pageSize = maxPageSize
+		}
+		if page == 0 {
+			page = 1
+		}
+		return db.Offset(int((page - 1) * pageSize)).Limit(int(pageSize))
+	}
+}
@@ -0,0 +1,329 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/audit"
+	"grpc-rbac-backend/internal/model"
+	"grpc-rbac-backend/internal/notify"
+	"grpc-rbac-backend/internal/password"
+	"grpc-rbac-backend/internal/utils"
+)
+
+// passwordError 返回 InvalidArgument，错误详情（BadRequest）中按字段列出不满足的规则
+func passwordError(field string, problems []string) error {
+	br := &errdetails.BadRequest{}
+	for _, p := range problems {
+		br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{Field: field, Description: p})
+	}
+	st := status.New(codes.InvalidArgument, "密码不符合要求："+strings.Join(problems, "；"))
+	if detailed, err := st.WithDetails(br); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// setPassword 按密码策略校验新密码，已存在的用户还要检查是否与最近用过的密码重复；
+// 通过后写入哈希，调用方保存用户后需调用 recordPasswordHistory
+func (s *Service) setPassword(tx *gorm.DB, user *model.User, field, plain string) error {
+	if user.IsService() {
+		return status.Error(codes.InvalidArgument, "服务账号不能设置密码，请使用 API Key")
+	}
+	if problems := s.passwords.Validate(user.Username, plain); len(problems) > 0 {
+		return passwordError(field, problems)
+	}
+	if user.ID != 0 {
+		reused, err := s.passwordReused(tx, user, plain)
+		if err != nil {
+			return err
+		}
+		if reused {
+			return passwordError(field, []string{fmt.Sprintf("不能与最近 %d 次使用的密码相同", s.cfg.PasswordHistory)})
+		}
+	}
+	hash, err := password.Hash(plain)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	user.Password = hash
+	user.PasswordChangedAt = &now
+	return nil
+}
+
+// passwordReused 新密码是否与当前密码或最近 PasswordHistory 次用过的密码相同
+func (s *Service) passwordReused(tx *gorm.DB, user *model.User, plain string) (bool, error) {
+	if s.cfg.PasswordHistory <= 0 {
+		return false, nil
+	}
+	if password.Verify(user.Password, plain) {
+		return true, nil
+	}
+	var history []model.PasswordHistory
+	if err := tx.Where("user_id = ?", user.ID).Order("id DESC").Limit(s.cfg.PasswordHistory).Find(&history).Error; err != nil {
+		return false, err
+	}
+	for _, h := range history {
+		if password.Verify(h.Hash, plain) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// recordPasswordHistory 记录用户当前的密码哈希，只保留最近 PasswordHistory 条
+func (s *Service) recordPasswordHistory(tx *gorm.DB, user *model.User) error {
+	if s.cfg.PasswordHistory <= 0 {
+		return nil
+	}
+	if err := tx.Create(&model.PasswordHistory{UserID: user.ID, Hash: user.Password}).Error; err != nil {
+		return err
+	}
+	var ids []uint
+	if err := tx.Model(&model.PasswordHistory{}).Where("user_id = ?", user.ID).Order("id DESC").Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	if len(ids) <= s.cfg.PasswordHistory {
+		return nil
+	}
+	return tx.Delete(&model.PasswordHistory{}, ids[s.cfg.PasswordHistory:]).Error
+}
+
+// passwordExpired 密码是否超过最长有效期；未记录修改时间的存量用户视为未过期
+func (s *Service) passwordExpired(user *model.User) bool {
+	return s.cfg.PasswordMaxAge > 0 && user.PasswordChangedAt != nil &&
+		time.Since(*user.PasswordChangedAt) > s.cfg.PasswordMaxAge
+}
+
+// upgradePasswordHash 存量明文密码在登录成功后改存哈希，并从此时开始计算有效期
+func upgradePasswordHash(user *model.User, plain string) error {
+	hash, err := password.Hash(plain)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	user.Password = hash
+	user.PasswordChangedAt = &now
+	return model.DB.Model(user).Select("password", "password_changed_at").Updates(user).Error
+}
+
+// ChangePassword 用户校验当前密码后修改密码，并撤销除当前会话外的其他会话
+func (s *Service) ChangePassword(ctx context.Context, req *api.ChangePasswordRequest) (*api.ChangePasswordResponse, error) {
+	claims, err := callerClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys := loginThrottleKeys(ctx, claims.TenantID, claims.Username)
+	if err := checkLoginThrottle(keys...); err != nil {
+		return nil, err
+	}
+
+	var wrongPassword bool
+	err = withAudit(ctx, "ChangePassword", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		user, err := currentUser(tx, ctx)
+		if err != nil {
+			return err
+		}
+		ev.Target = audit.Target("user", user.ID)
+		if !password.Verify(user.Password, req.CurrentPassword) {
+			wrongPassword = true
+			return status.Error(codes.InvalidArgument, "当前密码错误")
+		}
+		if err := s.setPassword(tx, user, "newPassword", req.NewPassword); err != nil {
+			return err
+		}
+		if err := tx.Select("password", "password_changed_at").Updates(user).Error; err != nil {
+			return err
+		}
+		if err := s.recordPasswordHistory(tx, user); err != nil {
+			return err
+		}
+		return model.RevokeUserSessions(tx, user.ID, claims.ID)
+	})
+	if wrongPassword {
+		if err := s.recordLoginFailures(keys); err != nil {
+			return nil, err
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &api.ChangePasswordResponse{Message: "密码修改成功，其他设备上的登录已失效"}, nil
+}
+
+// RequestPasswordReset 用户自助找回密码：生成重置 token 并通过通知发送。
+// 用户不存在时同样返回成功，避免被用来枚举用户名
+func (s *Service) RequestPasswordReset(ctx context.Context, req *api.RequestPasswordResetRequest) (*api.RequestPasswordResetResponse, error) {
+	resp := &api.RequestPasswordResetResponse{Message: "如果该用户存在，重置说明已发送"}
+	tenant, err := resolveTenant(model.DB, req.Tenant)
+	if err != nil {
+		return nil, err
+	}
+	var user model.User
+	err = model.DB.Scopes(model.TenantScope(tenant.ID)).Where("username = ?", req.Username).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) || user.IsService() {
+		return resp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 同一用户一分钟内只发送一次
+	var recent int64
+	if err := model.DB.Model(&model.PasswordResetToken{}).
+		Where("user_id = ? AND used_at IS NULL AND created_at > ?", user.ID, time.Now().Add(-time.Minute)).
+		Count(&recent).Error; err != nil {
+		return nil, err
+	}
+	if recent > 0 {
+		return resp, nil
+	}
+	if err := s.sendPasswordReset(ctx, tenant, &user); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// InitiatePasswordReset 管理员为用户发起密码重置：撤销该用户的所有会话，并向其发送重置 token
+func (s *Service) InitiatePasswordReset(ctx context.Context, req *api.InitiatePasswordResetRequest) (*api.InitiatePasswordResetResponse, error) {
+	if err := requireTenantAdmin(ctx); err != nil {
+		return nil, err
+	}
+	claims, err := callerClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var user model.User
+	err = withAudit(ctx, "InitiatePasswordReset", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		ev.Target = audit.Target("user", uint(req.UserId))
+		if err := tx.Scopes(model.TenantScope(claims.TenantID)).First(&user, req.UserId).Error; err != nil {
+			return err
+		}
+		if user.IsService() {
+			return status.Error(codes.InvalidArgument, "服务账号没有密码，请使用 API Key")
+		}
+		return model.RevokeUserSessions(tx, user.ID, "")
+	})
+	if err != nil {
+		return nil, err
+	}
+	tenant := model.Tenant{ID: claims.TenantID, Name: claims.Tenant}
+	if err := s.sendPasswordReset(ctx, &tenant, &user); err != nil {
+		return nil, err
+	}
+	return &api.InitiatePasswordResetResponse{Message: "已向用户发送重置说明"}, nil
+}
+
+// sendPasswordReset 作废用户未使用的重置 token，生成新的 token 并发送通知；库中只保存哈希
+func (s *Service) sendPasswordReset(ctx context.Context, tenant *model.Tenant, user *model.User) error {
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(s.cfg.PasswordResetTTL)
+	err = model.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL", user.ID).
+			Update("expires_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Create(&model.PasswordResetToken{
+			UserID:    user.ID,
+			TokenHash: model.HashResetToken(token),
+			ExpiresAt: expiresAt,
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	body := "重置 token: " + token
+	if s.cfg.PasswordResetURL != "" {
+		body = "重置链接: " + s.cfg.PasswordResetURL + "?token=" + token
+	}
+	body += fmt.Sprintf("\n有效期至 %s，仅可使用一次。如非本人操作请忽略。", expiresAt.Format(time.DateTime))
+	return notify.Send(ctx, &notify.Message{
+		TenantID: tenant.ID,
+		Tenant:   tenant.Name,
+		Username: user.Username,
+		Subject:  "重置密码",
+		Body:     body,
+	})
+}
+
+// ConfirmPasswordReset 使用重置 token 设置新密码；token 只能使用一次，成功后撤销该用户的所有会话
+func (s *Service) ConfirmPasswordReset(ctx context.Context, req *api.ConfirmPasswordResetRequest) (*api.ConfirmPasswordResetResponse, error) {
+	var user model.User
+	err := withAudit(ctx, "ConfirmPasswordReset", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		var rt model.PasswordResetToken
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", model.HashResetToken(req.Token)).
+			Limit(1).Find(&rt).Error; err != nil {
+			return err
+		}
+		if rt.ID == 0 || rt.UsedAt != nil || !time.Now().Before(rt.ExpiresAt) {
+			return status.Error(codes.InvalidArgument, "重置 token 无效或已过期")
+		}
+		if err := tx.First(&user, rt.UserID).Error; err != nil {
+			return err
+		}
+		ev.Target = audit.Target("user", user.ID)
+		ev.TenantID = user.TenantID
+
+		if err := s.setPassword(tx, &user, "newPassword", req.NewPassword); err != nil {
+			return err
+		}
+		if err := tx.Select("password", "password_changed_at").Updates(&user).Error; err != nil {
+			return err
+		}
+		if err := s.recordPasswordHistory(tx, &user); err != nil {
+			return err
+		}
+		now := time.Now()
+		if err := tx.Model(&rt).Update("used_at", &now).Error; err != nil {
+			return err
+		}
+		return model.RevokeUserSessions(tx, user.ID, "")
+	})
+	if err != nil {
+		return nil, err
+	}
+	// 重置后解除因密码错误导致的锁定
+	if err := resetLoginFailures(model.UserThrottleKey(user.TenantID, user.Username)); err != nil {
+		return nil, err
+	}
+	return &api.ConfirmPasswordResetResponse{Message: "密码已重置，请重新登录"}, nil
+}
+
+// sweepSessions 清理已过期的会话、refresh token、授权码、联合登录状态、重置 token 和邮箱验证 token
+func sweepSessions(ctx context.Context) error {
+	now := time.Now()
+	if err := model.DB.WithContext(ctx).Where("expires_at < ?", now).Delete(&model.EmailVerificationToken{}).Error; err != nil {
+		return err
+	}
+	if err := model.DB.WithContext(ctx).Where("expires_at < ?", now).Delete(&model.FederatedLoginState{}).Error; err != nil {
+		return err
+	}
+	if err := model.DB.WithContext(ctx).Where("expires_at < ?", now).Delete(&model.AuthorizationCode{}).Error; err != nil {
+		return err
+	}
+	if err := model.DB.WithContext(ctx).Where("expires_at < ?", now).Delete(&model.RefreshToken{}).Error; err != nil {
+		return err
+	}
+	if err := model.DB.WithContext(ctx).Where("expires_at < ?", now).Delete(&model.Session{}).Error; err != nil {
+		return err
+	}
+	return model.DB.WithContext(ctx).Where("expires_at < ?", now).Delete(&model.PasswordResetToken{}).Error
+}
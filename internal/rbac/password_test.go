@@ -0,0 +1,67 @@
+package rbac
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/model"
+	"grpc-rbac-backend/internal/password"
+)
+
+// 新租户的管理员记录密码修改时间和第一条密码历史，PASSWORD_MAX_AGE 对其生效
+func TestCreateTenantRecordsAdminPassword(t *testing.T) {
+	tt := newTestTenant(t)
+	if tt.Admin.PasswordChangedAt == nil {
+		t.Fatal("管理员未记录密码修改时间")
+	}
+	var history int64
+	model.DB.Model(&model.PasswordHistory{}).Where("user_id = ?", tt.Admin.ID).Count(&history)
+	if history != 1 {
+		t.Errorf("密码历史 %d 条，期望 1", history)
+	}
+
+	s := newTestService(t, nil, nil)
+	s.cfg.PasswordMaxAge = 1
+	if !s.passwordExpired(tt.Admin) {
+		t.Error("超过有效期的管理员密码未过期")
+	}
+}
+
+// 普通用户不能通过 UpdateUser 绕过当前密码校验设置密码
+func TestUpdateUserPasswordRequiresTenantAdmin(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	alice := tt.createUser(t, "alice")
+	const newPassword = "N3w-Passw0rd!"
+
+	for _, target := range []*model.User{tt.Admin, alice} {
+		_, err := s.UpdateUser(tt.ctx("alice", "user"), &api.UpdateUserRequest{
+			UserId: uint32(target.ID), Username: target.Username, Password: newPassword,
+		})
+		wantCode(t, err, codes.PermissionDenied)
+	}
+	var admin model.User
+	if err := model.DB.First(&admin, tt.Admin.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !password.Verify(admin.Password, testPassword) {
+		t.Error("管理员的密码被修改")
+	}
+
+	if _, err := s.UpdateUser(tt.adminCtx(), &api.UpdateUserRequest{
+		UserId: uint32(alice.ID), Username: "alice", Password: newPassword,
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateUserRejectsOverlongPassword(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	long := testPassword + strings.Repeat("a", 72)
+	_, err := s.CreateUser(tt.adminCtx(), &api.CreateUserRequest{Username: "frank", Password: long})
+	wantCode(t, err, codes.InvalidArgument)
+}
@@ -0,0 +1,455 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/audit"
+	"grpc-rbac-backend/internal/model"
+	"grpc-rbac-backend/internal/notify"
+	"grpc-rbac-backend/internal/scim"
+	"grpc-rbac-backend/internal/utils"
+)
+
+// attributeNamePattern 属性名会拼入过滤条件的 SQL，只允许小写字母、数字和下划线
+var attributeNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// toUserInfo 用户的对外信息，locked 为正处于锁定期的用户（见 lockedUsers）
+func toUserInfo(user *model.User, locked map[string]bool) *api.UserInfo {
+	info := &api.UserInfo{
+		Username:      user.Username,
+		Kind:          user.Kind,
+		Id:            uint32(user.ID),
+		Status:        displayStatus(user, locked),
+		Email:         user.EmailAddress(),
+		EmailVerified: user.EmailVerifiedAt != nil,
+		DisplayName:   user.DisplayName,
+		CreatedAt:     user.CreatedAt.Unix(),
+		UpdatedAt:     user.UpdatedAt.Unix(),
+		LastLoginAt:   ptrUnix(user.LastLoginAt),
+		Attributes:    attributeStrings(user.Attributes),
+	}
+	for _, r := range user.Roles {
+		info.Roles = append(info.Roles, r.Name)
+	}
+	return info
+}
+
+func attributeStrings(attrs []model.UserAttribute) map[string]string {
+	if len(attrs) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(attrs))
+	for _, a := range attrs {
+		out[a.Name] = a.Value
+	}
+	return out
+}
+
+// attributeValues 按类型转换的自定义属性，供条件表达式通过 subject.attributes.xxx 访问
+func attributeValues(attrs []model.UserAttribute) map[string]any {
+	out := make(map[string]any, len(attrs))
+	for _, a := range attrs {
+		switch a.Type {
+		case model.AttributeTypeInt:
+			n, _ := strconv.ParseInt(a.Value, 10, 64)
+			out[a.Name] = n
+		case model.AttributeTypeBool:
+			out[a.Name] = a.Value == "true"
+		default:
+			out[a.Name] = a.Value
+		}
+	}
+	return out
+}
+
+// canonicalAttributeValue 校验属性值并转为规范文本
+func canonicalAttributeValue(typ, v string) (string, error) {
+	switch typ {
+	case model.AttributeTypeInt:
+		n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil {
+			return "", errors.New("须为整数")
+		}
+		return strconv.FormatInt(n, 10), nil
+	case model.AttributeTypeBool:
+		b, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			return "", errors.New("须为 true 或 false")
+		}
+		return strconv.FormatBool(b), nil
+	}
+	if len(v) > 1024 {
+		return "", errors.New("不能超过 1024 字节")
+	}
+	return v, nil
+}
+
+// setUserEmail 设置邮箱，须为合法地址且在租户内唯一（包括保留期内已删除的用户）；
+// 修改后需重新验证，空字符串表示清除
+func setUserEmail(tx *gorm.DB, user *model.User, email string) error {
+	email = strings.TrimSpace(email)
+	if email == user.EmailAddress() {
+		return nil
+	}
+	user.EmailVerifiedAt = nil
+	if email == "" {
+		user.Email = nil
+		return nil
+	}
+	if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email || len(email) > 191 {
+		return status.Error(codes.InvalidArgument, "邮箱格式错误")
+	}
+	var count int64
+	if err := tx.Unscoped().Model(&model.User{}).Scopes(model.TenantScope(user.TenantID)).
+		Where("email = ? AND id <> ?", email, user.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return status.Error(codes.AlreadyExists, "邮箱已被其他用户使用")
+	}
+	user.Email = &email
+	return nil
+}
+
+// touchLastLogin 记录最近登录时间，不更新 updated_at
+func touchLastLogin(user *model.User) error {
+	now := time.Now()
+	user.LastLoginAt = &now
+	return model.DB.Model(user).UpdateColumn("last_login_at", now).Error
+}
+
+// userFilterColumns ListUsers 可过滤的属性，自定义属性为 attributes.<name>
+func userFilterColumns(tx *gorm.DB, tenantID uint) (map[string]scim.Column, error) {
+	columns := map[string]scim.Column{
+		"id":            {Expr: "users.id"},
+		"username":      {Expr: "users.username"},
+		"email":         {Expr: "users.email"},
+		"emailverified": {Expr: "users.email_verified_at IS NOT NULL", Bool: true},
+		"displayname":   {Expr: "users.display_name"},
+		"kind":          {Expr: "users.kind"},
+		"status":        {Expr: "users.status"},
+		"createdat":     {Expr: "users.created_at"},
+		"updatedat":     {Expr: "users.updated_at"},
+		"lastloginat":   {Expr: "users.last_login_at"},
+	}
+	var defs []model.UserAttributeDefinition
+	if err := tx.Scopes(model.TenantScope(tenantID)).Find(&defs).Error; err != nil {
+		return nil, err
+	}
+	for _, d := range defs {
+		const value = "(SELECT user_attributes.value FROM user_attributes WHERE user_attributes.user_id = users.id AND user_attributes.name = ?)"
+		args := []any{d.Name}
+		switch d.Type {
+		case model.AttributeTypeInt:
+			columns["attributes."+d.Name] = scim.Column{Expr: "CAST(" + value + " AS SIGNED)", Args: args}
+		case model.AttributeTypeBool:
+			columns["attributes."+d.Name] = scim.Column{Expr: value + " = 'true'", Args: args, Bool: true}
+		default:
+			columns["attributes."+d.Name] = scim.Column{Expr: value, Args: args}
+		}
+	}
+	return columns, nil
+}
+
+// filterUsers 按过滤表达式追加查询条件
+func filterUsers(db *gorm.DB, tenantID uint, filter string) (*gorm.DB, error) {
+	if strings.TrimSpace(filter) == "" {
+		return db, nil
+	}
+	f, err := scim.ParseFilter(filter)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "filter 无效: "+err.Error())
+	}
+	columns, err := userFilterColumns(model.DB, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	cond, args, err := scim.ToSQL(f, columns)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "filter 无效: "+err.Error())
+	}
+	return db.Where(cond, args...), nil
+}
+
+// ========== 邮箱验证 ==========
+
+// RequestEmailVerification 向调用方本人的邮箱发送验证 token；库中只保存哈希
+func (s *Service) RequestEmailVerification(ctx context.Context, req *api.RequestEmailVerificationRequest) (*api.RequestEmailVerificationResponse, error) {
+	claims, err := callerClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+	user, err := currentUser(model.DB, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if user.Email == nil {
+		return nil, status.Error(codes.FailedPrecondition, "尚未设置邮箱")
+	}
+	if user.EmailVerifiedAt != nil {
+		return &api.RequestEmailVerificationResponse{Message: "邮箱已验证"}, nil
+	}
+	resp := &api.RequestEmailVerificationResponse{Message: "验证邮件已发送至 " + *user.Email}
+
+	// 同一用户一分钟内只发送一次
+	var recent int64
+	if err := model.DB.Model(&model.EmailVerificationToken{}).
+		Where("user_id = ? AND used_at IS NULL AND created_at > ?", user.ID, time.Now().Add(-time.Minute)).
+		Count(&recent).Error; err != nil {
+		return nil, err
+	}
+	if recent > 0 {
+		return resp, nil
+	}
+
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := time.Now().Add(s.cfg.EmailVerificationTTL)
+	err = model.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.EmailVerificationToken{}).
+			Where("user_id = ? AND used_at IS NULL", user.ID).
+			Update("expires_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Create(&model.EmailVerificationToken{
+			UserID:    user.ID,
+			Email:     *user.Email,
+			TokenHash: model.HashResetToken(token),
+			ExpiresAt: expiresAt,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	body := "验证 token: " + token
+	if s.cfg.EmailVerificationURL != "" {
+		body = "验证链接: " + s.cfg.EmailVerificationURL + "?token=" + token
+	}
+	body += fmt.Sprintf("\n有效期至 %s，仅可使用一次。如非本人操作请忽略。", expiresAt.Format(time.DateTime))
+	err = notify.Send(ctx, &notify.Message{
+		TenantID: claims.TenantID,
+		Tenant:   claims.Tenant,
+		Username: user.Username,
+		To:       *user.Email,
+		Subject:  "验证邮箱",
+		Body:     body,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ConfirmEmailVerification 使用验证 token 确认邮箱；发送后邮箱被修改的 token 不再有效
+func (s *Service) ConfirmEmailVerification(ctx context.Context, req *api.ConfirmEmailVerificationRequest) (*api.ConfirmEmailVerificationResponse, error) {
+	err := withAudit(ctx, "ConfirmEmailVerification", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		var vt model.EmailVerificationToken
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", model.HashResetToken(req.Token)).
+			Limit(1).Find(&vt).Error; err != nil {
+			return err
+		}
+		if vt.ID == 0 || vt.UsedAt != nil || !time.Now().Before(vt.ExpiresAt) {
+			return status.Error(codes.InvalidArgument, "验证 token 无效或已过期")
+		}
+		var user model.User
+		if err := tx.Limit(1).Find(&user, vt.UserID).Error; err != nil {
+			return err
+		}
+		if user.ID == 0 || user.EmailAddress() != vt.Email {
+			return status.Error(codes.InvalidArgument, "验证 token 无效或已过期")
+		}
+		ev.Target = audit.Target("user", user.ID)
+		ev.TenantID = user.TenantID
+
+		now := time.Now()
+		if err := tx.Model(&vt).Update("used_at", &now).Error; err != nil {
+			return err
+		}
+		ev.After = audit.Snapshot(map[string]string{"email": vt.Email})
+		return tx.Model(&user).Update("email_verified_at", &now).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.ConfirmEmailVerificationResponse{Message: "邮箱验证成功"}, nil
+}
+
+// ========== 自定义属性 ==========
+
+func toAttributeDefinition(d *model.UserAttributeDefinition) *api.UserAttributeDefinition {
+	return &api.UserAttributeDefinition{
+		Name:        d.Name,
+		Type:        d.Type,
+		Description: d.Description,
+		CreatedAt:   d.CreatedAt.Unix(),
+	}
+}
+
+// CreateUserAttributeDefinition 租户管理员定义用户自定义属性
+func (s *Service) CreateUserAttributeDefinition(ctx context.Context, req *api.CreateUserAttributeDefinitionRequest) (*api.UserAttributeDefinition, error) {
+	if err := requireTenantAdmin(ctx); err != nil {
+		return nil, err
+	}
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !attributeNamePattern.MatchString(req.Name) {
+		return nil, status.Error(codes.InvalidArgument, "属性名须以小写字母开头，只含小写字母、数字和下划线，最长 64 个字符")
+	}
+	switch req.Type {
+	case model.AttributeTypeString, model.AttributeTypeInt, model.AttributeTypeBool:
+	default:
+		return nil, status.Error(codes.InvalidArgument, "属性类型须为 string、int 或 bool")
+	}
+
+	def := model.UserAttributeDefinition{TenantID: tenantID, Name: req.Name, Type: req.Type, Description: req.Description}
+	err = withAudit(ctx, "CreateUserAttributeDefinition", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		var count int64
+		if err := tx.Model(&model.UserAttributeDefinition{}).Scopes(model.TenantScope(tenantID)).
+			Where("name = ?", req.Name).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return status.Error(codes.AlreadyExists, "属性已存在: "+req.Name)
+		}
+		if err := tx.Create(&def).Error; err != nil {
+			return err
+		}
+		ev.Target = audit.Target("user_attribute", def.ID)
+		ev.After = audit.Snapshot(def)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return toAttributeDefinition(&def), nil
+}
+
+func (s *Service) ListUserAttributeDefinitions(ctx context.Context, req *api.ListUserAttributeDefinitionsRequest) (*api.ListUserAttributeDefinitionsResponse, error) {
+	db, _, err := scoped(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var defs []model.UserAttributeDefinition
+	if err := db.Order("name").Find(&defs).Error; err != nil {
+		return nil, err
+	}
+	resp := &api.ListUserAttributeDefinitionsResponse{}
+	for i := range defs {
+		resp.Definitions = append(resp.Definitions, toAttributeDefinition(&defs[i]))
+	}
+	return resp, nil
+}
+
+// DeleteUserAttributeDefinition 删除属性定义及所有用户的该属性值
+func (s *Service) DeleteUserAttributeDefinition(ctx context.Context, req *api.DeleteUserAttributeDefinitionRequest) (*api.DeleteUserAttributeDefinitionResponse, error) {
+	if err := requireTenantAdmin(ctx); err != nil {
+		return nil, err
+	}
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = withAudit(ctx, "DeleteUserAttributeDefinition", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		var def model.UserAttributeDefinition
+		if err := tx.Scopes(model.TenantScope(tenantID)).Where("name = ?", req.Name).First(&def).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return status.Error(codes.NotFound, "属性不存在: "+req.Name)
+			}
+			return err
+		}
+		ev.Target = audit.Target("user_attribute", def.ID)
+		ev.Before = audit.Snapshot(def)
+		if err := tx.Where("name = ? AND user_id IN (?)", def.Name,
+			tx.Unscoped().Model(&model.User{}).Select("id").Where("tenant_id = ?", tenantID)).
+			Delete(&model.UserAttribute{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&def).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.DeleteUserAttributeDefinitionResponse{Message: "属性已删除"}, nil
+}
+
+// SetUserAttributes 租户管理员替换用户的全部自定义属性；属性可用于授予条件，用户不能自行修改
+func (s *Service) SetUserAttributes(ctx context.Context, req *api.SetUserAttributesRequest) (*api.SetUserAttributesResponse, error) {
+	if err := requireTenantAdmin(ctx); err != nil {
+		return nil, err
+	}
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var attrs []model.UserAttribute
+	err = withAudit(ctx, "SetUserAttributes", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		ev.Target = audit.Target("user", uint(req.UserId))
+
+		var user model.User
+		if err := tx.Scopes(model.TenantScope(tenantID)).Preload("Attributes").First(&user, req.UserId).Error; err != nil {
+			return err
+		}
+		ev.Before = audit.Snapshot(attributeStrings(user.Attributes))
+
+		var defs []model.UserAttributeDefinition
+		if err := tx.Scopes(model.TenantScope(tenantID)).Find(&defs).Error; err != nil {
+			return err
+		}
+		types := make(map[string]string, len(defs))
+		for _, d := range defs {
+			types[d.Name] = d.Type
+		}
+		names := make([]string, 0, len(req.Attributes))
+		for name := range req.Attributes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			typ, ok := types[name]
+			if !ok {
+				return status.Error(codes.InvalidArgument, "属性未定义: "+name)
+			}
+			value, err := canonicalAttributeValue(typ, req.Attributes[name])
+			if err != nil {
+				return status.Errorf(codes.InvalidArgument, "属性 %s %s", name, err.Error())
+			}
+			attrs = append(attrs, model.UserAttribute{UserID: user.ID, Name: name, Type: typ, Value: value})
+		}
+
+		if err := tx.Where("user_id = ?", user.ID).Delete(&model.UserAttribute{}).Error; err != nil {
+			return err
+		}
+		if len(attrs) > 0 {
+			if err := tx.Create(&attrs).Error; err != nil {
+				return err
+			}
+		}
+		ev.After = audit.Snapshot(attributeStrings(attrs))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.SetUserAttributesResponse{Attributes: attributeStrings(attrs)}, nil
+}
@@ -0,0 +1,163 @@
+package rbac
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/model"
+)
+
+// defineAttributes 以管理员身份创建属性定义，name 到类型
+func (tt *testTenant) defineAttributes(t *testing.T, s *Service, defs map[string]string) {
+	t.Helper()
+	for name, typ := range defs {
+		if _, err := s.CreateUserAttributeDefinition(tt.adminCtx(), &api.CreateUserAttributeDefinitionRequest{Name: name, Type: typ}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func (tt *testTenant) setAttributes(t *testing.T, s *Service, user *model.User, attrs map[string]string) {
+	t.Helper()
+	if _, err := s.SetUserAttributes(tt.adminCtx(), &api.SetUserAttributesRequest{UserId: uint32(user.ID), Attributes: attrs}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// listUsernames 按过滤条件列出用户名
+func listUsernames(t *testing.T, s *Service, tt *testTenant, filter string) []string {
+	t.Helper()
+	resp, err := s.ListUsers(tt.adminCtx(), &api.ListUsersRequest{Filter: filter})
+	if err != nil {
+		t.Fatalf("%s: %v", filter, err)
+	}
+	var names []string
+	for _, u := range resp.Users {
+		names = append(names, u.Username)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestListUsersFilterByAttributes(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	tt.defineAttributes(t, s, map[string]string{
+		"level":      model.AttributeTypeInt,
+		"vip":        model.AttributeTypeBool,
+		"department": model.AttributeTypeString,
+	})
+	alice, bob, carol := tt.createUser(t, "alice"), tt.createUser(t, "bob"), tt.createUser(t, "carol")
+	tt.setAttributes(t, s, alice, map[string]string{"level": "10", "vip": "true", "department": "finance"})
+	tt.setAttributes(t, s, bob, map[string]string{"level": "3", "vip": "false", "department": "it's"})
+	tt.setAttributes(t, s, carol, map[string]string{"level": "2"})
+
+	cases := []struct {
+		filter string
+		want   string
+	}{
+		// 按整数比较，而不是按文本比较（"10" < "3"）
+		{`attributes.level ge 3`, "alice,bob"},
+		{`attributes.level lt 3`, "carol"},
+		{`attributes.vip eq true`, "alice"},
+		{`attributes.vip eq false`, "bob"},
+		{`attributes.department pr`, "alice,bob"},
+		{`attributes.department eq "it's"`, "bob"},
+		{`attributes.department sw "fin" and attributes.level gt 5`, "alice"},
+		{`attributes.level le 3 and not (attributes.department pr)`, "carol"},
+	}
+	for _, c := range cases {
+		got := listUsernames(t, s, tt, c.filter)
+		if joined := strings.Join(got, ","); joined != c.want {
+			t.Errorf("%s: 结果 %s，期望 %s", c.filter, joined, c.want)
+		}
+	}
+
+	// 未定义的属性不能过滤
+	_, err := s.ListUsers(tt.adminCtx(), &api.ListUsersRequest{Filter: `attributes.unknown eq "x"`})
+	wantCode(t, err, codes.InvalidArgument)
+}
+
+// 授予条件可以使用按类型转换后的自定义属性
+func TestGrantConditionUsesAttributes(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	tt.defineAttributes(t, s, map[string]string{
+		"level": model.AttributeTypeInt,
+		"vip":   model.AttributeTypeBool,
+	})
+	role := tt.createRole(t, "reporting", "reports:read")
+	senior, junior, guest := tt.createUser(t, "senior"), tt.createUser(t, "junior"), tt.createUser(t, "guest")
+	for _, u := range []*model.User{senior, junior, guest} {
+		tt.grant(t, u, role)
+	}
+	tt.setAttributes(t, s, senior, map[string]string{"level": "10", "vip": "true"})
+	tt.setAttributes(t, s, junior, map[string]string{"level": "2", "vip": "true"})
+
+	ctx := tt.adminCtx()
+	if _, err := s.SetGrantCondition(ctx, &api.SetGrantConditionRequest{
+		RoleId:       uint32(role.ID),
+		PermissionId: uint32(role.Permissions[0].ID),
+		Condition:    "has(subject.attributes.level) && subject.attributes.level >= 3 && subject.attributes.vip == true",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		user *model.User
+		want bool
+	}{
+		{senior, true},
+		{junior, false},
+		// 没有属性的用户不报错，按不满足处理
+		{guest, false},
+	} {
+		resp, err := s.CheckPermission(ctx, &api.CheckPermissionRequest{UserId: strconv.Itoa(int(c.user.ID)), Permission: "reports:read"})
+		if err != nil {
+			t.Fatalf("%s: %v", c.user.Username, err)
+		}
+		if resp.Allowed != c.want {
+			t.Errorf("%s: allowed = %v，期望 %v", c.user.Username, resp.Allowed, c.want)
+		}
+	}
+}
+
+// 非管理员只能修改自己的邮箱
+func TestUpdateUserEmailRequiresSelfOrAdmin(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	alice := tt.createUser(t, "alice")
+	ctx := tt.ctx("alice", "user")
+	email := "attacker@example.com"
+
+	_, err := s.UpdateUser(ctx, &api.UpdateUserRequest{UserId: uint32(tt.Admin.ID), Username: tt.Admin.Username, Email: &email})
+	wantCode(t, err, codes.PermissionDenied)
+	var admin model.User
+	if err := model.DB.First(&admin, tt.Admin.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if admin.EmailAddress() != "" {
+		t.Errorf("管理员的邮箱被修改为 %s", admin.EmailAddress())
+	}
+
+	own := "alice@example.com"
+	if _, err := s.UpdateUser(ctx, &api.UpdateUserRequest{UserId: uint32(alice.ID), Username: "alice", Email: &own}); err != nil {
+		t.Fatal(err)
+	}
+	other := "alice2@example.com"
+	if _, err := s.UpdateUser(tt.adminCtx(), &api.UpdateUserRequest{UserId: uint32(alice.ID), Username: "alice", Email: &other}); err != nil {
+		t.Fatal(err)
+	}
+	var got model.User
+	if err := model.DB.First(&got, alice.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if got.EmailAddress() != other {
+		t.Errorf("邮箱 = %s，期望 %s", got.EmailAddress(), other)
+	}
+}
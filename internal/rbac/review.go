@@ -0,0 +1,459 @@
+package rbac
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/audit"
+	"grpc-rbac-backend/internal/model"
+)
+
+// StartReviewCampaign 发起权限审核活动，为指定角色当前的每条直接分配生成审核项，
+// 审核项轮流分配给各审核人，且不会分给被审核的用户本人
+func (s *Service) StartReviewCampaign(ctx context.Context, req *api.StartReviewCampaignRequest) (*api.StartReviewCampaignResponse, error) {
+	if err := requireTenantAdmin(ctx); err != nil {
+		return nil, err
+	}
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.Name == "" || len(req.RoleIds) == 0 || len(req.ReviewerIds) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "活动名称、角色和审核人不能为空")
+	}
+
+	campaign := model.ReviewCampaign{
+		TenantID:    tenantID,
+		Name:        req.Name,
+		Description: req.Description,
+		State:       model.ReviewCampaignOpen,
+		DueAt:       unixPtr(req.DueAt),
+	}
+	var items []model.ReviewItem
+	err = withAudit(ctx, "StartReviewCampaign", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		creator, err := currentUser(tx, ctx)
+		if err != nil {
+			return err
+		}
+		campaign.CreatedBy = creator.ID
+
+		if err := tx.Scopes(model.TenantScope(tenantID)).Find(&campaign.Roles, req.RoleIds).Error; err != nil {
+			return err
+		}
+		if len(campaign.Roles) != len(uniqueIDs(req.RoleIds)) {
+			return status.Error(codes.NotFound, "角色不存在或不属于当前租户")
+		}
+		var reviewers []model.User
+		if err := tx.Scopes(model.TenantScope(tenantID)).Order("id").Find(&reviewers, req.ReviewerIds).Error; err != nil {
+			return err
+		}
+		if len(reviewers) != len(uniqueIDs(req.ReviewerIds)) {
+			return status.Error(codes.NotFound, "审核人不存在或不属于当前租户")
+		}
+
+		if err := tx.Create(&campaign).Error; err != nil {
+			return err
+		}
+
+		var assignments []model.UserRole
+		if err := tx.Scopes(model.ActiveAt(time.Now())).
+			Where("role_id IN ?", req.RoleIds).
+			Order("role_id, user_id").Find(&assignments).Error; err != nil {
+			return err
+		}
+		next := 0
+		for i, a := range assignments {
+			reviewer, ok := pickReviewer(reviewers, a.UserID, &next)
+			if !ok {
+				return status.Errorf(codes.FailedPrecondition, "用户 %d 没有可分配的审核人", a.UserID)
+			}
+			item := model.ReviewItem{
+				CampaignID: campaign.ID,
+				UserID:     a.UserID,
+				RoleID:     a.RoleID,
+				ReviewerID: reviewer,
+				Decision:   model.ReviewPending,
+			}
+			item.SnapshotGrant(&assignments[i])
+			items = append(items, item)
+		}
+		if len(items) > 0 {
+			if err := tx.CreateInBatches(&items, 100).Error; err != nil {
+				return err
+			}
+		}
+
+		ev.Target = audit.Target("review-campaign", campaign.ID)
+		ev.After = audit.Snapshot(map[string]interface{}{"campaign": campaign, "items": len(items)})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.StartReviewCampaignResponse{
+		Message:    "审核活动已发起",
+		CampaignId: uint32(campaign.ID),
+		ItemCount:  int64(len(items)),
+	}, nil
+}
+
+// pickReviewer 从 next 开始轮流选择审核人，跳过被审核用户本人
+func pickReviewer(reviewers []model.User, userID uint, next *int) (uint, bool) {
+	for i := 0; i < len(reviewers); i++ {
+		r := reviewers[(*next+i)%len(reviewers)]
+		if r.ID != userID {
+			*next = (*next + i + 1) % len(reviewers)
+			return r.ID, true
+		}
+	}
+	return 0, false
+}
+
+// ListReviewCampaigns 列出本租户的审核活动
+func (s *Service) ListReviewCampaigns(ctx context.Context, req *api.ListReviewCampaignsRequest) (*api.ListReviewCampaignsResponse, error) {
+	db, _, err := scoped(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	q := db.Model(&model.ReviewCampaign{})
+	if req.State != "" {
+		q = q.Where("state = ?", req.State)
+	}
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, err
+	}
+	var campaigns []model.ReviewCampaign
+	if err := q.Preload("Roles").Order("id DESC").Scopes(paginate(req.Page, req.PageSize)).Find(&campaigns).Error; err != nil {
+		return nil, err
+	}
+
+	infos := make([]*api.ReviewCampaignInfo, 0, len(campaigns))
+	for _, c := range campaigns {
+		roles := make([]string, 0, len(c.Roles))
+		for _, r := range c.Roles {
+			roles = append(roles, r.Name)
+		}
+		infos = append(infos, &api.ReviewCampaignInfo{
+			Id:          uint32(c.ID),
+			Name:        c.Name,
+			Description: c.Description,
+			State:       c.State,
+			Roles:       roles,
+			DueAt:       ptrUnix(c.DueAt),
+			CreatedAt:   c.CreatedAt.Unix(),
+			ClosedAt:    ptrUnix(c.ClosedAt),
+		})
+	}
+	return &api.ListReviewCampaignsResponse{Campaigns: infos, Total: total}, nil
+}
+
+// ListReviewItems 审核人的待办队列；管理员设置 all 后可查看活动的全部审核项
+func (s *Service) ListReviewItems(ctx context.Context, req *api.ListReviewItemsRequest) (*api.ListReviewItemsResponse, error) {
+	me, err := currentUser(model.DB, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.All {
+		if err := requireTenantAdmin(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	q := model.DB.Model(&model.ReviewItem{}).
+		Joins("JOIN review_campaigns ON review_campaigns.id = review_items.campaign_id").
+		Where("review_campaigns.tenant_id = ?", me.TenantID)
+	if !req.All {
+		q = q.Where("review_items.reviewer_id = ?", me.ID)
+	}
+	if req.CampaignId > 0 {
+		q = q.Where("review_items.campaign_id = ?", req.CampaignId)
+	}
+	if req.Decision != "" {
+		q = q.Where("review_items.decision = ?", req.Decision)
+	}
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		model.ReviewItem
+		Username     string
+		RoleName     string
+		Reviewer     string
+		CampaignName string
+	}
+	if err := q.Select("review_items.*, users.username, roles.name AS role_name, reviewers.username AS reviewer, review_campaigns.name AS campaign_name").
+		Joins("JOIN users ON users.id = review_items.user_id").
+		Joins("JOIN roles ON roles.id = review_items.role_id").
+		Joins("JOIN users AS reviewers ON reviewers.id = review_items.reviewer_id").
+		Order("review_items.id").
+		Scopes(paginate(req.Page, req.PageSize)).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	items := make([]*api.ReviewItemInfo, 0, len(rows))
+	for _, r := range rows {
+		items = append(items, &api.ReviewItemInfo{
+			Id:           uint32(r.ID),
+			CampaignId:   uint32(r.CampaignID),
+			CampaignName: r.CampaignName,
+			UserId:       uint32(r.UserID),
+			Username:     r.Username,
+			RoleId:       uint32(r.RoleID),
+			RoleName:     r.RoleName,
+			Reviewer:     r.Reviewer,
+			Decision:     r.Decision,
+			Comment:      r.Comment,
+			DecidedAt:    ptrUnix(r.DecidedAt),
+			Applied:      r.Applied,
+		})
+	}
+	return &api.ListReviewItemsResponse{Items: items, Total: total}, nil
+}
+
+// DecideReviewItem 审核人给出保留或撤销的结论，活动结束前可以修改
+func (s *Service) DecideReviewItem(ctx context.Context, req *api.DecideReviewItemRequest) (*api.DecideReviewItemResponse, error) {
+	if req.Decision != model.ReviewKeep && req.Decision != model.ReviewRevoke {
+		return nil, status.Error(codes.InvalidArgument, "结论只能是 keep 或 revoke")
+	}
+
+	err := withAudit(ctx, "DecideReviewItem", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		ev.Target = audit.Target("review-item", uint(req.ItemId))
+
+		me, err := currentUser(tx, ctx)
+		if err != nil {
+			return err
+		}
+		var item model.ReviewItem
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&item, req.ItemId).Error; err != nil {
+			return err
+		}
+		var campaign model.ReviewCampaign
+		if err := tx.Scopes(model.TenantScope(me.TenantID)).First(&campaign, item.CampaignID).Error; err != nil {
+			return err
+		}
+		if item.ReviewerID != me.ID {
+			return status.Error(codes.PermissionDenied, "该审核项未分配给你")
+		}
+		if campaign.State != model.ReviewCampaignOpen {
+			return status.Error(codes.FailedPrecondition, "审核活动已结束")
+		}
+		ev.Before = audit.Snapshot(item)
+
+		grant, err := lockAssignment(tx, item.UserID, item.RoleID)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		item.Decision = req.Decision
+		item.Comment = req.Comment
+		item.DecidedAt = &now
+		item.SnapshotGrant(grant)
+		if err := tx.Save(&item).Error; err != nil {
+			return err
+		}
+		ev.After = audit.Snapshot(item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.DecideReviewItemResponse{Message: "审核结论已记录"}, nil
+}
+
+// CloseReviewCampaign 结束审核活动并执行所有撤销结论；revokePending 为 true 时未审核的分配也一并撤销
+func (s *Service) CloseReviewCampaign(ctx context.Context, req *api.CloseReviewCampaignRequest) (*api.CloseReviewCampaignResponse, error) {
+	if err := requireTenantAdmin(ctx); err != nil {
+		return nil, err
+	}
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var revoked int64
+	err = withAudit(ctx, "CloseReviewCampaign", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		ev.Target = audit.Target("review-campaign", uint(req.CampaignId))
+
+		var campaign model.ReviewCampaign
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Scopes(model.TenantScope(tenantID)).
+			First(&campaign, req.CampaignId).Error; err != nil {
+			return err
+		}
+		revoked, err = closeReviewCampaign(tx, &campaign, req.RevokePending, ev)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.CloseReviewCampaignResponse{Message: "审核活动已结束", Revoked: revoked}, nil
+}
+
+// lockAssignment 加锁读取用户的直接角色分配，不存在时返回 nil
+func lockAssignment(tx *gorm.DB, userID, roleID uint) (*model.UserRole, error) {
+	var grants []model.UserRole
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND role_id = ?", userID, roleID).Limit(1).Find(&grants).Error; err != nil {
+		return nil, err
+	}
+	if len(grants) == 0 {
+		return nil, nil
+	}
+	return &grants[0], nil
+}
+
+// closeReviewCampaign 在事务中删除结论为撤销的角色分配并将活动标记为已结束；
+// 作出结论后被重新分配或修改过的分配不会被撤销
+func closeReviewCampaign(tx *gorm.DB, campaign *model.ReviewCampaign, revokePending bool, ev *model.AuditEvent) (int64, error) {
+	if campaign.State != model.ReviewCampaignOpen {
+		return 0, status.Error(codes.FailedPrecondition, "审核活动已结束")
+	}
+	ev.Before = audit.Snapshot(campaign)
+
+	decisions := []string{model.ReviewRevoke}
+	if revokePending {
+		decisions = append(decisions, model.ReviewPending)
+	}
+	var items []model.ReviewItem
+	if err := tx.Where("campaign_id = ? AND decision IN ?", campaign.ID, decisions).Find(&items).Error; err != nil {
+		return 0, err
+	}
+
+	var revoked int64
+	applied := make([]map[string]uint, 0, len(items))
+	skipped := make([]map[string]uint, 0)
+	ids := make([]uint, 0, len(items))
+	for _, item := range items {
+		grant, err := lockAssignment(tx, item.UserID, item.RoleID)
+		if err != nil {
+			return 0, err
+		}
+		if grant == nil {
+			continue
+		}
+		if !item.MatchesGrant(grant) {
+			skipped = append(skipped, map[string]uint{"user_id": item.UserID, "role_id": item.RoleID})
+			continue
+		}
+		res := tx.Where("user_id = ? AND role_id = ?", item.UserID, item.RoleID).Delete(&model.UserRole{})
+		if res.Error != nil {
+			return 0, res.Error
+		}
+		revoked += res.RowsAffected
+		applied = append(applied, map[string]uint{"user_id": item.UserID, "role_id": item.RoleID})
+		ids = append(ids, item.ID)
+	}
+	if len(ids) > 0 {
+		if err := tx.Model(&model.ReviewItem{}).Where("id IN ?", ids).Update("applied", true).Error; err != nil {
+			return 0, err
+		}
+	}
+
+	now := time.Now()
+	campaign.State = model.ReviewCampaignClosed
+	campaign.ClosedAt = &now
+	if err := tx.Model(campaign).Updates(map[string]interface{}{"state": campaign.State, "closed_at": now}).Error; err != nil {
+		return 0, err
+	}
+	ev.After = audit.Snapshot(map[string]interface{}{"campaign": campaign, "revoked": applied, "skipped": skipped})
+	return revoked, nil
+}
+
+// GetReviewCampaignReport 审核活动的完成情况，含各审核人的进度
+func (s *Service) GetReviewCampaignReport(ctx context.Context, req *api.GetReviewCampaignReportRequest) (*api.GetReviewCampaignReportResponse, error) {
+	db, _, err := scoped(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var campaign model.ReviewCampaign
+	if err := db.First(&campaign, req.CampaignId).Error; err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		ReviewerID uint
+		Reviewer   string
+		Decision   string
+		Applied    bool
+		Count      int64
+	}
+	if err := model.DB.Table("review_items").
+		Select("review_items.reviewer_id, users.username AS reviewer, review_items.decision, review_items.applied, COUNT(*) AS count").
+		Joins("JOIN users ON users.id = review_items.reviewer_id").
+		Where("review_items.campaign_id = ?", campaign.ID).
+		Group("review_items.reviewer_id, users.username, review_items.decision, review_items.applied").
+		Order("review_items.reviewer_id").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	report := &api.GetReviewCampaignReportResponse{
+		CampaignId: uint32(campaign.ID),
+		Name:       campaign.Name,
+		State:      campaign.State,
+	}
+	byReviewer := make(map[uint]*api.ReviewerProgress)
+	for _, r := range rows {
+		p, ok := byReviewer[r.ReviewerID]
+		if !ok {
+			p = &api.ReviewerProgress{Reviewer: r.Reviewer}
+			byReviewer[r.ReviewerID] = p
+			report.Reviewers = append(report.Reviewers, p)
+		}
+		p.Total += r.Count
+		report.Total += r.Count
+		switch r.Decision {
+		case model.ReviewKeep:
+			p.Decided += r.Count
+			report.Kept += r.Count
+		case model.ReviewRevoke:
+			p.Decided += r.Count
+			report.Revoked += r.Count
+		default:
+			report.Pending += r.Count
+		}
+		if r.Applied {
+			report.Applied += r.Count
+		}
+	}
+	if report.Total > 0 {
+		report.CompletionPercent = float64(report.Total-report.Pending) * 100 / float64(report.Total)
+	}
+	return report, nil
+}
+
+// sweepDueReviewCampaigns 到期的审核活动自动结束，未审核的分配保持不变
+func sweepDueReviewCampaigns(ctx context.Context) error {
+	var due []model.ReviewCampaign
+	if err := model.DB.Where("state = ? AND due_at IS NOT NULL AND due_at <= ?", model.ReviewCampaignOpen, time.Now()).
+		Limit(sweepBatchSize).Find(&due).Error; err != nil {
+		return err
+	}
+	for i := range due {
+		campaign := due[i]
+		err := withAudit(ctx, "CloseReviewCampaign", func(tx *gorm.DB, ev *model.AuditEvent) error {
+			ev.Target = audit.Target("review-campaign", campaign.ID)
+			ev.TenantID = campaign.TenantID
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&campaign, campaign.ID).Error; err != nil {
+				return err
+			}
+			_, err := closeReviewCampaign(tx, &campaign, false, ev)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
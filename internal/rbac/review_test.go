@@ -0,0 +1,57 @@
+package rbac
+
+import (
+	"testing"
+	"time"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/model"
+)
+
+// 作出撤销结论后被重新分配的角色在活动结束时保留
+func TestCloseReviewCampaignSkipsChangedGrants(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	alice, bob := tt.createUser(t, "alice"), tt.createUser(t, "bob")
+	reviewer := tt.createUser(t, "reviewer")
+	role := tt.createRole(t, "finance", "invoices:approve")
+	tt.grant(t, alice, role)
+	tt.grant(t, bob, role)
+	ctx := tt.adminCtx()
+
+	started, err := s.StartReviewCampaign(ctx, &api.StartReviewCampaignRequest{
+		Name: "Q3", RoleIds: []uint32{uint32(role.ID)}, ReviewerIds: []uint32{uint32(reviewer.ID)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var items []model.ReviewItem
+	if err := model.DB.Where("campaign_id = ?", started.CampaignId).Find(&items).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range items {
+		if _, err := s.DecideReviewItem(tt.ctx("reviewer"), &api.DecideReviewItemRequest{ItemId: uint32(item.ID), Decision: model.ReviewRevoke}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 结论之后重新分配了 alice 的角色
+	if _, err := s.AssignUserRole(ctx, &api.AssignUserRoleRequest{
+		UserId: uint32(alice.ID), RoleId: uint32(role.ID), ExpiresAt: time.Now().Add(24 * time.Hour).Unix(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	closed, err := s.CloseReviewCampaign(ctx, &api.CloseReviewCampaignRequest{CampaignId: started.CampaignId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if closed.Revoked != 1 {
+		t.Errorf("撤销了 %d 条分配，期望 1", closed.Revoked)
+	}
+	if got := directRoles(t, alice.ID); len(got) != 1 {
+		t.Errorf("重新分配后的角色被撤销: %v", got)
+	}
+	if got := directRoles(t, bob.ID); len(got) != 0 {
+		t.Errorf("未变化的分配未被撤销: %v", got)
+	}
+}
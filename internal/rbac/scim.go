@@ -0,0 +1,1017 @@
+package rbac
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/audit"
+	"grpc-rbac-backend/internal/model"
+	"grpc-rbac-backend/internal/scim"
+)
+
+// scimProvisionPermission 预配客户端（如 HR 系统的服务账号）需要的权限，租户管理员无需单独授予
+const scimProvisionPermission = "scim:provision"
+
+var errScimNotFound = status.Error(codes.NotFound, "资源不存在")
+
+// scimError 带 SCIM 错误类型（scimType）的错误，网关据此生成 SCIM 错误响应
+func scimError(code codes.Code, scimType, msg string) error {
+	st := status.New(code, msg)
+	if detailed, err := st.WithDetails(&errdetails.ErrorInfo{Reason: scimType, Domain: scim.ErrorDomain}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// requireProvisioner 允许租户管理员，或拥有 scim:provision 权限的调用方（可以是限定范围的 API Key）。
+// 后者不能让用户获得 admin 角色，也不能修改管理员的密码，见 adminGuard
+func requireProvisioner(ctx context.Context) (uint, error) {
+	claims, err := callerClaims(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if requireTenantAdmin(ctx) == nil {
+		return claims.TenantID, nil
+	}
+	user, err := currentUser(model.DB, ctx)
+	if err != nil {
+		return 0, err
+	}
+	ok, err := callerHasPermission(model.DB, ctx, user, scimProvisionPermission)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, status.Error(codes.PermissionDenied, "需要管理员权限或 "+scimProvisionPermission+" 权限")
+	}
+	return claims.TenantID, nil
+}
+
+// holdsAdminRole 用户是否直接或通过用户组拥有 admin 角色
+func holdsAdminRole(tx *gorm.DB, tenantID, userID uint) (bool, error) {
+	grants, err := effectiveRoles(tx, tenantID, userID, false)
+	if err != nil {
+		return false, err
+	}
+	for _, g := range grants {
+		if g.Role.Name == "admin" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// adminGuard 防止仅有 scim:provision 权限的调用方借分配角色或添加组成员把用户提升为管理员；
+// 调用方为租户管理员时为 nil，不做限制
+type adminGuard struct {
+	tenantID uint
+	userIDs  []uint
+	before   map[uint]bool
+}
+
+// beginAdminGuard 在变更前记录哪些用户已是管理员
+func beginAdminGuard(ctx context.Context, tx *gorm.DB, tenantID uint, userIDs []uint) (*adminGuard, error) {
+	if requireTenantAdmin(ctx) == nil {
+		return nil, nil
+	}
+	g := &adminGuard{tenantID: tenantID, userIDs: userIDs, before: make(map[uint]bool, len(userIDs))}
+	for _, id := range userIDs {
+		admin, err := holdsAdminRole(tx, tenantID, id)
+		if err != nil {
+			return nil, err
+		}
+		g.before[id] = admin
+	}
+	return g, nil
+}
+
+// check 变更后有用户新获得 admin 角色时拒绝，调用方回滚事务
+func (g *adminGuard) check(tx *gorm.DB) error {
+	if g == nil {
+		return nil
+	}
+	for _, id := range g.userIDs {
+		if g.before[id] {
+			continue
+		}
+		admin, err := holdsAdminRole(tx, g.tenantID, id)
+		if err != nil {
+			return err
+		}
+		if admin {
+			return status.Error(codes.PermissionDenied, "只有租户管理员可以授予 admin 角色")
+		}
+	}
+	return nil
+}
+
+func parseScimID(id string) (uint, error) {
+	n, err := strconv.ParseUint(id, 10, 32)
+	if err != nil || n == 0 {
+		return 0, errScimNotFound
+	}
+	return uint(n), nil
+}
+
+// 可用于过滤的属性
+var (
+	scimUserColumns = map[string]scim.Column{
+		"id":         {Expr: "users.id"},
+		"username":   {Expr: "users.username"},
+		"externalid": {Expr: "users.external_id"},
+		"active":     {Expr: "users.status = 'active'", Bool: true},
+	}
+	scimGroupColumns = map[string]scim.Column{
+		"id":          {Expr: "`groups`.id"},
+		"displayname": {Expr: "`groups`.name"},
+		"externalid":  {Expr: "`groups`.external_id"},
+	}
+)
+
+// scimFilter 按过滤表达式追加查询条件
+func scimFilter(db *gorm.DB, filter string, columns map[string]scim.Column) (*gorm.DB, error) {
+	if strings.TrimSpace(filter) == "" {
+		return db, nil
+	}
+	f, err := scim.ParseFilter(filter)
+	if err != nil {
+		return nil, scimError(codes.InvalidArgument, scim.ErrInvalidFilter, err.Error())
+	}
+	cond, args, err := scim.ToSQL(f, columns)
+	if err != nil {
+		return nil, scimError(codes.InvalidArgument, scim.ErrInvalidFilter, err.Error())
+	}
+	return db.Where(cond, args...), nil
+}
+
+// scimPage startIndex 从 1 开始，count 不超过 scim.MaxCount
+func scimPage(req *api.ListScimRequest) (start, count int) {
+	start, count = int(req.StartIndex), int(req.Count)
+	if start < 1 {
+		start = 1
+	}
+	if count < 0 {
+		count = 0
+	}
+	if count > scim.MaxCount {
+		count = scim.MaxCount
+	}
+	return start, count
+}
+
+// scimPatchValue 解码 PATCH 操作的 value
+func scimPatchValue(op *api.ScimPatchOperation) (any, error) {
+	if op.Value == "" {
+		return nil, nil
+	}
+	var v any
+	if err := json.Unmarshal([]byte(op.Value), &v); err != nil {
+		return nil, scimError(codes.InvalidArgument, scim.ErrInvalidSyntax, "value 不是合法的 JSON")
+	}
+	return v, nil
+}
+
+// scimValues 多值属性的值：字符串、{"value": ...} 或它们的数组
+func scimValues(v any) ([]string, bool) {
+	switch val := v.(type) {
+	case string:
+		return []string{val}, true
+	case map[string]any:
+		s, ok := val["value"].(string)
+		return []string{s}, ok
+	case []any:
+		out := make([]string, 0, len(val))
+		for _, item := range val {
+			values, ok := scimValues(item)
+			if !ok {
+				return nil, false
+			}
+			out = append(out, values...)
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+// scimBool 布尔值，兼容部分客户端发送的 "True"/"False"
+func scimBool(v any) (bool, bool) {
+	switch val := v.(type) {
+	case bool:
+		return val, true
+	case string:
+		b, err := strconv.ParseBool(strings.ToLower(val))
+		return b, err == nil
+	}
+	return false, false
+}
+
+// scimOperations 拆分 PATCH 操作：没有 path 时 value 为属性到值的对象，逐个展开
+func scimOperations(ops []*api.ScimPatchOperation) ([]scimOperation, error) {
+	var out []scimOperation
+	for _, op := range ops {
+		name := strings.ToLower(op.Op)
+		if name != "add" && name != "remove" && name != "replace" {
+			return nil, scimError(codes.InvalidArgument, scim.ErrInvalidSyntax, "不支持的 op: "+op.Op)
+		}
+		value, err := scimPatchValue(op)
+		if err != nil {
+			return nil, err
+		}
+		if op.Path == "" {
+			obj, ok := value.(map[string]any)
+			if !ok || name == "remove" {
+				return nil, scimError(codes.InvalidArgument, scim.ErrNoTarget, "缺少 path")
+			}
+			for k, v := range obj {
+				attr, filter, sub, err := scim.ParsePath(k)
+				if err != nil {
+					return nil, scimError(codes.InvalidArgument, scim.ErrInvalidPath, err.Error())
+				}
+				out = append(out, scimOperation{op: name, attr: attr, filter: filter, sub: sub, value: v})
+			}
+			continue
+		}
+		attr, filter, sub, err := scim.ParsePath(op.Path)
+		if err != nil {
+			return nil, scimError(codes.InvalidArgument, scim.ErrInvalidPath, err.Error())
+		}
+		out = append(out, scimOperation{op: name, attr: attr, filter: filter, sub: sub, value: value})
+	}
+	return out, nil
+}
+
+// scimOperation 展开后的一个 PATCH 操作，attr 为小写属性名
+type scimOperation struct {
+	op     string
+	attr   string
+	filter scim.Filter
+	sub    string
+	value  any
+}
+
+// applyMultiValued 对多值属性（角色名或成员 ID）执行 PATCH 操作，display 为值对应的显示名
+func applyMultiValued(current []string, o scimOperation, display map[string]string) ([]string, error) {
+	values, ok := scimValues(o.value)
+	if o.value != nil && !ok {
+		return nil, scimError(codes.InvalidArgument, scim.ErrInvalidValue, o.attr+" 的值格式错误")
+	}
+	switch o.op {
+	case "add":
+		return appendUnique(current, values...), nil
+	case "replace":
+		if o.filter != nil {
+			return nil, scimError(codes.InvalidArgument, scim.ErrInvalidPath, "不支持替换 "+o.attr+" 中的单个值")
+		}
+		return appendUnique(nil, values...), nil
+	}
+	// remove：带筛选条件时删除匹配的值，带 value 时删除这些值，都没有时清空
+	if o.filter == nil && o.value == nil {
+		return nil, nil
+	}
+	drop := make(map[string]bool, len(values))
+	for _, v := range values {
+		drop[v] = true
+	}
+	var kept []string
+	for _, v := range current {
+		if o.filter != nil && scim.Match(o.filter, map[string]string{"value": v, "display": display[v]}) {
+			continue
+		}
+		if drop[v] {
+			continue
+		}
+		kept = append(kept, v)
+	}
+	return kept, nil
+}
+
+func appendUnique(list []string, values ...string) []string {
+	seen := make(map[string]bool, len(list))
+	for _, v := range list {
+		seen[v] = true
+	}
+	for _, v := range values {
+		if v != "" && !seen[v] {
+			seen[v] = true
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// ========== Users ==========
+
+// loadScimUser 本租户的普通用户，服务账号不通过 SCIM 管理
+func loadScimUser(tx *gorm.DB, tenantID, id uint) (*model.User, error) {
+	var user model.User
+	if err := tx.Scopes(model.TenantScope(tenantID)).Where("kind = ?", model.UserKindHuman).
+		Preload("Roles").Limit(1).Find(&user, id).Error; err != nil {
+		return nil, err
+	}
+	if user.ID == 0 {
+		return nil, errScimNotFound
+	}
+	return &user, nil
+}
+
+func toScimUser(tx *gorm.DB, user *model.User) (*api.ScimUser, error) {
+	var groups []model.Group
+	if err := tx.Joins("JOIN group_users ON group_users.group_id = `groups`.id").
+		Where("group_users.user_id = ?", user.ID).Order("`groups`.id").Find(&groups).Error; err != nil {
+		return nil, err
+	}
+	u := &api.ScimUser{
+		Id:         strconv.FormatUint(uint64(user.ID), 10),
+		ExternalId: user.ExternalID,
+		UserName:   user.Username,
+		Active:     user.Status == model.UserStatusActive,
+	}
+	for _, r := range user.Roles {
+		u.Roles = append(u.Roles, r.Name)
+	}
+	for _, g := range groups {
+		u.Groups = append(u.Groups, &api.ScimRef{Value: strconv.FormatUint(uint64(g.ID), 10), Display: g.Name})
+	}
+	return u, nil
+}
+
+func (s *Service) scimUser(tenantID, id uint) (*api.ScimUser, error) {
+	user, err := loadScimUser(model.DB, tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	return toScimUser(model.DB, user)
+}
+
+// checkScimUsername 用户名不能为空，且不能与本租户的其他用户（包括保留期内已删除的用户）重复
+func checkScimUsername(tx *gorm.DB, tenantID, selfID uint, username string) error {
+	if username == "" {
+		return scimError(codes.InvalidArgument, scim.ErrInvalidValue, "userName 不能为空")
+	}
+	var count int64
+	if err := tx.Unscoped().Model(&model.User{}).Scopes(model.TenantScope(tenantID)).
+		Where("username = ? AND id <> ?", username, selfID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return scimError(codes.AlreadyExists, scim.ErrUniqueness, "用户名已存在: "+username)
+	}
+	return nil
+}
+
+// setScimActive active 为 false 时停用用户并撤销其全部会话，尚未激活的用户保持 pending
+func setScimActive(tx *gorm.DB, user *model.User, active bool) error {
+	switch {
+	case active:
+		return setUserStatus(tx, user, model.UserStatusActive, statusSourceSCIM)
+	case user.Status == model.UserStatusActive:
+		return setUserStatus(tx, user, model.UserStatusDisabled, statusSourceSCIM)
+	}
+	return nil
+}
+
+// setScimRoles 把由 SCIM 分配的角色替换为 names，手工、限时、审批和目录同步的分配保持不变；
+// 分配时校验职责分离约束
+func setScimRoles(ctx context.Context, tx *gorm.DB, tenantID, userID uint, names []string) error {
+	names = appendUnique(nil, names...)
+	var roles []model.Role
+	if len(names) > 0 {
+		if err := tx.Scopes(model.TenantScope(tenantID)).Where("name IN ?", names).Find(&roles).Error; err != nil {
+			return err
+		}
+	}
+	if len(roles) != len(names) {
+		return scimError(codes.InvalidArgument, scim.ErrInvalidValue, "roles 中包含不存在的角色")
+	}
+	admins, err := beginAdminGuard(ctx, tx, tenantID, []uint{userID})
+	if err != nil {
+		return err
+	}
+	q := tx.Where("user_id = ? AND source = ?", userID, model.UserRoleSourceSCIM)
+	if len(roles) > 0 {
+		ids := make([]uint, 0, len(roles))
+		for _, r := range roles {
+			ids = append(ids, r.ID)
+		}
+		q = q.Where("role_id NOT IN ?", ids)
+	}
+	if err := q.Delete(&model.UserRole{}).Error; err != nil {
+		return err
+	}
+
+	// 已有的分配（包括其他来源的）保持原样
+	guard, err := beginSodGuard(tx, tenantID, []uint{userID})
+	if err != nil {
+		return err
+	}
+	for _, role := range roles {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&model.UserRole{UserID: userID, RoleID: role.ID, Source: model.UserRoleSourceSCIM}).Error; err != nil {
+			return err
+		}
+	}
+	if err := guard.check(tx); err != nil {
+		return err
+	}
+	return admins.check(tx)
+}
+
+// ListScimUsers 按过滤条件分页列出用户
+func (s *Service) ListScimUsers(ctx context.Context, req *api.ListScimRequest) (*api.ListScimUsersResponse, error) {
+	tenantID, err := requireProvisioner(ctx)
+	if err != nil {
+		return nil, err
+	}
+	db, err := scimFilter(model.DB.Model(&model.User{}).Scopes(model.TenantScope(tenantID)).
+		Where("kind = ?", model.UserKindHuman), req.Filter, scimUserColumns)
+	if err != nil {
+		return nil, err
+	}
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, err
+	}
+	start, count := scimPage(req)
+	resp := &api.ListScimUsersResponse{TotalResults: int32(total), StartIndex: int32(start)}
+	if count == 0 {
+		return resp, nil
+	}
+	var users []model.User
+	if err := db.Preload("Roles").Order("users.id").Offset(start - 1).Limit(count).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	for i := range users {
+		u, err := toScimUser(model.DB, &users[i])
+		if err != nil {
+			return nil, err
+		}
+		resp.Resources = append(resp.Resources, u)
+	}
+	return resp, nil
+}
+
+func (s *Service) GetScimUser(ctx context.Context, req *api.ScimResourceRequest) (*api.ScimUser, error) {
+	tenantID, err := requireProvisioner(ctx)
+	if err != nil {
+		return nil, err
+	}
+	id, err := parseScimID(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return s.scimUser(tenantID, id)
+}
+
+// CreateScimUser 创建用户；不带密码时只能通过联合登录或找回密码登录
+func (s *Service) CreateScimUser(ctx context.Context, req *api.ScimUser) (*api.ScimUser, error) {
+	tenantID, err := requireProvisioner(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// 以 active=false 创建的用户（如尚未入职的员工）为 pending，设为 active 后才能登录
+	user := model.User{TenantID: tenantID, Username: req.UserName, ExternalID: req.ExternalId, Kind: model.UserKindHuman,
+		Status: model.UserStatusActive, StatusSource: statusSourceSCIM}
+	if !req.Active {
+		user.Status = model.UserStatusPending
+	}
+	err = withAudit(ctx, "ScimCreateUser", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		if err := checkScimUsername(tx, tenantID, 0, req.UserName); err != nil {
+			return err
+		}
+		if req.Password != "" {
+			if err := s.setPassword(tx, &user, "password", req.Password); err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		if req.Password != "" {
+			if err := s.recordPasswordHistory(tx, &user); err != nil {
+				return err
+			}
+		}
+		if err := setScimRoles(ctx, tx, tenantID, user.ID, req.Roles); err != nil {
+			return err
+		}
+		ev.Target = audit.Target("user", user.ID)
+		ev.After = audit.Snapshot(user)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.scimUser(tenantID, user.ID)
+}
+
+// ReplaceScimUser 替换用户的属性，停用时撤销其会话
+func (s *Service) ReplaceScimUser(ctx context.Context, req *api.ReplaceScimUserRequest) (*api.ScimUser, error) {
+	tenantID, err := requireProvisioner(ctx)
+	if err != nil {
+		return nil, err
+	}
+	id, err := parseScimID(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	if req.User == nil {
+		return nil, scimError(codes.InvalidArgument, scim.ErrInvalidSyntax, "缺少用户")
+	}
+	err = withAudit(ctx, "ScimReplaceUser", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		ev.Target = audit.Target("user", id)
+		user, err := loadScimUser(tx, tenantID, id)
+		if err != nil {
+			return err
+		}
+		ev.Before = audit.Snapshot(user)
+		if err := checkScimUsername(tx, tenantID, user.ID, req.User.UserName); err != nil {
+			return err
+		}
+		user.Username = req.User.UserName
+		user.ExternalID = req.User.ExternalId
+		if err := s.updateScimUser(ctx, tx, tenantID, user, req.User.Password, req.User.Active); err != nil {
+			return err
+		}
+		if req.RolesPresent {
+			if err := setScimRoles(ctx, tx, tenantID, user.ID, req.User.Roles); err != nil {
+				return err
+			}
+		}
+		ev.After = audit.Snapshot(user)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.scimUser(tenantID, id)
+}
+
+// updateScimUser 保存用户属性，plain 非空时修改密码并撤销其会话；管理员的密码只有租户管理员可以修改
+func (s *Service) updateScimUser(ctx context.Context, tx *gorm.DB, tenantID uint, user *model.User, plain string, active bool) error {
+	if plain != "" {
+		if requireTenantAdmin(ctx) != nil {
+			admin, err := holdsAdminRole(tx, tenantID, user.ID)
+			if err != nil {
+				return err
+			}
+			if admin {
+				return status.Error(codes.PermissionDenied, "只有租户管理员可以修改管理员的密码")
+			}
+		}
+		if err := s.setPassword(tx, user, "password", plain); err != nil {
+			return err
+		}
+	}
+	if err := setScimActive(tx, user, active); err != nil {
+		return err
+	}
+	if err := tx.Omit(clause.Associations).Save(user).Error; err != nil {
+		return err
+	}
+	if plain != "" {
+		if err := s.recordPasswordHistory(tx, user); err != nil {
+			return err
+		}
+		return model.RevokeUserSessions(tx, user.ID, "")
+	}
+	return nil
+}
+
+// PatchScimUser 按 PATCH 操作修改 userName、externalId、active、password 和 roles；
+// groups 只读，其余属性忽略
+func (s *Service) PatchScimUser(ctx context.Context, req *api.PatchScimRequest) (*api.ScimUser, error) {
+	tenantID, err := requireProvisioner(ctx)
+	if err != nil {
+		return nil, err
+	}
+	id, err := parseScimID(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	ops, err := scimOperations(req.Operations)
+	if err != nil {
+		return nil, err
+	}
+	err = withAudit(ctx, "ScimPatchUser", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		ev.Target = audit.Target("user", id)
+		user, err := loadScimUser(tx, tenantID, id)
+		if err != nil {
+			return err
+		}
+		ev.Before = audit.Snapshot(user)
+
+		roles := make([]string, 0, len(user.Roles))
+		for _, r := range user.Roles {
+			roles = append(roles, r.Name)
+		}
+		rolesChanged := false
+		active := user.Status == model.UserStatusActive
+		var plain string
+		for _, o := range ops {
+			str, isString := o.value.(string)
+			switch o.attr {
+			case "username":
+				if o.op == "remove" || !isString {
+					return scimError(codes.InvalidArgument, scim.ErrMutability, "userName 不能删除，须为字符串")
+				}
+				if err := checkScimUsername(tx, tenantID, user.ID, str); err != nil {
+					return err
+				}
+				user.Username = str
+			case "externalid":
+				if o.op == "remove" {
+					str, isString = "", true
+				}
+				if !isString {
+					return scimError(codes.InvalidArgument, scim.ErrInvalidValue, "externalId 须为字符串")
+				}
+				user.ExternalID = str
+			case "active":
+				b, ok := scimBool(o.value)
+				if o.op == "remove" || !ok {
+					return scimError(codes.InvalidArgument, scim.ErrInvalidValue, "active 须为布尔值")
+				}
+				active = b
+			case "password":
+				if o.op == "remove" || !isString || str == "" {
+					return scimError(codes.InvalidArgument, scim.ErrInvalidValue, "password 须为非空字符串")
+				}
+				plain = str
+			case "roles":
+				if roles, err = applyMultiValued(roles, o, nil); err != nil {
+					return err
+				}
+				rolesChanged = true
+			case "groups":
+				return scimError(codes.InvalidArgument, scim.ErrMutability, "groups 只读，请通过 Group 资源修改成员")
+			}
+		}
+		if err := s.updateScimUser(ctx, tx, tenantID, user, plain, active); err != nil {
+			return err
+		}
+		if rolesChanged {
+			if err := setScimRoles(ctx, tx, tenantID, user.ID, roles); err != nil {
+				return err
+			}
+		}
+		ev.After = audit.Snapshot(user)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.scimUser(tenantID, id)
+}
+
+// DeleteScimUser 软删除用户，与 DeleteUser 相同，保留期内可以恢复
+func (s *Service) DeleteScimUser(ctx context.Context, req *api.ScimResourceRequest) (*api.DeleteScimResponse, error) {
+	tenantID, err := requireProvisioner(ctx)
+	if err != nil {
+		return nil, err
+	}
+	id, err := parseScimID(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	err = withAudit(ctx, "ScimDeleteUser", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		ev.Target = audit.Target("user", id)
+		user, err := loadScimUser(tx, tenantID, id)
+		if err != nil {
+			return err
+		}
+		ev.Before = audit.Snapshot(user)
+		return softDeleteUser(tx, user, statusSourceSCIM)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.DeleteScimResponse{}, nil
+}
+
+// ========== Groups ==========
+
+func loadScimGroup(tx *gorm.DB, tenantID, id uint) (*model.Group, error) {
+	var group model.Group
+	if err := tx.Scopes(model.TenantScope(tenantID)).
+		Preload("Users", func(db *gorm.DB) *gorm.DB { return db.Order("users.id") }).
+		Limit(1).Find(&group, id).Error; err != nil {
+		return nil, err
+	}
+	if group.ID == 0 {
+		return nil, errScimNotFound
+	}
+	return &group, nil
+}
+
+func toScimGroup(group *model.Group) *api.ScimGroup {
+	g := &api.ScimGroup{
+		Id:          strconv.FormatUint(uint64(group.ID), 10),
+		ExternalId:  group.ExternalID,
+		DisplayName: group.Name,
+	}
+	for _, u := range group.Users {
+		g.Members = append(g.Members, &api.ScimRef{Value: strconv.FormatUint(uint64(u.ID), 10), Display: u.Username})
+	}
+	return g
+}
+
+func (s *Service) scimGroup(tenantID, id uint) (*api.ScimGroup, error) {
+	group, err := loadScimGroup(model.DB, tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	return toScimGroup(group), nil
+}
+
+// checkScimGroupName 组名不能为空，且不能与本租户的其他组重复
+func checkScimGroupName(tx *gorm.DB, tenantID, selfID uint, name string) error {
+	if name == "" {
+		return scimError(codes.InvalidArgument, scim.ErrInvalidValue, "displayName 不能为空")
+	}
+	var count int64
+	if err := tx.Model(&model.Group{}).Scopes(model.TenantScope(tenantID)).
+		Where("name = ? AND id <> ?", name, selfID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return scimError(codes.AlreadyExists, scim.ErrUniqueness, "用户组已存在: "+name)
+	}
+	return nil
+}
+
+// scimMemberIDs 成员引用中的用户 ID
+func scimMemberIDs(refs []*api.ScimRef) []string {
+	ids := make([]string, 0, len(refs))
+	for _, m := range refs {
+		ids = append(ids, m.Value)
+	}
+	return ids
+}
+
+// setScimMembers 把组成员替换为 ids，新加入的成员校验继承角色的职责分离约束，
+// 且不能因此获得 admin 角色（调用方为租户管理员时除外）
+func setScimMembers(ctx context.Context, tx *gorm.DB, tenantID uint, group *model.Group, ids []string) error {
+	ids = appendUnique(nil, ids...)
+	userIDs := make([]uint, 0, len(ids))
+	for _, v := range ids {
+		id, err := parseScimID(v)
+		if err != nil {
+			return scimError(codes.InvalidArgument, scim.ErrInvalidValue, "成员不存在: "+v)
+		}
+		userIDs = append(userIDs, id)
+	}
+	var users []model.User
+	if len(userIDs) > 0 {
+		if err := tx.Scopes(model.TenantScope(tenantID)).Where("kind = ? AND id IN ?", model.UserKindHuman, userIDs).
+			Find(&users).Error; err != nil {
+			return err
+		}
+	}
+	if len(users) != len(userIDs) {
+		return scimError(codes.InvalidArgument, scim.ErrInvalidValue, "成员中包含不存在的用户")
+	}
+
+	current := make(map[uint]bool, len(group.Users))
+	for _, u := range group.Users {
+		current[u.ID] = true
+	}
+	var added []uint
+	for _, u := range users {
+		if !current[u.ID] {
+			added = append(added, u.ID)
+		}
+	}
+	guard, err := beginSodGuard(tx, tenantID, added)
+	if err != nil {
+		return err
+	}
+	admins, err := beginAdminGuard(ctx, tx, tenantID, added)
+	if err != nil {
+		return err
+	}
+	if err := tx.Model(group).Association("Users").Replace(users); err != nil {
+		return err
+	}
+	group.Users = users
+	if err := guard.check(tx); err != nil {
+		return err
+	}
+	return admins.check(tx)
+}
+
+// ListScimGroups 按过滤条件分页列出用户组
+func (s *Service) ListScimGroups(ctx context.Context, req *api.ListScimRequest) (*api.ListScimGroupsResponse, error) {
+	tenantID, err := requireProvisioner(ctx)
+	if err != nil {
+		return nil, err
+	}
+	db, err := scimFilter(model.DB.Model(&model.Group{}).Scopes(model.TenantScope(tenantID)), req.Filter, scimGroupColumns)
+	if err != nil {
+		return nil, err
+	}
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, err
+	}
+	start, count := scimPage(req)
+	resp := &api.ListScimGroupsResponse{TotalResults: int32(total), StartIndex: int32(start)}
+	if count == 0 {
+		return resp, nil
+	}
+	var groups []model.Group
+	if err := db.Preload("Users", func(db *gorm.DB) *gorm.DB { return db.Order("users.id") }).
+		Order("`groups`.id").Offset(start - 1).Limit(count).Find(&groups).Error; err != nil {
+		return nil, err
+	}
+	for i := range groups {
+		resp.Resources = append(resp.Resources, toScimGroup(&groups[i]))
+	}
+	return resp, nil
+}
+
+func (s *Service) GetScimGroup(ctx context.Context, req *api.ScimResourceRequest) (*api.ScimGroup, error) {
+	tenantID, err := requireProvisioner(ctx)
+	if err != nil {
+		return nil, err
+	}
+	id, err := parseScimID(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return s.scimGroup(tenantID, id)
+}
+
+func (s *Service) CreateScimGroup(ctx context.Context, req *api.ScimGroup) (*api.ScimGroup, error) {
+	tenantID, err := requireProvisioner(ctx)
+	if err != nil {
+		return nil, err
+	}
+	group := model.Group{TenantID: tenantID, Name: req.DisplayName, ExternalID: req.ExternalId}
+	err = withAudit(ctx, "ScimCreateGroup", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		if err := checkScimGroupName(tx, tenantID, 0, req.DisplayName); err != nil {
+			return err
+		}
+		if err := tx.Create(&group).Error; err != nil {
+			return err
+		}
+		if err := setScimMembers(ctx, tx, tenantID, &group, scimMemberIDs(req.Members)); err != nil {
+			return err
+		}
+		ev.Target = audit.Target("group", group.ID)
+		ev.After = audit.Snapshot(toScimGroup(&group))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.scimGroup(tenantID, group.ID)
+}
+
+func (s *Service) ReplaceScimGroup(ctx context.Context, req *api.ReplaceScimGroupRequest) (*api.ScimGroup, error) {
+	tenantID, err := requireProvisioner(ctx)
+	if err != nil {
+		return nil, err
+	}
+	id, err := parseScimID(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	if req.Group == nil {
+		return nil, scimError(codes.InvalidArgument, scim.ErrInvalidSyntax, "缺少用户组")
+	}
+	err = withAudit(ctx, "ScimReplaceGroup", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		ev.Target = audit.Target("group", id)
+		group, err := loadScimGroup(tx, tenantID, id)
+		if err != nil {
+			return err
+		}
+		ev.Before = audit.Snapshot(toScimGroup(group))
+		if err := checkScimGroupName(tx, tenantID, group.ID, req.Group.DisplayName); err != nil {
+			return err
+		}
+		group.Name = req.Group.DisplayName
+		group.ExternalID = req.Group.ExternalId
+		if err := tx.Omit(clause.Associations).Save(group).Error; err != nil {
+			return err
+		}
+		if req.MembersPresent {
+			if err := setScimMembers(ctx, tx, tenantID, group, scimMemberIDs(req.Group.Members)); err != nil {
+				return err
+			}
+		}
+		ev.After = audit.Snapshot(toScimGroup(group))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.scimGroup(tenantID, id)
+}
+
+// PatchScimGroup 按 PATCH 操作修改 displayName、externalId 和 members，
+// 支持 members[value eq "1"] 形式的删除
+func (s *Service) PatchScimGroup(ctx context.Context, req *api.PatchScimRequest) (*api.ScimGroup, error) {
+	tenantID, err := requireProvisioner(ctx)
+	if err != nil {
+		return nil, err
+	}
+	id, err := parseScimID(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	ops, err := scimOperations(req.Operations)
+	if err != nil {
+		return nil, err
+	}
+	err = withAudit(ctx, "ScimPatchGroup", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		ev.Target = audit.Target("group", id)
+		group, err := loadScimGroup(tx, tenantID, id)
+		if err != nil {
+			return err
+		}
+		ev.Before = audit.Snapshot(toScimGroup(group))
+
+		members := make([]string, 0, len(group.Users))
+		display := make(map[string]string, len(group.Users))
+		for _, u := range group.Users {
+			v := strconv.FormatUint(uint64(u.ID), 10)
+			members = append(members, v)
+			display[v] = u.Username
+		}
+		membersChanged := false
+		for _, o := range ops {
+			str, isString := o.value.(string)
+			switch o.attr {
+			case "displayname":
+				if o.op == "remove" || !isString {
+					return scimError(codes.InvalidArgument, scim.ErrMutability, "displayName 不能删除，须为字符串")
+				}
+				if err := checkScimGroupName(tx, tenantID, group.ID, str); err != nil {
+					return err
+				}
+				group.Name = str
+			case "externalid":
+				if o.op == "remove" {
+					str, isString = "", true
+				}
+				if !isString {
+					return scimError(codes.InvalidArgument, scim.ErrInvalidValue, "externalId 须为字符串")
+				}
+				group.ExternalID = str
+			case "members":
+				if members, err = applyMultiValued(members, o, display); err != nil {
+					return err
+				}
+				membersChanged = true
+			}
+		}
+		if err := tx.Omit(clause.Associations).Save(group).Error; err != nil {
+			return err
+		}
+		if membersChanged {
+			if err := setScimMembers(ctx, tx, tenantID, group, members); err != nil {
+				return err
+			}
+		}
+		ev.After = audit.Snapshot(toScimGroup(group))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.scimGroup(tenantID, id)
+}
+
+// DeleteScimGroup 删除用户组及其成员、角色和父子关系
+func (s *Service) DeleteScimGroup(ctx context.Context, req *api.ScimResourceRequest) (*api.DeleteScimResponse, error) {
+	tenantID, err := requireProvisioner(ctx)
+	if err != nil {
+		return nil, err
+	}
+	id, err := parseScimID(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	err = withAudit(ctx, "ScimDeleteGroup", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		ev.Target = audit.Target("group", id)
+		group, err := loadScimGroup(tx, tenantID, id)
+		if err != nil {
+			return err
+		}
+		ev.Before = audit.Snapshot(toScimGroup(group))
+		return model.DeleteGroupWithRelations(tx, group)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.DeleteScimResponse{}, nil
+}
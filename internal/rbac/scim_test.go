@@ -0,0 +1,280 @@
+package rbac
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/model"
+)
+
+// provisionerCtx 拥有 scim:provision 权限、但不是管理员的调用方
+func provisionerCtx(t *testing.T, tt *testTenant) context.Context {
+	t.Helper()
+	user := tt.createUser(t, "hr-sync")
+	tt.grant(t, user, tt.createRole(t, "provisioner", scimProvisionPermission))
+	return tt.ctx(user.Username, "provisioner")
+}
+
+func scimID(id uint) string { return strconv.FormatUint(uint64(id), 10) }
+
+func createScimUser(t *testing.T, s *Service, ctx context.Context, username string, roles ...string) *api.ScimUser {
+	t.Helper()
+	u, err := s.CreateScimUser(ctx, &api.ScimUser{UserName: username, Active: true, Roles: roles})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func patchOp(op, path, value string) *api.ScimPatchOperation {
+	return &api.ScimPatchOperation{Op: op, Path: path, Value: value}
+}
+
+func TestScimListUsersFilterAndPagination(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	ctx := provisionerCtx(t, tt)
+	for _, name := range []string{"sam", "sara", "sue", "tom"} {
+		createScimUser(t, s, ctx, name)
+	}
+	if _, err := s.PatchScimUser(ctx, &api.PatchScimRequest{
+		Id: createScimUser(t, s, ctx, "sid").Id, Operations: []*api.ScimPatchOperation{patchOp("replace", "active", "false")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	list := func(filter string, start, count int32) *api.ListScimUsersResponse {
+		t.Helper()
+		resp, err := s.ListScimUsers(ctx, &api.ListScimRequest{Filter: filter, StartIndex: start, Count: count})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	names := func(resp *api.ListScimUsersResponse) []string {
+		var out []string
+		for _, u := range resp.Resources {
+			out = append(out, u.UserName)
+		}
+		return out
+	}
+
+	if resp := list(`userName sw "s" and active eq true`, 1, 10); resp.TotalResults != 3 || len(resp.Resources) != 3 {
+		t.Errorf("过滤结果 = %v（共 %d），期望 sam、sara、sue", names(resp), resp.TotalResults)
+	}
+	if resp := list(`userName eq "tom" or userName eq "sid"`, 1, 10); resp.TotalResults != 2 {
+		t.Errorf("or 过滤结果 = %v", names(resp))
+	}
+	// 分页：startIndex 从 1 开始，count 为 0 时只返回总数
+	page := list(`userName sw "s"`, 2, 2)
+	if page.TotalResults != 4 || page.StartIndex != 2 || len(page.Resources) != 2 || page.Resources[0].UserName != "sara" {
+		t.Errorf("第二页 = %v（共 %d，起始 %d）", names(page), page.TotalResults, page.StartIndex)
+	}
+	if resp := list("", 1, 0); resp.TotalResults != 7 || len(resp.Resources) != 0 {
+		t.Errorf("count=0 时返回 %d 条（共 %d），期望只返回总数 7（含管理员和 hr-sync）", len(resp.Resources), resp.TotalResults)
+	}
+	if resp := list("", 0, 10); resp.StartIndex != 1 {
+		t.Errorf("startIndex 小于 1 时 = %d，期望 1", resp.StartIndex)
+	}
+
+	_, err := s.ListScimUsers(ctx, &api.ListScimRequest{Filter: `userName xx "a"`})
+	wantCode(t, err, codes.InvalidArgument)
+	_, err = s.ListScimUsers(ctx, &api.ListScimRequest{Filter: `password eq "a"`})
+	wantCode(t, err, codes.InvalidArgument)
+}
+
+func TestScimPatchUser(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	tt.createRole(t, "developer")
+	tt.createRole(t, "oncall")
+	ctx := provisionerCtx(t, tt)
+	u := createScimUser(t, s, ctx, "uma", "developer")
+
+	patched, err := s.PatchScimUser(ctx, &api.PatchScimRequest{Id: u.Id, Operations: []*api.ScimPatchOperation{
+		patchOp("add", "roles", `[{"value": "oncall"}]`),
+		patchOp("replace", "", `{"userName": "uma.k", "externalId": "E7"}`),
+		patchOp("Replace", "active", `"False"`),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patched.UserName != "uma.k" || patched.ExternalId != "E7" || patched.Active || len(patched.Roles) != 2 {
+		t.Errorf("PATCH 结果 = %+v", patched)
+	}
+
+	patched, err = s.PatchScimUser(ctx, &api.PatchScimRequest{Id: u.Id, Operations: []*api.ScimPatchOperation{
+		patchOp("remove", `roles[value eq "developer"]`, ""),
+		patchOp("replace", "active", "true"),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !patched.Active || len(patched.Roles) != 1 || patched.Roles[0] != "oncall" {
+		t.Errorf("PATCH 结果 = %+v", patched)
+	}
+
+	for name, op := range map[string]*api.ScimPatchOperation{
+		"不支持的 op":     patchOp("move", "active", "true"),
+		"删除 userName": patchOp("remove", "userName", ""),
+		"修改 groups":   patchOp("add", "groups", `[{"value": "1"}]`),
+		"不存在的角色":      patchOp("add", "roles", `["nope"]`),
+		"非法 JSON":     patchOp("replace", "active", "{"),
+	} {
+		_, err := s.PatchScimUser(ctx, &api.PatchScimRequest{Id: u.Id, Operations: []*api.ScimPatchOperation{op}})
+		if code := status.Code(err); code != codes.InvalidArgument {
+			t.Errorf("%s: 状态码 = %v，期望 InvalidArgument", name, code)
+		}
+	}
+	_, err = s.PatchScimUser(ctx, &api.PatchScimRequest{Id: "999999", Operations: []*api.ScimPatchOperation{patchOp("replace", "active", "true")}})
+	wantCode(t, err, codes.NotFound)
+}
+
+func TestScimGroups(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	ctx := provisionerCtx(t, tt)
+	a, b, c := createScimUser(t, s, ctx, "ann"), createScimUser(t, s, ctx, "ben"), createScimUser(t, s, ctx, "cat")
+
+	g, err := s.CreateScimGroup(ctx, &api.ScimGroup{DisplayName: "eng", Members: []*api.ScimRef{{Value: a.Id}, {Value: b.Id}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateScimGroup(ctx, &api.ScimGroup{DisplayName: "ops"}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.CreateScimGroup(ctx, &api.ScimGroup{DisplayName: "eng"})
+	wantCode(t, err, codes.AlreadyExists)
+
+	g, err = s.PatchScimGroup(ctx, &api.PatchScimRequest{Id: g.Id, Operations: []*api.ScimPatchOperation{
+		patchOp("add", "members", `[{"value": "`+c.Id+`"}]`),
+		patchOp("remove", `members[value eq "`+a.Id+`"]`, ""),
+		patchOp("replace", "displayName", `"engineering"`),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.DisplayName != "engineering" || len(g.Members) != 2 || g.Members[0].Value != b.Id || g.Members[1].Value != c.Id {
+		t.Errorf("PATCH 结果 = %+v", g)
+	}
+
+	groups, err := s.ListScimGroups(ctx, &api.ListScimRequest{Filter: `displayName co "eer"`, StartIndex: 1, Count: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if groups.TotalResults != 1 || groups.Resources[0].Id != g.Id {
+		t.Errorf("过滤结果 = %+v", groups.Resources)
+	}
+	user, err := s.GetScimUser(ctx, &api.ScimResourceRequest{Id: c.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(user.Groups) != 1 || user.Groups[0].Display != "engineering" {
+		t.Errorf("用户的 groups = %+v", user.Groups)
+	}
+
+	// 成员中有不存在的用户时整体失败
+	_, err = s.PatchScimGroup(ctx, &api.PatchScimRequest{Id: g.Id, Operations: []*api.ScimPatchOperation{patchOp("add", "members", `["999999"]`)}})
+	wantCode(t, err, codes.InvalidArgument)
+}
+
+// SCIM 只撤销由 SCIM 分配的角色，手工和限时分配保持不变
+func TestScimRolesOnlyReconcileScimGrants(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	tt.createRole(t, "developer")
+	oncall := tt.createRole(t, "oncall")
+	manual := tt.createRole(t, "auditor")
+	ctx := provisionerCtx(t, tt)
+	u := createScimUser(t, s, ctx, "vic", "developer")
+	id, _ := strconv.Atoi(u.Id)
+
+	expires := time.Now().Add(8 * time.Hour).Truncate(time.Second)
+	if err := model.DB.Create(&model.UserRole{UserID: uint(id), RoleID: oncall.ID, ExpiresAt: &expires}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := model.DB.Create(&model.UserRole{UserID: uint(id), RoleID: manual.ID}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.ReplaceScimUser(ctx, &api.ReplaceScimUserRequest{Id: u.Id, User: &api.ScimUser{UserName: "vic", Active: true}, RolesPresent: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got := directRoles(t, uint(id)); len(got) != 2 || got[0] != "auditor" || got[1] != "oncall" {
+		t.Errorf("PUT roles=[] 后的角色 = %v，期望保留 [auditor oncall]", got)
+	}
+	if ur := findAssignment(t, uint(id), oncall.ID); ur.ExpiresAt == nil || !ur.ExpiresAt.Equal(expires) {
+		t.Errorf("限时分配被修改: %+v", ur)
+	}
+
+	// 列出已有的手工分配不会接管它
+	if _, err := s.PatchScimUser(ctx, &api.PatchScimRequest{Id: u.Id, Operations: []*api.ScimPatchOperation{patchOp("replace", "roles", `["oncall"]`)}}); err != nil {
+		t.Fatal(err)
+	}
+	if ur := findAssignment(t, uint(id), oncall.ID); ur.Source != "" || ur.ExpiresAt == nil {
+		t.Errorf("手工分配被 SCIM 接管: %+v", ur)
+	}
+}
+
+// 仅有 scim:provision 权限的调用方不能提升权限
+func TestScimProvisionerCannotEscalate(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	ctx := provisionerCtx(t, tt)
+	u := createScimUser(t, s, ctx, "wes")
+	adminID := scimID(tt.Admin.ID)
+
+	_, err := s.CreateScimUser(ctx, &api.ScimUser{UserName: "mallory", Active: true, Roles: []string{"admin"}})
+	wantCode(t, err, codes.PermissionDenied)
+	_, err = s.PatchScimUser(ctx, &api.PatchScimRequest{Id: u.Id, Operations: []*api.ScimPatchOperation{patchOp("add", "roles", `["admin"]`)}})
+	wantCode(t, err, codes.PermissionDenied)
+	_, err = s.ReplaceScimUser(ctx, &api.ReplaceScimUserRequest{Id: u.Id, User: &api.ScimUser{UserName: "wes", Active: true, Roles: []string{"admin"}}, RolesPresent: true})
+	wantCode(t, err, codes.PermissionDenied)
+
+	// 管理员的密码
+	_, err = s.PatchScimUser(ctx, &api.PatchScimRequest{Id: adminID, Operations: []*api.ScimPatchOperation{patchOp("replace", "password", `"N3w!Passw0rd"`)}})
+	wantCode(t, err, codes.PermissionDenied)
+	_, err = s.ReplaceScimUser(ctx, &api.ReplaceScimUserRequest{Id: adminID, User: &api.ScimUser{UserName: "admin", Active: true, Password: "N3w!Passw0rd"}})
+	wantCode(t, err, codes.PermissionDenied)
+
+	// 拥有 admin 角色的用户组
+	group := model.Group{TenantID: tt.ID, Name: "admins"}
+	if err := model.DB.Create(&group).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AssignGroupRoles(tt.adminCtx(), &api.AssignGroupRolesRequest{GroupId: uint32(group.ID), RoleIds: []uint32{uint32(tt.role(t, "admin").ID)}}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.PatchScimGroup(ctx, &api.PatchScimRequest{Id: scimID(group.ID), Operations: []*api.ScimPatchOperation{patchOp("add", "members", `["`+u.Id+`"]`)}})
+	wantCode(t, err, codes.PermissionDenied)
+
+	id, _ := strconv.Atoi(u.Id)
+	if ok, _ := holdsAdminRole(model.DB, tt.ID, uint(id)); ok {
+		t.Fatal("provisioner 把用户提升为了管理员")
+	}
+
+	// 普通用户的密码和角色不受影响，租户管理员不受限制
+	if _, err := s.PatchScimUser(ctx, &api.PatchScimRequest{Id: u.Id, Operations: []*api.ScimPatchOperation{patchOp("replace", "password", `"N3w!Passw0rd"`)}}); err != nil {
+		t.Errorf("修改普通用户的密码失败: %v", err)
+	}
+	if _, err := s.PatchScimGroup(tt.adminCtx(), &api.PatchScimRequest{Id: scimID(group.ID), Operations: []*api.ScimPatchOperation{patchOp("add", "members", `["`+u.Id+`"]`)}}); err != nil {
+		t.Errorf("租户管理员添加成员失败: %v", err)
+	}
+}
+
+func TestScimRequiresProvisioner(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	tt.createUser(t, "nobody")
+	ctx := tt.ctx("nobody", "user")
+	_, err := s.ListScimUsers(ctx, &api.ListScimRequest{})
+	wantCode(t, err, codes.PermissionDenied)
+	_, err = s.ListScimGroups(ctx, &api.ListScimRequest{})
+	wantCode(t, err, codes.PermissionDenied)
+}
@@ -4,107 +4,326 @@ import (
 	"context"
 	"errors"
 	"grpc-rbac-backend/api"
-	_ "grpc-rbac-backend/internal/middleware"
+	"grpc-rbac-backend/config"
+	"grpc-rbac-backend/internal/abac"
+	"grpc-rbac-backend/internal/audit"
+	"grpc-rbac-backend/internal/decision"
 	"grpc-rbac-backend/internal/model"
+	"grpc-rbac-backend/internal/oidc"
+	"grpc-rbac-backend/internal/password"
 	"grpc-rbac-backend/internal/utils"
+	"time"
 
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"gorm.io/gorm"
 )
 
 type Service struct {
 	api.UnimplementedRBACServiceServer
+	cfg        *config.Config
+	namespaces *NamespaceConfig
+	passwords  *password.Policy
+	signer     *oidc.Signer
+	providers  *FederationConfig
+	// directories 和 authenticators 为 LDAP 目录，authenticators 为登录时依次尝试的顺序
+	directories    *DirectoryConfig
+	authenticators []Authenticator
 }
 
-func NewRBACService() *Service {
-	return &Service{}
+func NewRBACService(cfg *config.Config, namespaces *NamespaceConfig, passwords *password.Policy, signer *oidc.Signer,
+	providers *FederationConfig, directories *DirectoryConfig) *Service {
+	return &Service{
+		cfg:            cfg,
+		namespaces:     namespaces,
+		passwords:      passwords,
+		signer:         signer,
+		providers:      providers,
+		directories:    directories,
+		authenticators: directories.authenticators(),
+	}
 }
 
-// Login 登录校验
-func (s *Service) Login(_ context.Context, req *api.LoginRequest) (*api.LoginResponse, error) {
-	var user model.User
-	if err := model.DB.Where("username = ?", req.Username).First(&user).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("用户名不存在")
+// Login 登录校验，按用户名和客户端 IP 限制连续失败的次数。
+// 用户已启用 MFA 时只返回挑战 token，需通过 VerifyMFA 换取正式 token。
+// 指定 provider 时改为联合登录，只返回外部身份提供方的跳转地址
+func (s *Service) Login(ctx context.Context, req *api.LoginRequest) (*api.LoginResponse, error) {
+	if req.Provider != "" {
+		return s.federatedRedirect(req.Provider)
+	}
+	tenant, err := resolveTenant(model.DB, req.Tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	user, keys, err := s.checkPassword(ctx, tenant, req.Username, req.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	totp, err := findTOTP(model.DB, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if totp != nil && totp.Enabled() {
+		challenge, err := utils.GeneratePurposeJWT(tenant.ID, tenant.Name, user.Username, utils.PurposeMFA, mfaChallengeTTL)
+		if err != nil {
+			return nil, err
 		}
+		return &api.LoginResponse{MfaRequired: true, ChallengeToken: challenge}, nil
+	}
+	if err := resetLoginFailures(keys[0]); err != nil {
 		return nil, err
 	}
-	if user.Password != req.Password {
-		return nil, errors.New("密码错误")
+
+	// 获取角色列表（含组继承的角色），只包含当前生效的分配
+	grants, err := effectiveRoles(model.DB, tenant.ID, user.ID, false)
+	if err != nil {
+		return nil, err
+	}
+	if s.mfaRequired(grants) {
+		challenge, err := utils.GeneratePurposeJWT(tenant.ID, tenant.Name, user.Username, utils.PurposeMFAEnroll, mfaChallengeTTL)
+		if err != nil {
+			return nil, err
+		}
+		return &api.LoginResponse{MfaEnrollmentRequired: true, ChallengeToken: challenge}, nil
+	}
+	t, err := s.sessionToken(tenant.ID, tenant.Name, user, grants)
+	if err != nil {
+		return nil, err
+	}
+	return &api.LoginResponse{Token: t.AccessToken, RefreshToken: t.RefreshToken}, nil
+}
+
+// checkPassword 按限流规则校验用户名和密码，本地账号和目录账号分别交给对应的 Authenticator；
+// 返回的 keys 供调用方在完成全部校验后重置失败计数
+func (s *Service) checkPassword(ctx context.Context, tenant *model.Tenant, username, plain string) (*model.User, []string, error) {
+	keys := loginThrottleKeys(ctx, tenant.ID, username)
+	if err := checkLoginThrottle(keys...); err != nil {
+		return nil, nil, err
+	}
+
+	var found model.User
+	if err := model.DB.Scopes(model.TenantScope(tenant.ID)).Where("username = ?", username).
+		Limit(1).Find(&found).Error; err != nil {
+		return nil, nil, err
+	}
+	var existing *model.User
+	if found.ID != 0 {
+		existing = &found
+	}
+	user, err := s.authenticate(ctx, tenant, existing, username, plain)
+	if errors.Is(err, errBadCredentials) {
+		if err := s.recordLoginFailures(keys); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, errBadCredentials
+	}
+	if err != nil {
+		return nil, nil, err
 	}
+	if err := checkUserActive(user); err != nil {
+		return nil, nil, err
+	}
+	return user, keys, nil
+}
 
-	// 获取角色列表
-	var roles []model.Role
-	if err := model.DB.Model(&user).Association("Roles").Find(&roles); err != nil {
+// issuedToken 签发给客户端的 token，启用 refresh token 时附带 refresh token
+type issuedToken struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time
+}
+
+// sessionToken 创建登录会话并签发包含当前生效角色的正式 token，同时记录最近登录时间
+func (s *Service) sessionToken(tenantID uint, tenantName string, user *model.User, grants []roleGrant) (*issuedToken, error) {
+	t, err := s.startSession(&model.Session{TenantID: tenantID, UserID: user.ID}, tenantName, user.Username, grants, true)
+	if err != nil {
 		return nil, err
 	}
-	roleNames := make([]string, 0)
-	for _, r := range roles {
-		roleNames = append(roleNames, r.Name)
+	if err := touchLastLogin(user); err != nil {
+		return nil, err
 	}
+	return t, nil
+}
 
-	token, err := utils.GenerateJWT(user.Username, roleNames)
+// startSession 创建会话并签发 token，session 中需已填好租户、用户，以及可选的客户端和权限范围。
+// withRefresh 且启用了 refresh token 时会话持续到 refresh token 过期，期间可通过 /oauth2/token 换取新的 token
+func (s *Service) startSession(session *model.Session, tenantName, username string, grants []roleGrant, withRefresh bool) (*issuedToken, error) {
+	sessionID, err := utils.RandomToken(16)
 	if err != nil {
 		return nil, err
 	}
-	return &api.LoginResponse{Token: token}, nil
+	withRefresh = withRefresh && s.cfg.RefreshTokenTTL > 0
+	session.ID = sessionID
+	session.ExpiresAt = time.Now().Add(utils.TokenTTL)
+	if withRefresh && s.cfg.RefreshTokenTTL > utils.TokenTTL {
+		session.ExpiresAt = time.Now().Add(s.cfg.RefreshTokenTTL)
+	}
+	if err := model.DB.Create(session).Error; err != nil {
+		return nil, err
+	}
+
+	t := &issuedToken{}
+	if t.AccessToken, t.ExpiresAt, err = accessToken(session, tenantName, username, grants); err != nil {
+		return nil, err
+	}
+	if withRefresh {
+		if t.RefreshToken, err = newRefreshToken(model.DB, session); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// accessToken 为会话签发 token，沿用会话的客户端和权限范围；有效期不超过会话和其中限时角色的失效时间
+func accessToken(session *model.Session, tenantName, username string, grants []roleGrant) (string, time.Time, error) {
+	roleNames, roleExpiry := roleNamesWithExpiry(grants)
+	expiresAt := time.Now().Add(utils.TokenTTL)
+	if session.ExpiresAt.Before(expiresAt) {
+		expiresAt = session.ExpiresAt
+	}
+	if roleExpiry != nil && roleExpiry.Before(expiresAt) {
+		expiresAt = *roleExpiry
+	}
+	token, err := utils.SignClaims(&utils.CustomClaims{
+		TenantID: session.TenantID,
+		Tenant:   tenantName,
+		Username: username,
+		Roles:    roleNames,
+		Scope:    session.Scope,
+		ClientID: session.ClientID,
+	}, session.ID, expiresAt)
+	return token, expiresAt, err
 }
 
 // GetUserRoles 查询角色
-func (s *Service) GetUserRoles(_ context.Context, req *api.GetUserRolesRequest) (*api.GetUserRolesResponse, error) {
+func (s *Service) GetUserRoles(ctx context.Context, req *api.GetUserRolesRequest) (*api.GetUserRolesResponse, error) {
+	db, tenantID, err := scoped(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var user model.User
-	if err := model.DB.Where("username = ?", req.UserId).Preload("Roles").First(&user).Error; err != nil {
+	if err := db.Where("username = ?", req.UserId).First(&user).Error; err != nil {
 		return nil, err
 	}
-	roleNames := make([]string, 0)
-	for _, r := range user.Roles {
-		roleNames = append(roleNames, r.Name)
+	roleNames, err := effectiveRoleNames(model.DB, tenantID, user.ID)
+	if err != nil {
+		return nil, err
 	}
 	return &api.GetUserRolesResponse{Roles: roleNames}, nil
 }
 
 // CheckPermission 校验权限
-func (s *Service) CheckPermission(_ context.Context, req *api.CheckPermissionRequest) (*api.CheckPermissionResponse, error) {
+func (s *Service) CheckPermission(ctx context.Context, req *api.CheckPermissionRequest) (*api.CheckPermissionResponse, error) {
+	start := time.Now()
+	entry := &decision.Entry{
+		Source:     decision.SourceCheckPermission,
+		Subject:    "user:" + req.UserId,
+		Permission: req.Permission,
+		Resource:   req.Resource,
+		Decision:   decision.Deny,
+	}
+	defer func() {
+		entry.LatencyMs = decision.Since(start)
+		decision.Log(entry)
+	}()
+
+	db, tenantID, err := scoped(ctx)
+	if err != nil {
+		entry.MatchedRule = "missing-tenant"
+		return nil, err
+	}
+	claims, err := callerClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var user model.User
-	if err := model.DB.Where("ID = ?", req.UserId).Preload("Roles.Permissions").First(&user).Error; err != nil {
+	if err := db.Preload("Attributes").Where("ID = ?", req.UserId).First(&user).Error; err != nil {
+		entry.MatchedRule = "user-not-found"
 		return nil, err
 	}
+	// 停用或尚未激活的用户不拥有任何权限
+	if user.Status != model.UserStatusActive {
+		entry.MatchedRule = "user-" + user.Status
+		return &api.CheckPermissionResponse{Allowed: false}, nil
+	}
 
-	for _, role := range user.Roles {
-		for _, perm := range role.Permissions {
-			if perm.Name == req.Permission {
-				return &api.CheckPermissionResponse{Allowed: true}, nil
-			}
-		}
+	grants, err := effectiveRoles(model.DB, tenantID, user.ID, true)
+	if err != nil {
+		entry.MatchedRule = "error"
+		return nil, err
+	}
+	in := &abac.Input{
+		Subject:  subjectAttributes(&user, claims.Tenant, grants),
+		Resource: req.Resource,
+		Context:  req.Context,
+	}
+	m, evalErr := findGrant(grants, req.Permission, in)
+	if m != nil {
+		entry.Decision = decision.Allow
+		entry.MatchedRule = m.describe()
+		return &api.CheckPermissionResponse{Allowed: true}, nil
+	}
+	if evalErr != nil {
+		entry.MatchedRule = "condition-error: " + evalErr.Error()
+		return &api.CheckPermissionResponse{Allowed: false}, nil
 	}
+	entry.MatchedRule = "no-matching-grant"
 	return &api.CheckPermissionResponse{Allowed: false}, nil
 }
 
 // Register 注册
 func (s *Service) Register(ctx context.Context, req *api.RegisterRequest) (*api.RegisterResponse, error) {
-	// 1. 检查用户是否已存在
-	var count int64
-	if err := model.DB.Model(&model.User{}).
-		Where("username = ?", req.Username).
-		Count(&count).Error; err != nil {
-		return nil, err
-	}
-	if count > 0 {
-		return nil, errors.New("用户名已存在")
-	}
+	err := withAudit(ctx, "Register", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		ev.Actor = req.Username
 
-	// 2. 查找默认角色（user）
-	var userRole model.Role
-	if err := model.DB.Where("name = ?", "user").First(&userRole).Error; err != nil {
-		return nil, errors.New("默认角色不存在，请初始化数据库")
-	}
+		tenant, err := resolveTenant(tx, req.Tenant)
+		if err != nil {
+			return err
+		}
+		ev.TenantID = tenant.ID
 
-	// 3. 创建用户并关联角色
-	user := model.User{
-		Username: req.Username,
-		Password: req.Password, // 实际生产应 bcrypt 加密
-		Roles:    []model.Role{userRole},
-	}
-	if err := model.DB.Create(&user).Error; err != nil {
+		// 1. 检查用户是否已存在，保留期内已删除的用户仍占用用户名
+		var count int64
+		if err := tx.Unscoped().Model(&model.User{}).
+			Scopes(model.TenantScope(tenant.ID)).
+			Where("username = ?", req.Username).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return errors.New("用户名已存在")
+		}
+
+		// 2. 查找默认角色（user）
+		var userRole model.Role
+		if err := tx.Scopes(model.TenantScope(tenant.ID)).Where("name = ?", "user").First(&userRole).Error; err != nil {
+			return errors.New("默认角色不存在，请初始化数据库")
+		}
+
+		// 3. 创建用户并关联角色
+		user := model.User{
+			TenantID: tenant.ID,
+			Username: req.Username,
+			Roles:    []model.Role{userRole},
+		}
+		if err := s.setPassword(tx, &user, "password", req.Password); err != nil {
+			return err
+		}
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		if err := s.recordPasswordHistory(tx, &user); err != nil {
+			return err
+		}
+		ev.Target = audit.Target("user", user.ID)
+		ev.After = audit.Snapshot(user)
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 
@@ -114,40 +333,69 @@ func (s *Service) Register(ctx context.Context, req *api.RegisterRequest) (*api.
 }
 
 func (s *Service) ListUsers(ctx context.Context, req *api.ListUsersRequest) (*api.ListUsersResponse, error) {
+	db, tenantID, err := scoped(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.IncludeDeleted {
+		db = db.Unscoped()
+	}
+
+	db, err = filterUsers(db, tenantID, req.Filter)
+	if err != nil {
+		return nil, err
+	}
+
 	var users []model.User
-	if err := model.DB.Preload("Roles").Find(&users).Error; err != nil {
+	if err := db.Preload("Roles").Preload("Attributes").Find(&users).Error; err != nil {
+		return nil, err
+	}
+	locked, err := s.lockedUsers(tenantID, users)
+	if err != nil {
 		return nil, err
 	}
 
 	var userInfos []*api.UserInfo
-	for _, user := range users {
-		var roleNames []string
-		for _, r := range user.Roles {
-			roleNames = append(roleNames, r.Name)
-		}
-		userInfos = append(userInfos, &api.UserInfo{
-			Username: user.Username,
-			Roles:    roleNames,
-		})
+	for i := range users {
+		userInfos = append(userInfos, toUserInfo(&users[i], locked))
 	}
 
 	return &api.ListUsersResponse{Users: userInfos}, nil
 }
 
 func (s *Service) CreatePermission(ctx context.Context, req *api.CreatePermissionRequest) (*api.CreatePermissionResponse, error) {
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	p := model.Permission{
+		TenantID:    tenantID,
 		Name:        req.Name,
 		Description: req.Description,
 	}
-	if err := model.DB.Create(&p).Error; err != nil {
+	err = withAudit(ctx, "CreatePermission", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		if err := tx.Create(&p).Error; err != nil {
+			return err
+		}
+		ev.Target = audit.Target("permission", p.ID)
+		ev.After = audit.Snapshot(p)
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 	return &api.CreatePermissionResponse{Id: uint32(p.ID)}, nil
 }
 
 func (s *Service) ListPermissions(ctx context.Context, req *api.ListPermissionsRequest) (*api.ListPermissionsResponse, error) {
+	db, _, err := scoped(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var perms []model.Permission
-	if err := model.DB.Find(&perms).Error; err != nil {
+	if err := db.Find(&perms).Error; err != nil {
 		return nil, err
 	}
 	var permInfos []*api.PermissionInfo
@@ -162,11 +410,25 @@ func (s *Service) ListPermissions(ctx context.Context, req *api.ListPermissionsR
 }
 
 func (s *Service) CreateRole(ctx context.Context, req *api.CreateRoleRequest) (*api.CreateRoleResponse, error) {
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	role := model.Role{
+		TenantID:    tenantID,
 		Name:        req.Name,
 		Description: req.Description,
 	}
-	if err := model.DB.Create(&role).Error; err != nil {
+	err = withAudit(ctx, "CreateRole", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		if err := tx.Create(&role).Error; err != nil {
+			return err
+		}
+		ev.Target = audit.Target("role", role.ID)
+		ev.After = audit.Snapshot(role)
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 	return &api.CreateRoleResponse{
@@ -176,34 +438,111 @@ func (s *Service) CreateRole(ctx context.Context, req *api.CreateRoleRequest) (*
 }
 
 func (s *Service) AssignPermissions(ctx context.Context, req *api.AssignPermissionsRequest) (*api.AssignPermissionsResponse, error) {
-	var role model.Role
-	if err := model.DB.Preload("Permissions").First(&role, req.RoleId).Error; err != nil {
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
 		return nil, err
 	}
 
-	var permissions []model.Permission
-	if err := model.DB.Where("id IN ?", req.PermissionIds).Find(&permissions).Error; err != nil {
+	err = withAudit(ctx, "AssignPermissions", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		ev.Target = audit.Target("role", uint(req.RoleId))
+
+		var role model.Role
+		if err := tx.Scopes(model.TenantScope(tenantID)).Preload("Permissions").First(&role, req.RoleId).Error; err != nil {
+			return err
+		}
+		ev.Before = audit.Snapshot(role)
+
+		// 只允许分配同租户的权限
+		var permissions []model.Permission
+		if err := tx.Scopes(model.TenantScope(tenantID)).Where("id IN ?", req.PermissionIds).Find(&permissions).Error; err != nil {
+			return err
+		}
+		if len(permissions) != len(uniqueIDs(req.PermissionIds)) {
+			return status.Error(codes.NotFound, "权限不存在或不属于当前租户")
+		}
+
+		if err := tx.Model(&role).Association("Permissions").Replace(&permissions); err != nil {
+			return err
+		}
+		role.Permissions = permissions
+		ev.After = audit.Snapshot(role)
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	if err := model.DB.Model(&role).Association("Permissions").Replace(&permissions); err != nil {
+	return &api.AssignPermissionsResponse{Message: "权限分配成功"}, nil
+}
+
+// SetGrantCondition 设置角色权限授予的条件表达式，留空表示无条件授予
+func (s *Service) SetGrantCondition(ctx context.Context, req *api.SetGrantConditionRequest) (*api.SetGrantConditionResponse, error) {
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
 		return nil, err
 	}
+	if req.Condition != "" {
+		if _, err := abac.Compile(req.Condition); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "条件表达式无效: %v", err)
+		}
+	}
 
-	return &api.AssignPermissionsResponse{Message: "权限分配成功"}, nil
+	err = withAudit(ctx, "SetGrantCondition", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		ev.Target = audit.Target("role", uint(req.RoleId))
+
+		var role model.Role
+		if err := tx.Scopes(model.TenantScope(tenantID)).First(&role, req.RoleId).Error; err != nil {
+			return err
+		}
+		var grant model.RolePermission
+		if err := tx.Where("role_id = ? AND permission_id = ?", role.ID, req.PermissionId).First(&grant).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return status.Error(codes.NotFound, "角色未被授予该权限")
+			}
+			return err
+		}
+		ev.Before = audit.Snapshot(grant)
+
+		grant.Condition = req.Condition
+		if err := tx.Model(&grant).Where("role_id = ? AND permission_id = ?", grant.RoleID, grant.PermissionID).
+			Update("condition_expr", grant.Condition).Error; err != nil {
+			return err
+		}
+		ev.After = audit.Snapshot(grant)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.SetGrantConditionResponse{Message: "授予条件设置成功"}, nil
 }
 
 func (s *Service) GetRolePermissions(ctx context.Context, req *api.GetRolePermissionsRequest) (*api.GetRolePermissionsResponse, error) {
+	db, _, err := scoped(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var role model.Role
-	if err := model.DB.Preload("Permissions").First(&role, req.RoleId).Error; err != nil {
+	if err := db.Preload("Permissions").First(&role, req.RoleId).Error; err != nil {
+		return nil, err
+	}
+
+	var grants []model.RolePermission
+	if err := model.DB.Where("role_id = ?", role.ID).Find(&grants).Error; err != nil {
 		return nil, err
 	}
+	conditions := make(map[uint]string, len(grants))
+	for _, g := range grants {
+		conditions[g.PermissionID] = g.Condition
+	}
 
 	permissions := make([]*api.PermissionInfo, 0, len(role.Permissions))
 	for _, perm := range role.Permissions {
 		permissions = append(permissions, &api.PermissionInfo{
-			Id:   uint32(perm.ID),
-			Name: perm.Name,
+			Id:        uint32(perm.ID),
+			Name:      perm.Name,
+			Condition: conditions[perm.ID],
 		})
 	}
 
@@ -211,11 +550,34 @@ func (s *Service) GetRolePermissions(ctx context.Context, req *api.GetRolePermis
 }
 
 func (s *Service) CreateUser(ctx context.Context, req *api.CreateUserRequest) (*api.CreateUserResponse, error) {
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	user := model.User{
-		Username: req.Username,
-		Password: req.Password, // 生产环境需加密
+		TenantID:    tenantID,
+		Username:    req.Username,
+		DisplayName: req.DisplayName,
 	}
-	if err := model.DB.Create(&user).Error; err != nil {
+	err = withAudit(ctx, "CreateUser", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		if err := setUserEmail(tx, &user, req.Email); err != nil {
+			return err
+		}
+		if err := s.setPassword(tx, &user, "password", req.Password); err != nil {
+			return err
+		}
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		if err := s.recordPasswordHistory(tx, &user); err != nil {
+			return err
+		}
+		ev.Target = audit.Target("user", user.ID)
+		ev.After = audit.Snapshot(user)
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 	return &api.CreateUserResponse{
@@ -225,23 +587,89 @@ func (s *Service) CreateUser(ctx context.Context, req *api.CreateUserRequest) (*
 }
 
 func (s *Service) UpdateUser(ctx context.Context, req *api.UpdateUserRequest) (*api.UpdateUserResponse, error) {
-	var user model.User
-	if err := model.DB.First(&user, req.UserId).Error; err != nil {
-		return nil, err
-	}
-	user.Username = req.Username
+	// 直接设置密码不校验当前密码，仅租户管理员可用；用户修改自己的密码应调用 ChangePassword
 	if req.Password != "" {
-		user.Password = req.Password
+		if err := requireTenantAdmin(ctx); err != nil {
+			return nil, err
+		}
 	}
-	if err := model.DB.Save(&user).Error; err != nil {
+	claims, err := callerClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = withAudit(ctx, "UpdateUser", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		ev.Target = audit.Target("user", uint(req.UserId))
+
+		var user model.User
+		if err := tx.Scopes(model.TenantScope(claims.TenantID)).First(&user, req.UserId).Error; err != nil {
+			return err
+		}
+		ev.Before = audit.Snapshot(user)
+
+		// 非管理员只能修改自己的邮箱
+		if req.Email != nil && user.Username != claims.Username {
+			if err := requireTenantAdmin(ctx); err != nil {
+				return status.Error(codes.PermissionDenied, "只能修改自己的邮箱")
+			}
+		}
+
+		user.Username = req.Username
+		if req.DisplayName != nil {
+			user.DisplayName = *req.DisplayName
+		}
+		if req.Email != nil {
+			if err := setUserEmail(tx, &user, *req.Email); err != nil {
+				return err
+			}
+		}
+		if req.Password != "" {
+			if err := s.setPassword(tx, &user, "password", req.Password); err != nil {
+				return err
+			}
+		}
+		if err := tx.Save(&user).Error; err != nil {
+			return err
+		}
+		if req.Password != "" {
+			if err := s.recordPasswordHistory(tx, &user); err != nil {
+				return err
+			}
+			if err := model.RevokeUserSessions(tx, user.ID, ""); err != nil {
+				return err
+			}
+		}
+		ev.After = audit.Snapshot(user)
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 	return &api.UpdateUserResponse{Message: "用户更新成功"}, nil
 }
 
+// DeleteUser 管理员软删除用户并撤销其会话，保留期内可通过 RestoreUser 恢复，之后由清理任务彻底删除；不能删除自己
 func (s *Service) DeleteUser(ctx context.Context, req *api.DeleteUserRequest) (*api.DeleteUserResponse, error) {
-	err := model.DB.Transaction(func(tx *gorm.DB) error {
-		return model.DeleteUserWithRelations(tx, uint(req.UserId))
+	if err := requireTenantAdmin(ctx); err != nil {
+		return nil, err
+	}
+	claims, err := callerClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = withAudit(ctx, "DeleteUser", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		ev.Target = audit.Target("user", uint(req.UserId))
+
+		var user model.User
+		if err := tx.Scopes(model.TenantScope(claims.TenantID)).Preload("Roles").First(&user, req.UserId).Error; err != nil {
+			return err
+		}
+		if user.Username == claims.Username {
+			return status.Error(codes.InvalidArgument, "不能删除自己")
+		}
+		ev.Before = audit.Snapshot(user)
+		return softDeleteUser(tx, &user, statusSourceAdmin)
 	})
 	if err != nil {
 		return nil, err
@@ -250,8 +678,13 @@ func (s *Service) DeleteUser(ctx context.Context, req *api.DeleteUserRequest) (*
 }
 
 func (s *Service) GetUser(ctx context.Context, req *api.GetUserRequest) (*api.GetUserResponse, error) {
+	db, _, err := scoped(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var user model.User
-	if err := model.DB.Preload("Roles").First(&user, req.UserId).Error; err != nil {
+	if err := db.Preload("Roles").First(&user, req.UserId).Error; err != nil {
 		return nil, err
 	}
 	roles := make([]string, len(user.Roles))
@@ -263,3 +696,16 @@ func (s *Service) GetUser(ctx context.Context, req *api.GetUserRequest) (*api.Ge
 		Roles:    roles,
 	}, nil
 }
+
+// uniqueIDs 去重后的 ID 列表
+func uniqueIDs(ids []uint32) []uint32 {
+	seen := make(map[uint32]bool, len(ids))
+	out := make([]uint32, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
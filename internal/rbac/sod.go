@@ -0,0 +1,339 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/audit"
+	"grpc-rbac-backend/internal/model"
+)
+
+// sodViolation 一个用户违反的一条职责分离约束
+type sodViolation struct {
+	UserID     uint
+	Constraint *model.SodConstraint
+	Roles      []model.Role
+}
+
+func (v sodViolation) roleNames() []string {
+	names := make([]string, 0, len(v.Roles))
+	for _, r := range v.Roles {
+		names = append(names, r.Name)
+	}
+	return names
+}
+
+func (v sodViolation) String() string {
+	return fmt.Sprintf("约束 %s 最多允许同时拥有 %d 个角色，用户 %d 拥有 %s",
+		v.Constraint.Name, v.Constraint.MaxRoles, v.UserID, strings.Join(v.roleNames(), "、"))
+}
+
+// heldRoleSets 用户当前及将来会拥有的角色（未过期的直接分配，含尚未生效的，以及通过用户组继承的）
+func heldRoleSets(tx *gorm.DB, tenantID uint, userIDs []uint) (map[uint]map[uint]bool, error) {
+	sets := make(map[uint]map[uint]bool, len(userIDs))
+	for _, id := range userIDs {
+		sets[id] = make(map[uint]bool)
+	}
+	if len(userIDs) == 0 {
+		return sets, nil
+	}
+
+	var assignments []model.UserRole
+	if err := tx.Where("user_id IN ? AND (expires_at IS NULL OR expires_at > ?)", userIDs, time.Now()).
+		Find(&assignments).Error; err != nil {
+		return nil, err
+	}
+	for _, ur := range assignments {
+		sets[ur.UserID][ur.RoleID] = true
+	}
+
+	var memberships []struct {
+		GroupID uint
+		UserID  uint
+	}
+	if err := tx.Table("group_users").Select("group_id, user_id").Where("user_id IN ?", userIDs).Scan(&memberships).Error; err != nil {
+		return nil, err
+	}
+	if len(memberships) == 0 {
+		return sets, nil
+	}
+	graph, err := loadGroupGraph(tx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	direct := make(map[uint][]uint)
+	for _, m := range memberships {
+		direct[m.UserID] = append(direct[m.UserID], m.GroupID)
+	}
+	userGroups := make(map[uint]map[uint][]string, len(direct))
+	var allGroups []uint
+	for userID, groups := range direct {
+		userGroups[userID] = graph.ancestors(groups)
+		for id := range userGroups[userID] {
+			allGroups = append(allGroups, id)
+		}
+	}
+	var groupRoles []struct {
+		GroupID uint
+		RoleID  uint
+	}
+	if err := tx.Table("group_roles").Select("group_id, role_id").Where("group_id IN ?", allGroups).Scan(&groupRoles).Error; err != nil {
+		return nil, err
+	}
+	rolesByGroup := make(map[uint][]uint)
+	for _, gr := range groupRoles {
+		rolesByGroup[gr.GroupID] = append(rolesByGroup[gr.GroupID], gr.RoleID)
+	}
+	for userID, groups := range userGroups {
+		for groupID := range groups {
+			for _, roleID := range rolesByGroup[groupID] {
+				sets[userID][roleID] = true
+			}
+		}
+	}
+	return sets, nil
+}
+
+// findSodViolations 检查用户是否违反本租户的职责分离约束
+func findSodViolations(tx *gorm.DB, tenantID uint, userIDs []uint) ([]sodViolation, error) {
+	var constraints []model.SodConstraint
+	if err := tx.Scopes(model.TenantScope(tenantID)).Preload("Roles").Order("id").Find(&constraints).Error; err != nil {
+		return nil, err
+	}
+	if len(constraints) == 0 {
+		return nil, nil
+	}
+	sets, err := heldRoleSets(tx, tenantID, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var violations []sodViolation
+	for _, userID := range userIDs {
+		for i := range constraints {
+			if held, ok := constraints[i].Violated(sets[userID]); ok {
+				violations = append(violations, sodViolation{UserID: userID, Constraint: &constraints[i], Roles: held})
+			}
+		}
+	}
+	return violations, nil
+}
+
+// sodGuard 在角色变更前记录用户已有的违规，变更后只拒绝新增的违规，
+// 避免约束创建前遗留的违规阻塞无关的变更
+type sodGuard struct {
+	tenantID uint
+	userIDs  []uint
+	before   map[string]int
+}
+
+func sodKey(v sodViolation) string {
+	return fmt.Sprintf("%d/%d", v.UserID, v.Constraint.ID)
+}
+
+// beginSodGuard 须在同一事务中、写入角色变更之前调用。
+// 先锁定本租户的约束，使涉及约束的角色变更（直接分配、用户组成员和角色等）串行执行，
+// 避免并发的变更各自通过校验后共同形成违规
+func beginSodGuard(tx *gorm.DB, tenantID uint, userIDs []uint) (*sodGuard, error) {
+	var locked []uint
+	if err := tx.Model(&model.SodConstraint{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+		Scopes(model.TenantScope(tenantID)).Order("id").Pluck("id", &locked).Error; err != nil {
+		return nil, err
+	}
+	violations, err := findSodViolations(tx, tenantID, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	g := &sodGuard{tenantID: tenantID, userIDs: userIDs, before: make(map[string]int, len(violations))}
+	for _, v := range violations {
+		g.before[sodKey(v)] = len(v.Roles)
+	}
+	return g, nil
+}
+
+// check 在写入角色变更后、事务提交前调用，出现新的违规时返回 FailedPrecondition 使事务回滚
+func (g *sodGuard) check(tx *gorm.DB) error {
+	violations, err := findSodViolations(tx, g.tenantID, g.userIDs)
+	if err != nil {
+		return err
+	}
+
+	var msgs []string
+	failure := &errdetails.PreconditionFailure{}
+	for _, v := range violations {
+		if n, ok := g.before[sodKey(v)]; ok && len(v.Roles) <= n {
+			continue
+		}
+		msgs = append(msgs, v.String())
+		failure.Violations = append(failure.Violations, &errdetails.PreconditionFailure_Violation{
+			Type:        "SOD",
+			Subject:     fmt.Sprintf("user:%d", v.UserID),
+			Description: fmt.Sprintf("%s: %s", v.Constraint.Name, strings.Join(v.roleNames(), ",")),
+		})
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	st := status.New(codes.FailedPrecondition, "违反职责分离约束："+strings.Join(msgs, "；"))
+	if detailed, err := st.WithDetails(failure); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// groupSubtreeMembers 组及其所有下级组的成员
+func groupSubtreeMembers(tx *gorm.DB, tenantID, groupID uint) ([]uint, error) {
+	graph, err := loadGroupGraph(tx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	groupIDs := make([]uint, 0)
+	for id := range graph.descendants([]uint{groupID}) {
+		groupIDs = append(groupIDs, id)
+	}
+	var userIDs []uint
+	err = tx.Table("group_users").Where("group_id IN ?", groupIDs).Distinct().Pluck("user_id", &userIDs).Error
+	return userIDs, err
+}
+
+// CreateSodConstraint 租户管理员创建职责分离约束，已有的违规分配不受影响，可通过 ListSodViolations 查看
+func (s *Service) CreateSodConstraint(ctx context.Context, req *api.CreateSodConstraintRequest) (*api.CreateSodConstraintResponse, error) {
+	if err := requireTenantAdmin(ctx); err != nil {
+		return nil, err
+	}
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	maxRoles := int(req.MaxRoles)
+	if maxRoles == 0 {
+		maxRoles = 1
+	}
+	if len(uniqueIDs(req.RoleIds)) <= maxRoles {
+		return nil, status.Error(codes.InvalidArgument, "约束的角色数必须大于允许同时拥有的数量")
+	}
+
+	constraint := model.SodConstraint{
+		TenantID:    tenantID,
+		Name:        req.Name,
+		Description: req.Description,
+		MaxRoles:    maxRoles,
+	}
+	err = withAudit(ctx, "CreateSodConstraint", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		if err := tx.Scopes(model.TenantScope(tenantID)).Find(&constraint.Roles, req.RoleIds).Error; err != nil {
+			return err
+		}
+		if len(constraint.Roles) != len(uniqueIDs(req.RoleIds)) {
+			return status.Error(codes.NotFound, "角色不存在或不属于当前租户")
+		}
+		if err := tx.Create(&constraint).Error; err != nil {
+			return err
+		}
+		ev.Target = audit.Target("sod-constraint", constraint.ID)
+		ev.After = audit.Snapshot(constraint)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.CreateSodConstraintResponse{Message: "职责分离约束创建成功", ConstraintId: uint32(constraint.ID)}, nil
+}
+
+// ListSodConstraints 列出本租户的职责分离约束
+func (s *Service) ListSodConstraints(ctx context.Context, req *api.ListSodConstraintsRequest) (*api.ListSodConstraintsResponse, error) {
+	db, _, err := scoped(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var constraints []model.SodConstraint
+	if err := db.Preload("Roles").Order("id").Find(&constraints).Error; err != nil {
+		return nil, err
+	}
+	infos := make([]*api.SodConstraintInfo, 0, len(constraints))
+	for _, c := range constraints {
+		roles := make([]string, 0, len(c.Roles))
+		for _, r := range c.Roles {
+			roles = append(roles, r.Name)
+		}
+		infos = append(infos, &api.SodConstraintInfo{
+			Id:          uint32(c.ID),
+			Name:        c.Name,
+			Description: c.Description,
+			MaxRoles:    uint32(c.MaxRoles),
+			Roles:       roles,
+		})
+	}
+	return &api.ListSodConstraintsResponse{Constraints: infos}, nil
+}
+
+// DeleteSodConstraint 租户管理员删除职责分离约束
+func (s *Service) DeleteSodConstraint(ctx context.Context, req *api.DeleteSodConstraintRequest) (*api.DeleteSodConstraintResponse, error) {
+	if err := requireTenantAdmin(ctx); err != nil {
+		return nil, err
+	}
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = withAudit(ctx, "DeleteSodConstraint", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		ev.Target = audit.Target("sod-constraint", uint(req.ConstraintId))
+
+		var constraint model.SodConstraint
+		if err := tx.Scopes(model.TenantScope(tenantID)).Preload("Roles").First(&constraint, req.ConstraintId).Error; err != nil {
+			return err
+		}
+		ev.Before = audit.Snapshot(constraint)
+		if err := tx.Model(&constraint).Association("Roles").Clear(); err != nil {
+			return err
+		}
+		return tx.Delete(&constraint).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.DeleteSodConstraintResponse{Message: "职责分离约束删除成功"}, nil
+}
+
+// ListSodViolations 列出本租户当前违反职责分离约束的用户，如约束创建前已存在的分配
+func (s *Service) ListSodViolations(ctx context.Context, req *api.ListSodViolationsRequest) (*api.ListSodViolationsResponse, error) {
+	db, tenantID, err := scoped(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var users []model.User
+	if err := db.Select("id", "username").Order("id").Find(&users).Error; err != nil {
+		return nil, err
+	}
+	usernames := make(map[uint]string, len(users))
+	userIDs := make([]uint, 0, len(users))
+	for _, u := range users {
+		usernames[u.ID] = u.Username
+		userIDs = append(userIDs, u.ID)
+	}
+
+	violations, err := findSodViolations(model.DB, tenantID, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]*api.SodViolation, 0, len(violations))
+	for _, v := range violations {
+		infos = append(infos, &api.SodViolation{
+			UserId:         uint32(v.UserID),
+			Username:       usernames[v.UserID],
+			ConstraintId:   uint32(v.Constraint.ID),
+			ConstraintName: v.Constraint.Name,
+			MaxRoles:       uint32(v.Constraint.MaxRoles),
+			Roles:          v.roleNames(),
+		})
+	}
+	return &api.ListSodViolationsResponse{Violations: infos}, nil
+}
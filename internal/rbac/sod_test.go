@@ -0,0 +1,174 @@
+package rbac
+
+import (
+	"fmt"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/model"
+)
+
+func (tt *testTenant) createSodConstraint(t *testing.T, s *Service, name string, maxRoles uint32, roles ...*model.Role) uint32 {
+	t.Helper()
+	req := &api.CreateSodConstraintRequest{Name: name, MaxRoles: maxRoles}
+	for _, r := range roles {
+		req.RoleIds = append(req.RoleIds, uint32(r.ID))
+	}
+	resp, err := s.CreateSodConstraint(tt.adminCtx(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.ConstraintId
+}
+
+// wantSodViolation 断言 err 为违反职责分离约束，错误详情列出冲突的用户
+func wantSodViolation(t *testing.T, err error, user *model.User) {
+	t.Helper()
+	wantCode(t, err, codes.FailedPrecondition)
+	for _, d := range status.Convert(err).Details() {
+		if failure, ok := d.(*errdetails.PreconditionFailure); ok {
+			for _, v := range failure.Violations {
+				if v.Type == "SOD" && v.Subject == fmt.Sprintf("user:%d", user.ID) {
+					return
+				}
+			}
+		}
+	}
+	t.Fatalf("错误详情未列出用户 %s: %v", user.Username, err)
+}
+
+func TestSodDirectAssignment(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	create, approve := tt.createRole(t, "payments-create"), tt.createRole(t, "payments-approve")
+	tt.createSodConstraint(t, s, "payments", 0, create, approve)
+	user := tt.createUser(t, "alice")
+	ctx := tt.adminCtx()
+
+	if _, err := s.AssignUserRole(ctx, &api.AssignUserRoleRequest{UserId: uint32(user.ID), RoleId: uint32(create.ID)}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.AssignUserRole(ctx, &api.AssignUserRoleRequest{UserId: uint32(user.ID), RoleId: uint32(approve.ID)})
+	wantSodViolation(t, err, user)
+	if got := directRoles(t, user.ID); len(got) != 1 || got[0] != "payments-create" {
+		t.Errorf("违规的分配未回滚: %v", got)
+	}
+}
+
+func TestSodGroupAssignment(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	create, approve := tt.createRole(t, "payments-create"), tt.createRole(t, "payments-approve")
+	tt.createSodConstraint(t, s, "payments", 1, create, approve)
+	user := tt.createUser(t, "alice")
+	tt.grant(t, user, create)
+	ctx := tt.adminCtx()
+	approvers, finance := tt.createGroup(t, s, "approvers"), tt.createGroup(t, s, "finance")
+	if _, err := s.AssignGroupRoles(ctx, &api.AssignGroupRolesRequest{GroupId: approvers, RoleIds: []uint32{uint32(approve.ID)}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 加入拥有冲突角色的组
+	_, err := s.AddGroupMembers(ctx, &api.AddGroupMembersRequest{GroupId: approvers, UserIds: []uint32{uint32(user.ID)}})
+	wantSodViolation(t, err, user)
+
+	// 所在组成为拥有冲突角色的组的子组
+	if _, err := s.AddGroupMembers(ctx, &api.AddGroupMembersRequest{GroupId: finance, UserIds: []uint32{uint32(user.ID)}}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.AddSubgroup(ctx, &api.AddSubgroupRequest{GroupId: approvers, ChildGroupId: finance})
+	wantSodViolation(t, err, user)
+
+	// 所在组被分配冲突角色
+	_, err = s.AssignGroupRoles(ctx, &api.AssignGroupRolesRequest{GroupId: finance, RoleIds: []uint32{uint32(approve.ID)}})
+	wantSodViolation(t, err, user)
+
+	grants, err := effectiveRoles(model.DB, tt.ID, user.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grants) != 1 || grants[0].Role.ID != create.ID {
+		t.Errorf("违规的变更未回滚: %+v", grants)
+	}
+}
+
+// maxRoles 为 N 时允许同时拥有 N 个角色
+func TestSodMaxRoles(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	a, b, c := tt.createRole(t, "a"), tt.createRole(t, "b"), tt.createRole(t, "c")
+	_, err := s.CreateSodConstraint(tt.adminCtx(), &api.CreateSodConstraintRequest{
+		Name: "abc", MaxRoles: 3, RoleIds: []uint32{uint32(a.ID), uint32(b.ID), uint32(c.ID)},
+	})
+	wantCode(t, err, codes.InvalidArgument)
+	tt.createSodConstraint(t, s, "abc", 2, a, b, c)
+	user := tt.createUser(t, "alice")
+	ctx := tt.adminCtx()
+
+	for _, r := range []*model.Role{a, b} {
+		if _, err := s.AssignUserRole(ctx, &api.AssignUserRoleRequest{UserId: uint32(user.ID), RoleId: uint32(r.ID)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = s.AssignUserRole(ctx, &api.AssignUserRoleRequest{UserId: uint32(user.ID), RoleId: uint32(c.ID)})
+	wantSodViolation(t, err, user)
+}
+
+// 约束创建前已存在的违规出现在报告中，且不阻塞无关的变更
+func TestListSodViolations(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	create, approve := tt.createRole(t, "payments-create"), tt.createRole(t, "payments-approve")
+	viewer := tt.createRole(t, "viewer")
+	alice, bob := tt.createUser(t, "alice"), tt.createUser(t, "bob")
+	tt.grant(t, alice, create)
+	tt.grant(t, alice, approve)
+	tt.grant(t, bob, create)
+	id := tt.createSodConstraint(t, s, "payments", 1, create, approve)
+	ctx := tt.adminCtx()
+
+	resp, err := s.ListSodViolations(ctx, &api.ListSodViolationsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Violations) != 1 {
+		t.Fatalf("违规 = %v，期望仅 alice", resp.Violations)
+	}
+	v := resp.Violations[0]
+	if v.Username != "alice" || v.ConstraintId != id || v.MaxRoles != 1 || len(v.Roles) != 2 {
+		t.Errorf("违规 = %+v", v)
+	}
+
+	if _, err := s.AssignUserRole(ctx, &api.AssignUserRoleRequest{UserId: uint32(alice.ID), RoleId: uint32(viewer.ID)}); err != nil {
+		t.Errorf("已有违规阻塞了无关的分配: %v", err)
+	}
+
+	if _, err := s.DeleteSodConstraint(ctx, &api.DeleteSodConstraintRequest{ConstraintId: id}); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err = s.ListSodViolations(ctx, &api.ListSodViolationsRequest{}); err != nil || len(resp.Violations) != 0 {
+		t.Errorf("删除约束后仍有违规: %v %v", resp, err)
+	}
+}
+
+// 普通用户不能删除限制自己的约束
+func TestSodConstraintsRequireTenantAdmin(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	tt := newTestTenant(t)
+	create, approve := tt.createRole(t, "payments-create"), tt.createRole(t, "payments-approve")
+	id := tt.createSodConstraint(t, s, "payments", 1, create, approve)
+	ctx := tt.ctx("mallory", "user")
+
+	_, err := s.CreateSodConstraint(ctx, &api.CreateSodConstraintRequest{Name: "x", RoleIds: []uint32{uint32(create.ID), uint32(approve.ID)}})
+	wantCode(t, err, codes.PermissionDenied)
+	_, err = s.DeleteSodConstraint(ctx, &api.DeleteSodConstraintRequest{ConstraintId: id})
+	wantCode(t, err, codes.PermissionDenied)
+	var n int64
+	if err := model.DB.Model(&model.SodConstraint{}).Where("id = ?", id).Count(&n).Error; err != nil || n != 1 {
+		t.Errorf("约束被删除: %d %v", n, err)
+	}
+}
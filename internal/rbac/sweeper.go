@@ -0,0 +1,88 @@
+package rbac
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+
+	"grpc-rbac-backend/internal/audit"
+	"grpc-rbac-backend/internal/model"
+)
+
+const sweepBatchSize = 100
+
+type sweepTask struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// sweepTasks 后台定期执行的清理任务
+func (s *Service) sweepTasks() []sweepTask {
+	return []sweepTask{
+		{"过期角色分配", sweepExpiredUserRoles},
+		{"过期权限申请", sweepAccessRequests},
+		{"到期审核活动", sweepDueReviewCampaigns},
+		{"登录失败记录", s.sweepLoginThrottles},
+		{"过期会话", sweepSessions},
+		{"已删除用户", s.sweepDeletedUsers},
+	}
+}
+
+// StartSweeper 按配置的间隔定期执行清理任务，ctx 取消后退出；间隔不大于 0 时不启动
+func (s *Service) StartSweeper(ctx context.Context) {
+	if s.cfg.SweepInterval <= 0 {
+		log.Printf("⚠️ SWEEP_INTERVAL 为 %v，不启动后台清理任务", s.cfg.SweepInterval)
+		return
+	}
+	tasks := s.sweepTasks()
+	go func() {
+		ticker := time.NewTicker(s.cfg.SweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				for _, task := range tasks {
+					if err := task.run(ctx); err != nil {
+						log.Printf("❌ 清理%s失败: %v", task.name, err)
+					}
+				}
+			}
+		}
+	}()
+}
+
+// sweepExpiredUserRoles 删除已过期的角色分配，每条分配单独写一条审计记录
+func sweepExpiredUserRoles(ctx context.Context) error {
+	for {
+		var expired []model.UserRole
+		if err := model.DB.Where("expires_at IS NOT NULL AND expires_at <= ?", time.Now()).
+			Limit(sweepBatchSize).Find(&expired).Error; err != nil {
+			return err
+		}
+		for i := range expired {
+			ur := expired[i]
+			err := withAudit(ctx, "ExpireUserRole", func(tx *gorm.DB, ev *model.AuditEvent) error {
+				ev.Target = audit.Target("user", ur.UserID)
+				ev.Before = audit.Snapshot(ur)
+
+				var role model.Role
+				if err := tx.Select("id", "tenant_id").First(&role, ur.RoleID).Error; err == nil {
+					ev.TenantID = role.TenantID
+				}
+				// 带上过期条件，避免误删在此期间被续期的分配
+				return tx.Where("user_id = ? AND role_id = ? AND expires_at <= ?", ur.UserID, ur.RoleID, time.Now()).
+					Delete(&model.UserRole{}).Error
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if len(expired) < sweepBatchSize || ctx.Err() != nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/audit"
+	"grpc-rbac-backend/internal/middleware"
+	"grpc-rbac-backend/internal/model"
+	"grpc-rbac-backend/internal/utils"
+)
+
+// callerClaims 取出拦截器放入 context 的 token 信息
+func callerClaims(ctx context.Context) (*utils.CustomClaims, error) {
+	claims, ok := ctx.Value(middleware.ContextUserKey).(*utils.CustomClaims)
+	if !ok || claims.TenantID == 0 {
+		return nil, status.Error(codes.Unauthenticated, "缺少租户信息，请重新登录")
+	}
+	return claims, nil
+}
+
+// callerTenant 调用方所属租户，所有已认证接口的查询都限定在该租户内
+func callerTenant(ctx context.Context) (uint, error) {
+	claims, err := callerClaims(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return claims.TenantID, nil
+}
+
+// scoped 返回限定在调用方租户内、可重复使用的查询
+func scoped(ctx context.Context) (*gorm.DB, uint, error) {
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	return model.DB.Scopes(model.TenantScope(tenantID)).Session(&gorm.Session{}), tenantID, nil
+}
+
+// resolveTenant 按名称查找租户，名称为空时使用默认租户，供登录、注册等未认证接口使用
+func resolveTenant(tx *gorm.DB, name string) (*model.Tenant, error) {
+	if name == "" {
+		name = model.DefaultTenantName
+	}
+	var tenant model.Tenant
+	if err := tx.Where("name = ?", name).First(&tenant).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("租户不存在")
+		}
+		return nil, err
+	}
+	return &tenant, nil
+}
+
+// requirePlatformAdmin 仅允许默认租户的管理员执行跨租户操作
+func requirePlatformAdmin(ctx context.Context) error {
+	claims, err := callerClaims(ctx)
+	if err != nil {
+		return err
+	}
+	if claims.Tenant != model.DefaultTenantName || !hasRole(claims.Roles, "admin") || claims.Scoped() {
+		return status.Error(codes.PermissionDenied, "需要平台管理员权限")
+	}
+	return nil
+}
+
+// requireTenantAdmin 仅允许本租户的管理员执行
+func requireTenantAdmin(ctx context.Context) error {
+	claims, err := callerClaims(ctx)
+	if err != nil {
+		return err
+	}
+	// 限定了权限范围的 API Key 等调用方不能执行管理操作
+	if !hasRole(claims.Roles, "admin") || claims.Scoped() {
+		return status.Error(codes.PermissionDenied, "需要管理员权限")
+	}
+	return nil
+}
+
+func hasRole(roles []string, name string) bool {
+	for _, r := range roles {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateTenant 创建租户及其管理员账号
+func (s *Service) CreateTenant(ctx context.Context, req *api.CreateTenantRequest) (*api.CreateTenantResponse, error) {
+	if err := requirePlatformAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if req.Name == "" || req.AdminUsername == "" {
+		return nil, status.Error(codes.InvalidArgument, "租户名和管理员用户名不能为空")
+	}
+
+	if problems := s.passwords.Validate(req.AdminUsername, req.AdminPassword); len(problems) > 0 {
+		return nil, passwordError("adminPassword", problems)
+	}
+
+	tenant := model.Tenant{Name: req.Name, Description: req.Description}
+	err := withAudit(ctx, "CreateTenant", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		admin, err := model.CreateTenantWithAdmin(tx, &tenant, req.AdminUsername, req.AdminPassword)
+		if err != nil {
+			return err
+		}
+		ev.Target = audit.Target("tenant", tenant.ID)
+		ev.After = audit.Snapshot(map[string]interface{}{"tenant": tenant, "admin": admin})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.CreateTenantResponse{
+		Message:  "租户创建成功",
+		TenantId: uint32(tenant.ID),
+	}, nil
+}
+
+// ListTenants 列出所有租户
+func (s *Service) ListTenants(ctx context.Context, req *api.ListTenantsRequest) (*api.ListTenantsResponse, error) {
+	if err := requirePlatformAdmin(ctx); err != nil {
+		return nil, err
+	}
+	var tenants []model.Tenant
+	if err := model.DB.Order("id").Find(&tenants).Error; err != nil {
+		return nil, err
+	}
+	infos := make([]*api.TenantInfo, 0, len(tenants))
+	for _, t := range tenants {
+		infos = append(infos, &api.TenantInfo{
+			Id:          uint32(t.ID),
+			Name:        t.Name,
+			Description: t.Description,
+		})
+	}
+	return &api.ListTenantsResponse{Tenants: infos}, nil
+}
+
+// currentUser 查询调用方本人
+func currentUser(tx *gorm.DB, ctx context.Context) (*model.User, error) {
+	claims, err := callerClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var user model.User
+	if err := tx.Scopes(model.TenantScope(claims.TenantID)).Where("username = ?", claims.Username).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, status.Error(codes.Unauthenticated, "当前用户不存在")
+		}
+		return nil, err
+	}
+	return &user, nil
+}
@@ -0,0 +1,168 @@
+package rbac
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/model"
+)
+
+// wantNotFound 跨租户访问应表现为记录不存在（拦截器把 ErrRecordNotFound 转为 NotFound）或无权限
+func wantNotFound(t *testing.T, name string, err error) {
+	t.Helper()
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return
+	}
+	if c := status.Code(err); c != codes.NotFound && c != codes.PermissionDenied {
+		t.Errorf("%s: err = %v，期望 NotFound 或 PermissionDenied", name, err)
+	}
+}
+
+func TestTenantIsolationReads(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	a, b := newTestTenant(t), newTestTenant(t)
+	bob := b.createUser(t, "bob")
+	bRole := b.createRole(t, "editor", "docs:write")
+	b.grant(t, bob, bRole)
+	bGroup := model.Group{TenantID: b.ID, Name: "finance"}
+	if err := model.DB.Create(&bGroup).Error; err != nil {
+		t.Fatal(err)
+	}
+	ctx := a.adminCtx()
+
+	_, err := s.GetUser(ctx, &api.GetUserRequest{UserId: uint32(bob.ID)})
+	wantNotFound(t, "GetUser", err)
+	_, err = s.GetUserRoles(ctx, &api.GetUserRolesRequest{UserId: bob.Username})
+	wantNotFound(t, "GetUserRoles", err)
+	_, err = s.GetRolePermissions(ctx, &api.GetRolePermissionsRequest{RoleId: uint32(bRole.ID)})
+	wantNotFound(t, "GetRolePermissions", err)
+	_, err = s.GetGroup(ctx, &api.GetGroupRequest{GroupId: uint32(bGroup.ID)})
+	wantNotFound(t, "GetGroup", err)
+	_, err = s.ListEffectivePermissions(ctx, &api.ListEffectivePermissionsRequest{UserId: uint32(bob.ID)})
+	wantNotFound(t, "ListEffectivePermissions", err)
+	_, err = s.ListUserRoleAssignments(ctx, &api.ListUserRoleAssignmentsRequest{UserId: uint32(bob.ID)})
+	wantNotFound(t, "ListUserRoleAssignments", err)
+	_, err = s.ExplainDecision(ctx, &api.ExplainDecisionRequest{UserId: uint32(bob.ID), Permission: "docs:write"})
+	wantNotFound(t, "ExplainDecision", err)
+	_, err = s.CheckPermission(ctx, &api.CheckPermissionRequest{UserId: strconv.Itoa(int(bob.ID)), Permission: "docs:write"})
+	wantNotFound(t, "CheckPermission", err)
+
+	users, err := s.ListUsers(ctx, &api.ListUsersRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range users.Users {
+		if uint(u.Id) == bob.ID {
+			t.Errorf("ListUsers 返回了其他租户的用户 %s", u.Username)
+		}
+	}
+	perms, err := s.ListPermissions(ctx, &api.ListPermissionsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range perms.Permissions {
+		if p.Name == "docs:write" {
+			t.Errorf("ListPermissions 返回了其他租户的权限 %s", p.Name)
+		}
+	}
+	groups, err := s.ListGroups(ctx, &api.ListGroupsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, g := range groups.Groups {
+		if uint(g.Id) == bGroup.ID {
+			t.Errorf("ListGroups 返回了其他租户的用户组 %s", g.Name)
+		}
+	}
+}
+
+func TestTenantIsolationWrites(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	a, b := newTestTenant(t), newTestTenant(t)
+	alice := a.createUser(t, "alice")
+	aRole := a.createRole(t, "viewer")
+	bob := b.createUser(t, "bob")
+	bRole := b.createRole(t, "editor", "docs:write")
+	b.grant(t, bob, bRole)
+	var bPerm model.Permission
+	if err := model.DB.Scopes(model.TenantScope(b.ID)).Where("name = ?", "docs:write").First(&bPerm).Error; err != nil {
+		t.Fatal(err)
+	}
+	bGroup := model.Group{TenantID: b.ID, Name: "finance"}
+	if err := model.DB.Create(&bGroup).Error; err != nil {
+		t.Fatal(err)
+	}
+	ctx := a.adminCtx()
+
+	_, err := s.UpdateUser(ctx, &api.UpdateUserRequest{UserId: uint32(bob.ID), Username: "mallory"})
+	wantNotFound(t, "UpdateUser", err)
+	_, err = s.DeleteUser(ctx, &api.DeleteUserRequest{UserId: uint32(bob.ID)})
+	wantNotFound(t, "DeleteUser", err)
+	_, err = s.DisableUser(ctx, &api.UserStatusRequest{UserId: uint32(bob.ID)})
+	wantNotFound(t, "DisableUser", err)
+	_, err = s.SetUserAttributes(ctx, &api.SetUserAttributesRequest{UserId: uint32(bob.ID)})
+	wantNotFound(t, "SetUserAttributes", err)
+	// 本租户用户 + 其他租户角色，以及其他租户用户 + 本租户角色
+	_, err = s.AssignUserRole(ctx, &api.AssignUserRoleRequest{UserId: uint32(alice.ID), RoleId: uint32(bRole.ID)})
+	wantNotFound(t, "AssignUserRole(跨租户角色)", err)
+	_, err = s.AssignUserRole(ctx, &api.AssignUserRoleRequest{UserId: uint32(bob.ID), RoleId: uint32(aRole.ID)})
+	wantNotFound(t, "AssignUserRole(跨租户用户)", err)
+	_, err = s.RevokeUserRole(ctx, &api.RevokeUserRoleRequest{UserId: uint32(bob.ID), RoleId: uint32(bRole.ID)})
+	wantNotFound(t, "RevokeUserRole", err)
+	_, err = s.AssignPermissions(ctx, &api.AssignPermissionsRequest{RoleId: uint32(aRole.ID), PermissionIds: []uint32{uint32(bPerm.ID)}})
+	wantNotFound(t, "AssignPermissions(跨租户权限)", err)
+	_, err = s.AssignPermissions(ctx, &api.AssignPermissionsRequest{RoleId: uint32(bRole.ID)})
+	wantNotFound(t, "AssignPermissions(跨租户角色)", err)
+	_, err = s.SetGrantCondition(ctx, &api.SetGrantConditionRequest{RoleId: uint32(bRole.ID), PermissionId: uint32(bPerm.ID), Condition: "false"})
+	wantNotFound(t, "SetGrantCondition", err)
+	_, err = s.AddGroupMembers(ctx, &api.AddGroupMembersRequest{GroupId: uint32(bGroup.ID), UserIds: []uint32{uint32(alice.ID)}})
+	wantNotFound(t, "AddGroupMembers(跨租户用户组)", err)
+	_, err = s.AssignGroupRoles(ctx, &api.AssignGroupRolesRequest{GroupId: uint32(bGroup.ID), RoleIds: []uint32{uint32(bRole.ID)}})
+	wantNotFound(t, "AssignGroupRoles", err)
+
+	// 其他租户的数据保持不变
+	var after model.User
+	if err := model.DB.First(&after, bob.ID).Error; err != nil {
+		t.Fatalf("租户 B 的用户被删除: %v", err)
+	}
+	if after.Username != "bob" || after.Status != model.UserStatusActive {
+		t.Errorf("租户 B 的用户被修改: %+v", after)
+	}
+	if got := directRoles(t, bob.ID); len(got) != 1 || got[0] != "editor" {
+		t.Errorf("租户 B 用户的角色 = %v，期望 [editor]", got)
+	}
+	if got := directRoles(t, alice.ID); len(got) != 0 {
+		t.Errorf("租户 A 用户获得了角色 %v", got)
+	}
+	var rp model.RolePermission
+	if err := model.DB.Where("role_id = ? AND permission_id = ?", bRole.ID, bPerm.ID).First(&rp).Error; err != nil || rp.Condition != "" {
+		t.Errorf("租户 B 的权限授予被修改: %+v, %v", rp, err)
+	}
+	var members int64
+	model.DB.Table("group_users").Where("group_id = ?", bGroup.ID).Count(&members)
+	if members != 0 {
+		t.Errorf("租户 B 的用户组被加入了 %d 个成员", members)
+	}
+}
+
+// 其他租户的同名用户、角色互不冲突
+func TestTenantScopedUniqueness(t *testing.T) {
+	s := newTestService(t, nil, nil)
+	a, b := newTestTenant(t), newTestTenant(t)
+	a.createUser(t, "carol")
+	if _, err := s.CreateUser(b.adminCtx(), &api.CreateUserRequest{Username: "carol", Password: testPassword}); err != nil {
+		t.Fatalf("其他租户的同名用户创建失败: %v", err)
+	}
+	if _, err := s.CreateRole(b.adminCtx(), &api.CreateRoleRequest{Name: "admin-like"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateRole(a.adminCtx(), &api.CreateRoleRequest{Name: "admin-like"}); err != nil {
+		t.Fatalf("其他租户的同名角色创建失败: %v", err)
+	}
+}
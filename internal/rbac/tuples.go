@@ -0,0 +1,443 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"grpc-rbac-backend/api"
+	"grpc-rbac-backend/internal/audit"
+	"grpc-rbac-backend/internal/model"
+)
+
+// maxTupleDepth 关系改写的最大递归深度，防止配置或数据形成环时无限展开
+const maxTupleDepth = 32
+
+var errTupleDepth = status.Error(codes.FailedPrecondition, "关系展开层级过深")
+
+// WriteTuples 写入关系元组，已存在的元组会被忽略
+func (s *Service) WriteTuples(ctx context.Context, req *api.WriteTuplesRequest) (*api.WriteTuplesResponse, error) {
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tuples, err := s.parseTuples(tenantID, req.Tuples)
+	if err != nil {
+		return nil, err
+	}
+
+	err = withAudit(ctx, "WriteTuples", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		ev.Target = audit.Target("tuple", 0)
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&tuples).Error; err != nil {
+			return err
+		}
+		ev.After = audit.Snapshot(tupleStrings(tuples))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.WriteTuplesResponse{Message: "关系写入成功"}, nil
+}
+
+// DeleteTuples 删除关系元组
+func (s *Service) DeleteTuples(ctx context.Context, req *api.DeleteTuplesRequest) (*api.DeleteTuplesResponse, error) {
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tuples, err := s.parseTuples(tenantID, req.Tuples)
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted int64
+	err = withAudit(ctx, "DeleteTuples", func(tx *gorm.DB, ev *model.AuditEvent) error {
+		ev.Target = audit.Target("tuple", 0)
+		ev.Before = audit.Snapshot(tupleStrings(tuples))
+		for _, t := range tuples {
+			res := tx.Where(&t, "TenantID", "Namespace", "ObjectID", "Relation", "SubjectType", "SubjectID", "SubjectRelation").
+				Delete(&model.RelationTuple{})
+			if res.Error != nil {
+				return res.Error
+			}
+			deleted += res.RowsAffected
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.DeleteTuplesResponse{Message: "关系删除成功", Deleted: deleted}, nil
+}
+
+// Check 判断主体是否拥有对象的指定关系，会沿命名空间配置的改写规则展开
+func (s *Service) Check(ctx context.Context, req *api.CheckRequest) (*api.CheckResponse, error) {
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ns, obj, err := model.ParseObject(req.Object)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	subject, err := parseTupleSubject(req.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	c := s.newTupleChecker(tenantID, subject)
+	allowed, err := c.check(ns, obj, req.Relation, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &api.CheckResponse{Allowed: allowed}, nil
+}
+
+// Expand 展开对象关系的改写规则，返回主体集合树
+func (s *Service) Expand(ctx context.Context, req *api.ExpandRequest) (*api.ExpandResponse, error) {
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ns, obj, err := model.ParseObject(req.Object)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	e := &tupleExpander{namespaces: s.namespaces, db: model.DB, tenantID: tenantID}
+	tree, err := e.expand(ns, obj, req.Relation, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &api.ExpandResponse{Tree: tree}, nil
+}
+
+// ListObjects 列出命名空间中主体拥有指定关系的所有对象
+func (s *Service) ListObjects(ctx context.Context, req *api.ListObjectsRequest) (*api.ListObjectsResponse, error) {
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if s.namespaces.rewrite(req.Namespace, req.Relation) == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "未定义的关系 %s#%s", req.Namespace, req.Relation)
+	}
+	subject, err := parseTupleSubject(req.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	// 候选对象为该命名空间下出现过的所有对象，逐个判定
+	var candidates []string
+	if err := model.DB.Model(&model.RelationTuple{}).
+		Scopes(model.TenantScope(tenantID)).
+		Where("namespace = ?", req.Namespace).
+		Distinct().Order("object_id").
+		Pluck("object_id", &candidates).Error; err != nil {
+		return nil, err
+	}
+
+	c := s.newTupleChecker(tenantID, subject)
+	objects := make([]string, 0)
+	for _, id := range candidates {
+		ok, err := c.check(req.Namespace, id, req.Relation, 0)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			objects = append(objects, req.Namespace+":"+id)
+		}
+	}
+	return &api.ListObjectsResponse{Objects: objects}, nil
+}
+
+// parseTuples 解析并校验请求中的元组，关系和主体类型必须在命名空间配置中定义
+func (s *Service) parseTuples(tenantID uint, in []*api.RelationTuple) ([]model.RelationTuple, error) {
+	if len(in) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "元组不能为空")
+	}
+	tuples := make([]model.RelationTuple, 0, len(in))
+	for _, t := range in {
+		ns, obj, err := model.ParseObject(t.Object)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if s.namespaces.rewrite(ns, t.Relation) == nil {
+			return nil, status.Errorf(codes.InvalidArgument, "未定义的关系 %s#%s", ns, t.Relation)
+		}
+		typ, id, rel, err := model.ParseSubject(t.Subject)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if !s.namespaces.hasNamespace(typ) {
+			return nil, status.Errorf(codes.InvalidArgument, "未定义的命名空间 %s", typ)
+		}
+		if rel != "" && s.namespaces.rewrite(typ, rel) == nil {
+			return nil, status.Errorf(codes.InvalidArgument, "未定义的关系 %s#%s", typ, rel)
+		}
+		tuples = append(tuples, model.RelationTuple{
+			TenantID:        tenantID,
+			Namespace:       ns,
+			ObjectID:        obj,
+			Relation:        t.Relation,
+			SubjectType:     typ,
+			SubjectID:       id,
+			SubjectRelation: rel,
+		})
+	}
+	return tuples, nil
+}
+
+func parseTupleSubject(s string) (model.RelationTuple, error) {
+	typ, id, rel, err := model.ParseSubject(s)
+	if err != nil {
+		return model.RelationTuple{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return model.RelationTuple{SubjectType: typ, SubjectID: id, SubjectRelation: rel}, nil
+}
+
+func tupleStrings(tuples []model.RelationTuple) []string {
+	out := make([]string, 0, len(tuples))
+	for _, t := range tuples {
+		out = append(out, t.String())
+	}
+	return out
+}
+
+// loadTuples 查询对象某个关系下的所有元组
+func loadTuples(tx *gorm.DB, tenantID uint, namespace, objectID, relation string) ([]model.RelationTuple, error) {
+	var tuples []model.RelationTuple
+	err := tx.Scopes(model.TenantScope(tenantID)).
+		Where("namespace = ? AND object_id = ? AND relation = ?", namespace, objectID, relation).
+		Order("id").Find(&tuples).Error
+	return tuples, err
+}
+
+// tupleChecker 针对单个主体的判定，缓存已确认包含该主体的 object#relation；
+// negated 为当前所处的排除（exclusion）减集层数，visiting 记录正在展开的关系集合开始时的层数
+type tupleChecker struct {
+	namespaces *NamespaceConfig
+	db         *gorm.DB
+	tenantID   uint
+	subject    model.RelationTuple
+	granted    map[string]bool
+	visiting   map[string]int
+	negated    int
+}
+
+func (s *Service) newTupleChecker(tenantID uint, subject model.RelationTuple) *tupleChecker {
+	return &tupleChecker{
+		namespaces: s.namespaces,
+		db:         model.DB,
+		tenantID:   tenantID,
+		subject:    subject,
+		granted:    make(map[string]bool),
+		visiting:   make(map[string]int),
+	}
+}
+
+func (c *tupleChecker) check(namespace, objectID, relation string, depth int) (bool, error) {
+	if depth > maxTupleDepth {
+		return false, errTupleDepth
+	}
+	rw := c.namespaces.rewrite(namespace, relation)
+	if rw == nil {
+		return false, status.Errorf(codes.InvalidArgument, "未定义的关系 %s#%s", namespace, relation)
+	}
+	// 主体本身就是该关系集合
+	if c.subject.SubjectType == namespace && c.subject.SubjectID == objectID && c.subject.SubjectRelation == relation {
+		return true, nil
+	}
+
+	key := namespace + ":" + objectID + "#" + relation
+	if c.granted[key] {
+		return true, nil
+	}
+	// 正在展开的关系集合再次出现说明形成了环。环内没有经过排除时按不包含处理（即最小不动点）；
+	// 环穿过了排除的减集时无法确定，按偏向拒绝处理：处于减集中时视为包含（即被排除）
+	if level, ok := c.visiting[key]; ok {
+		return level != c.negated && c.negated%2 == 1, nil
+	}
+	c.visiting[key] = c.negated
+	defer delete(c.visiting, key)
+
+	ok, err := c.eval(rw, namespace, objectID, relation, depth)
+	// 减集中的结果可能依赖上面对环的假设，不能缓存
+	if ok && c.negated == 0 {
+		c.granted[key] = true
+	}
+	return ok, err
+}
+
+func (c *tupleChecker) eval(u *Userset, namespace, objectID, relation string, depth int) (bool, error) {
+	switch {
+	case u.ComputedUserset != "":
+		return c.check(namespace, objectID, u.ComputedUserset, depth+1)
+
+	case u.TupleToUserset != nil:
+		tuples, err := loadTuples(c.db, c.tenantID, namespace, objectID, u.TupleToUserset.Tupleset)
+		if err != nil {
+			return false, err
+		}
+		for _, t := range tuples {
+			if c.namespaces.rewrite(t.SubjectType, u.TupleToUserset.ComputedUserset) == nil {
+				continue
+			}
+			ok, err := c.check(t.SubjectType, t.SubjectID, u.TupleToUserset.ComputedUserset, depth+1)
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+
+	case len(u.Union) > 0:
+		for _, child := range u.Union {
+			ok, err := c.eval(child, namespace, objectID, relation, depth+1)
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+
+	case len(u.Intersection) > 0:
+		for _, child := range u.Intersection {
+			ok, err := c.eval(child, namespace, objectID, relation, depth+1)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+
+	case u.Exclusion != nil:
+		ok, err := c.eval(u.Exclusion.Base, namespace, objectID, relation, depth+1)
+		if err != nil || !ok {
+			return false, err
+		}
+		c.negated++
+		excluded, err := c.eval(u.Exclusion.Subtract, namespace, objectID, relation, depth+1)
+		c.negated--
+		return !excluded && err == nil, err
+
+	default:
+		// this：直接写入的元组，主体为关系集合时继续展开
+		tuples, err := loadTuples(c.db, c.tenantID, namespace, objectID, relation)
+		if err != nil {
+			return false, err
+		}
+		for _, t := range tuples {
+			if t.SubjectType == c.subject.SubjectType && t.SubjectID == c.subject.SubjectID &&
+				t.SubjectRelation == c.subject.SubjectRelation {
+				return true, nil
+			}
+			if t.SubjectRelation == "" {
+				continue
+			}
+			ok, err := c.check(t.SubjectType, t.SubjectID, t.SubjectRelation, depth+1)
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+	}
+}
+
+// tupleExpander 将改写规则展开为主体集合树，直接元组中的关系集合主体以引用形式保留
+type tupleExpander struct {
+	namespaces *NamespaceConfig
+	db         *gorm.DB
+	tenantID   uint
+}
+
+func (e *tupleExpander) expand(namespace, objectID, relation string, depth int) (*api.UsersetNode, error) {
+	if depth > maxTupleDepth {
+		return nil, errTupleDepth
+	}
+	rw := e.namespaces.rewrite(namespace, relation)
+	if rw == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "未定义的关系 %s#%s", namespace, relation)
+	}
+	node, err := e.expandRewrite(rw, namespace, objectID, relation, depth)
+	if err != nil {
+		return nil, err
+	}
+	userset := namespace + ":" + objectID + "#" + relation
+	// computed_userset 返回的是被引用关系的节点，需要保留其标签，外面再包一层当前关系
+	if node.Userset != "" && node.Userset != userset {
+		node = &api.UsersetNode{Operation: "union", Children: []*api.UsersetNode{node}}
+	}
+	node.Userset = userset
+	return node, nil
+}
+
+func (e *tupleExpander) expandRewrite(u *Userset, namespace, objectID, relation string, depth int) (*api.UsersetNode, error) {
+	switch {
+	case u.ComputedUserset != "":
+		return e.expand(namespace, objectID, u.ComputedUserset, depth+1)
+
+	case u.TupleToUserset != nil:
+		tuples, err := loadTuples(e.db, e.tenantID, namespace, objectID, u.TupleToUserset.Tupleset)
+		if err != nil {
+			return nil, err
+		}
+		node := &api.UsersetNode{Operation: "union"}
+		for _, t := range tuples {
+			if e.namespaces.rewrite(t.SubjectType, u.TupleToUserset.ComputedUserset) == nil {
+				continue
+			}
+			child, err := e.expand(t.SubjectType, t.SubjectID, u.TupleToUserset.ComputedUserset, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, child)
+		}
+		return node, nil
+
+	case len(u.Union) > 0 || len(u.Intersection) > 0:
+		node := &api.UsersetNode{Operation: "union"}
+		children := u.Union
+		if len(u.Intersection) > 0 {
+			node.Operation = "intersection"
+			children = u.Intersection
+		}
+		for _, c := range children {
+			child, err := e.expandRewrite(c, namespace, objectID, relation, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, child)
+		}
+		return node, nil
+
+	case u.Exclusion != nil:
+		base, err := e.expandRewrite(u.Exclusion.Base, namespace, objectID, relation, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		subtract, err := e.expandRewrite(u.Exclusion.Subtract, namespace, objectID, relation, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		return &api.UsersetNode{Operation: "exclusion", Children: []*api.UsersetNode{base, subtract}}, nil
+
+	default:
+		tuples, err := loadTuples(e.db, e.tenantID, namespace, objectID, relation)
+		if err != nil {
+			return nil, err
+		}
+		subjects := make([]string, 0, len(tuples))
+		for _, t := range tuples {
+			subjects = append(subjects, t.Subject())
+		}
+		sort.Strings(subjects)
+		return &api.UsersetNode{
+			Operation: "leaf",
+			Userset:   fmt.Sprintf("%s:%s#%s", namespace, objectID, relation),
+			Subjects:  subjects,
+		}, nil
+	}
+}
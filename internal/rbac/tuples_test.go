@@ -0,0 +1,108 @@
+package rbac
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"grpc-rbac-backend/api"
+)
+
+// 测试用命名空间：reader 直接引用 viewer；viewer 为 this 去掉 blocked；
+// banned 又引用了 viewer，形成穿过排除的环
+const testNamespaces = `{
+  "namespaces": {
+    "user": {},
+    "group": {"relations": {"member": {}}},
+    "doc": {
+      "relations": {
+        "blocked": {},
+        "viewer": {"exclusion": {"base": {"this": true}, "subtract": {"computed_userset": "blocked"}}},
+        "reader": {"computed_userset": "viewer"},
+        "banned": {"union": [{"this": true}, {"computed_userset": "guarded"}]},
+        "guarded": {"exclusion": {"base": {"this": true}, "subtract": {"computed_userset": "banned"}}}
+      }
+    }
+  }
+}`
+
+func newTupleTestService(t *testing.T) (*Service, *testTenant) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "namespaces.json")
+	if err := os.WriteFile(path, []byte(testNamespaces), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	namespaces, err := LoadNamespaceConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestService(t, nil, nil)
+	s.namespaces = namespaces
+	return s, newTestTenant(t)
+}
+
+func writeTuples(t *testing.T, s *Service, tt *testTenant, tuples ...[3]string) {
+	t.Helper()
+	req := &api.WriteTuplesRequest{}
+	for _, tu := range tuples {
+		req.Tuples = append(req.Tuples, &api.RelationTuple{Object: tu[0], Relation: tu[1], Subject: tu[2]})
+	}
+	if _, err := s.WriteTuples(tt.adminCtx(), req); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func check(t *testing.T, s *Service, tt *testTenant, object, relation, subject string) bool {
+	t.Helper()
+	resp, err := s.Check(tt.adminCtx(), &api.CheckRequest{Object: object, Relation: relation, Subject: subject})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.Allowed
+}
+
+// 减集内部的环（嵌套用户组互相包含）按最小不动点求值，不影响判定
+func TestCheckCycleInsideSubtract(t *testing.T) {
+	s, tt := newTupleTestService(t)
+	writeTuples(t, s, tt,
+		[3]string{"doc:1", "viewer", "user:alice"},
+		[3]string{"doc:1", "viewer", "user:bob"},
+		[3]string{"doc:1", "blocked", "group:g#member"},
+		[3]string{"group:g", "member", "group:h#member"},
+		[3]string{"group:h", "member", "group:g#member"},
+		[3]string{"group:h", "member", "user:bob"},
+	)
+	if !check(t, s, tt, "doc:1", "viewer", "user:alice") {
+		t.Error("alice 不在 blocked 中，应允许")
+	}
+	if check(t, s, tt, "doc:1", "viewer", "user:bob") {
+		t.Error("bob 通过嵌套用户组位于 blocked 中，应拒绝")
+	}
+}
+
+// 穿过排除的环无法确定结果，按拒绝处理
+func TestCheckCycleThroughExclusionDenies(t *testing.T) {
+	s, tt := newTupleTestService(t)
+	writeTuples(t, s, tt, [3]string{"doc:1", "guarded", "user:alice"})
+	if check(t, s, tt, "doc:1", "guarded", "user:alice") {
+		t.Error("guarded 与 banned 互相引用，应拒绝")
+	}
+}
+
+// computed_userset 的展开树保留被引用关系的标签
+func TestExpandComputedUsersetLabels(t *testing.T) {
+	s, tt := newTupleTestService(t)
+	writeTuples(t, s, tt, [3]string{"doc:1", "viewer", "user:alice"})
+	resp, err := s.Expand(tt.adminCtx(), &api.ExpandRequest{Object: "doc:1", Relation: "reader"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := resp.Tree
+	if root.Userset != "doc:1#reader" || len(root.Children) != 1 {
+		t.Fatalf("根节点 = %s（%d 个子节点），期望 doc:1#reader 包含一个子节点", root.Userset, len(root.Children))
+	}
+	viewer := root.Children[0]
+	if viewer.Userset != "doc:1#viewer" || viewer.Operation != "exclusion" {
+		t.Errorf("子节点 = %s %s，期望 doc:1#viewer exclusion", viewer.Userset, viewer.Operation)
+	}
+}
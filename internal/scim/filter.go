@@ -0,0 +1,418 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Filter SCIM 过滤表达式（RFC 7644 3.4.2.2）的语法树
+type Filter interface {
+	filter()
+}
+
+// AttrExpr 属性比较，如 userName eq "alice"；Op 为 pr 时没有 Value
+type AttrExpr struct {
+	Attr  string
+	Op    string
+	Value any // string、float64、bool 或 nil
+}
+
+// LogicExpr and / or
+type LogicExpr struct {
+	Op          string
+	Left, Right Filter
+}
+
+// NotExpr not (...)
+type NotExpr struct {
+	Filter Filter
+}
+
+// ValuePath 多值属性的筛选，如 members[value eq "1"]
+type ValuePath struct {
+	Attr   string
+	Filter Filter
+}
+
+func (AttrExpr) filter()  {}
+func (LogicExpr) filter() {}
+func (NotExpr) filter()   {}
+func (ValuePath) filter() {}
+
+var compareOps = map[string]bool{
+	"eq": true, "ne": true, "co": true, "sw": true, "ew": true,
+	"gt": true, "ge": true, "lt": true, "le": true,
+}
+
+// ParseFilter 解析过滤表达式，运算符和属性名不区分大小写，属性名统一转为小写
+func ParseFilter(s string) (Filter, error) {
+	p := &parser{tokens: tokenize(s)}
+	if p.err() != nil {
+		return nil, p.err()
+	}
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("过滤表达式在 %q 处有多余内容", p.peek().text)
+	}
+	return f, nil
+}
+
+// ParsePath 解析 PATCH 操作的 path，如 active、members[value eq "1"]、name.givenName；
+// 返回小写的属性名、可选的筛选条件和子属性
+func ParsePath(s string) (attr string, filter Filter, sub string, err error) {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '['); i >= 0 {
+		j := strings.LastIndexByte(s, ']')
+		if j < i {
+			return "", nil, "", fmt.Errorf("path 格式错误: %s", s)
+		}
+		if filter, err = ParseFilter(s[i+1 : j]); err != nil {
+			return "", nil, "", err
+		}
+		attr, sub = normalizeAttr(s[:i]), strings.TrimPrefix(s[j+1:], ".")
+		return attr, filter, strings.ToLower(sub), nil
+	}
+	attr = normalizeAttr(s)
+	if head, rest, ok := strings.Cut(attr, "."); ok {
+		return head, nil, rest, nil
+	}
+	return attr, nil, "", nil
+}
+
+// normalizeAttr 去掉完整的 schema URN 前缀并转为小写，如
+// urn:ietf:params:scim:schemas:core:2.0:User:userName 转为 username
+func normalizeAttr(s string) string {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(strings.ToLower(s), "urn:") {
+		if i := strings.LastIndexByte(s, ':'); i >= 0 {
+			s = s[i+1:]
+		}
+	}
+	return strings.ToLower(s)
+}
+
+// Match 在内存中对一个多值属性的元素求值，attrs 的键为小写属性名
+func Match(f Filter, attrs map[string]string) bool {
+	switch e := f.(type) {
+	case AttrExpr:
+		v, ok := attrs[e.Attr]
+		if e.Op == "pr" {
+			return ok && v != ""
+		}
+		want := fmt.Sprint(e.Value)
+		switch e.Op {
+		case "eq":
+			return ok && strings.EqualFold(v, want)
+		case "ne":
+			return !ok || !strings.EqualFold(v, want)
+		case "co":
+			return ok && strings.Contains(strings.ToLower(v), strings.ToLower(want))
+		case "sw":
+			return ok && strings.HasPrefix(strings.ToLower(v), strings.ToLower(want))
+		case "ew":
+			return ok && strings.HasSuffix(strings.ToLower(v), strings.ToLower(want))
+		}
+		return false
+	case LogicExpr:
+		if e.Op == "and" {
+			return Match(e.Left, attrs) && Match(e.Right, attrs)
+		}
+		return Match(e.Left, attrs) || Match(e.Right, attrs)
+	case NotExpr:
+		return !Match(e.Filter, attrs)
+	}
+	return false
+}
+
+// Column 过滤属性对应的 SQL 表达式
+type Column struct {
+	Expr string
+	// Args Expr 中占位符对应的参数，如自定义属性名，不直接拼接到 SQL 中
+	Args []any
+	// Bool 为 true 时只支持 eq、ne 和布尔值
+	Bool bool
+}
+
+// args Expr 出现 n 次时的参数，后接比较值
+func (c Column) args(n int, values ...any) []any {
+	out := make([]any, 0, n*len(c.Args)+len(values))
+	for i := 0; i < n; i++ {
+		out = append(out, c.Args...)
+	}
+	return append(out, values...)
+}
+
+// ToSQL 把过滤表达式转为 SQL 条件，columns 的键为小写属性名；不支持的属性或运算返回错误
+func ToSQL(f Filter, columns map[string]Column) (string, []any, error) {
+	switch e := f.(type) {
+	case AttrExpr:
+		col, ok := columns[e.Attr]
+		if !ok {
+			return "", nil, fmt.Errorf("不支持按属性 %s 过滤", e.Attr)
+		}
+		if col.Bool {
+			b, ok := e.Value.(bool)
+			if !ok || (e.Op != "eq" && e.Op != "ne") {
+				return "", nil, fmt.Errorf("属性 %s 只支持 eq、ne 布尔值", e.Attr)
+			}
+			if e.Op == "ne" {
+				b = !b
+			}
+			if b {
+				return "(" + col.Expr + ")", col.args(1), nil
+			}
+			return "NOT (" + col.Expr + ")", col.args(1), nil
+		}
+		if e.Op == "pr" {
+			return fmt.Sprintf("(%s IS NOT NULL AND %s <> '')", col.Expr, col.Expr), col.args(2), nil
+		}
+		var v any
+		switch val := e.Value.(type) {
+		case string:
+			v = val
+		case float64:
+			v = strconv.FormatFloat(val, 'f', -1, 64)
+		default:
+			return "", nil, fmt.Errorf("属性 %s 的比较值无效", e.Attr)
+		}
+		switch e.Op {
+		case "eq":
+			return col.Expr + " = ?", col.args(1, v), nil
+		case "ne":
+			return col.Expr + " <> ?", col.args(1, v), nil
+		case "co":
+			return col.Expr + " LIKE ?", col.args(1, "%"+escapeLike(v.(string))+"%"), nil
+		case "sw":
+			return col.Expr + " LIKE ?", col.args(1, escapeLike(v.(string))+"%"), nil
+		case "ew":
+			return col.Expr + " LIKE ?", col.args(1, "%"+escapeLike(v.(string))), nil
+		case "gt":
+			return col.Expr + " > ?", col.args(1, v), nil
+		case "ge":
+			return col.Expr + " >= ?", col.args(1, v), nil
+		case "lt":
+			return col.Expr + " < ?", col.args(1, v), nil
+		case "le":
+			return col.Expr + " <= ?", col.args(1, v), nil
+		}
+	case LogicExpr:
+		left, la, err := ToSQL(e.Left, columns)
+		if err != nil {
+			return "", nil, err
+		}
+		right, ra, err := ToSQL(e.Right, columns)
+		if err != nil {
+			return "", nil, err
+		}
+		return fmt.Sprintf("(%s %s %s)", left, strings.ToUpper(e.Op), right), append(la, ra...), nil
+	case NotExpr:
+		inner, args, err := ToSQL(e.Filter, columns)
+		if err != nil {
+			return "", nil, err
+		}
+		return "NOT (" + inner + ")", args, nil
+	case ValuePath:
+		return "", nil, fmt.Errorf("不支持按多值属性 %s 过滤", e.Attr)
+	}
+	return "", nil, errors.New("过滤表达式无效")
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// ========== 词法与语法分析 ==========
+
+type token struct {
+	kind string // word、string、(、)、[、]，出错时为 error
+	text string
+}
+
+func tokenize(s string) []token {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(' || c == ')' || c == '[' || c == ']':
+			tokens = append(tokens, token{kind: string(c), text: string(c)})
+			i++
+		case c == '"':
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return append(tokens, token{kind: "error", text: "字符串缺少结束引号"})
+			}
+			tokens = append(tokens, token{kind: "string", text: s[i : j+1]})
+			i = j + 1
+		default:
+			j := i
+			for j < len(s) && !strings.ContainsRune(" \t\n()[]\"", rune(s[j])) {
+				j++
+			}
+			tokens = append(tokens, token{kind: "word", text: s[i:j]})
+			i = j
+		}
+	}
+	return tokens
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) err() error {
+	for _, t := range p.tokens {
+		if t.kind == "error" {
+			return errors.New(t.text)
+		}
+	}
+	return nil
+}
+
+func (p *parser) done() bool { return p.pos >= len(p.tokens) }
+
+func (p *parser) peek() token {
+	if p.done() {
+		return token{}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+// peekKeyword 下一个词是否为指定的关键字（不区分大小写）
+func (p *parser) peekKeyword(kw string) bool {
+	t := p.peek()
+	return t.kind == "word" && strings.EqualFold(t.text, kw)
+}
+
+func (p *parser) parseOr() (Filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = LogicExpr{Op: "or", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Filter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("and") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = LogicExpr{Op: "and", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (Filter, error) {
+	if p.peekKeyword("not") {
+		p.next()
+		if p.next().kind != "(" {
+			return nil, errors.New("not 后须为括号")
+		}
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next().kind != ")" {
+			return nil, errors.New("缺少右括号")
+		}
+		return NotExpr{Filter: inner}, nil
+	}
+	if p.peek().kind == "(" {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next().kind != ")" {
+			return nil, errors.New("缺少右括号")
+		}
+		return inner, nil
+	}
+	return p.parseAttr()
+}
+
+func (p *parser) parseAttr() (Filter, error) {
+	t := p.next()
+	if t.kind != "word" {
+		return nil, fmt.Errorf("此处应为属性名: %q", t.text)
+	}
+	attr := normalizeAttr(t.text)
+	if p.peek().kind == "[" {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next().kind != "]" {
+			return nil, errors.New("缺少右方括号")
+		}
+		return ValuePath{Attr: attr, Filter: inner}, nil
+	}
+
+	opTok := p.next()
+	op := strings.ToLower(opTok.text)
+	if opTok.kind != "word" || (op != "pr" && !compareOps[op]) {
+		return nil, fmt.Errorf("不支持的运算符: %q", opTok.text)
+	}
+	if op == "pr" {
+		return AttrExpr{Attr: attr, Op: op}, nil
+	}
+	vt := p.next()
+	var value any
+	switch {
+	case vt.kind == "string":
+		var s string
+		if err := json.Unmarshal([]byte(vt.text), &s); err != nil {
+			return nil, fmt.Errorf("字符串格式错误: %s", vt.text)
+		}
+		value = s
+	case vt.kind == "word" && strings.EqualFold(vt.text, "true"):
+		value = true
+	case vt.kind == "word" && strings.EqualFold(vt.text, "false"):
+		value = false
+	case vt.kind == "word" && strings.EqualFold(vt.text, "null"):
+		value = nil
+	case vt.kind == "word":
+		n, err := strconv.ParseFloat(vt.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("比较值无效: %q", vt.text)
+		}
+		value = n
+	default:
+		return nil, fmt.Errorf("缺少比较值")
+	}
+	return AttrExpr{Attr: attr, Op: op, Value: value}, nil
+}
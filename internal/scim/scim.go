@@ -0,0 +1,34 @@
+// Package scim SCIM 2.0（RFC 7643 / RFC 7644）用户预配所需的常量、过滤表达式和 PATCH 路径解析
+package scim
+
+// SCIM 资源和消息的 schema
+const (
+	UserSchema         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	GroupSchema        = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	ListResponseSchema = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	PatchOpSchema      = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	ErrorSchema        = "urn:ietf:params:scim:api:messages:2.0:Error"
+
+	ServiceProviderConfigSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	ResourceTypeSchema          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+)
+
+// 列表查询每页的默认和最大条数
+const (
+	DefaultCount = 100
+	MaxCount     = 500
+)
+
+// 错误类型（RFC 7644 3.12），通过 gRPC ErrorInfo.Reason 传给网关
+const (
+	ErrInvalidFilter = "invalidFilter"
+	ErrInvalidPath   = "invalidPath"
+	ErrInvalidValue  = "invalidValue"
+	ErrNoTarget      = "noTarget"
+	ErrUniqueness    = "uniqueness"
+	ErrMutability    = "mutability"
+	ErrInvalidSyntax = "invalidSyntax"
+)
+
+// ErrorDomain gRPC ErrorInfo 的 domain
+const ErrorDomain = "scim"
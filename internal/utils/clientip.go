@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// ClientIP 返回请求的客户端 IP。只有来自本机（即 HTTP 网关）的请求才信任 x-forwarded-for，
+// 并取网关追加的最后一个地址，客户端自行伪造的前缀地址会被忽略
+func ClientIP(ctx context.Context) string {
+	var ip string
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		ip = p.Addr.String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+	}
+	if parsed := net.ParseIP(ip); parsed == nil || !parsed.IsLoopback() {
+		return ip
+	}
+
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ip
+	}
+	values := md.Get("x-forwarded-for")
+	if len(values) == 0 {
+		return ip
+	}
+	parts := strings.Split(values[len(values)-1], ",")
+	if forwarded := strings.TrimSpace(parts[len(parts)-1]); net.ParseIP(forwarded) != nil {
+		return forwarded
+	}
+	return ip
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -10,17 +11,83 @@ import (
 var jwtSecret = []byte("secret123")
 
 type CustomClaims struct {
+	TenantID uint     `json:"tid"`
+	Tenant   string   `json:"tenant"`
 	Username string   `json:"username"`
 	Roles    []string `json:"roles"`
+	// Purpose 非空表示受限用途的 token（如 MFA 挑战），不能用于访问普通接口
+	Purpose string `json:"purpose,omitempty"`
+	// Scope 空格分隔的权限名，非空时调用方只拥有其中的权限，且不能执行管理操作
+	Scope string `json:"scope,omitempty"`
+	// ClientID 通过 OAuth2 客户端签发的 token 记录客户端 ID
+	ClientID string `json:"client_id,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func GenerateJWT(username string, roles []string) (string, error) {
-	claims := CustomClaims{
+// Scoped 是否为限定了权限范围的调用方
+func (c *CustomClaims) Scoped() bool {
+	return c.Scope != ""
+}
+
+// InScope 未限定范围，或 permission 在范围内
+func (c *CustomClaims) InScope(permission string) bool {
+	if c.Scope == "" {
+		return true
+	}
+	for _, s := range strings.Fields(c.Scope) {
+		if s == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// 受限 token 的用途
+const (
+	// PurposeMFA 密码校验通过、等待提交第二因素
+	PurposeMFA = "mfa"
+	// PurposeMFAEnroll 策略要求启用 MFA 但尚未绑定，只能调用绑定接口
+	PurposeMFAEnroll = "mfa-enroll"
+)
+
+// TokenTTL token 默认有效期
+const TokenTTL = 2 * time.Hour
+
+// GenerateJWT sessionID 写入 jti，服务端据此校验会话是否已被撤销
+func GenerateJWT(sessionID string, tenantID uint, tenant string, username string, roles []string) (string, error) {
+	return GenerateJWTWithExpiry(sessionID, tenantID, tenant, username, roles, time.Now().Add(TokenTTL))
+}
+
+// GenerateJWTWithExpiry 指定过期时间签发 token，用于限时角色等需要缩短有效期的场景
+func GenerateJWTWithExpiry(sessionID string, tenantID uint, tenant string, username string, roles []string, expiresAt time.Time) (string, error) {
+	return SignClaims(&CustomClaims{
+		TenantID: tenantID,
+		Tenant:   tenant,
 		Username: username,
 		Roles:    roles,
+	}, sessionID, expiresAt)
+}
+
+// SignClaims 为已填好身份信息的 claims 补上 jti、签发和过期时间后签名，用于限定权限范围等场景
+func SignClaims(claims *CustomClaims, sessionID string, expiresAt time.Time) (string, error) {
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        sessionID,
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(jwtSecret)
+}
+
+// GeneratePurposeJWT 签发受限用途的短期 token，不携带角色
+func GeneratePurposeJWT(tenantID uint, tenant string, username string, purpose string, ttl time.Duration) (string, error) {
+	claims := CustomClaims{
+		TenantID: tenantID,
+		Tenant:   tenant,
+		Username: username,
+		Purpose:  purpose,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(2 * time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}